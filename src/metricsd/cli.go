@@ -7,6 +7,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 	"metricsd/config"
 )
 
@@ -18,10 +19,21 @@ var (
 	debugLevel       = flag.Int("debug", int(config.DEFAULT_SEVERITY), "Set the debug level, the lower - the more verbose (0-5)")
 	sliceInt         = flag.Int("slice", config.DEFAULT_SLICE_INTERVAL, "Set the slice interval in seconds")
 	writeInt         = flag.Int("write", config.DEFAULT_WRITE_INTERVAL, "Set the write interval in seconds")
+	writeJitter      = flag.Int("jitter", config.DEFAULT_WRITE_JITTER, "Set the maximum random delay of writes in seconds")
 	rrdUpdateThreads = flag.Int("threads", config.DEFAULT_RRD_UPDATE_THREADS, "Set the number of RRD update threads")
 	batchWrites      = flag.Bool("batch", config.DEFAULT_BATCH_WRITES, "Set the value indicating whether batch RRD updates should be used")
+	dryRun           = flag.Bool("dry-run", config.DEFAULT_DRY_RUN, "Compute rollups, but do not write them anywhere (log what would be written)")
 	dnsLookup        = flag.Bool("lookup", config.DEFAULT_LOOKUP_DNS, "Set the value indicating whether reverse DNS lookup should be performed for sources")
+	ingestBufferSize = flag.Int("buffer", config.DEFAULT_INGEST_BUFFER_SIZE, "Set the size of the ingestion queue between listener and timeline")
+	ingestPolicy     = flag.String("overflow", config.DEFAULT_INGEST_POLICY, "Set the policy applied when ingestion queue is full (drop or block)")
+	writerNames      = flag.String("writers", config.DEFAULT_WRITERS, "Set the comma-separated list of active writers")
 	testAndExit      = flag.Bool("test", false, "Validate config file and exit")
+	checkConfigPath  = flag.String("check-config", "", "Validate the given config file (writers, listeners, name templates, and settings) and exit")
+	importPath       = flag.String("import", "", "Import historical data from CSV/TSV file (name,timestamp,value) and exit")
+	replayPath       = flag.String("replay", "", "Replay timestamped events from CSV/TSV file (name,timestamp,value) as if received live, and exit")
+	importDedup      = flag.Bool("dedup", config.DEFAULT_IMPORT_DEDUP, "Set the value indicating whether exact duplicates of imported records should be dropped")
+	printRollups     = flag.Bool("print", false, "Print rollups of all writers to standard output instead of writing RRD files")
+	restorePath      = flag.String("restore", "", "Restore open slices from the timeline snapshot file (json or gob) on startup")
 )
 
 func parseCommandLineArguments() {
@@ -36,15 +48,15 @@ func parseCommandLineArguments() {
 
 	// Make config file path absolute
 	cfgpath := *configPath
+	if *checkConfigPath != "" {
+		cfgpath = *checkConfigPath
+	}
 	if !path.IsAbs(cfgpath) {
 		cfgpath = path.Join(binaryRoot, cfgpath)
 	}
 
 	// Load config from a config file
 	config.Load(cfgpath)
-	if *testAndExit {
-		os.Exit(0)
-	}
 
 	// Override options with values passed in command line arguments
 	// (but only if they have a value different from a default one)
@@ -66,15 +78,36 @@ func parseCommandLineArguments() {
 	if *writeInt != config.DEFAULT_WRITE_INTERVAL {
 		config.WriteInterval = *writeInt
 	}
+	if *writeJitter != config.DEFAULT_WRITE_JITTER {
+		config.WriteJitter = *writeJitter
+	}
 	if *rrdUpdateThreads != config.DEFAULT_RRD_UPDATE_THREADS {
 		config.RrdUpdateThreads = *rrdUpdateThreads
 	}
 	if *batchWrites != config.DEFAULT_BATCH_WRITES {
 		config.BatchWrites = *batchWrites
 	}
+	if *dryRun != config.DEFAULT_DRY_RUN {
+		config.DryRun = *dryRun
+	}
 	if *dnsLookup != config.DEFAULT_LOOKUP_DNS {
 		config.LookupDns = *dnsLookup
 	}
+	if *ingestBufferSize != config.DEFAULT_INGEST_BUFFER_SIZE {
+		config.IngestBufferSize = *ingestBufferSize
+	}
+	if *ingestPolicy != config.DEFAULT_INGEST_POLICY {
+		config.IngestPolicy = *ingestPolicy
+	}
+	if *writerNames != config.DEFAULT_WRITERS {
+		config.Writers = strings.Split(*writerNames, ",")
+	}
+	if *importDedup != config.DEFAULT_IMPORT_DEDUP {
+		config.ImportDedup = *importDedup
+	}
+	if *printRollups {
+		config.Outputs = map[string][]string{config.ALL_WRITERS: []string{config.OUTPUT_STDOUT}}
+	}
 
 	// Make data directory path absolute
 	if !path.IsAbs(config.DataDir) {
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+var (
+	// Slice intervals in seconds, metrics of the default timeline could be
+	// adapted to (empty disables adaptive intervals)
+	AdaptiveIntervals []int
+	// Intervals picked for metrics by arrival rate, keyed by metric name
+	adaptedIntervals      = make(map[string]int)
+	adaptedIntervalsMutex = &sync.RWMutex{}
+)
+
+// AdaptedInterval returns the slice interval picked for the metric with the
+// given name by its arrival rate, and a value indicating whether it has
+// been picked already.
+func AdaptedInterval(name string) (interval int, found bool) {
+	adaptedIntervalsMutex.RLock()
+	defer adaptedIntervalsMutex.RUnlock()
+	interval, found = adaptedIntervals[name]
+	return
+}
+
+// SetAdaptedInterval remembers the slice interval picked for the metric.
+func SetAdaptedInterval(name string, interval int) {
+	adaptedIntervalsMutex.Lock()
+	defer adaptedIntervalsMutex.Unlock()
+	adaptedIntervals[name] = interval
+}
+
+// ResetAdaptedIntervals forgets intervals picked for all metrics.
+func ResetAdaptedIntervals() {
+	adaptedIntervalsMutex.Lock()
+	defer adaptedIntervalsMutex.Unlock()
+	adaptedIntervals = make(map[string]int)
+}
+
+// loadAdaptiveIntervals parses adaptive slice intervals from the config
+// file, returns them sorted in increasing order.
+func loadAdaptiveIntervals(items []interface{}) (intervals []int, err os.Error) {
+	intervals = make([]int, 0, len(items))
+	for _, item := range items {
+		interval := int(item.(float64))
+		if interval <= 0 {
+			return nil, os.NewError(fmt.Sprintf("Interval %d should be positive", interval))
+		}
+		intervals = append(intervals, interval)
+	}
+	sort.Ints(intervals)
+	return
+}
@@ -0,0 +1,21 @@
+package config
+
+var (
+	// Additional names of metrics, keyed by metric name. Events are copied
+	// to every alias on ingest, so aliases are aggregated separately, with
+	// their own per-metric options (see parser.Aliases).
+	Aliases map[string][]string
+)
+
+// loadAliases parses aliases from the config file: a map of metric names to
+// lists of their aliases.
+func loadAliases(items map[string]interface{}) map[string][]string {
+	aliases := make(map[string][]string, len(items))
+	for name, names := range items {
+		aliases[name] = make([]string, 0, len(names.([]interface{})))
+		for _, alias := range names.([]interface{}) {
+			aliases[name] = append(aliases[name], alias.(string))
+		}
+	}
+	return aliases
+}
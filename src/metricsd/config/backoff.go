@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"os"
+)
+
+// A BackoffConfig describes delays between reconnection attempts of network
+// outputs: the delay grows exponentially from Initial up to Max seconds,
+// and is randomly reduced by up to Jitter fraction of it, so instances
+// reconnecting at the same time spread out.
+type BackoffConfig struct {
+	Initial float64 // delay after the first failure, in seconds
+	Max     float64 // maximum delay, in seconds
+	Factor  float64 // multiplier applied to the delay after every failure
+	Jitter  float64 // fraction of the delay randomized, in [0, 1]
+}
+
+// Default reconnection backoff of network outputs.
+var DEFAULT_RECONNECT = &BackoffConfig{Initial: 0.5, Max: 30, Factor: 2, Jitter: 0.2}
+
+var (
+	// Reconnection backoff of network outputs (Graphite, InfluxDB)
+	Reconnect *BackoffConfig = DEFAULT_RECONNECT
+)
+
+func (backoff *BackoffConfig) String() string {
+	return fmt.Sprintf("%vs..%vs (factor=%v, jitter=%v)", backoff.Initial, backoff.Max, backoff.Factor, backoff.Jitter)
+}
+
+// loadBackoff parses backoff settings from the config file. Settings not
+// mentioned in the config file keep their default values.
+func loadBackoff(items map[string]interface{}) (backoff *BackoffConfig, err os.Error) {
+	backoff = &BackoffConfig{}
+	*backoff = *DEFAULT_RECONNECT
+	if initial, found := items["Initial"]; found {
+		backoff.Initial = initial.(float64)
+	}
+	if max, found := items["Max"]; found {
+		backoff.Max = max.(float64)
+	}
+	if factor, found := items["Factor"]; found {
+		backoff.Factor = factor.(float64)
+	}
+	if jitter, found := items["Jitter"]; found {
+		backoff.Jitter = jitter.(float64)
+	}
+
+	if backoff.Initial <= 0 || backoff.Max < backoff.Initial {
+		return nil, os.NewError(fmt.Sprintf("Delays should be positive, and Max should not be less than Initial: %s", backoff))
+	}
+	if backoff.Factor < 1 {
+		return nil, os.NewError(fmt.Sprintf("Factor should be at least 1: %s", backoff))
+	}
+	if backoff.Jitter < 0 || backoff.Jitter > 1 {
+		return nil, os.NewError(fmt.Sprintf("Jitter should be in [0, 1]: %s", backoff))
+	}
+	return
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"fmt"
+	"os"
+)
+
+// A BatchConfig describes batching of network outputs: lines are
+// accumulated and sent together once MaxSize bytes are collected, or
+// MaxDelay seconds after the first line of a partial batch.
+type BatchConfig struct {
+	MaxSize  int     // maximum size of a batch, in bytes (0 disables batching)
+	MaxDelay float64 // maximum delay of a partial batch, in seconds
+}
+
+// Default batching of network outputs (every line is sent immediately).
+var DEFAULT_OUTPUT_BATCH = &BatchConfig{MaxSize: 0, MaxDelay: 1}
+
+var (
+	// Batching of network outputs (Graphite, InfluxDB)
+	OutputBatch *BatchConfig = DEFAULT_OUTPUT_BATCH
+)
+
+func (batch *BatchConfig) String() string {
+	if batch.MaxSize == 0 {
+		return "disabled"
+	}
+	return fmt.Sprintf("%d bytes, %vs", batch.MaxSize, batch.MaxDelay)
+}
+
+// loadBatch parses batching settings from the config file. Settings not
+// mentioned in the config file keep their default values.
+func loadBatch(items map[string]interface{}) (batch *BatchConfig, err os.Error) {
+	batch = &BatchConfig{}
+	*batch = *DEFAULT_OUTPUT_BATCH
+	if maxSize, found := items["MaxSize"]; found {
+		batch.MaxSize = int(maxSize.(float64))
+	}
+	if maxDelay, found := items["MaxDelay"]; found {
+		batch.MaxDelay = maxDelay.(float64)
+	}
+
+	if batch.MaxSize < 0 {
+		return nil, os.NewError(fmt.Sprintf("MaxSize should not be negative: %d", batch.MaxSize))
+	}
+	if batch.MaxDelay <= 0 {
+		return nil, os.NewError(fmt.Sprintf("MaxDelay should be positive: %v", batch.MaxDelay))
+	}
+	return
+}
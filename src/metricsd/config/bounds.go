@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Unbounded minimum or maximum of RRD data sources.
+const BOUND_UNKNOWN = "U"
+
+// A Bounds holds the minimum and maximum values of data sources of RRD
+// files, values outside of them are stored as unknown by RRDTool. Both are
+// numbers or "U" (unbounded); empty bound keeps the writer's default.
+type Bounds struct {
+	Min string
+	Max string
+}
+
+// loadBounds parses data source bounds per writer name, e.g.
+// {"quartiles": {"Min": -100, "Max": "U"}}. Bounds should be numbers or
+// "U", the minimum should be less than the maximum.
+func loadBounds(items map[string]interface{}) (bounds map[string]*Bounds, err os.Error) {
+	bounds = make(map[string]*Bounds)
+	for writer, item := range items {
+		options, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, os.NewError(fmt.Sprintf("Bounds of %q should be an object with Min and Max", writer))
+		}
+		bound := &Bounds{}
+		if bound.Min, err = parseBound(options["Min"]); err != nil {
+			return nil, os.NewError(fmt.Sprintf("Min of %q is invalid: %s", writer, err))
+		}
+		if bound.Max, err = parseBound(options["Max"]); err != nil {
+			return nil, os.NewError(fmt.Sprintf("Max of %q is invalid: %s", writer, err))
+		}
+		if err = CheckBounds(bound.Min, bound.Max); err != nil {
+			return nil, os.NewError(fmt.Sprintf("Bounds of %q are invalid: %s", writer, err))
+		}
+		bounds[writer] = bound
+	}
+	return
+}
+
+// parseBound returns the bound in RRDTool format, empty string when it is
+// not specified.
+func parseBound(value interface{}) (string, os.Error) {
+	switch bound := value.(type) {
+	case nil:
+		return "", nil
+	case float64:
+		return strconv.Ftoa64(bound, 'g', -1), nil
+	case string:
+		if bound == BOUND_UNKNOWN {
+			return bound, nil
+		}
+		if _, err := strconv.Atof64(bound); err == nil {
+			return bound, nil
+		}
+	}
+	return "", os.NewError(fmt.Sprintf("number or %q expected, got %v", BOUND_UNKNOWN, value))
+}
+
+// CheckBounds returns an error when both bounds are numbers, and the
+// minimum is not less than the maximum.
+func CheckBounds(min, max string) os.Error {
+	low, err := strconv.Atof64(min)
+	if err != nil {
+		return nil
+	}
+	high, err := strconv.Atof64(max)
+	if err != nil {
+		return nil
+	}
+	if low >= high {
+		return os.NewError(fmt.Sprintf("minimum %s should be less than maximum %s", min, max))
+	}
+	return nil
+}
+
+func (bounds *Bounds) String() string {
+	return fmt.Sprintf("%s:%s", bounds.Min, bounds.Max)
+}
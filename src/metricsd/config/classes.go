@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// A ClassesConfig describes labeled classes of classes writer: values less
+// than or equal to Thresholds[i] (and greater than the previous threshold)
+// belong to Labels[i], values greater than all thresholds belong to the
+// last label. Values of classes with empty labels are not counted.
+type ClassesConfig struct {
+	Thresholds []int    // upper bounds of classes, in increasing order
+	Labels     []string // names of classes, one more than thresholds
+}
+
+// Default classes count positive and negative values, ignoring zeros, the
+// same way as count writer.
+var DEFAULT_CLASSES = &ClassesConfig{
+	Thresholds: []int{-1, 0},
+	Labels:     []string{"fail", "", "ok"},
+}
+
+var (
+	// Classes counted by classes writer
+	Classes *ClassesConfig = DEFAULT_CLASSES
+	// Labels of classes should be valid RRD data source names
+	classLabelRegexp = regexp.MustCompile("^[a-zA-Z0-9_]{1,19}$")
+)
+
+func (classes *ClassesConfig) String() string {
+	return fmt.Sprintf("%v (thresholds=%v)", classes.Labels, classes.Thresholds)
+}
+
+// Class returns the label of the class of the value, empty when the value
+// is not counted.
+func (classes *ClassesConfig) Class(value int) string {
+	for idx, threshold := range classes.Thresholds {
+		if value <= threshold {
+			return classes.Labels[idx]
+		}
+	}
+	return classes.Labels[len(classes.Thresholds)]
+}
+
+// CountedLabels returns non-empty labels in the order of classes.
+func (classes *ClassesConfig) CountedLabels() []string {
+	labels := make([]string, 0, len(classes.Labels))
+	for _, label := range classes.Labels {
+		if label != "" {
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
+
+// loadClasses parses classes of classes writer from the config file.
+func loadClasses(config map[string]interface{}) (classes *ClassesConfig, err os.Error) {
+	classes = &ClassesConfig{}
+	if thresholds, found := config["Thresholds"]; found {
+		for _, threshold := range thresholds.([]interface{}) {
+			classes.Thresholds = append(classes.Thresholds, (int)(threshold.(float64)))
+		}
+	}
+	if labels, found := config["Labels"]; found {
+		for _, label := range labels.([]interface{}) {
+			classes.Labels = append(classes.Labels, label.(string))
+		}
+	}
+	if len(classes.Labels) != len(classes.Thresholds)+1 {
+		return nil, os.NewError(fmt.Sprintf("%d labels are defined for %d thresholds, should be one more label than thresholds", len(classes.Labels), len(classes.Thresholds)))
+	}
+	for idx := 1; idx < len(classes.Thresholds); idx++ {
+		if classes.Thresholds[idx] <= classes.Thresholds[idx-1] {
+			return nil, os.NewError(fmt.Sprintf("Thresholds %v should be in increasing order", classes.Thresholds))
+		}
+	}
+	seen := make(map[string]bool)
+	for _, label := range classes.CountedLabels() {
+		if !classLabelRegexp.MatchString(label) {
+			return nil, os.NewError(fmt.Sprintf("Label %q is invalid, should be 1 to 19 letters, digits, or underscores", label))
+		}
+		if seen[label] {
+			return nil, os.NewError(fmt.Sprintf("Label %q is defined twice", label))
+		}
+		seen[label] = true
+	}
+	if len(seen) == 0 {
+		return nil, os.NewError("At least one label should not be empty")
+	}
+	return
+}
@@ -4,8 +4,8 @@ package config
 import (
 	"fmt"
 	"json"
-	"net"
 	"os"
+	"strings"
 	"metricsd/logger"
 )
 
@@ -17,39 +17,246 @@ const (
 	DEFAULT_SEVERITY           = logger.INFO
 	DEFAULT_SLICE_INTERVAL     = 10
 	DEFAULT_WRITE_INTERVAL     = 60
+	DEFAULT_WRITE_JITTER       = 0
+	DEFAULT_MIN_INTERVAL       = 0
+	DEFAULT_INTERVAL_POLICY    = INTERVAL_POLICY_REJECT
+	DEFAULT_FLUSH_SLICES       = 0
+	DEFAULT_STATE_TTL          = 0
+	DEFAULT_RECENT_ROLLUPS     = 0
+	MAX_RECENT_ROLLUPS         = 100
 	DEFAULT_RRD_UPDATE_THREADS = 1
+	DEFAULT_RRD_CREATE_LIMIT   = 0
+	DEFAULT_SAMPLE_RATE        = 1.0
+	DEFAULT_WRITE_RETRIES      = 0
+	DEFAULT_RETRY_QUEUE_SIZE   = 1000
+	DEFAULT_DEAD_LETTER_FILE   = ""
+	DEFAULT_SHUTDOWN_TIMEOUT   = 30
+	DEFAULT_STALL_TIMEOUT      = 300
+	DEFAULT_DEGRADED_AFTER     = 10
+	DEFAULT_DEGRADED_RETRY     = 60
 	DEFAULT_BATCH_WRITES       = false
+	DEFAULT_DRY_RUN            = false
+	DEFAULT_RECOVER_PANICS     = true
+	DEFAULT_DEDUP_RRD_UPDATES  = false
 	DEFAULT_LOOKUP_DNS         = false
+	DEFAULT_IMPORT_DEDUP       = false
+	DEFAULT_RECYCLE_SLICES     = false
+	DEFAULT_TIMELINE_SHARDS    = 1
+	DEFAULT_PERSIST_STATE      = false
+	DEFAULT_ATOMIC_COUNTERS    = false
+	DEFAULT_LATEST_GAUGES      = false
+	DEFAULT_INGEST_BUFFER_SIZE = 10000
+	DEFAULT_INTERN_LIMIT       = 100000
+	DEFAULT_MEMORY_LIMIT       = 0
+	DEFAULT_PRESSURE_VALUES    = 1000
+	DEFAULT_MAX_LINE_LENGTH    = 1024
+	DEFAULT_HEX_VALUES         = false
+	DEFAULT_INGEST_POLICY      = INGEST_POLICY_DROP
+	DEFAULT_COLLISION_POLICY   = COLLISION_POLICY_ERROR
+	DEFAULT_PARTIAL_POLICY     = PARTIAL_POLICY_KEEP
+	DEFAULT_CLOCK_POLICY       = CLOCK_POLICY_CLAMP
+	DEFAULT_WRITERS            = "count,quartiles,percentiles"
+	DEFAULT_PERCENTILE_METHOD  = PERCENTILE_NIST
+	DEFAULT_RESERVOIR_SIZE     = 1000
+	DEFAULT_SKETCH_ACCURACY    = 0.01
+	DEFAULT_TRIM_FRACTION      = 0.1
+	DEFAULT_SET_EXACT_LIMIT    = 10000
+	DEFAULT_GRAPHITE_ADDRESS   = ""
+	DEFAULT_GRAPHITE_PREFIX    = ""
+	DEFAULT_GRAPHITE_SUFFIX    = ""
+	DEFAULT_INFLUX_ADDRESS     = ""
+	DEFAULT_HASH_REPLICAS      = 100
+	DEFAULT_DEBUG_FILE         = ""
+	DEFAULT_RRDTOOL_PATH       = "/usr/bin/rrdtool"
+	DEFAULT_RRD_RESTORE_DIR    = ""
+	DEFAULT_ADMIN_TOKEN        = ""
+	DEFAULT_DEBUG_FORMAT       = DEBUG_FORMAT_TEXT
+	DEFAULT_SNAPSHOT_FORMAT    = SNAPSHOT_FORMAT_JSON
+)
+
+// Default upper bounds of histogram writer buckets.
+var DEFAULT_HISTOGRAM_BUCKETS = []int{10, 50, 100, 500, 1000, 5000}
+
+// Default quantiles calculated by sketch writer.
+var DEFAULT_SKETCH_QUANTILES = []float64{0.5, 0.9, 0.95, 0.99}
+
+// Compression of data received by stream (TCP, Unix) listeners.
+const (
+	COMPRESSION_NONE = ""     // data is not compressed
+	COMPRESSION_GZIP = "gzip" // every connection is a gzip stream
+	COMPRESSION_AUTO = "auto" // gzip streams are detected by the magic bytes
+)
+
+// A ListenerConfig describes a single network listener.
+type ListenerConfig struct {
+	Protocol    string            // "udp", "tcp", or "unix"
+	Address     string            // address (or socket path for "unix") to listen at
+	Parser      string            // name of the protocol parser ("metricsd", "statsd", or "graphite")
+	Compression string            // compression of stream connections ("", "gzip", or "auto")
+	Tags        map[string]string // static tags added to every received event, winning over tags sent by producers
+}
+
+func (listener *ListenerConfig) String() string {
+	options := listener.Parser
+	if listener.Compression != COMPRESSION_NONE {
+		options += ", " + listener.Compression
+	}
+	if len(listener.Tags) > 0 {
+		options += fmt.Sprintf(", tags %v", listener.Tags)
+	}
+	return fmt.Sprintf("%s://%s (%s)", listener.Protocol, listener.Address, options)
+}
+
+// A RelabelConfig describes a rule renaming or dropping metrics on ingest
+// (see parser.RelabelRule).
+type RelabelConfig struct {
+	Match       string // regular expression matching the whole metric name
+	Replacement string // new name, $1...$9 are replaced with submatches
+	Drop        bool   // drop matching metrics instead of renaming
+}
+
+func (relabel *RelabelConfig) String() string {
+	if relabel.Drop {
+		return fmt.Sprintf("%q (drop)", relabel.Match)
+	}
+	return fmt.Sprintf("%q -> %q", relabel.Match, relabel.Replacement)
+}
+
+// Policies applied to slice and write intervals shorter than MinInterval.
+const (
+	INTERVAL_POLICY_REJECT = "reject" // refuse to start
+	INTERVAL_POLICY_ROUND  = "round"  // use MinInterval instead, and log a warning
+)
+
+// Policies applied when the ingestion queue is full.
+const (
+	INGEST_POLICY_DROP  = "drop"  // drop incoming event and count it
+	INGEST_POLICY_BLOCK = "block" // wait until there is a room in the queue
+)
+
+// Policies applied when different series or writers would write the same
+// RRD file.
+const (
+	COLLISION_POLICY_ERROR  = "error"  // reject colliding writers on startup, drop updates of colliding series
+	COLLISION_POLICY_RENAME = "rename" // ignore writers listed twice, write colliding series to files with a hash suffix
+)
+
+// Policies applied to the slice in progress, when slices are flushed
+// before its end (on shutdown or SIGHUP).
+const (
+	PARTIAL_POLICY_KEEP = "keep" // write the slice as any other
+	PARTIAL_POLICY_DROP = "drop" // discard the slice
+	PARTIAL_POLICY_MARK = "mark" // tag its series with partial="true", skip RRD files
+)
+
+// Policies applied when the clock steps back behind the latest slice of a
+// timeline (e.g. NTP correction).
+const (
+	CLOCK_POLICY_CLAMP  = "clamp"  // add events to the latest slice until the clock catches up
+	CLOCK_POLICY_FOLLOW = "follow" // add events to slices of the clock, only count the step
+)
+
+// Methods of percentile calculation, for N values sorted in increasing
+// order (see writers.Percentiles).
+const (
+	PERCENTILE_NIST    = "nist"    // interpolation at rank p(N+1), NIST recommended method
+	PERCENTILE_NEAREST = "nearest" // value at rank ceil(pN), no interpolation
+	PERCENTILE_LINEAR  = "linear"  // interpolation at rank 1+p(N-1) (Excel, NumPy default)
+	PERCENTILE_LOWER   = "lower"   // value at rank 1+floor(p(N-1))
+	PERCENTILE_HIGHER  = "higher"  // value at rank 1+ceil(p(N-1))
+)
+
+// Formats of timeline snapshots (see SIGUSR1).
+const (
+	SNAPSHOT_FORMAT_JSON = "json" // readable, for debugging
+	SNAPSHOT_FORMAT_GOB  = "gob"  // compact and fast, for warm restarts
 )
 
 var (
-	Listen           string        = DEFAULT_LISTEN             // port and address to listen at
-	DataDir          string        = DEFAULT_DATA_DIR           // data directory
-	RootDir          string        = DEFAULT_ROOT_DIR           // root directory
-	LogLevel         int           = int(DEFAULT_SEVERITY)      // debug level, the lower - the more verbose (0-5)
-	SliceInterval    int           = DEFAULT_SLICE_INTERVAL     // slice interval in seconds
-	WriteInterval    int           = DEFAULT_WRITE_INTERVAL     // write interval in seconds
-	RrdUpdateThreads int           = DEFAULT_RRD_UPDATE_THREADS // number of RRD update threads
-	BatchWrites      bool          = DEFAULT_BATCH_WRITES       // value indicating whether batch RRD updates should be used
-	LookupDns        bool          = DEFAULT_LOOKUP_DNS         // value indicating whether reverse DNS lookup should be performed for sources
-	UDPAddress       *net.UDPAddr                               // address to listen at (for internal usage)
-	Logger           logger.Logger                              // logger instance
+	Listen           string            = DEFAULT_LISTEN                      // port and address to listen at
+	DataDir          string            = DEFAULT_DATA_DIR                    // data directory
+	RootDir          string            = DEFAULT_ROOT_DIR                    // root directory
+	LogLevel         int               = int(DEFAULT_SEVERITY)               // debug level, the lower - the more verbose (0-5)
+	SliceInterval    int               = DEFAULT_SLICE_INTERVAL              // slice interval in seconds
+	WriteInterval    int               = DEFAULT_WRITE_INTERVAL              // write interval in seconds
+	MinInterval      int               = DEFAULT_MIN_INTERVAL                // shortest allowed slice and write interval in seconds (0 means no limit)
+	IntervalPolicy   string            = DEFAULT_INTERVAL_POLICY             // what to do with intervals shorter than MinInterval ("reject" or "round")
+	WriteJitter      int               = DEFAULT_WRITE_JITTER                // maximum random delay of writes in seconds (at most half of write interval)
+	FlushSlices      int               = DEFAULT_FLUSH_SLICES                // number of closed slices triggering writes before write interval elapses (0 means disabled)
+	StateTTL         int               = DEFAULT_STATE_TTL                   // number of slice intervals after which state of absent metrics is forgotten (0 means never)
+	RecentRollups    int               = DEFAULT_RECENT_ROLLUPS              // number of the most recent rollups per series kept in memory for exports (0 means only the latest)
+	RrdUpdateThreads int               = DEFAULT_RRD_UPDATE_THREADS          // number of RRD update threads
+	RrdCreateLimit   int               = DEFAULT_RRD_CREATE_LIMIT            // maximum number of RRD files created concurrently, others are queued (0 means no limit)
+	WriteRetries     int               = DEFAULT_WRITE_RETRIES               // number of retries of failed RRD updates (on the next writes)
+	RetryQueueSize   int               = DEFAULT_RETRY_QUEUE_SIZE            // maximum number of failed RRD updates waiting for retries
+	DeadLetterFile   string            = DEFAULT_DEAD_LETTER_FILE            // path to the file receiving RRD updates given up on (dropped if empty)
+	ShutdownTimeout  int               = DEFAULT_SHUTDOWN_TIMEOUT            // maximum time in seconds to wait for writes on shutdown
+	StallTimeout     int               = DEFAULT_STALL_TIMEOUT               // time in seconds without completed writes after which MetricsD is reported unhealthy
+	DegradedAfter    int               = DEFAULT_DEGRADED_AFTER              // number of consecutive storage errors of RRD updates entering degraded mode (0 means disabled)
+	DegradedRetry    int               = DEFAULT_DEGRADED_RETRY              // time in seconds between attempts to update RRD files in degraded mode
+	BatchWrites      bool              = DEFAULT_BATCH_WRITES                // value indicating whether batch RRD updates should be used
+	DryRun           bool              = DEFAULT_DRY_RUN                     // value indicating whether rollups should be computed, but not written anywhere
+	RecoverPanics    bool              = DEFAULT_RECOVER_PANICS              // value indicating whether panics of writers should be logged and counted instead of crashing
+	DedupRrdUpdates  bool              = DEFAULT_DEDUP_RRD_UPDATES           // value indicating whether RRD updates not newer than the last update of the file should be skipped
+	ImportDedup      bool              = DEFAULT_IMPORT_DEDUP                // value indicating whether exact duplicates of imported records should be dropped
+	RecycleSlices    bool              = DEFAULT_RECYCLE_SLICES              // value indicating whether extracted slices and sample sets should be reused
+	TimelineShards   int               = DEFAULT_TIMELINE_SHARDS             // number of shards of every timeline receiving events concurrently
+	PersistState     bool              = DEFAULT_PERSIST_STATE               // value indicating whether writer state should be saved on shutdown and restored on startup
+	LookupDns        bool              = DEFAULT_LOOKUP_DNS                  // value indicating whether reverse DNS lookup should be performed for sources
+	MaxLineLength    int               = DEFAULT_MAX_LINE_LENGTH             // maximum length of a line (TCP, Unix) or a packet (UDP) in bytes
+	HexValues        bool              = DEFAULT_HEX_VALUES                  // value indicating whether hexadecimal values with "0x" prefix are accepted
+	SampleRate       float64           = DEFAULT_SAMPLE_RATE                 // fraction of events kept by global sampling at ingest, counters are scaled up by 1/rate (1 means disabled)
+	IngestBufferSize int               = DEFAULT_INGEST_BUFFER_SIZE          // size of the queue between network listener and timeline
+	InternLimit      int               = DEFAULT_INTERN_LIMIT                // maximum number of interned sample set keys, i.e. distinct metrics per source (0 means disabled)
+	MemoryLimit      int               = DEFAULT_MEMORY_LIMIT                // heap usage in KB engaging memory pressure mode (0 means disabled)
+	PressureValues   int               = DEFAULT_PRESSURE_VALUES             // maximum number of values per sample set in memory pressure mode
+	IngestPolicy     string            = DEFAULT_INGEST_POLICY               // what to do when ingestion queue is full ("drop" or "block")
+	CollisionPolicy  string            = DEFAULT_COLLISION_POLICY            // what to do when series or writers would write the same RRD file ("error" or "rename")
+	PartialPolicy    string            = DEFAULT_PARTIAL_POLICY              // what to do with the slice in progress on forced flushes ("keep", "drop", or "mark")
+	ClockPolicy      string            = DEFAULT_CLOCK_POLICY                // what to do when the clock steps backwards ("clamp" or "follow")
+	Writers          []string          = strings.Split(DEFAULT_WRITERS, ",") // names of active writers
+	Listeners        []*ListenerConfig                                       // network listeners (UDP listener on Listen address if empty)
+	NameTemplates    []string                                                // templates extracting tags from metric names (see parser.NameTemplate)
+	Relabel          []*RelabelConfig                                        // rules renaming or dropping metrics on ingest, the first matching rule wins
+	HistogramBuckets []int             = DEFAULT_HISTOGRAM_BUCKETS           // upper bounds of histogram writer buckets, in increasing order
+	PercentileMethod string            = DEFAULT_PERCENTILE_METHOD           // method of percentile calculation ("nist", "nearest", "linear", "lower", or "higher")
+	ReservoirSize    int               = DEFAULT_RESERVOIR_SIZE              // number of values sampled by reservoir writer
+	SketchAccuracy   float64           = DEFAULT_SKETCH_ACCURACY             // relative accuracy of quantiles calculated by sketch writer
+	TrimFraction     float64           = DEFAULT_TRIM_FRACTION               // fraction of values discarded from each tail by trimmed_mean writer
+	SetExactLimit    int               = DEFAULT_SET_EXACT_LIMIT             // number of unique values counted exactly by set writer, estimated above it
+	SketchQuantiles  []float64         = DEFAULT_SKETCH_QUANTILES            // quantiles calculated by sketch writer, each in (0, 1]
+	GraphiteAddress  string            = DEFAULT_GRAPHITE_ADDRESS            // comma-separated addresses of Carbon plaintext listeners to forward rollups to (disabled if empty)
+	GraphitePrefix   string            = DEFAULT_GRAPHITE_PREFIX             // prefix of Graphite metric paths
+	GraphiteSuffix   string            = DEFAULT_GRAPHITE_SUFFIX             // suffix of Graphite metric names ("{writer}" is replaced with writer name)
+	InfluxAddress    string            = DEFAULT_INFLUX_ADDRESS              // comma-separated addresses of InfluxDB UDP listeners to forward rollups to (disabled if empty)
+	HashReplicas     int               = DEFAULT_HASH_REPLICAS               // number of points of every Graphite or InfluxDB address on the consistent hash ring
+	RrdtoolPath      string            = DEFAULT_RRDTOOL_PATH                // path to rrdtool binary used to render graphs
+	RrdtoolArgs      []string                                                // extra arguments passed to rrdtool graph
+	RrdRestoreDir    string            = DEFAULT_RRD_RESTORE_DIR             // directory with XML dumps of RRD files restored on startup (disabled if empty)
+	AdminToken       string            = DEFAULT_ADMIN_TOKEN                 // token required by RRD dump and restore endpoints (disabled if empty)
+	DebugFile        string            = DEFAULT_DEBUG_FILE                  // path to the file receiving rollups of "file" output
+	DebugFormat      string            = DEFAULT_DEBUG_FORMAT                // format of rollups in "stdout" and "file" outputs ("text" or "json")
+	SnapshotFormat   string            = DEFAULT_SNAPSHOT_FORMAT             // format of timeline snapshots ("json" or "gob")
+	Logger           logger.Logger                                           // logger instance
 )
 
-// Load loads configuration from a JSON file.
+// Load loads configuration from a JSON file, overridden with environment
+// variables (see EnvName). The file is optional when all settings are
+// defined in the environment.
 func Load(path string) {
-	file, error := os.Open(path)
-	if error != nil {
+	config := make(map[string]interface{})
+	if file, error := os.Open(path); error != nil {
 		fmt.Printf("Config file does not exist or failed to read the file: %s. Original error: %s\n", path, error)
-		return
+	} else {
+		defer file.Close()
+		decoder := json.NewDecoder(file)
+		if error = decoder.Decode(&config); error != nil {
+			fmt.Printf("Failed to parse config file: %s\n", error)
+			os.Exit(1)
+		}
 	}
-	defer file.Close()
-
-	config := make(map[string]interface{})
-	decoder := json.NewDecoder(file)
-	error = decoder.Decode(&config)
-	if error != nil {
-		fmt.Printf("Failed to parse config file: %s\n", error)
+	if error := overlayEnvironment(config, os.Environ()); error != nil {
+		fmt.Printf("Failed to parse environment: %s\n", error)
 		os.Exit(1)
 	}
 
@@ -68,29 +275,594 @@ func Load(path string) {
 	if writeInterval, found := config["WriteInterval"]; found {
 		WriteInterval = (int)(writeInterval.(float64))
 	}
+	if minInterval, found := config["MinInterval"]; found {
+		MinInterval = (int)(minInterval.(float64))
+	}
+	if intervalPolicy, found := config["IntervalPolicy"]; found {
+		IntervalPolicy = intervalPolicy.(string)
+	}
+	if writeJitter, found := config["WriteJitter"]; found {
+		WriteJitter = (int)(writeJitter.(float64))
+	}
+	if flushSlices, found := config["FlushSlices"]; found {
+		FlushSlices = (int)(flushSlices.(float64))
+	}
+	if stateTTL, found := config["StateTTL"]; found {
+		StateTTL = (int)(stateTTL.(float64))
+	}
+	if recentRollups, found := config["RecentRollups"]; found {
+		RecentRollups = (int)(recentRollups.(float64))
+	}
 	if rrdUpdateThreads, found := config["RrdUpdateThreads"]; found {
 		RrdUpdateThreads = (int)(rrdUpdateThreads.(float64))
 	}
+	if rrdCreateLimit, found := config["RrdCreateLimit"]; found {
+		RrdCreateLimit = (int)(rrdCreateLimit.(float64))
+	}
+	if writeRetries, found := config["WriteRetries"]; found {
+		WriteRetries = (int)(writeRetries.(float64))
+	}
+	if retryQueueSize, found := config["RetryQueueSize"]; found {
+		RetryQueueSize = (int)(retryQueueSize.(float64))
+	}
+	if deadLetterFile, found := config["DeadLetterFile"]; found {
+		DeadLetterFile = deadLetterFile.(string)
+	}
+	if shutdownTimeout, found := config["ShutdownTimeout"]; found {
+		ShutdownTimeout = (int)(shutdownTimeout.(float64))
+	}
+	if stallTimeout, found := config["StallTimeout"]; found {
+		StallTimeout = (int)(stallTimeout.(float64))
+	}
+	if degradedAfter, found := config["DegradedAfter"]; found {
+		DegradedAfter = (int)(degradedAfter.(float64))
+	}
+	if degradedRetry, found := config["DegradedRetry"]; found {
+		DegradedRetry = (int)(degradedRetry.(float64))
+	}
 	if batchWrites, found := config["BatchWrites"]; found {
 		BatchWrites = batchWrites.(bool)
 	}
+	if dryRun, found := config["DryRun"]; found {
+		DryRun = dryRun.(bool)
+	}
+	if recoverPanics, found := config["RecoverPanics"]; found {
+		RecoverPanics = recoverPanics.(bool)
+	}
+	if dedupRrdUpdates, found := config["DedupRrdUpdates"]; found {
+		DedupRrdUpdates = dedupRrdUpdates.(bool)
+	}
+	if importDedup, found := config["ImportDedup"]; found {
+		ImportDedup = importDedup.(bool)
+	}
+	if recycleSlices, found := config["RecycleSlices"]; found {
+		RecycleSlices = recycleSlices.(bool)
+	}
+	if timelineShards, found := config["TimelineShards"]; found {
+		TimelineShards = (int)(timelineShards.(float64))
+	}
+	if persistState, found := config["PersistState"]; found {
+		PersistState = persistState.(bool)
+	}
 	if lookupDns, found := config["LookupDns"]; found {
 		LookupDns = lookupDns.(bool)
 	}
+	if maxLineLength, found := config["MaxLineLength"]; found {
+		MaxLineLength = (int)(maxLineLength.(float64))
+	}
+	if hexValues, found := config["HexValues"]; found {
+		HexValues = hexValues.(bool)
+	}
+	if sampleRate, found := config["SampleRate"]; found {
+		SampleRate = sampleRate.(float64)
+	}
+	if ingestBufferSize, found := config["IngestBufferSize"]; found {
+		IngestBufferSize = (int)(ingestBufferSize.(float64))
+	}
+	if internLimit, found := config["InternLimit"]; found {
+		InternLimit = (int)(internLimit.(float64))
+	}
+	if memoryLimit, found := config["MemoryLimit"]; found {
+		MemoryLimit = (int)(memoryLimit.(float64))
+	}
+	if pressureValues, found := config["PressureValues"]; found {
+		PressureValues = (int)(pressureValues.(float64))
+	}
+	if ingestPolicy, found := config["IngestPolicy"]; found {
+		IngestPolicy = ingestPolicy.(string)
+	}
+	if collisionPolicy, found := config["CollisionPolicy"]; found {
+		CollisionPolicy = collisionPolicy.(string)
+	}
+	if partialPolicy, found := config["PartialPolicy"]; found {
+		PartialPolicy = partialPolicy.(string)
+	}
+	if clockPolicy, found := config["ClockPolicy"]; found {
+		ClockPolicy = clockPolicy.(string)
+	}
+	if writers, found := config["Writers"]; found {
+		Writers = make([]string, 0, len(writers.([]interface{})))
+		for _, writer := range writers.([]interface{}) {
+			Writers = append(Writers, writer.(string))
+		}
+	}
+	if writers, found := config["SampleCountWriters"]; found {
+		SampleCountWriters = loadStrings(writers.([]interface{}))
+	}
+	if templates, found := config["NameTemplates"]; found {
+		NameTemplates = make([]string, 0, len(templates.([]interface{})))
+		for _, template := range templates.([]interface{}) {
+			NameTemplates = append(NameTemplates, template.(string))
+		}
+	}
+	if relabel, found := config["Relabel"]; found {
+		Relabel = make([]*RelabelConfig, 0, len(relabel.([]interface{})))
+		for _, item := range relabel.([]interface{}) {
+			rule := item.(map[string]interface{})
+			loaded := &RelabelConfig{Match: rule["Match"].(string)}
+			if replacement, found := rule["Replacement"]; found {
+				loaded.Replacement = replacement.(string)
+			}
+			if drop, found := rule["Drop"]; found {
+				loaded.Drop = drop.(bool)
+			}
+			Relabel = append(Relabel, loaded)
+		}
+	}
+	if aliases, found := config["Aliases"]; found {
+		Aliases = loadAliases(aliases.(map[string]interface{}))
+	}
+	if buckets, found := config["HistogramBuckets"]; found {
+		HistogramBuckets = make([]int, 0, len(buckets.([]interface{})))
+		for _, bucket := range buckets.([]interface{}) {
+			HistogramBuckets = append(HistogramBuckets, (int)(bucket.(float64)))
+		}
+	}
+	if method, found := config["PercentileMethod"]; found {
+		PercentileMethod = method.(string)
+	}
+	if reservoirSize, found := config["ReservoirSize"]; found {
+		ReservoirSize = (int)(reservoirSize.(float64))
+	}
+	if sketchAccuracy, found := config["SketchAccuracy"]; found {
+		SketchAccuracy = sketchAccuracy.(float64)
+	}
+	if trimFraction, found := config["TrimFraction"]; found {
+		TrimFraction = trimFraction.(float64)
+	}
+	if setExactLimit, found := config["SetExactLimit"]; found {
+		SetExactLimit = (int)(setExactLimit.(float64))
+	}
+	if quantiles, found := config["SketchQuantiles"]; found {
+		SketchQuantiles = make([]float64, 0, len(quantiles.([]interface{})))
+		for _, quantile := range quantiles.([]interface{}) {
+			SketchQuantiles = append(SketchQuantiles, quantile.(float64))
+		}
+	}
+	if graphiteAddress, found := config["GraphiteAddress"]; found {
+		GraphiteAddress = graphiteAddress.(string)
+	}
+	if graphitePrefix, found := config["GraphitePrefix"]; found {
+		GraphitePrefix = graphitePrefix.(string)
+	}
+	if graphiteSuffix, found := config["GraphiteSuffix"]; found {
+		GraphiteSuffix = graphiteSuffix.(string)
+	}
+	if influxAddress, found := config["InfluxAddress"]; found {
+		InfluxAddress = influxAddress.(string)
+	}
+	if hashReplicas, found := config["HashReplicas"]; found {
+		HashReplicas = (int)(hashReplicas.(float64))
+	}
+	if rrdRestoreDir, found := config["RrdRestoreDir"]; found {
+		RrdRestoreDir = rrdRestoreDir.(string)
+	}
+	if adminToken, found := config["AdminToken"]; found {
+		AdminToken = adminToken.(string)
+	}
+	if rrdtoolPath, found := config["RrdtoolPath"]; found {
+		RrdtoolPath = rrdtoolPath.(string)
+	}
+	if args, found := config["RrdtoolArgs"]; found {
+		RrdtoolArgs = make([]string, 0, len(args.([]interface{})))
+		for _, arg := range args.([]interface{}) {
+			RrdtoolArgs = append(RrdtoolArgs, arg.(string))
+		}
+	}
+	if debugFile, found := config["DebugFile"]; found {
+		DebugFile = debugFile.(string)
+	}
+	if debugFormat, found := config["DebugFormat"]; found {
+		DebugFormat = debugFormat.(string)
+		if DebugFormat != DEBUG_FORMAT_TEXT && DebugFormat != DEBUG_FORMAT_JSON {
+			fmt.Printf("Debug format %q is invalid, should be one of: %s, %s\n", DebugFormat, DEBUG_FORMAT_TEXT, DEBUG_FORMAT_JSON)
+			os.Exit(1)
+		}
+	}
+	if snapshotFormat, found := config["SnapshotFormat"]; found {
+		SnapshotFormat = snapshotFormat.(string)
+		if SnapshotFormat != SNAPSHOT_FORMAT_JSON && SnapshotFormat != SNAPSHOT_FORMAT_GOB {
+			fmt.Printf("Snapshot format %q is invalid, should be one of: %s, %s\n", SnapshotFormat, SNAPSHOT_FORMAT_JSON, SNAPSHOT_FORMAT_GOB)
+			os.Exit(1)
+		}
+	}
+	if outputs, found := config["Outputs"]; found {
+		loaded, error := loadOutputs(outputs.(map[string]interface{}))
+		if error != nil {
+			fmt.Printf("Failed to parse outputs settings: %s\n", error)
+			os.Exit(1)
+		}
+		Outputs = loaded
+	}
+	if kafka, found := config["Kafka"]; found {
+		loaded, error := loadKafka(kafka.(map[string]interface{}))
+		if error != nil {
+			fmt.Printf("Failed to parse Kafka settings: %s\n", error)
+			os.Exit(1)
+		}
+		Kafka = loaded
+	}
+	if reconnect, found := config["Reconnect"]; found {
+		loaded, error := loadBackoff(reconnect.(map[string]interface{}))
+		if error != nil {
+			fmt.Printf("Failed to parse reconnect settings: %s\n", error)
+			os.Exit(1)
+		}
+		Reconnect = loaded
+	}
+	if outputBatch, found := config["OutputBatch"]; found {
+		loaded, error := loadBatch(outputBatch.(map[string]interface{}))
+		if error != nil {
+			fmt.Printf("Failed to parse output batch settings: %s\n", error)
+			os.Exit(1)
+		}
+		OutputBatch = loaded
+	}
+	if launch, found := config["LaunchCapture"]; found {
+		loaded, error := loadLaunch(launch.(map[string]interface{}))
+		if error != nil {
+			fmt.Printf("Failed to parse launch capture settings: %s\n", error)
+			os.Exit(1)
+		}
+		LaunchCapture = loaded
+	}
+	if summary, found := config["Summary"]; found {
+		loaded, error := loadSummary(summary.(map[string]interface{}))
+		if error != nil {
+			fmt.Printf("Failed to parse summary settings: %s\n", error)
+			os.Exit(1)
+		}
+		Summary = loaded
+	}
+	if classes, found := config["Classes"]; found {
+		loaded, error := loadClasses(classes.(map[string]interface{}))
+		if error != nil {
+			fmt.Printf("Failed to parse classes settings: %s\n", error)
+			os.Exit(1)
+		}
+		Classes = loaded
+	}
+	if rateLimit, found := config["RateLimit"]; found {
+		loaded, error := loadRateLimit(rateLimit.(map[string]interface{}))
+		if error != nil {
+			fmt.Printf("Failed to parse rate limit settings: %s\n", error)
+			os.Exit(1)
+		}
+		RateLimit = loaded
+	}
+	if unknownValues, found := config["UnknownValues"]; found {
+		loaded, error := loadUnknownValues(unknownValues.(map[string]interface{}))
+		if error != nil {
+			fmt.Printf("Failed to parse unknown values settings: %s\n", error)
+			os.Exit(1)
+		}
+		UnknownValues = loaded
+	}
+	if exportArchives, found := config["ExportArchives"]; found {
+		loaded, error := loadExportArchives(exportArchives.(map[string]interface{}))
+		if error != nil {
+			fmt.Printf("Failed to parse export archives settings: %s\n", error)
+			os.Exit(1)
+		}
+		ExportArchives = loaded
+	}
+	if minSamples, found := config["MinSamples"]; found {
+		loaded, error := loadMinSamples(minSamples.(map[string]interface{}))
+		if error != nil {
+			fmt.Printf("Failed to parse minimum samples settings: %s\n", error)
+			os.Exit(1)
+		}
+		MinSamples = loaded
+	}
+	if negativeValues, found := config["NegativeValues"]; found {
+		loaded, error := loadNegativeValues(negativeValues.(map[string]interface{}))
+		if error != nil {
+			fmt.Printf("Failed to parse negative values settings: %s\n", error)
+			os.Exit(1)
+		}
+		NegativeValues = loaded
+	}
+	if typeWriters, found := config["TypeWriters"]; found {
+		TypeWriters = loadTypeWriters(typeWriters.(map[string]interface{}))
+	}
+	if writers, found := config["UnknownTypeWriters"]; found {
+		UnknownTypeWriters = loadStrings(writers.([]interface{}))
+	}
+	if atomicCounters, found := config["AtomicCounters"]; found {
+		AtomicCounters = atomicCounters.(bool)
+	}
+	if latestGauges, found := config["LatestGauges"]; found {
+		LatestGauges = latestGauges.(bool)
+	}
+	if metrics, found := config["Metrics"]; found {
+		loaded, error := loadMetrics(metrics.([]interface{}))
+		if error != nil {
+			fmt.Printf("Failed to parse metrics settings: %s\n", error)
+			os.Exit(1)
+		}
+		SetMetrics(loaded)
+	}
+	if tagWriters, found := config["TagWriters"]; found {
+		loaded, error := loadTagWriters(tagWriters.([]interface{}))
+		if error != nil {
+			fmt.Printf("Failed to parse tag writers settings: %s\n", error)
+			os.Exit(1)
+		}
+		TagWriters = loaded
+	}
+	if timelines, found := config["Timelines"]; found {
+		loaded, error := loadTimelines(timelines.([]interface{}))
+		if error != nil {
+			fmt.Printf("Failed to parse timelines settings: %s\n", error)
+			os.Exit(1)
+		}
+		Timelines = loaded
+	}
+	if adaptiveIntervals, found := config["AdaptiveIntervals"]; found {
+		loaded, error := loadAdaptiveIntervals(adaptiveIntervals.([]interface{}))
+		if error != nil {
+			fmt.Printf("Failed to parse adaptive intervals settings: %s\n", error)
+			os.Exit(1)
+		}
+		AdaptiveIntervals = loaded
+	}
+	if listeners, found := config["Listeners"]; found {
+		Listeners = make([]*ListenerConfig, 0, len(listeners.([]interface{})))
+		for _, item := range listeners.([]interface{}) {
+			listener := item.(map[string]interface{})
+			loaded := &ListenerConfig{
+				Protocol: listener["Protocol"].(string),
+				Address:  listener["Address"].(string),
+				Parser:   listener["Parser"].(string),
+			}
+			if compression, found := listener["Compression"]; found {
+				loaded.Compression = compression.(string)
+			}
+			if tags, found := listener["Tags"]; found {
+				loaded.Tags = make(map[string]string)
+				for key, value := range tags.(map[string]interface{}) {
+					loaded.Tags[key] = value.(string)
+				}
+			}
+			Listeners = append(Listeners, loaded)
+		}
+	}
+}
+
+// GetListeners returns configured network listeners, or the default UDP
+// listener on the Listen address when no listeners configured.
+func GetListeners() []*ListenerConfig {
+	if len(Listeners) > 0 {
+		return Listeners
+	}
+	return []*ListenerConfig{&ListenerConfig{Protocol: "udp", Address: Listen, Parser: "metricsd"}}
+}
+
+// Validate returns an error when settings are inconsistent or out of range.
+// Settings parsed from the config file are validated by Load, Validate
+// checks the result of command line overrides as well.
+func Validate() os.Error {
+	switch {
+	case SliceInterval <= 0:
+		return os.NewError(fmt.Sprintf("Slice interval %d should be positive", SliceInterval))
+	case WriteInterval <= 0:
+		return os.NewError(fmt.Sprintf("Write interval %d should be positive", WriteInterval))
+	case MinInterval < 0:
+		return os.NewError(fmt.Sprintf("Min interval %d should not be negative", MinInterval))
+	case IntervalPolicy != INTERVAL_POLICY_REJECT && IntervalPolicy != INTERVAL_POLICY_ROUND:
+		return os.NewError(fmt.Sprintf("Unknown interval policy %q, should be one of: %s, %s", IntervalPolicy, INTERVAL_POLICY_REJECT, INTERVAL_POLICY_ROUND))
+	case RrdUpdateThreads <= 0:
+		return os.NewError(fmt.Sprintf("Number of RRD update threads %d should be positive", RrdUpdateThreads))
+	case RecentRollups < 0 || RecentRollups > MAX_RECENT_ROLLUPS:
+		return os.NewError(fmt.Sprintf("Number of recent rollups %d should be between 0 and %d", RecentRollups, MAX_RECENT_ROLLUPS))
+	case RrdCreateLimit < 0:
+		return os.NewError(fmt.Sprintf("RRD create limit %d should not be negative", RrdCreateLimit))
+	case HashReplicas <= 0:
+		return os.NewError(fmt.Sprintf("Number of hash replicas %d should be positive", HashReplicas))
+	case LaunchCapture.Enabled() && LaunchCapture.Interval >= SliceInterval:
+		return os.NewError(fmt.Sprintf("Launch capture interval %d should be shorter than slice interval %d", LaunchCapture.Interval, SliceInterval))
+	case RetryQueueSize <= 0:
+		return os.NewError(fmt.Sprintf("Retry queue size %d should be positive", RetryQueueSize))
+	case InternLimit < 0:
+		return os.NewError(fmt.Sprintf("Intern limit %d should not be negative", InternLimit))
+	case MemoryLimit < 0:
+		return os.NewError(fmt.Sprintf("Memory limit %d should not be negative", MemoryLimit))
+	case TrimFraction < 0 || TrimFraction > 0.5:
+		return os.NewError(fmt.Sprintf("Trim fraction %v should be between 0 and 0.5", TrimFraction))
+	case SetExactLimit < 0:
+		return os.NewError(fmt.Sprintf("Set exact limit %d should not be negative", SetExactLimit))
+	case PressureValues <= 0:
+		return os.NewError(fmt.Sprintf("Pressure values %d should be positive", PressureValues))
+	case SampleRate <= 0 || SampleRate > 1:
+		return os.NewError(fmt.Sprintf("Sample rate %v should be greater than 0 and at most 1", SampleRate))
+	case IngestBufferSize < 0:
+		return os.NewError(fmt.Sprintf("Ingest buffer size %d should not be negative", IngestBufferSize))
+	case TimelineShards < 1:
+		return os.NewError(fmt.Sprintf("Number of timeline shards %d should be positive", TimelineShards))
+	case DegradedAfter < 0:
+		return os.NewError(fmt.Sprintf("Number of errors entering degraded mode %d should not be negative", DegradedAfter))
+	case DegradedRetry <= 0:
+		return os.NewError(fmt.Sprintf("Degraded mode retry interval %d should be positive", DegradedRetry))
+	case MaxLineLength <= 0:
+		return os.NewError(fmt.Sprintf("Max line length %d should be positive", MaxLineLength))
+	case IngestPolicy != INGEST_POLICY_DROP && IngestPolicy != INGEST_POLICY_BLOCK:
+		return os.NewError(fmt.Sprintf("Unknown ingest policy %q, should be one of: %s, %s", IngestPolicy, INGEST_POLICY_DROP, INGEST_POLICY_BLOCK))
+	case CollisionPolicy != COLLISION_POLICY_ERROR && CollisionPolicy != COLLISION_POLICY_RENAME:
+		return os.NewError(fmt.Sprintf("Unknown collision policy %q, should be one of: %s, %s", CollisionPolicy, COLLISION_POLICY_ERROR, COLLISION_POLICY_RENAME))
+	case PartialPolicy != PARTIAL_POLICY_KEEP && PartialPolicy != PARTIAL_POLICY_DROP && PartialPolicy != PARTIAL_POLICY_MARK:
+		return os.NewError(fmt.Sprintf("Unknown partial policy %q, should be one of: %s, %s, %s", PartialPolicy, PARTIAL_POLICY_KEEP, PARTIAL_POLICY_DROP, PARTIAL_POLICY_MARK))
+	case ClockPolicy != CLOCK_POLICY_CLAMP && ClockPolicy != CLOCK_POLICY_FOLLOW:
+		return os.NewError(fmt.Sprintf("Unknown clock policy %q, should be one of: %s, %s", ClockPolicy, CLOCK_POLICY_CLAMP, CLOCK_POLICY_FOLLOW))
+	case PercentileMethod != PERCENTILE_NIST && PercentileMethod != PERCENTILE_NEAREST && PercentileMethod != PERCENTILE_LINEAR && PercentileMethod != PERCENTILE_LOWER && PercentileMethod != PERCENTILE_HIGHER:
+		return os.NewError(fmt.Sprintf("Unknown percentile method %q, should be one of: %s, %s, %s, %s, %s", PercentileMethod, PERCENTILE_NIST, PERCENTILE_NEAREST, PERCENTILE_LINEAR, PERCENTILE_LOWER, PERCENTILE_HIGHER))
+	}
+	return enforceMinInterval()
+}
+
+// enforceMinInterval checks slice and write intervals, intervals of
+// timelines, adaptive intervals, and the launch capture interval against
+// MinInterval, so a tiny interval with many metrics does not make slice
+// churn dominate the daemon. Shorter intervals are rejected, or rounded up
+// to MinInterval with a warning, according to IntervalPolicy.
+func enforceMinInterval() os.Error {
+	if MinInterval == 0 {
+		return nil
+	}
+	names := []string{"Slice interval", "Write interval"}
+	intervals := []*int{&SliceInterval, &WriteInterval}
+	for _, timeline := range Timelines {
+		if timeline.Interval > 0 {
+			names = append(names, fmt.Sprintf("Slice interval of timeline %q", timeline.Prefix))
+			intervals = append(intervals, &timeline.Interval)
+		}
+	}
+	for idx := range AdaptiveIntervals {
+		names = append(names, "Adaptive interval")
+		intervals = append(intervals, &AdaptiveIntervals[idx])
+	}
+	if LaunchCapture.Enabled() {
+		names = append(names, "Launch capture interval")
+		intervals = append(intervals, &LaunchCapture.Interval)
+	}
+
+	for idx, interval := range intervals {
+		if *interval >= MinInterval {
+			continue
+		}
+		if IntervalPolicy == INTERVAL_POLICY_REJECT {
+			return os.NewError(fmt.Sprintf("%s %d is shorter than min interval %d (lower MinInterval to allow it)", names[idx], *interval, MinInterval))
+		}
+		Logger.Warn("%s %d is shorter than min interval %d, using %d", names[idx], *interval, MinInterval, MinInterval)
+		*interval = MinInterval
+	}
+	if LaunchCapture.Enabled() && LaunchCapture.Interval >= SliceInterval {
+		return os.NewError(fmt.Sprintf("Launch capture interval %d rounded up to min interval should be shorter than slice interval %d", LaunchCapture.Interval, SliceInterval))
+	}
+	return nil
+}
+
+// GetWriteJitter returns maximum random delay of writes in seconds, which
+// is limited to the half of write interval, so writes are never delayed
+// past the next write interval boundary.
+func GetWriteJitter() int {
+	if WriteJitter > WriteInterval/2 {
+		return WriteInterval / 2
+	}
+	if WriteJitter < 0 {
+		return 0
+	}
+	return WriteJitter
 }
 
 // String returns a string representation of current configuration.
 func String() string {
 	return fmt.Sprintf(
-		"Configuration:\nListen: \t%s\nData dir:\t%s\nRoot dir:\t%s\nLog level:\t%s\nSlice interval:\t%d\nWrite interval:\t%d\nRRD threads:\t%d\nBatch writes:\t%t\nLookup DNS:\t%t\n",
+		"Configuration:\nListen: \t%s\nData dir:\t%s\nRoot dir:\t%s\nLog level:\t%s\nSlice interval:\t%d\nWrite interval:\t%d\nMin interval:\t%d (policy=%s)\nWrite jitter:\t%d\nFlush slices:\t%d\nState TTL:\t%d\nRecent rollups:\t%d\nRRD threads:\t%d (create limit=%d)\nWrite retries:\t%d (queue=%d, dead letters=%q)\nShutdown timeout:\t%d\nStall timeout:\t%d\nDegraded mode:\tafter %d errors (retry=%d)\nBatch writes:\t%t\nDry run:\t%t\nRecover panics:\t%t\nDedup RRD updates:\t%t\nImport dedup:\t%t\nRecycle slices:\t%t\nTimeline shards:\t%d\nPersist state:\t%t\nLookup DNS:\t%t\nMax line:\t%d\nHex values:\t%t\nSample rate:\t%v\nIngest buffer:\t%d\nIntern limit:\t%d\nMemory limit:\t%d KB (pressure values=%d)\nIngest policy:\t%s\nCollision policy:\t%s\nPartial policy:\t%s\nClock policy:\t%s\nWriters:\t%s\nSample counts:\t%v\nType writers:\t%v (unknown=%v, atomic counters=%t, latest gauges=%t)\nListeners:\t%v\nTimelines:\t%v\nAdaptive intervals:\t%v\nLaunch capture:\t%s\nName templates:\t%v\nRelabel:\t%v\nAliases:\t%v\nHistogram:\t%v\nClasses:\t%s\nPercentile method:\t%s\nReservoir:\t%d\nSketch:\t%v (accuracy=%v)\nTrim fraction:\t%v\nSet exact limit:\t%d\nSummary:\t%s\nMetrics:\t%v\nTag writers:\t%v\nGraphite:\t%s (prefix=%q, suffix=%q)\nInfluxDB:\t%s\nHash replicas:\t%d\nKafka:\t%v\nReconnect:\t%s\nOutput batch:\t%s\nRate limit:\t%s\nRRDTool:\t%s %v\nRRD restore dir:\t%q\nAdmin token:\t%t\nDebug:\t%s (format=%s)\nSnapshot format:\t%s\nOutputs:\t%v\nUnknown values:\t%v\nExport archives:\t%v\nNegative values:\t%v\nMin samples:\t%v\n",
 		Listen,
 		DataDir,
 		RootDir,
 		logger.Severity(LogLevel),
 		SliceInterval,
 		WriteInterval,
+		MinInterval,
+		IntervalPolicy,
+		WriteJitter,
+		FlushSlices,
+		StateTTL,
+		RecentRollups,
 		RrdUpdateThreads,
+		RrdCreateLimit,
+		WriteRetries,
+		RetryQueueSize,
+		DeadLetterFile,
+		ShutdownTimeout,
+		StallTimeout,
+		DegradedAfter,
+		DegradedRetry,
 		BatchWrites,
+		DryRun,
+		RecoverPanics,
+		DedupRrdUpdates,
+		ImportDedup,
+		RecycleSlices,
+		TimelineShards,
+		PersistState,
 		LookupDns,
+		MaxLineLength,
+		HexValues,
+		SampleRate,
+		IngestBufferSize,
+		InternLimit,
+		MemoryLimit,
+		PressureValues,
+		IngestPolicy,
+		CollisionPolicy,
+		PartialPolicy,
+		ClockPolicy,
+		strings.Join(Writers, ", "),
+		SampleCountWriters,
+		TypeWriters,
+		UnknownTypeWriters,
+		AtomicCounters,
+		LatestGauges,
+		GetListeners(),
+		Timelines,
+		AdaptiveIntervals,
+		LaunchCapture,
+		NameTemplates,
+		Relabel,
+		Aliases,
+		HistogramBuckets,
+		Classes,
+		PercentileMethod,
+		ReservoirSize,
+		SketchQuantiles,
+		SketchAccuracy,
+		TrimFraction,
+		SetExactLimit,
+		Summary,
+		Metrics,
+		TagWriters,
+		GraphiteAddress,
+		GraphitePrefix,
+		GraphiteSuffix,
+		InfluxAddress,
+		HashReplicas,
+		Kafka,
+		Reconnect,
+		OutputBatch,
+		RateLimit,
+		RrdtoolPath,
+		RrdtoolArgs,
+		RrdRestoreDir,
+		AdminToken != "",
+		DebugFile,
+		DebugFormat,
+		SnapshotFormat,
+		Outputs,
+		UnknownValues,
+		ExportArchives,
+		NegativeValues,
+		MinSamples,
 	)
 }
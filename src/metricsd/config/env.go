@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"json"
+	"os"
+	"strings"
+)
+
+// Prefix of environment variables overriding config file settings.
+const ENV_PREFIX = "METRICSD_"
+
+// Names of settings which could be overridden with environment variables,
+// string settings are taken as is, all other ones should be valid JSON.
+var (
+	environmentStrings = []string{
+		"Listen", "DataDir", "IngestPolicy", "GraphiteAddress", "GraphitePrefix", "GraphiteSuffix",
+		"InfluxAddress", "RrdtoolPath", "RrdRestoreDir", "AdminToken", "DebugFile", "DebugFormat", "SnapshotFormat", "PercentileMethod", "CollisionPolicy", "PartialPolicy", "ClockPolicy", "IntervalPolicy", "DeadLetterFile",
+	}
+	environmentValues = []string{
+		"LogLevel", "SliceInterval", "WriteInterval", "MinInterval", "WriteJitter", "FlushSlices", "StateTTL", "RecentRollups",
+		"RrdUpdateThreads", "RrdCreateLimit", "WriteRetries", "RetryQueueSize", "ShutdownTimeout", "StallTimeout", "DegradedAfter", "DegradedRetry", "BatchWrites", "DryRun", "RecoverPanics", "DedupRrdUpdates",
+		"ImportDedup", "RecycleSlices", "TimelineShards", "PersistState", "LookupDns", "MaxLineLength", "HexValues", "SampleRate", "IngestBufferSize", "InternLimit", "MemoryLimit", "PressureValues", "Writers", "SampleCountWriters",
+		"TypeWriters", "UnknownTypeWriters", "AtomicCounters", "LatestGauges", "Listeners", "Timelines", "AdaptiveIntervals", "LaunchCapture", "NameTemplates", "Relabel", "Aliases",
+		"HistogramBuckets", "Classes", "ReservoirSize", "SketchQuantiles", "SketchAccuracy", "TrimFraction", "SetExactLimit", "Summary", "Metrics", "TagWriters", "Reconnect", "RateLimit", "HashReplicas",
+		"OutputBatch", "Kafka", "RrdtoolArgs", "Outputs", "UnknownValues", "ExportArchives", "NegativeValues", "MinSamples",
+	}
+)
+
+// EnvName returns the name of the environment variable overriding the
+// setting, e.g. METRICSD_SLICE_INTERVAL for SliceInterval and
+// METRICSD_STATE_TTL for StateTTL.
+func EnvName(key string) string {
+	name := make([]byte, 0, len(ENV_PREFIX)+len(key)+4)
+	name = append(name, ENV_PREFIX...)
+	for idx := 0; idx < len(key); idx++ {
+		upper := key[idx] >= 'A' && key[idx] <= 'Z'
+		if upper && idx > 0 {
+			previousLower := key[idx-1] < 'A' || key[idx-1] > 'Z'
+			nextLower := idx+1 < len(key) && (key[idx+1] < 'A' || key[idx+1] > 'Z')
+			if previousLower || nextLower {
+				name = append(name, '_')
+			}
+		}
+		name = append(name, strings.ToUpper(key[idx:idx+1])...)
+	}
+	return string(name)
+}
+
+// overlayEnvironment replaces settings parsed from the config file with
+// values of environment variables (given as "NAME=value" pairs), so
+// environment wins over the config file, and the config file over
+// defaults. Unknown variables with ENV_PREFIX are reported as errors, to
+// catch misspelled names.
+func overlayEnvironment(config map[string]interface{}, environ []string) os.Error {
+	variables := make(map[string]string)
+	for _, pair := range environ {
+		if !strings.HasPrefix(pair, ENV_PREFIX) {
+			continue
+		}
+		if idx := strings.Index(pair, "="); idx > 0 {
+			variables[pair[:idx]] = pair[idx+1:]
+		}
+	}
+
+	for _, key := range environmentStrings {
+		name := EnvName(key)
+		if value, found := variables[name]; found {
+			config[key] = value
+			variables[name] = "", false
+		}
+	}
+	for _, key := range environmentValues {
+		name := EnvName(key)
+		if value, found := variables[name]; found {
+			var parsed interface{}
+			if error := json.Unmarshal([]byte(value), &parsed); error != nil {
+				return os.NewError(fmt.Sprintf("Environment variable %s should be valid JSON: %s", name, error))
+			}
+			config[key] = parsed
+			variables[name] = "", false
+		}
+	}
+	for name := range variables {
+		return os.NewError(fmt.Sprintf("Environment variable %s does not match any setting", name))
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"fmt"
+	"os"
+)
+
+// Formats of rollups produced to Kafka.
+const (
+	KAFKA_FORMAT_JSON = "json" // the same object as in JSON debug format
+	KAFKA_FORMAT_AVRO = "avro" // Avro binary encoding (see writers.KafkaAvroSchema)
+)
+
+// A KafkaConfig describes Kafka output: rollups produced in every write
+// pass are sent as a batch to Topic, keyed by metric name.
+type KafkaConfig struct {
+	Brokers      []string // addresses of brokers used to discover partition leaders
+	Topic        string   // topic receiving rollups
+	Format       string   // format of messages ("json" or "avro")
+	ClientId     string   // client id sent to brokers
+	RequiredAcks int      // acknowledgements required from brokers (0: none, 1: leader, -1: all in-sync replicas)
+	Timeout      int      // time brokers wait for acknowledgements, in seconds
+}
+
+// Default Kafka settings, brokers and topic should be configured.
+var DEFAULT_KAFKA = &KafkaConfig{Format: KAFKA_FORMAT_JSON, ClientId: "metricsd", RequiredAcks: 1, Timeout: 10}
+
+var (
+	// Kafka output (disabled if nil)
+	Kafka *KafkaConfig
+)
+
+func (kafka *KafkaConfig) String() string {
+	if kafka == nil {
+		return "disabled"
+	}
+	return fmt.Sprintf("%v topic=%s (format=%s, acks=%d, timeout=%ds)", kafka.Brokers, kafka.Topic, kafka.Format, kafka.RequiredAcks, kafka.Timeout)
+}
+
+// loadKafka parses Kafka output settings from the config file. Settings
+// not mentioned in the config file keep their default values.
+func loadKafka(items map[string]interface{}) (kafka *KafkaConfig, err os.Error) {
+	kafka = &KafkaConfig{}
+	*kafka = *DEFAULT_KAFKA
+	if brokers, found := items["Brokers"]; found {
+		for _, broker := range brokers.([]interface{}) {
+			kafka.Brokers = append(kafka.Brokers, broker.(string))
+		}
+	}
+	if topic, found := items["Topic"]; found {
+		kafka.Topic = topic.(string)
+	}
+	if format, found := items["Format"]; found {
+		kafka.Format = format.(string)
+	}
+	if clientId, found := items["ClientId"]; found {
+		kafka.ClientId = clientId.(string)
+	}
+	if requiredAcks, found := items["RequiredAcks"]; found {
+		kafka.RequiredAcks = (int)(requiredAcks.(float64))
+	}
+	if timeout, found := items["Timeout"]; found {
+		kafka.Timeout = (int)(timeout.(float64))
+	}
+
+	switch {
+	case len(kafka.Brokers) == 0:
+		return nil, os.NewError("At least one broker should be defined")
+	case kafka.Topic == "":
+		return nil, os.NewError("Topic should be defined")
+	case kafka.Format != KAFKA_FORMAT_JSON && kafka.Format != KAFKA_FORMAT_AVRO:
+		return nil, os.NewError(fmt.Sprintf("Format %q is invalid, should be one of: %s, %s", kafka.Format, KAFKA_FORMAT_JSON, KAFKA_FORMAT_AVRO))
+	case kafka.RequiredAcks < -1:
+		return nil, os.NewError(fmt.Sprintf("Required acks %d should be -1, 0, or positive", kafka.RequiredAcks))
+	case kafka.Timeout <= 0:
+		return nil, os.NewError(fmt.Sprintf("Timeout %d should be positive", kafka.Timeout))
+	}
+	return
+}
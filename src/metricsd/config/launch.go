@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"os"
+)
+
+// Suffix appended to names of metrics captured at the launch interval (see
+// LaunchConfig).
+const LAUNCH_SUFFIX = ".launch"
+
+// A LaunchConfig describes capturing of new metrics at a finer interval:
+// events of a metric are copied to a separate timeline with Interval
+// seconds slices for Intervals slices after the metric is first seen,
+// under the metric name with LAUNCH_SUFFIX appended.
+type LaunchConfig struct {
+	Interval  int // slice interval of captured metrics, in seconds
+	Intervals int // number of captured slices (0 disables capturing)
+}
+
+// Default capturing of new metrics (disabled).
+var DEFAULT_LAUNCH_CAPTURE = &LaunchConfig{Interval: 1, Intervals: 0}
+
+var (
+	// Capturing of new metrics at a finer interval
+	LaunchCapture *LaunchConfig = DEFAULT_LAUNCH_CAPTURE
+)
+
+func (launch *LaunchConfig) String() string {
+	if launch.Intervals == 0 {
+		return "disabled"
+	}
+	return fmt.Sprintf("%d intervals of %ds", launch.Intervals, launch.Interval)
+}
+
+// Enabled returns a value indicating whether new metrics are captured.
+func (launch *LaunchConfig) Enabled() bool {
+	return launch.Intervals > 0
+}
+
+// Duration returns the time in seconds new metrics are captured for.
+func (launch *LaunchConfig) Duration() int64 {
+	return int64(launch.Interval) * int64(launch.Intervals)
+}
+
+// loadLaunch parses capturing settings from the config file. Settings not
+// mentioned in the config file keep their default values.
+func loadLaunch(items map[string]interface{}) (launch *LaunchConfig, err os.Error) {
+	launch = &LaunchConfig{}
+	*launch = *DEFAULT_LAUNCH_CAPTURE
+	if interval, found := items["Interval"]; found {
+		launch.Interval = int(interval.(float64))
+	}
+	if intervals, found := items["Intervals"]; found {
+		launch.Intervals = int(intervals.(float64))
+	}
+
+	if launch.Interval <= 0 {
+		return nil, os.NewError(fmt.Sprintf("Interval should be positive: %d", launch.Interval))
+	}
+	if launch.Intervals < 0 {
+		return nil, os.NewError(fmt.Sprintf("Intervals should not be negative: %d", launch.Intervals))
+	}
+	return
+}
@@ -0,0 +1,154 @@
+package config
+
+// Metric types declared by producers (see TypeWriters).
+const (
+	METRIC_TYPE_COUNTER = "counter"
+	METRIC_TYPE_GAUGE   = "gauge"
+	METRIC_TYPE_TIMER   = "timer"
+	METRIC_TYPE_SET     = "set"
+)
+
+// Default writers per declared metric type.
+var DEFAULT_TYPE_WRITERS = map[string][]string{
+	METRIC_TYPE_COUNTER: []string{"count"},
+	METRIC_TYPE_GAUGE:   []string{"quartiles"},
+	METRIC_TYPE_TIMER:   []string{"quartiles", "percentiles"},
+	METRIC_TYPE_SET:     []string{"count"},
+}
+
+var (
+	// Writers per declared metric type
+	TypeWriters map[string][]string = DEFAULT_TYPE_WRITERS
+	// Writers used for metrics of unknown types (all active writers if nil)
+	UnknownTypeWriters []string
+	// Sum counter values on arrival instead of storing them (see Accumulates)
+	AtomicCounters bool = DEFAULT_ATOMIC_COUNTERS
+	// Writers able to process sample sets with accumulated values only
+	AccumulatingWriters []string = []string{"sum"}
+	// Keep only the latest values of gauges instead of storing all of them
+	// (see KeepsLatest)
+	LatestGauges bool = DEFAULT_LATEST_GAUGES
+	// Writers able to process sample sets with the latest values only
+	LatestWriters []string = []string{"last"}
+	// Writers able to process sample sets with summaries of values (see
+	// Summarizes)
+	SummaryWriters []string = []string{"sum", "sketch", "quartiles", "time_weighted"}
+)
+
+// KnownMetricType returns a value indicating whether writers are defined
+// for the metric type.
+func KnownMetricType(metricType string) bool {
+	_, found := TypeWriters[metricType]
+	return found
+}
+
+// UsesWriter returns a value indicating whether the active writer should
+// process the series of the metric with the given name, declared type, and
+// tags. The first matching rule of TagWriters wins, then per-metric Writers
+// setting, then writers defined for the type in TypeWriters (or
+// UnknownTypeWriters for unknown types). Metrics without declared type are
+// processed by all active writers.
+func UsesWriter(name, metricType string, tags map[string]string, writer string) bool {
+	if writers := tagWriters(name, tags); writers != nil {
+		return contains(writers, writer)
+	}
+	if writers := MetricOptions(name).Writers; writers != nil {
+		return contains(writers, writer)
+	}
+	if metricType == "" {
+		return true
+	}
+	if writers, found := TypeWriters[metricType]; found {
+		return contains(writers, writer)
+	}
+	if UnknownTypeWriters != nil {
+		return contains(UnknownTypeWriters, writer)
+	}
+	return true
+}
+
+// Accumulates returns a value indicating whether values of the series of
+// the metric with the given name, declared type, and tags should be summed
+// on arrival instead of being stored in sample sets. It is the case for
+// counters processed by AccumulatingWriters only, when AtomicCounters is
+// enabled.
+func Accumulates(name, metricType string, tags map[string]string) bool {
+	if !AtomicCounters || metricType != METRIC_TYPE_COUNTER {
+		return false
+	}
+	return onlyWriters(name, metricType, tags, AccumulatingWriters)
+}
+
+// KeepsLatest returns a value indicating whether only the latest value of
+// the series of the metric with the given name, declared type, and tags
+// should be kept in sample sets. It is the case for gauges processed by
+// LatestWriters only, when LatestGauges is enabled.
+func KeepsLatest(name, metricType string, tags map[string]string) bool {
+	if !LatestGauges || metricType != METRIC_TYPE_GAUGE {
+		return false
+	}
+	return onlyWriters(name, metricType, tags, LatestWriters)
+}
+
+// Summarizes returns a value indicating whether a bounded summary of
+// values of the series of the metric with the given name, declared type,
+// and tags should be kept in sample sets instead of the values. It is the
+// case for metrics with "summary" Storage per-metric option processed by
+// SummaryWriters only, values of other metrics are stored as usual.
+func Summarizes(name, metricType string, tags map[string]string) bool {
+	if MetricOptions(name).Storage != STORAGE_SUMMARY {
+		return false
+	}
+	return onlyWriters(name, metricType, tags, SummaryWriters)
+}
+
+// onlyWriters returns a value indicating whether the series of the metric
+// with the given name, declared type, and tags is processed by some of the
+// listed writers, and by no other writers (see UsesWriter).
+func onlyWriters(name, metricType string, tags map[string]string, list []string) bool {
+	writers := tagWriters(name, tags)
+	if writers == nil {
+		writers = MetricOptions(name).Writers
+	}
+	if writers == nil {
+		writers = TypeWriters[metricType]
+	}
+	for _, writer := range writers {
+		if !contains(list, writer) {
+			return false
+		}
+	}
+	return len(writers) > 0
+}
+
+// loadTypeWriters parses writers per metric type from the config file.
+// Types not mentioned in the config file keep their default writers.
+func loadTypeWriters(items map[string]interface{}) map[string][]string {
+	writers := make(map[string][]string)
+	for metricType, list := range DEFAULT_TYPE_WRITERS {
+		writers[metricType] = list
+	}
+	for metricType, list := range items {
+		writers[metricType] = loadStrings(list.([]interface{}))
+	}
+	return writers
+}
+
+// loadStrings converts a list parsed from the config file to strings.
+func loadStrings(items []interface{}) []string {
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		list = append(list, item.(string))
+	}
+	return list
+}
+
+// contains returns a value indicating whether the list contains the item.
+func contains(list []string, item string) bool {
+	for _, elem := range list {
+		if elem == item {
+			return true
+		}
+	}
+	return false
+}
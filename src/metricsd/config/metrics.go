@@ -0,0 +1,331 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sync"
+)
+
+const (
+	DEFAULT_MAX_STALENESS = 600
+)
+
+// Policies applied by gauge writers to slices without samples.
+const (
+	GAP_POLICY_NONE    = ""        // do not report anything (RRD heartbeat decides)
+	GAP_POLICY_CARRY   = "carry"   // carry forward the last received value
+	GAP_POLICY_UNKNOWN = "unknown" // report unknown value explicitly
+	GAP_POLICY_DEFAULT = "default" // report DefaultValue
+)
+
+// Policies applied to values received after MaxValues values are stored in
+// a sample set.
+const (
+	OVERFLOW_POLICY_DROP   = "drop"   // drop new values
+	OVERFLOW_POLICY_SAMPLE = "sample" // keep a random sample of all values (reservoir sampling)
+)
+
+// Policies applied by count writer to zero values, when metrics are
+// classified by sign.
+const (
+	ZERO_POLICY_NONE = ""     // do not count zeros
+	ZERO_POLICY_OK   = "ok"   // count zeros as successful
+	ZERO_POLICY_FAIL = "fail" // count zeros as failed
+)
+
+// Ways rollups of slices written in the same pass are consolidated into a
+// single flushed value per series.
+const (
+	CONSOLIDATION_NONE    = ""        // write rollups of every slice
+	CONSOLIDATION_AVERAGE = "average" // average of every value
+	CONSOLIDATION_SUM     = "sum"     // sum of every value
+	CONSOLIDATION_MAX     = "max"     // maximum of every value
+	CONSOLIDATION_LAST    = "last"    // rollup of the latest slice
+)
+
+// Ways values of metrics are stored in sample sets until they are written
+// (see Summarizes).
+const (
+	STORAGE_VALUES  = ""        // store every value
+	STORAGE_SUMMARY = "summary" // store a bounded summary of values instead of them
+)
+
+// A MetricConfig holds settings applied to metrics with names matching the
+// pattern.
+type MetricConfig struct {
+	Pattern       string              // shell pattern matching metric names (see path.Match)
+	GapPolicy     string              // what gauge writers report for slices without samples ("", "carry", "unknown", or "default")
+	DefaultValue  int                 // value reported for slices without samples with "default" gap policy
+	MaxStaleness  int                 // for how long (in seconds) slices without samples are reported using GapPolicy
+	Outputs       map[string][]string // output backends per writer name (see WriterOutputs)
+	MaxValues     int                 // maximum number of values stored in a sample set per slice (0 means unlimited)
+	Overflow      string              // what happens to values beyond MaxValues ("drop" or "sample")
+	Coalesce      bool                // consecutive identical values are stored as a single weighted value
+	Storage       string              // how values are stored in sample sets ("" or "summary")
+	Priority      int                 // order of writes, sample sets of metrics with higher priority are written first
+	Writers       []string            // writers processing matching metrics (see UsesWriter), nil means default
+	Retention     []*RetentionArchive // archives of RRD files created for matching metrics, nil means writer's defaults
+	Bounds        map[string]*Bounds  // minimum and maximum of data sources of created RRD files per writer name ("*" for all writers)
+	Heartbeat     int                 // heartbeat of data sources of created RRD files in seconds, 0 means writer's default
+	Warmup        int                 // number of first intervals of a metric, for which rate writers report nothing
+	MaxRate       float64             // events per second above which over_rate writer reports slices as over
+	HalfLife      float64             // age (in seconds) halving weights of values in time_weighted writer, 0 means equal weights
+	Units         map[string]string   // units of exported rollups per writer name ("*" for writers without their own unit)
+	Success       *Predicate          // condition of successful values counted by count writer, nil means by sign
+	Invert        bool                // successful and failed values are swapped by count writer
+	Zeros         string              // how count writer classifies zeros by sign ("", "ok", or "fail")
+	MaxErrorRate  float64             // ratio of failed values above which count rollups are exported as over threshold, 0 means not exported
+	Transforms    TransformPipeline   // transforms applied to values in order before they are stored, nil means none
+	Consolidation string              // how rollups of slices written in the same pass are combined ("", "average", "sum", "max", or "last")
+}
+
+var (
+	// Per-metric settings, the first matching pattern wins
+	Metrics []*MetricConfig
+	// Settings of metrics not matching any pattern
+	defaultMetricConfig = newMetricConfig("*")
+	// Settings found for metric names
+	metricConfigCache      = make(map[string]*MetricConfig)
+	metricConfigCacheMutex = &sync.RWMutex{}
+)
+
+func newMetricConfig(pattern string) *MetricConfig {
+	return &MetricConfig{
+		Pattern:      pattern,
+		GapPolicy:    GAP_POLICY_NONE,
+		MaxStaleness: DEFAULT_MAX_STALENESS,
+		Overflow:     OVERFLOW_POLICY_DROP,
+	}
+}
+
+// MetricOptions returns settings for the metric with the given name.
+func MetricOptions(name string) *MetricConfig {
+	metricConfigCacheMutex.RLock()
+	options, found := metricConfigCache[name]
+	metricConfigCacheMutex.RUnlock()
+	if found {
+		return options
+	}
+
+	options = defaultMetricConfig
+	for _, metric := range Metrics {
+		if matched, _ := path.Match(metric.Pattern, name); matched {
+			options = metric
+			break
+		}
+	}
+
+	metricConfigCacheMutex.Lock()
+	metricConfigCache[name] = options
+	metricConfigCacheMutex.Unlock()
+	return options
+}
+
+// UsesConsolidation returns a value indicating whether rollups of any
+// metrics are consolidated across slices written in the same pass.
+func UsesConsolidation() bool {
+	for _, metric := range Metrics {
+		if metric.Consolidation != CONSOLIDATION_NONE {
+			return true
+		}
+	}
+	return false
+}
+
+// UsesPriorities returns a value indicating whether any metrics have
+// non-default write priority.
+func UsesPriorities() bool {
+	for _, metric := range Metrics {
+		if metric.Priority != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// SetMetrics replaces per-metric settings.
+func SetMetrics(metrics []*MetricConfig) {
+	metricConfigCacheMutex.Lock()
+	defer metricConfigCacheMutex.Unlock()
+	Metrics = metrics
+	metricConfigCache = make(map[string]*MetricConfig)
+}
+
+// loadMetrics parses per-metric settings from the config file.
+func loadMetrics(items []interface{}) (metrics []*MetricConfig, err os.Error) {
+	metrics = make([]*MetricConfig, 0, len(items))
+	for _, item := range items {
+		options := item.(map[string]interface{})
+		pattern, found := options["Pattern"]
+		if !found {
+			return nil, os.NewError("Pattern is required for metric settings")
+		}
+		metric := newMetricConfig(pattern.(string))
+		if _, err = path.Match(metric.Pattern, ""); err != nil {
+			return nil, os.NewError(fmt.Sprintf("Pattern %q is invalid: %s", metric.Pattern, err))
+		}
+
+		if gapPolicy, found := options["GapPolicy"]; found {
+			metric.GapPolicy = gapPolicy.(string)
+		}
+		if defaultValue, found := options["DefaultValue"]; found {
+			metric.DefaultValue = (int)(defaultValue.(float64))
+		}
+		if maxStaleness, found := options["MaxStaleness"]; found {
+			metric.MaxStaleness = (int)(maxStaleness.(float64))
+		}
+
+		if maxValues, found := options["MaxValues"]; found {
+			metric.MaxValues = (int)(maxValues.(float64))
+		}
+		if overflow, found := options["Overflow"]; found {
+			metric.Overflow = overflow.(string)
+		}
+		if coalesce, found := options["Coalesce"]; found {
+			metric.Coalesce = coalesce.(bool)
+		}
+		if storage, found := options["Storage"]; found {
+			metric.Storage = storage.(string)
+		}
+		if priority, found := options["Priority"]; found {
+			metric.Priority = (int)(priority.(float64))
+		}
+
+		if writers, found := options["Writers"]; found {
+			metric.Writers = loadStrings(writers.([]interface{}))
+		}
+		if consolidation, found := options["Consolidation"]; found {
+			metric.Consolidation = consolidation.(string)
+		}
+
+		if warmup, found := options["Warmup"]; found {
+			metric.Warmup = (int)(warmup.(float64))
+			if metric.Warmup < 0 {
+				return nil, os.NewError(fmt.Sprintf("Warmup %d is invalid for %q", metric.Warmup, metric.Pattern))
+			}
+		}
+
+		if invert, found := options["Invert"]; found {
+			metric.Invert = invert.(bool)
+		}
+		if zeros, found := options["Zeros"]; found {
+			metric.Zeros = zeros.(string)
+		}
+
+		if maxErrorRate, found := options["MaxErrorRate"]; found {
+			metric.MaxErrorRate = maxErrorRate.(float64)
+			if metric.MaxErrorRate <= 0 || metric.MaxErrorRate >= 1 {
+				return nil, os.NewError(fmt.Sprintf("MaxErrorRate %v is invalid for %q", metric.MaxErrorRate, metric.Pattern))
+			}
+		}
+
+		if maxRate, found := options["MaxRate"]; found {
+			metric.MaxRate = maxRate.(float64)
+			if metric.MaxRate < 0 {
+				return nil, os.NewError(fmt.Sprintf("MaxRate %v is invalid for %q", metric.MaxRate, metric.Pattern))
+			}
+		}
+
+		if success, found := options["Success"]; found {
+			if metric.Success, err = ParsePredicate(success.(string)); err != nil {
+				return nil, os.NewError(fmt.Sprintf("%s (metric %q)", err, metric.Pattern))
+			}
+		}
+
+		if transforms, found := options["Transforms"]; found {
+			if metric.Transforms, err = loadTransforms(transforms.([]interface{})); err != nil {
+				return nil, os.NewError(fmt.Sprintf("%s (metric %q)", err, metric.Pattern))
+			}
+		}
+
+		if units, found := options["Units"]; found {
+			metric.Units = make(map[string]string)
+			for writer, unit := range units.(map[string]interface{}) {
+				metric.Units[writer] = unit.(string)
+			}
+		}
+
+		if retention, found := options["Retention"]; found {
+			if metric.Retention, err = loadRetention(retention.([]interface{})); err != nil {
+				return nil, os.NewError(fmt.Sprintf("%s (metric %q)", err, metric.Pattern))
+			}
+		}
+
+		if bounds, found := options["Bounds"]; found {
+			if metric.Bounds, err = loadBounds(bounds.(map[string]interface{})); err != nil {
+				return nil, os.NewError(fmt.Sprintf("%s (metric %q)", err, metric.Pattern))
+			}
+		}
+
+		if heartbeat, found := options["Heartbeat"]; found {
+			switch value := heartbeat.(type) {
+			case float64:
+				metric.Heartbeat = int(value)
+			case string:
+				if metric.Heartbeat, err = parseRetentionDuration(value); err != nil {
+					return nil, os.NewError(fmt.Sprintf("Invalid heartbeat: %s (metric %q)", err, metric.Pattern))
+				}
+			}
+			if metric.Heartbeat <= 0 {
+				return nil, os.NewError(fmt.Sprintf("Heartbeat %v is invalid for %q", heartbeat, metric.Pattern))
+			}
+		}
+
+		if halfLife, found := options["HalfLife"]; found {
+			switch value := halfLife.(type) {
+			case float64:
+				metric.HalfLife = value
+			case string:
+				seconds, err := parseRetentionDuration(value)
+				if err != nil {
+					return nil, os.NewError(fmt.Sprintf("Invalid half-life: %s (metric %q)", err, metric.Pattern))
+				}
+				metric.HalfLife = float64(seconds)
+			}
+			if metric.HalfLife <= 0 {
+				return nil, os.NewError(fmt.Sprintf("HalfLife %v is invalid for %q", halfLife, metric.Pattern))
+			}
+		}
+
+		if outputs, found := options["Outputs"]; found {
+			if metric.Outputs, err = loadOutputs(outputs.(map[string]interface{})); err != nil {
+				return nil, err
+			}
+		}
+
+		switch metric.GapPolicy {
+		case GAP_POLICY_NONE, GAP_POLICY_CARRY, GAP_POLICY_UNKNOWN, GAP_POLICY_DEFAULT:
+		default:
+			return nil, os.NewError(fmt.Sprintf("Gap policy %q is invalid for %q", metric.GapPolicy, metric.Pattern))
+		}
+		switch metric.Overflow {
+		case OVERFLOW_POLICY_DROP, OVERFLOW_POLICY_SAMPLE:
+		default:
+			return nil, os.NewError(fmt.Sprintf("Overflow policy %q is invalid for %q", metric.Overflow, metric.Pattern))
+		}
+		switch metric.Zeros {
+		case ZERO_POLICY_NONE, ZERO_POLICY_OK, ZERO_POLICY_FAIL:
+		default:
+			return nil, os.NewError(fmt.Sprintf("Zero policy %q is invalid for %q", metric.Zeros, metric.Pattern))
+		}
+		switch metric.Consolidation {
+		case CONSOLIDATION_NONE, CONSOLIDATION_AVERAGE, CONSOLIDATION_SUM, CONSOLIDATION_MAX, CONSOLIDATION_LAST:
+		default:
+			return nil, os.NewError(fmt.Sprintf("Consolidation %q is invalid for %q", metric.Consolidation, metric.Pattern))
+		}
+		switch {
+		case metric.Storage != STORAGE_VALUES && metric.Storage != STORAGE_SUMMARY:
+			return nil, os.NewError(fmt.Sprintf("Storage %q is invalid for %q", metric.Storage, metric.Pattern))
+		case metric.Storage == STORAGE_SUMMARY && metric.HalfLife > 0:
+			// Timestamps of values are not kept in summaries
+			return nil, os.NewError(fmt.Sprintf("Storage %q cannot be used with HalfLife for %q", metric.Storage, metric.Pattern))
+		}
+		metrics = append(metrics, metric)
+	}
+	return
+}
+
+func (metric *MetricConfig) String() string {
+	return fmt.Sprintf("%s (gaps=%q, default=%d, staleness=%d, outputs=%v, max values=%d, overflow=%q, coalesce=%t, storage=%q, priority=%d, writers=%v, retention=%v, bounds=%v, heartbeat=%d, warmup=%d, max rate=%v, half-life=%v, units=%v, success=%v, invert=%t, zeros=%q, max error rate=%v, transforms=%v, consolidation=%q)", metric.Pattern, metric.GapPolicy, metric.DefaultValue, metric.MaxStaleness, metric.Outputs, metric.MaxValues, metric.Overflow, metric.Coalesce, metric.Storage, metric.Priority, metric.Writers, metric.Retention, metric.Bounds, metric.Heartbeat, metric.Warmup, metric.MaxRate, metric.HalfLife, metric.Units, metric.Success, metric.Invert, metric.Zeros, metric.MaxErrorRate, metric.Transforms, metric.Consolidation)
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"fmt"
+	"os"
+)
+
+// Quantiles are reported for any number of samples by default.
+var DEFAULT_MIN_SAMPLES = map[string]int{}
+
+// Minimum number of samples per writer name, below which quantile writers
+// report unknown values ("*" applies to all writers without their own
+// setting).
+var MinSamples map[string]int = DEFAULT_MIN_SAMPLES
+
+// WriterMinSamples returns the minimum number of samples summarized by the
+// writer (0 means no minimum).
+func WriterMinSamples(writer string) int {
+	if min, found := MinSamples[writer]; found {
+		return min
+	}
+	return MinSamples[ALL_WRITERS]
+}
+
+// loadMinSamples parses minimum numbers of samples per writer name from the
+// config file.
+func loadMinSamples(items map[string]interface{}) (thresholds map[string]int, err os.Error) {
+	thresholds = make(map[string]int)
+	for writer, item := range items {
+		min := int(item.(float64))
+		if min < 0 {
+			return nil, os.NewError(fmt.Sprintf("Minimum number of samples %d is invalid for writer %q", min, writer))
+		}
+		thresholds[writer] = min
+	}
+	return
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"os"
+)
+
+// Policies for negative values, applied to sample sets before they are
+// summarized by a writer (see NegativeValues).
+const (
+	NEGATIVE_POLICY_ALLOW  = "allow"  // negative values are summarized as is
+	NEGATIVE_POLICY_CLAMP  = "clamp"  // negative values are replaced with zero
+	NEGATIVE_POLICY_REJECT = "reject" // negative values are dropped
+)
+
+// Negative values are allowed for all writers by default.
+var DEFAULT_NEGATIVE_VALUES = map[string]string{}
+
+// Policies for negative values per writer name ("*" applies to all writers
+// without their own setting).
+var NegativeValues map[string]string = DEFAULT_NEGATIVE_VALUES
+
+// NegativePolicy returns the policy for negative values summarized by the
+// writer.
+func NegativePolicy(writer string) string {
+	if policy, found := NegativeValues[writer]; found {
+		return policy
+	}
+	if policy, found := NegativeValues[ALL_WRITERS]; found {
+		return policy
+	}
+	return NEGATIVE_POLICY_ALLOW
+}
+
+// loadNegativeValues parses policies for negative values per writer name
+// from the config file.
+func loadNegativeValues(items map[string]interface{}) (policies map[string]string, err os.Error) {
+	policies = make(map[string]string)
+	for writer, item := range items {
+		policy := item.(string)
+		switch policy {
+		case NEGATIVE_POLICY_ALLOW, NEGATIVE_POLICY_CLAMP, NEGATIVE_POLICY_REJECT:
+		default:
+			return nil, os.NewError(fmt.Sprintf("Negative values policy %q is invalid for writer %q, should be one of: %s, %s, %s", policy, writer, NEGATIVE_POLICY_ALLOW, NEGATIVE_POLICY_CLAMP, NEGATIVE_POLICY_REJECT))
+		}
+		policies[writer] = policy
+	}
+	return
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Output backends receiving rollups produced by writers.
+const (
+	OUTPUT_RRD      = "rrd"      // RRD files in DataDir
+	OUTPUT_GRAPHITE = "graphite" // Carbon at GraphiteAddress
+	OUTPUT_INFLUX   = "influx"   // InfluxDB at InfluxAddress (UDP line protocol)
+	OUTPUT_KAFKA    = "kafka"    // Kafka topic (see Kafka)
+	OUTPUT_STDOUT   = "stdout"   // standard output (in DebugFormat)
+	OUTPUT_FILE     = "file"     // DebugFile (in DebugFormat)
+)
+
+// Formats of rollups in stdout and file outputs.
+const (
+	DEBUG_FORMAT_TEXT = "text" // space-separated source, metric, writer, RRD template and values
+	DEBUG_FORMAT_JSON = "json" // JSON object per line
+)
+
+// Outputs key applied to all writers without their own setting.
+const ALL_WRITERS = "*"
+
+// Prometheus export, rendering unknown values on its own (see UnknownValues).
+const EXPORT_PROMETHEUS = "prometheus"
+
+// Consolidation functions of RRD archives, which could be exported instead
+// of the most recent rollups (see ExportArchives).
+const (
+	CF_AVERAGE = "AVERAGE"
+	CF_MAX     = "MAX"
+	CF_MIN     = "MIN"
+	CF_LAST    = "LAST"
+)
+
+// An ExportArchive selects the RRD archive of a writer surfaced by exports
+// (JSON and Prometheus) instead of the most recent rollups: rollups are
+// consolidated into rows of the archive the same way RRDTool does, and the
+// last completed row is exported.
+type ExportArchive struct {
+	Function   string // consolidation function of the archive
+	Resolution int    // seconds per row, 0 for the finest archive of the function
+}
+
+// Default renderings of unknown values per output format. Empty string means
+// unknown values are skipped.
+var DEFAULT_UNKNOWN_VALUES = map[string]string{
+	OUTPUT_GRAPHITE:   "",
+	OUTPUT_INFLUX:     "",
+	DEBUG_FORMAT_JSON: "null",
+	EXPORT_PROMETHEUS: "",
+}
+
+var (
+	// Output backends per writer name, applied to metrics without their own
+	// Outputs setting
+	Outputs map[string][]string
+	// Renderings of unknown values per output format (RRD files and text
+	// debug format always use "U")
+	UnknownValues map[string]string = DEFAULT_UNKNOWN_VALUES
+	// Archives surfaced by exports per writer name ("*" for all writers),
+	// writers not mentioned export the most recent rollups (AVERAGE of a
+	// single slice)
+	ExportArchives map[string]*ExportArchive
+)
+
+// GetExportArchive returns the archive surfaced by exports of rollups
+// produced by the writer, nil when the most recent rollups are exported.
+func GetExportArchive(writer string) *ExportArchive {
+	if archive, found := ExportArchives[writer]; found {
+		return archive
+	}
+	return ExportArchives[ALL_WRITERS]
+}
+
+// ParseExportArchive parses an archive in "<function>[:<resolution>]"
+// format, e.g. "MAX:10m" (see parseRetentionDuration for resolution units).
+func ParseExportArchive(spec string) (archive *ExportArchive, err os.Error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) > 2 {
+		return nil, os.NewError(fmt.Sprintf("Export archive %q is invalid, should be <function>[:<resolution>]", spec))
+	}
+	archive = &ExportArchive{Function: strings.ToUpper(parts[0])}
+	switch archive.Function {
+	case CF_AVERAGE, CF_MAX, CF_MIN, CF_LAST:
+	default:
+		return nil, os.NewError(fmt.Sprintf("Export archive %q is invalid: function should be one of %s, %s, %s, %s", spec, CF_AVERAGE, CF_MAX, CF_MIN, CF_LAST))
+	}
+	if len(parts) == 2 {
+		if archive.Resolution, err = parseRetentionDuration(parts[1]); err != nil {
+			return nil, os.NewError(fmt.Sprintf("Export archive %q is invalid: %s", spec, err))
+		}
+	}
+	return
+}
+
+func (archive *ExportArchive) String() string {
+	if archive.Resolution == 0 {
+		return archive.Function
+	}
+	return fmt.Sprintf("%s:%ds", archive.Function, archive.Resolution)
+}
+
+// WriterOutputs returns the list of output backends for rollups of the
+// metric produced by the writer. Per-metric setting is used if defined for
+// the writer (or all writers, "*"), otherwise global Outputs setting. By
+// default rollups are written to RRD files, and forwarded to Graphite,
+// InfluxDB, and Kafka when they are configured.
+func WriterOutputs(name, writer string) []string {
+	metricOutputs := MetricOptions(name).Outputs
+	for _, outputs := range []map[string][]string{metricOutputs, Outputs} {
+		if list, found := outputs[writer]; found {
+			return list
+		}
+		if list, found := outputs[ALL_WRITERS]; found {
+			return list
+		}
+	}
+	outputs := []string{OUTPUT_RRD}
+	if GraphiteAddress != "" {
+		outputs = append(outputs, OUTPUT_GRAPHITE)
+	}
+	if InfluxAddress != "" {
+		outputs = append(outputs, OUTPUT_INFLUX)
+	}
+	if Kafka != nil {
+		outputs = append(outputs, OUTPUT_KAFKA)
+	}
+	return outputs
+}
+
+// HasOutput returns a value indicating whether rollups of the metric
+// produced by the writer should be sent to the output backend.
+func HasOutput(name, writer, output string) bool {
+	for _, item := range WriterOutputs(name, writer) {
+		if item == output {
+			return true
+		}
+	}
+	return false
+}
+
+// loadOutputs parses output backends per writer name from the config file.
+func loadOutputs(items map[string]interface{}) (outputs map[string][]string, err os.Error) {
+	outputs = make(map[string][]string)
+	for writer, list := range items {
+		outputs[writer] = make([]string, 0, len(list.([]interface{})))
+		for _, item := range list.([]interface{}) {
+			output := item.(string)
+			switch output {
+			case OUTPUT_RRD, OUTPUT_GRAPHITE, OUTPUT_INFLUX, OUTPUT_KAFKA, OUTPUT_STDOUT, OUTPUT_FILE:
+			default:
+				return nil, os.NewError(fmt.Sprintf("Output %q is invalid for writer %q", output, writer))
+			}
+			outputs[writer] = append(outputs[writer], output)
+		}
+	}
+	return
+}
+
+// loadExportArchives parses archives surfaced by exports per writer name
+// from the config file.
+func loadExportArchives(items map[string]interface{}) (archives map[string]*ExportArchive, err os.Error) {
+	archives = make(map[string]*ExportArchive)
+	for writer, item := range items {
+		archive, err := ParseExportArchive(item.(string))
+		if err != nil {
+			return nil, os.NewError(fmt.Sprintf("%s (writer %q)", err, writer))
+		}
+		archives[writer] = archive
+	}
+	return
+}
+
+// loadUnknownValues parses renderings of unknown values per output format
+// from the config file. Formats not mentioned in the config file keep their
+// default renderings.
+func loadUnknownValues(items map[string]interface{}) (renderings map[string]string, err os.Error) {
+	renderings = make(map[string]string)
+	for format, rendering := range DEFAULT_UNKNOWN_VALUES {
+		renderings[format] = rendering
+	}
+	for format, rendering := range items {
+		if _, found := DEFAULT_UNKNOWN_VALUES[format]; !found {
+			return nil, os.NewError(fmt.Sprintf("Output format %q is invalid, should be one of: %s, %s, %s, %s", format, OUTPUT_GRAPHITE, OUTPUT_INFLUX, DEBUG_FORMAT_JSON, EXPORT_PROMETHEUS))
+		}
+		renderings[format] = rendering.(string)
+	}
+	return
+}
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A Predicate is a condition applied to values, parsed from a spec of one
+// or more terms separated by "or" (a value matches when any term matches):
+//     >= 0, > 0, <= 0, < 0, == 0, != 0   comparison with a number
+//     in [200, 299]                       inclusive range
+//     in {200, 201, 204}                  set membership
+// For example, "in [200, 299] or == 304".
+type Predicate struct {
+	Spec  string
+	terms []*predicateTerm
+}
+
+// predicateTerm is a single condition of a predicate.
+type predicateTerm struct {
+	op     string       // comparison operator, "[]" for ranges, "{}" for sets
+	value  int          // number to compare with, or the lower bound of a range
+	high   int          // upper bound of a range
+	values map[int]bool // members of a set
+}
+
+// Comparison operators, two-character ones first so "<" does not match
+// "<=".
+var predicateOperators = []string{">=", "<=", "==", "!=", ">", "<"}
+
+// ParsePredicate parses the predicate spec, returns an error when it is
+// invalid.
+func ParsePredicate(spec string) (predicate *Predicate, err os.Error) {
+	predicate = &Predicate{Spec: spec}
+	for _, item := range strings.Split(spec, " or ") {
+		term, err := parsePredicateTerm(strings.TrimSpace(item))
+		if err != nil {
+			return nil, os.NewError(fmt.Sprintf("Predicate %q is invalid: %s", spec, err))
+		}
+		predicate.terms = append(predicate.terms, term)
+	}
+	return
+}
+
+// parsePredicateTerm parses a single term of a predicate spec.
+func parsePredicateTerm(item string) (term *predicateTerm, err os.Error) {
+	if strings.HasPrefix(item, "in ") {
+		return parseMembershipTerm(strings.TrimSpace(item[len("in "):]))
+	}
+	for _, op := range predicateOperators {
+		if strings.HasPrefix(item, op) {
+			value, err := strconv.Atoi(strings.TrimSpace(item[len(op):]))
+			if err != nil {
+				return nil, os.NewError(fmt.Sprintf("number expected after %q in %q", op, item))
+			}
+			return &predicateTerm{op: op, value: value}, nil
+		}
+	}
+	return nil, os.NewError(fmt.Sprintf("comparison, range, or set expected, got %q", item))
+}
+
+// parseMembershipTerm parses a range ("[low, high]") or a set ("{a, b}").
+func parseMembershipTerm(item string) (term *predicateTerm, err os.Error) {
+	if len(item) < 2 {
+		return nil, os.NewError(fmt.Sprintf("range or set expected, got %q", item))
+	}
+	numbers := make([]int, 0, 4)
+	for _, number := range strings.Split(item[1:len(item)-1], ",") {
+		value, err := strconv.Atoi(strings.TrimSpace(number))
+		if err != nil {
+			return nil, os.NewError(fmt.Sprintf("number expected, got %q in %q", number, item))
+		}
+		numbers = append(numbers, value)
+	}
+
+	switch {
+	case item[0] == '[' && item[len(item)-1] == ']':
+		if len(numbers) != 2 || numbers[0] > numbers[1] {
+			return nil, os.NewError(fmt.Sprintf("range should have lower and upper bounds in increasing order, got %q", item))
+		}
+		return &predicateTerm{op: "[]", value: numbers[0], high: numbers[1]}, nil
+	case item[0] == '{' && item[len(item)-1] == '}':
+		term = &predicateTerm{op: "{}", values: make(map[int]bool)}
+		for _, value := range numbers {
+			term.values[value] = true
+		}
+		return term, nil
+	}
+	return nil, os.NewError(fmt.Sprintf("range or set expected, got %q", item))
+}
+
+// Match returns a value indicating whether the value matches any term of
+// the predicate.
+func (predicate *Predicate) Match(value int) bool {
+	for _, term := range predicate.terms {
+		if term.match(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// match returns a value indicating whether the value matches the term.
+func (term *predicateTerm) match(value int) bool {
+	switch term.op {
+	case ">=":
+		return value >= term.value
+	case "<=":
+		return value <= term.value
+	case "==":
+		return value == term.value
+	case "!=":
+		return value != term.value
+	case ">":
+		return value > term.value
+	case "<":
+		return value < term.value
+	case "[]":
+		return value >= term.value && value <= term.high
+	case "{}":
+		return term.values[value]
+	}
+	return false
+}
+
+func (predicate *Predicate) String() string {
+	return predicate.Spec
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"os"
+)
+
+// A RateLimitConfig describes the limit of events per metric name: every
+// metric has a token bucket holding up to Burst events, refilled with Rate
+// events per second. Events arriving when the bucket is empty are dropped.
+type RateLimitConfig struct {
+	Rate  float64 // events per second per metric name (0 disables the limit)
+	Burst int     // maximum number of events accepted at once
+}
+
+// Default limit of events per metric name (no limit).
+var DEFAULT_RATE_LIMIT = &RateLimitConfig{Rate: 0, Burst: 0}
+
+var (
+	// Limit of events per metric name
+	RateLimit *RateLimitConfig = DEFAULT_RATE_LIMIT
+)
+
+func (limit *RateLimitConfig) String() string {
+	if limit.Rate == 0 {
+		return "disabled"
+	}
+	return fmt.Sprintf("%v/s per metric (burst=%d)", limit.Rate, limit.Burst)
+}
+
+// loadRateLimit parses the limit of events per metric name from the config
+// file. Burst defaults to one second worth of events.
+func loadRateLimit(items map[string]interface{}) (limit *RateLimitConfig, err os.Error) {
+	limit = &RateLimitConfig{}
+	if rate, found := items["Rate"]; found {
+		limit.Rate = rate.(float64)
+	}
+	if burst, found := items["Burst"]; found {
+		limit.Burst = int(burst.(float64))
+	} else {
+		limit.Burst = int(math.Ceil(limit.Rate))
+	}
+
+	if limit.Rate < 0 {
+		return nil, os.NewError(fmt.Sprintf("Rate should not be negative: %v", limit.Rate))
+	}
+	if limit.Rate > 0 && limit.Burst < 1 {
+		return nil, os.NewError(fmt.Sprintf("Burst should be positive: %d", limit.Burst))
+	}
+	return
+}
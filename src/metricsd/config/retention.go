@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A RetentionArchive describes an RRD archive by its resolution and the
+// period it covers, e.g. "10m:30d" (10 minutes resolution for 30 days).
+type RetentionArchive struct {
+	Resolution int // seconds per consolidated value
+	Period     int // seconds covered by the archive
+}
+
+// Seconds per retention period unit.
+var retentionUnits = map[string]int{
+	"s": 1,
+	"m": 60,
+	"h": 3600,
+	"d": 86400,
+	"w": 7 * 86400,
+	"y": 365 * 86400,
+}
+
+// ParseRetentionArchive parses an archive in "<resolution>:<period>"
+// format, where both are numbers followed by a unit: s (seconds),
+// m (minutes), h (hours), d (days), w (weeks), or y (years).
+func ParseRetentionArchive(spec string) (archive *RetentionArchive, err os.Error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) != 2 {
+		return nil, os.NewError(fmt.Sprintf("Retention archive %q is invalid, should be <resolution>:<period>", spec))
+	}
+	archive = &RetentionArchive{}
+	if archive.Resolution, err = parseRetentionDuration(parts[0]); err != nil {
+		return nil, os.NewError(fmt.Sprintf("Retention archive %q is invalid: %s", spec, err))
+	}
+	if archive.Period, err = parseRetentionDuration(parts[1]); err != nil {
+		return nil, os.NewError(fmt.Sprintf("Retention archive %q is invalid: %s", spec, err))
+	}
+	if archive.Period < archive.Resolution {
+		return nil, os.NewError(fmt.Sprintf("Retention archive %q is invalid: period is shorter than resolution", spec))
+	}
+	return
+}
+
+// parseRetentionDuration parses a positive number of seconds, minutes,
+// hours, days, weeks, or years (see retentionUnits).
+func parseRetentionDuration(duration string) (int, os.Error) {
+	if len(duration) < 2 {
+		return 0, os.NewError(fmt.Sprintf("duration %q should be a number followed by a unit", duration))
+	}
+	unit, found := retentionUnits[duration[len(duration)-1:]]
+	if !found {
+		return 0, os.NewError(fmt.Sprintf("unit of duration %q should be one of s, m, h, d, w, y", duration))
+	}
+	number, err := strconv.Atoi(duration[:len(duration)-1])
+	if err != nil || number <= 0 {
+		return 0, os.NewError(fmt.Sprintf("duration %q should be a positive number followed by a unit", duration))
+	}
+	return number * unit, nil
+}
+
+// Steps returns the number of slices consolidated into a single value of
+// the archive (at least 1).
+func (archive *RetentionArchive) Steps(sliceInterval int) int {
+	if steps := archive.Resolution / sliceInterval; steps > 1 {
+		return steps
+	}
+	return 1
+}
+
+// Rows returns the number of consolidated values stored in the archive
+// (at least 1).
+func (archive *RetentionArchive) Rows(sliceInterval int) int {
+	if rows := archive.Period / (archive.Steps(sliceInterval) * sliceInterval); rows > 1 {
+		return rows
+	}
+	return 1
+}
+
+func (archive *RetentionArchive) String() string {
+	return fmt.Sprintf("%ds:%ds", archive.Resolution, archive.Period)
+}
+
+// loadRetention parses the list of retention archives from the config file.
+func loadRetention(items []interface{}) (archives []*RetentionArchive, err os.Error) {
+	archives = make([]*RetentionArchive, 0, len(items))
+	for _, item := range items {
+		archive, err := ParseRetentionArchive(item.(string))
+		if err != nil {
+			return nil, err
+		}
+		archives = append(archives, archive)
+	}
+	return
+}
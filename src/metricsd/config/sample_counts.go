@@ -0,0 +1,16 @@
+package config
+
+// Names of writers appending the number of samples to their rollups ("*"
+// applies to all writers).
+var SampleCountWriters []string
+
+// CountsSamples returns a value indicating whether rollups of the writer
+// include the number of samples (see SampleCountWriters).
+func CountsSamples(writer string) bool {
+	for _, name := range SampleCountWriters {
+		if name == writer || name == ALL_WRITERS {
+			return true
+		}
+	}
+	return false
+}
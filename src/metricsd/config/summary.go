@@ -0,0 +1,106 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// An Objective is a quantile calculated by summary writer, along with the
+// allowed absolute error of its rank (e.g. 0.9 with error 0.01 means the
+// reported value is between the 89th and the 91st percentiles).
+type Objective struct {
+	Quantile float64
+	Error    float64
+}
+
+func (objective *Objective) String() string {
+	return fmt.Sprintf("%v±%v", objective.Quantile, objective.Error)
+}
+
+// A SummaryConfig describes quantiles calculated by summary writer over a
+// sliding time window, matching summaries of Prometheus client libraries:
+// values are observed in AgeBuckets streams, the oldest stream covering
+// MaxAge seconds is reset and rotated every MaxAge/AgeBuckets seconds.
+type SummaryConfig struct {
+	Objectives []*Objective // quantiles with allowed errors, sorted by quantile
+	MaxAge     int          // length of the window, in seconds
+	AgeBuckets int          // number of streams the window is split into
+}
+
+// Default summary settings (the same as in Prometheus client libraries).
+var DEFAULT_SUMMARY = &SummaryConfig{
+	Objectives: []*Objective{&Objective{0.5, 0.05}, &Objective{0.9, 0.01}, &Objective{0.99, 0.001}},
+	MaxAge:     600,
+	AgeBuckets: 5,
+}
+
+var (
+	// Quantiles calculated by summary writer
+	Summary *SummaryConfig = DEFAULT_SUMMARY
+)
+
+func (summary *SummaryConfig) String() string {
+	return fmt.Sprintf("%v (max age=%ds, age buckets=%d)", summary.Objectives, summary.MaxAge, summary.AgeBuckets)
+}
+
+// Quantiles returns the list of quantiles of objectives.
+func (summary *SummaryConfig) Quantiles() []float64 {
+	quantiles := make([]float64, len(summary.Objectives))
+	for idx, objective := range summary.Objectives {
+		quantiles[idx] = objective.Quantile
+	}
+	return quantiles
+}
+
+// objectives sorts objectives by quantile.
+type objectives []*Objective
+
+func (self objectives) Len() int           { return len(self) }
+func (self objectives) Less(i, j int) bool { return self[i].Quantile < self[j].Quantile }
+func (self objectives) Swap(i, j int)      { self[i], self[j] = self[j], self[i] }
+
+// loadSummary parses summary settings from the config file. Objectives are
+// defined as a map of quantiles (as strings, e.g. "0.99") to their allowed
+// errors. Settings not mentioned in the config file keep their default
+// values.
+func loadSummary(items map[string]interface{}) (summary *SummaryConfig, err os.Error) {
+	summary = &SummaryConfig{}
+	*summary = *DEFAULT_SUMMARY
+	if list, found := items["Objectives"]; found {
+		summary.Objectives = make([]*Objective, 0, len(list.(map[string]interface{})))
+		for key, value := range list.(map[string]interface{}) {
+			quantile, err := strconv.Atof64(key)
+			if err != nil {
+				return nil, os.NewError(fmt.Sprintf("Invalid quantile %q: %s", key, err))
+			}
+			objective := &Objective{Quantile: quantile, Error: value.(float64)}
+			if quantile <= 0 || quantile >= 1 {
+				return nil, os.NewError(fmt.Sprintf("Quantile should be in (0, 1): %v", quantile))
+			}
+			if objective.Error <= 0 || objective.Error >= 1 {
+				return nil, os.NewError(fmt.Sprintf("Error of quantile %v should be in (0, 1): %v", quantile, objective.Error))
+			}
+			summary.Objectives = append(summary.Objectives, objective)
+		}
+		sort.Sort(objectives(summary.Objectives))
+	}
+	if maxAge, found := items["MaxAge"]; found {
+		summary.MaxAge = int(maxAge.(float64))
+	}
+	if ageBuckets, found := items["AgeBuckets"]; found {
+		summary.AgeBuckets = int(ageBuckets.(float64))
+	}
+
+	if len(summary.Objectives) == 0 {
+		return nil, os.NewError("Objectives should not be empty")
+	}
+	if summary.MaxAge <= 0 {
+		return nil, os.NewError(fmt.Sprintf("MaxAge should be positive: %d", summary.MaxAge))
+	}
+	if summary.AgeBuckets <= 0 {
+		return nil, os.NewError(fmt.Sprintf("AgeBuckets should be positive: %d", summary.AgeBuckets))
+	}
+	return
+}
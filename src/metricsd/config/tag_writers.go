@@ -0,0 +1,96 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path"
+)
+
+// A TagWritersConfig routes series with matching tags to their own writers,
+// overriding per-metric and type writers (see UsesWriter).
+type TagWritersConfig struct {
+	Pattern string            // shell pattern matching metric names, empty matches all metrics
+	Tags    map[string]string // shell patterns matching tag values, keyed by tag name (all of them should match)
+	Writers []string          // writers processing matching series
+}
+
+var (
+	// Writers of series with matching tags, the first matching rule wins
+	TagWriters []*TagWritersConfig
+)
+
+func (rule *TagWritersConfig) String() string {
+	return fmt.Sprintf("%q %v -> %v", rule.Pattern, rule.Tags, rule.Writers)
+}
+
+// matches returns a value indicating whether the series of the metric with
+// the given name and tags matches the rule: the name matches the pattern,
+// and every tag of the rule is present with a matching value.
+func (rule *TagWritersConfig) matches(name string, tags map[string]string) bool {
+	if rule.Pattern != "" {
+		if matched, _ := path.Match(rule.Pattern, name); !matched {
+			return false
+		}
+	}
+	for key, pattern := range rule.Tags {
+		value, found := tags[key]
+		if !found {
+			return false
+		}
+		if matched, _ := path.Match(pattern, value); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// tagWriters returns writers of the first rule of TagWriters matching the
+// series, nil when no rule matches. Untagged series never match.
+func tagWriters(name string, tags map[string]string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	for _, rule := range TagWriters {
+		if rule.matches(name, tags) {
+			return rule.Writers
+		}
+	}
+	return nil
+}
+
+// loadTagWriters parses tag routing rules from the config file.
+func loadTagWriters(items []interface{}) (rules []*TagWritersConfig, err os.Error) {
+	rules = make([]*TagWritersConfig, 0, len(items))
+	for _, item := range items {
+		options := item.(map[string]interface{})
+		rule := &TagWritersConfig{Tags: make(map[string]string)}
+		if pattern, found := options["Pattern"]; found {
+			rule.Pattern = pattern.(string)
+		}
+		if tags, found := options["Tags"]; found {
+			for key, value := range tags.(map[string]interface{}) {
+				rule.Tags[key] = value.(string)
+			}
+		}
+		if writers, found := options["Writers"]; found {
+			rule.Writers = loadStrings(writers.([]interface{}))
+		}
+
+		if len(rule.Tags) == 0 {
+			return nil, os.NewError(fmt.Sprintf("Tags are required for tag writers (pattern %q)", rule.Pattern))
+		}
+		if len(rule.Writers) == 0 {
+			return nil, os.NewError(fmt.Sprintf("Writers are required for tag writers %v", rule.Tags))
+		}
+		if _, err := path.Match(rule.Pattern, ""); err != nil {
+			return nil, os.NewError(fmt.Sprintf("Pattern %q is invalid: %s", rule.Pattern, err))
+		}
+		for key, pattern := range rule.Tags {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, os.NewError(fmt.Sprintf("Pattern %q of tag %q is invalid: %s", pattern, key, err))
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return
+}
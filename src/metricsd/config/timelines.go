@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// A TimelineConfig describes a separate timeline receiving metrics with
+// names starting with the prefix (see Timelines).
+type TimelineConfig struct {
+	Prefix   string   // prefix of metric names routed to the timeline
+	Interval int      // slice interval in seconds, 0 means SliceInterval
+	Writers  []string // active writers of the timeline, nil means Writers
+}
+
+// Separate timelines per metric name prefix, metrics not matching any
+// prefix go to the default timeline
+var Timelines []*TimelineConfig
+
+// SliceInterval returns the slice interval of the timeline.
+func (timeline *TimelineConfig) SliceInterval() int {
+	if timeline.Interval > 0 {
+		return timeline.Interval
+	}
+	return SliceInterval
+}
+
+// ActiveWriters returns names of active writers of the timeline.
+func (timeline *TimelineConfig) ActiveWriters() []string {
+	if timeline.Writers != nil {
+		return timeline.Writers
+	}
+	return Writers
+}
+
+func (timeline *TimelineConfig) String() string {
+	return fmt.Sprintf("%s* (interval=%d, writers=%v)", timeline.Prefix, timeline.SliceInterval(), timeline.ActiveWriters())
+}
+
+// TimelineFor returns settings of the timeline receiving the metric with
+// the given name (the longest matching prefix wins), or nil when the metric
+// goes to the default timeline.
+func TimelineFor(name string) (result *TimelineConfig) {
+	for _, timeline := range Timelines {
+		if strings.HasPrefix(name, timeline.Prefix) && (result == nil || len(timeline.Prefix) > len(result.Prefix)) {
+			result = timeline
+		}
+	}
+	return
+}
+
+// MetricInterval returns the slice interval of the timeline receiving the
+// metric with the given name (see AdaptiveIntervals for metrics of the
+// default timeline).
+func MetricInterval(name string) int {
+	if timeline := TimelineFor(name); timeline != nil {
+		return timeline.SliceInterval()
+	}
+	if interval, found := AdaptedInterval(name); found {
+		return interval
+	}
+	return SliceInterval
+}
+
+// loadTimelines parses separate timelines from the config file.
+func loadTimelines(items []interface{}) (timelines []*TimelineConfig, err os.Error) {
+	timelines = make([]*TimelineConfig, 0, len(items))
+	prefixes := make(map[string]bool)
+	for _, item := range items {
+		options := item.(map[string]interface{})
+		prefix, found := options["Prefix"]
+		if !found || prefix.(string) == "" {
+			return nil, os.NewError("Prefix is required for timeline settings")
+		}
+		timeline := &TimelineConfig{Prefix: prefix.(string)}
+		if prefixes[timeline.Prefix] {
+			return nil, os.NewError(fmt.Sprintf("Timeline prefix %q is defined twice", timeline.Prefix))
+		}
+		prefixes[timeline.Prefix] = true
+
+		if interval, found := options["SliceInterval"]; found {
+			timeline.Interval = (int)(interval.(float64))
+			if timeline.Interval <= 0 {
+				return nil, os.NewError(fmt.Sprintf("Slice interval %d is invalid for timeline %q", timeline.Interval, timeline.Prefix))
+			}
+		}
+		if writers, found := options["Writers"]; found {
+			timeline.Writers = loadStrings(writers.([]interface{}))
+		}
+		timelines = append(timelines, timeline)
+	}
+	return
+}
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A TransformFunc converts a value before it is stored in a sample set,
+// returns false when the value should be dropped.
+type TransformFunc func(value float64) (float64, bool)
+
+// A Transform is a step of a transform pipeline, parsed from a spec with
+// the transform name followed by its arguments separated by ":", e.g.
+// "scale:0.001" or "clamp:0:100".
+type Transform struct {
+	Spec string
+	f    TransformFunc
+}
+
+// A TransformPipeline applies transforms to values in order.
+type TransformPipeline []*Transform
+
+// Constructors of transforms, keyed by transform name (see
+// RegisterTransform).
+var transforms = map[string]func(args []float64) (TransformFunc, os.Error){
+	"abs":   absTransform,
+	"scale": scaleTransform,
+	"clamp": clampTransform,
+	"log":   logTransform,
+}
+
+// Range of values returned by transform pipelines.
+const (
+	maxTransformed = int(^uint(0) >> 1)
+	minTransformed = -maxTransformed - 1
+)
+
+// RegisterTransform makes a transform available by the given name in
+// Transforms per-metric option. The constructor receives numeric arguments
+// of the spec, and returns an error when they are invalid. It should be
+// called before configuration is loaded. If RegisterTransform is called
+// twice with the same name, the latter constructor wins.
+func RegisterTransform(name string, constructor func(args []float64) (TransformFunc, os.Error)) {
+	transforms[name] = constructor
+}
+
+// ParseTransform parses the transform spec, returns an error when the
+// transform is unknown or its arguments are invalid.
+func ParseTransform(spec string) (transform *Transform, err os.Error) {
+	parts := strings.Split(spec, ":")
+	constructor, found := transforms[parts[0]]
+	if !found {
+		return nil, os.NewError(fmt.Sprintf("Unknown transform %q in %q", parts[0], spec))
+	}
+	args := make([]float64, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		arg, err := strconv.Atof64(part)
+		if err != nil {
+			return nil, os.NewError(fmt.Sprintf("Transform %q is invalid: number expected, got %q", spec, part))
+		}
+		args = append(args, arg)
+	}
+	f, err := constructor(args)
+	if err != nil {
+		return nil, os.NewError(fmt.Sprintf("Transform %q is invalid: %s", spec, err))
+	}
+	return &Transform{Spec: spec, f: f}, nil
+}
+
+// Apply passes the value through all transforms of the pipeline, and
+// returns the result rounded to the nearest integer (saturating at the
+// range of int). Returns false when a transform dropped the value.
+func (pipeline TransformPipeline) Apply(value int) (int, bool) {
+	result := float64(value)
+	for _, transform := range pipeline {
+		var ok bool
+		if result, ok = transform.f(result); !ok || math.IsNaN(result) {
+			return 0, false
+		}
+	}
+	switch {
+	case result >= float64(maxTransformed):
+		return maxTransformed, true
+	case result <= float64(minTransformed):
+		return minTransformed, true
+	}
+	return int(math.Floor(result + 0.5)), true
+}
+
+func (pipeline TransformPipeline) String() string {
+	specs := make([]string, len(pipeline))
+	for idx, transform := range pipeline {
+		specs[idx] = transform.Spec
+	}
+	return "[" + strings.Join(specs, ", ") + "]"
+}
+
+// loadTransforms parses transform specs from the config file.
+func loadTransforms(items []interface{}) (pipeline TransformPipeline, err os.Error) {
+	pipeline = make(TransformPipeline, 0, len(items))
+	for _, item := range items {
+		transform, err := ParseTransform(item.(string))
+		if err != nil {
+			return nil, err
+		}
+		pipeline = append(pipeline, transform)
+	}
+	return
+}
+
+// absTransform returns the absolute value ("abs").
+func absTransform(args []float64) (TransformFunc, os.Error) {
+	if len(args) != 0 {
+		return nil, os.NewError("no arguments expected")
+	}
+	return func(value float64) (float64, bool) {
+		return math.Fabs(value), true
+	}, nil
+}
+
+// scaleTransform multiplies the value by a factor ("scale:<factor>"), e.g.
+// to convert units.
+func scaleTransform(args []float64) (TransformFunc, os.Error) {
+	if len(args) != 1 {
+		return nil, os.NewError("factor expected")
+	}
+	factor := args[0]
+	return func(value float64) (float64, bool) {
+		return value * factor, true
+	}, nil
+}
+
+// clampTransform limits the value to a range ("clamp:<min>:<max>").
+func clampTransform(args []float64) (TransformFunc, os.Error) {
+	if len(args) != 2 || args[0] > args[1] {
+		return nil, os.NewError("minimum and maximum expected")
+	}
+	min, max := args[0], args[1]
+	return func(value float64) (float64, bool) {
+		return math.Fmin(math.Fmax(value, min), max), true
+	}, nil
+}
+
+// logTransform returns the logarithm of the value ("log[:<base>]", base 10
+// by default), dropping values which are not positive.
+func logTransform(args []float64) (TransformFunc, os.Error) {
+	base := 10.0
+	switch {
+	case len(args) > 1:
+		return nil, os.NewError("base expected")
+	case len(args) == 1:
+		base = args[0]
+	}
+	if base <= 0 || base == 1 {
+		return nil, os.NewError(fmt.Sprintf("base %v is invalid", base))
+	}
+	scale := math.Log(base)
+	return func(value float64) (float64, bool) {
+		if value <= 0 {
+			return 0, false
+		}
+		return math.Log(value) / scale, true
+	}, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+)
+
+// historyFile is a file with historical records opened by openHistory.
+type historyFile struct {
+	file         *os.File
+	decompressor io.ReadCloser // nil when the file is not compressed
+}
+
+// openHistory opens the file with historical records (see importFile and
+// replayFile) for reading line by line. Files compressed with gzip are
+// detected by the magic bytes (regardless of the extension), and
+// decompressed on the fly, so compressed archives of any size are streamed
+// without unpacking them first.
+func openHistory(path string) (reader *bufio.Reader, closer io.Closer, err os.Error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	history := &historyFile{file: file}
+	reader = bufio.NewReader(file)
+	if magic, error := reader.Peek(2); error == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		if history.decompressor, err = gzip.NewReader(reader); err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		reader = bufio.NewReader(history.decompressor)
+		log.Debug("Reading gzip-compressed history from %s", path)
+	}
+	return reader, history, nil
+}
+
+// Close closes the decompressor and the file.
+func (self *historyFile) Close() os.Error {
+	if self.decompressor != nil {
+		self.decompressor.Close()
+	}
+	return self.file.Close()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"metricsd/config"
+	"metricsd/parser"
+)
+
+// importFile reads historical data from the file in CSV/TSV format (see
+// parser.ParseRecord), and writes it using active writers. File is
+// processed line by line, so it could be of any size (gzip-compressed files
+// are decompressed on the fly, see openHistory). Records should be
+// sorted by timestamp: timeline is flushed every time a record crosses
+// the write interval boundary, and records older than the flushed data
+// are skipped (RRDTool does not accept updates in the past anyway). Exact
+// duplicates are dropped when ImportDedup is enabled.
+func importFile(path string) (err os.Error) {
+	reader, file, err := openHistory(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	var imported, skipped int
+	var flushAt, flushed int64
+	interval := int64(config.WriteInterval)
+	for lineNumber := 1; ; lineNumber++ {
+		line, error := reader.ReadString('\n')
+		if error != nil && error != os.EOF {
+			err = error
+			return
+		}
+
+		if record := strings.TrimSpace(line); record != "" && record[0] != '#' {
+			event, timestamp, parseError := parser.ParseRecord(record)
+			switch {
+			case parseError != nil && lineNumber == 1:
+				// Header line
+				log.Debug("Skipping header: %s", record)
+			case parseError != nil:
+				log.Warn("Skipping line %d: %s", lineNumber, parseError)
+				skipped++
+			case timestamp < flushed:
+				log.Warn("Skipping line %d: record is older than already written data (record=%q)", lineNumber, record)
+				skipped++
+			default:
+				// Write all slices before the next write interval
+				if timestamp >= flushAt {
+					if flushAt > 0 {
+						rollupSlices(true)
+						flushed = timestamp - timestamp%int64(config.SliceInterval)
+					}
+					flushAt = timestamp - timestamp%interval + interval
+				}
+				event.Source = "all"
+				parser.ExtractTags(nameTemplates, event)
+				router.AddAt(event, timestamp)
+				for _, alias := range parser.Aliases(config.Aliases, event) {
+					router.AddAt(alias, timestamp)
+				}
+				imported++
+			}
+		}
+
+		if error == os.EOF {
+			break
+		}
+	}
+	rollupSlices(true)
+	var duplicates int
+	for _, route := range router.Routes {
+		duplicates += int(route.Timeline.Duplicates)
+	}
+	log.Info("Imported %d events from %s (%d skipped, %d duplicates)", imported-duplicates, path, skipped, duplicates)
+	return
+}
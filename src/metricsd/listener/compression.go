@@ -0,0 +1,41 @@
+package listener
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"metricsd/config"
+)
+
+// Magic bytes starting gzip streams (see RFC 1952).
+const gzipMagic = "\x1f\x8b"
+
+// decompress returns the reader of data received from the connection,
+// decompressed according to the listener compression: "gzip" expects a
+// gzip stream, "auto" decompresses the stream when it starts with gzip
+// magic bytes, and reads it as is otherwise. Returns a value indicating
+// whether data is compressed, and an error when gzip header is invalid.
+func decompress(conn io.Reader, compression string) (reader io.Reader, compressed bool, err os.Error) {
+	switch compression {
+	case config.COMPRESSION_GZIP:
+		return gunzip(conn)
+	case config.COMPRESSION_AUTO:
+		buffered := bufio.NewReader(conn)
+		// Short or failed reads are reported by the caller reading lines
+		if magic, err := buffered.Peek(len(gzipMagic)); err == nil && string(magic) == gzipMagic {
+			return gunzip(buffered)
+		}
+		return buffered, false, nil
+	}
+	return conn, false, nil
+}
+
+// gunzip returns the reader of decompressed gzip stream.
+func gunzip(source io.Reader) (reader io.Reader, compressed bool, err os.Error) {
+	decompressor, err := gzip.NewReader(source)
+	if err != nil {
+		return nil, true, err
+	}
+	return decompressor, true, nil
+}
@@ -0,0 +1,308 @@
+// The listener package implements network listeners, which receive raw
+// events over UDP, TCP, or Unix sockets and pass them to a protocol parser.
+package listener
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"metricsd/config"
+	"metricsd/parser"
+)
+
+var (
+	// Number of lines (TCP, Unix) and packets (UDP) discarded because they
+	// exceed MaxLineLength
+	Discarded int64
+	// Number of connections closed because their data could not be
+	// decompressed
+	DecompressionErrors int64
+	// Number of connections closed because serving them panicked
+	ConnectionPanics int64
+)
+
+// A Handler is called for each packet (UDP) or line (TCP, Unix) received by
+// a listener, along with the parser configured for the listener.
+type Handler func(addr net.Addr, buf string, parse parser.ParseFunc)
+
+// A listener receives data from a single network address.
+type listener struct {
+	config *config.ListenerConfig
+	parse  parser.ParseFunc
+	bound  int32            // 1 while the listener is bound to its address (see Manager.Bound)
+	socket *inheritedSocket // socket passed by systemd, used by the first run only (see takeSocket)
+}
+
+func newListener(cfg *config.ListenerConfig) (l *listener, err os.Error) {
+	switch cfg.Protocol {
+	case "udp", "tcp", "unix":
+	default:
+		err = os.NewError(fmt.Sprintf("Unknown protocol %q for listener %s", cfg.Protocol, cfg))
+		return
+	}
+	switch {
+	case cfg.Compression != config.COMPRESSION_NONE && cfg.Compression != config.COMPRESSION_GZIP && cfg.Compression != config.COMPRESSION_AUTO:
+		err = os.NewError(fmt.Sprintf("Unknown compression %q for listener %s, should be one of: %s, %s", cfg.Compression, cfg, config.COMPRESSION_GZIP, config.COMPRESSION_AUTO))
+		return
+	case cfg.Compression != config.COMPRESSION_NONE && cfg.Protocol == "udp":
+		err = os.NewError(fmt.Sprintf("Compression is not supported for UDP listener %s", cfg))
+		return
+	}
+	parse, err := parser.Lookup(cfg.Parser)
+	if err != nil {
+		return
+	}
+	if len(cfg.Tags) > 0 {
+		parse = parser.WithTags(parse, cfg.Tags)
+	}
+	l = &listener{config: cfg, parse: parse}
+	return
+}
+
+// run listens for incoming data until quit channel is closed or an error
+// occurred. Returns the error (nil when stopped using the quit channel).
+func (l *listener) run(handle Handler, quit <-chan bool) (err os.Error) {
+	// Convert panics in the listener to errors, so it could be restarted
+	defer func() {
+		if r := recover(); r != nil {
+			err = os.NewError(fmt.Sprintf("Listener %s panicked: %v", l.config, r))
+		}
+	}()
+
+	switch l.config.Protocol {
+	case "udp":
+		return l.runPacket(handle, quit)
+	}
+	return l.runStream(handle, quit)
+}
+
+// takeSocket returns the socket passed by systemd (or nil), and forgets
+// it: restarted listeners bind their addresses themselves, since the socket
+// is closed by then.
+func (l *listener) takeSocket() (socket *inheritedSocket) {
+	socket, l.socket = l.socket, nil
+	return
+}
+
+// runPacket receives UDP packets, every packet is processed as a whole.
+func (l *listener) runPacket(handle Handler, quit <-chan bool) os.Error {
+	conn, err := l.listenPacket()
+	if err != nil {
+		return err
+	}
+	// Ensure listener will be closed on return
+	defer conn.Close()
+	defer l.setBound()()
+
+	// Timeout is 0.1 second, so we could check the quit channel
+	conn.SetTimeout(1e8)
+	conn.SetReadTimeout(1e8)
+
+	// One more byte to detect packets exceeding the limit
+	data := make([]byte, config.MaxLineLength+1)
+	for {
+		select {
+		case <-quit:
+			return nil
+		default:
+			n, addr, err := conn.ReadFromUDP(data)
+			if err != nil {
+				if addr != nil {
+					config.Logger.Debug("Cannot read UDP from %s: %s\n", addr, err)
+				}
+				continue
+			}
+			if n > config.MaxLineLength {
+				atomic.AddInt64(&Discarded, 1)
+				config.Logger.Debug("Packet from %s exceeds %d bytes, discarding", addr, config.MaxLineLength)
+				continue
+			}
+			handle(addr, string(data[0:n]), l.parse)
+		}
+	}
+	return nil
+}
+
+// listenPacket returns the UDP socket passed by systemd, or binds the
+// listener address.
+func (l *listener) listenPacket() (*net.UDPConn, os.Error) {
+	if socket := l.takeSocket(); socket != nil {
+		if conn, ok := socket.packet.(*net.UDPConn); ok {
+			return conn, nil
+		}
+		socket.close()
+		return nil, os.NewError(fmt.Sprintf("Socket passed by systemd for %s is not a UDP socket", l.config))
+	}
+	address, err := net.ResolveUDPAddr("udp", l.config.Address)
+	if err != nil {
+		return nil, err
+	}
+	return net.ListenUDP("udp", address)
+}
+
+// connections is a set of accepted stream connections being served.
+type connections struct {
+	conns  map[net.Conn]bool
+	mutex  *sync.Mutex
+	served *sync.WaitGroup // connections being served
+}
+
+func newConnections() *connections {
+	return &connections{conns: make(map[net.Conn]bool), mutex: &sync.Mutex{}, served: &sync.WaitGroup{}}
+}
+
+// add adds the connection to the set before it is served.
+func (self *connections) add(conn net.Conn) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.conns[conn] = true
+	self.served.Add(1)
+}
+
+// remove removes the connection served to the end from the set.
+func (self *connections) remove(conn net.Conn) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.conns[conn] = false, false
+	self.served.Done()
+}
+
+// close closes all connections, so blocked reads return, and waits until
+// they are not served anymore.
+func (self *connections) close() {
+	self.mutex.Lock()
+	for conn := range self.conns {
+		conn.Close()
+	}
+	self.mutex.Unlock()
+	self.served.Wait()
+}
+
+// runStream accepts TCP or Unix socket connections, every line received
+// from a connection is processed separately. Connections are closed when
+// the listener stops, and runStream returns after all of them are served,
+// so no events are handled by a stopped listener.
+func (l *listener) runStream(handle Handler, quit <-chan bool) os.Error {
+	ln, err := l.listenStream()
+	if err != nil {
+		return err
+	}
+	defer l.setBound()()
+	conns := newConnections()
+	defer conns.close()
+
+	// Accept blocks, so close the listener when asked to quit
+	go func() {
+		<-quit
+		ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if isClosed(quit) {
+				return nil
+			}
+			ln.Close()
+			return err
+		}
+		conns.add(conn)
+		go func() {
+			defer conns.remove(conn)
+			l.serve(conn, handle)
+		}()
+	}
+	return nil
+}
+
+// listenStream returns the stream socket passed by systemd, or binds the
+// listener address.
+func (l *listener) listenStream() (net.Listener, os.Error) {
+	if socket := l.takeSocket(); socket != nil {
+		if socket.stream != nil {
+			return socket.stream, nil
+		}
+		socket.close()
+		return nil, os.NewError(fmt.Sprintf("Socket passed by systemd for %s is not a stream socket", l.config))
+	}
+	if l.config.Protocol == "unix" {
+		// Remove stale socket left from the previous run
+		os.Remove(l.config.Address)
+	}
+	return net.Listen(l.config.Protocol, l.config.Address)
+}
+
+// setBound marks the listener as bound, and returns a function marking it
+// as not bound anymore.
+func (l *listener) setBound() func() {
+	atomic.AddInt32(&l.bound, 1)
+	return func() { atomic.AddInt32(&l.bound, -1) }
+}
+
+// serve reads lines from the connection until it is closed. Lines longer
+// than MaxLineLength are discarded. Compressed data is decompressed on the
+// fly (see decompress), the connection is closed on decompression errors.
+// Panics are logged and counted in ConnectionPanics, the connection is
+// closed then, while the listener keeps accepting other connections.
+func (l *listener) serve(conn net.Conn, handle Handler) {
+	defer func() {
+		if r := recover(); r != nil {
+			atomic.AddInt64(&ConnectionPanics, 1)
+			config.Logger.Error("Listener %s panicked serving %s: %v", l.config, conn.RemoteAddr(), r)
+		}
+	}()
+	defer conn.Close()
+	source, compressed, err := decompress(conn, l.config.Compression)
+	if err != nil {
+		atomic.AddInt64(&DecompressionErrors, 1)
+		config.Logger.Debug("Cannot decompress data from %s: %s", conn.RemoteAddr(), err)
+		return
+	}
+	// Line could be followed with "\r\n"
+	reader, err := bufio.NewReaderSize(source, config.MaxLineLength+2)
+	if err != nil {
+		config.Logger.Error("Cannot read from %s: %s", conn.RemoteAddr(), err)
+		return
+	}
+	for {
+		line, overlong, err := readLine(reader)
+		if overlong {
+			atomic.AddInt64(&Discarded, 1)
+			config.Logger.Debug("Line from %s exceeds %d bytes, discarding", conn.RemoteAddr(), config.MaxLineLength)
+		} else if len(line) > 0 {
+			handle(conn.RemoteAddr(), line, l.parse)
+		}
+		if err != nil {
+			if err != os.EOF {
+				if compressed {
+					atomic.AddInt64(&DecompressionErrors, 1)
+				}
+				config.Logger.Debug("Cannot read from %s: %s", conn.RemoteAddr(), err)
+			}
+			return
+		}
+	}
+}
+
+// readLine reads a line without line terminator. When line exceeds
+// MaxLineLength, it is skipped up to the next newline (so the following
+// lines are read correctly), and overlong is true.
+func readLine(reader *bufio.Reader) (line string, overlong bool, err os.Error) {
+	data, err := reader.ReadSlice('\n')
+	for err == bufio.ErrBufferFull {
+		overlong = true
+		_, err = reader.ReadSlice('\n')
+	}
+	if overlong {
+		return "", true, err
+	}
+	line = strings.TrimRight(string(data), "\r\n")
+	if len(line) > config.MaxLineLength {
+		return "", true, err
+	}
+	return
+}
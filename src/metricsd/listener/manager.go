@@ -0,0 +1,115 @@
+package listener
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+	"metricsd/config"
+)
+
+const (
+	// Delay before restarting a failed listener, in nanoseconds.
+	restartDelay = 1e9
+)
+
+// A Manager starts a set of listeners and supervises them: when a listener
+// dies, it will be restarted.
+type Manager struct {
+	listeners []*listener
+	handle    Handler
+}
+
+// NewManager creates listeners using the given configuration. Every
+// listener passes received data to the given handler. Sockets passed by
+// systemd socket activation are used by listeners with the same protocol
+// and address (see inheritSockets), other listeners bind their addresses.
+func NewManager(listeners []*config.ListenerConfig, handle Handler) (manager *Manager, err os.Error) {
+	manager = &Manager{listeners: make([]*listener, 0, len(listeners)), handle: handle}
+	for _, cfg := range listeners {
+		l, err := newListener(cfg)
+		if err != nil {
+			return nil, err
+		}
+		manager.listeners = append(manager.listeners, l)
+	}
+	sockets, err := inheritSockets()
+	if err != nil {
+		return nil, err
+	}
+	manager.assignSockets(sockets)
+	return
+}
+
+// assignSockets passes inherited sockets to listeners bound to the same
+// addresses. Sockets not matching any listener are closed.
+func (manager *Manager) assignSockets(sockets []*inheritedSocket) {
+	for _, socket := range sockets {
+		assigned := false
+		for _, l := range manager.listeners {
+			if l.socket == nil && socket.matches(l.config) {
+				config.Logger.Info("Using socket %s passed by systemd for listener %s", socket.addr(), l.config)
+				l.socket = socket
+				assigned = true
+				break
+			}
+		}
+		if !assigned {
+			config.Logger.Warn("Socket %s passed by systemd does not match any listener, closing", socket.addr())
+			socket.close()
+		}
+	}
+}
+
+// Run starts all listeners and blocks until the quit channel receives a
+// value. Then all listeners are stopped, Run returns after connections of
+// stream listeners are closed, and all received data is handled.
+func (manager *Manager) Run(quit <-chan bool) {
+	stop := make(chan bool)
+	done := make(chan bool)
+	for _, l := range manager.listeners {
+		go manager.supervise(l, stop, done)
+	}
+
+	<-quit
+	config.Logger.Debug("Shutting down listeners...")
+	close(stop)
+	for _ = range manager.listeners {
+		<-done
+	}
+}
+
+// Bound returns a value indicating whether all listeners are bound to their
+// addresses (listeners being restarted are not).
+func (manager *Manager) Bound() bool {
+	for _, l := range manager.listeners {
+		if atomic.AddInt32(&l.bound, 0) <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// supervise runs the listener, and restarts it when it dies.
+func (manager *Manager) supervise(l *listener, stop chan bool, done chan<- bool) {
+	for {
+		config.Logger.Debug("Starting listener on %s", l.config)
+		err := l.run(manager.handle, stop)
+		if isClosed(stop) {
+			break
+		}
+		config.Logger.Error("Listener %s died: %s, restarting", l.config, err)
+		time.Sleep(restartDelay)
+	}
+	done <- true
+}
+
+// isClosed returns a value indicating whether the given channel is closed
+// (it is never used to send values, only closed).
+func isClosed(ch <-chan bool) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+	}
+	return false
+}
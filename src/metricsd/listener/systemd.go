@@ -0,0 +1,107 @@
+package listener
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"metricsd/config"
+)
+
+// The first file descriptor passed by systemd socket activation (see
+// sd_listen_fds).
+const listenFdsStart = 3
+
+// An inheritedSocket is a socket opened by systemd and passed to MetricsD
+// on startup, so it does not have to bind the address itself.
+type inheritedSocket struct {
+	stream net.Listener   // TCP or Unix socket listener
+	packet net.PacketConn // UDP socket
+}
+
+// addr returns the local address of the socket.
+func (socket *inheritedSocket) addr() net.Addr {
+	if socket.stream != nil {
+		return socket.stream.Addr()
+	}
+	return socket.packet.LocalAddr()
+}
+
+// close closes the socket, when no listener uses it.
+func (socket *inheritedSocket) close() {
+	if socket.stream != nil {
+		socket.stream.Close()
+	} else {
+		socket.packet.Close()
+	}
+}
+
+// inheritSockets returns sockets passed with systemd socket activation:
+// LISTEN_FDS file descriptors starting from 3, when LISTEN_PID matches the
+// process. Returns an empty list when MetricsD is not socket-activated.
+func inheritSockets() (sockets []*inheritedSocket, err os.Error) {
+	if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 0 {
+		return nil, os.NewError(fmt.Sprintf("Invalid LISTEN_FDS %q passed by systemd", os.Getenv("LISTEN_FDS")))
+	}
+	sockets = make([]*inheritedSocket, 0, count)
+	for fd := listenFdsStart; fd < listenFdsStart+count; fd++ {
+		socket, err := inheritSocket(fd)
+		if err != nil {
+			return nil, err
+		}
+		sockets = append(sockets, socket)
+	}
+	return
+}
+
+// inheritSocket returns the stream or packet socket with the given file
+// descriptor.
+func inheritSocket(fd int) (socket *inheritedSocket, err os.Error) {
+	file := os.NewFile(fd, fmt.Sprintf("LISTEN_FD_%d", fd))
+	// Listeners and connections use duplicates of the file descriptor
+	defer file.Close()
+	if stream, err := net.FileListener(file); err == nil {
+		return &inheritedSocket{stream: stream}, nil
+	}
+	packet, err := net.FilePacketConn(file)
+	if err != nil {
+		return nil, os.NewError(fmt.Sprintf("File descriptor %d passed by systemd is not a socket: %s", fd, err))
+	}
+	return &inheritedSocket{packet: packet}, nil
+}
+
+// matches returns a value indicating whether the socket is bound to the
+// address of the listener, using the listener's protocol. Unspecified
+// hosts (e.g. ":5000") match sockets bound to all interfaces.
+func (socket *inheritedSocket) matches(cfg *config.ListenerConfig) bool {
+	switch addr := socket.addr().(type) {
+	case *net.TCPAddr:
+		expected, err := net.ResolveTCPAddr("tcp", cfg.Address)
+		return cfg.Protocol == "tcp" && err == nil && expected.Port == addr.Port && sameHost(expected.IP, addr.IP)
+	case *net.UDPAddr:
+		expected, err := net.ResolveUDPAddr("udp", cfg.Address)
+		return cfg.Protocol == "udp" && err == nil && expected.Port == addr.Port && sameHost(expected.IP, addr.IP)
+	case *net.UnixAddr:
+		return cfg.Protocol == "unix" && addr.Name == cfg.Address
+	}
+	return false
+}
+
+// sameHost returns a value indicating whether the address of a listener
+// matches the address a socket is bound to.
+func sameHost(expected, actual net.IP) bool {
+	if isUnspecified(expected) {
+		return isUnspecified(actual)
+	}
+	return expected.Equal(actual)
+}
+
+// isUnspecified returns a value indicating whether the address means all
+// interfaces.
+func isUnspecified(ip net.IP) bool {
+	return ip == nil || ip.String() == "0.0.0.0" || ip.String() == "::"
+}
@@ -1,13 +1,21 @@
 package main
 
 import (
+	"expvar"
+	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"rand"
 	"runtime"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 	"metricsd/config"
+	"metricsd/listener"
 	"metricsd/logger"
 	"metricsd/parser"
 	"metricsd/writers"
@@ -17,16 +25,33 @@ import (
 )
 
 var (
-	log                 logger.Logger     /* Logger instance */
-	hostLookupCache     map[string]string /* DNS names cache */
-	timeline            *types.Timeline   /* Timeline */
-	eventsReceived      int64             /* Events received */
-	totalEventsReceived int64             /* Total Events received */
-	bytesReceived       int64             /* Bytes sent */
-	totalBytesReceived  int64             /* Total bytes sent */
-	activeWriters       []writers.Writer  /* The list of active writers */
+	log                 logger.Logger          /* Logger instance */
+	hostLookupCache     map[string]string      /* DNS names cache */
+	hostLookupMutex     *sync.Mutex            /* Mutex protecting hostLookupCache (used by all listeners) */
+	timeline            *types.Timeline        /* Default timeline */
+	eventsReceived      int64                  /* Events received */
+	totalEventsReceived int64                  /* Total Events received */
+	bytesReceived       int64                  /* Bytes sent */
+	totalBytesReceived  int64                  /* Total bytes sent */
+	router              *writers.Router        /* Dispatches events to timelines by metric prefix */
+	events              chan *types.Event      /* Ingestion queue between listener and timeline */
+	eventsDropped       int64                  /* Events dropped because of full ingestion queue */
+	unknownTypes        int64                  /* Events with unknown declared type */
+	relabelDropped      int64                  /* Events dropped by relabeling rules */
+	ingestDone          chan bool              /* Signalled when ingestion queue is drained */
+	listenersDone       chan bool              /* Signalled when listeners are stopped and their connections are served */
+	listeners           *listener.Manager      /* Network listeners */
+	cancelWrites        chan bool              /* Closed when writes should be cancelled (shutdown timeout) */
+	nameTemplates       []*parser.NameTemplate /* Templates extracting tags from metric names */
+	relabelRules        []*parser.RelabelRule  /* Rules renaming or dropping metrics on ingest */
+	ingesting           int32                  /* 1 while ingestion goroutine is running */
+	dumping             int32                  /* 1 while dumper goroutine is running */
 )
 
+// Age of the oldest slice waiting for extraction in seconds, exported at
+// /debug/vars of the web UI
+var extractionLag = expvar.NewInt("metricsd.extraction_lag")
+
 const (
 	runningProcesses = 3
 )
@@ -35,23 +60,59 @@ func main() {
 	// Initialize MetricsD
 	initialize()
 
+	// Import historical data instead of listening for events
+	if *importPath != "" {
+		if error := importFile(*importPath); error != nil {
+			log.Fatal("Cannot import %s: %s", *importPath, error)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Replay recorded events instead of listening for events
+	if *replayPath != "" {
+		if error := replayFile(*replayPath); error != nil {
+			log.Fatal("Cannot replay %s: %s", *replayPath, error)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Restore open slices saved before restart
+	if *restorePath != "" {
+		if error := restoreTimeline(*restorePath); error != nil {
+			log.Fatal("Cannot restore timeline from %s: %s", *restorePath, error)
+			os.Exit(1)
+		}
+	}
+
+	// Restore RRD files migrated from another host
+	if config.RrdRestoreDir != "" {
+		restoreRrdFiles(config.RrdRestoreDir)
+	}
+
+	// Restore writer state saved on the last clean shutdown
+	if config.PersistState {
+		loadWriterState()
+	}
+
 	// Quit channel. Should be blocking (non-bufferred), so sender
 	// will wait until receiver accepts the message
 	// (and then will shut himself down).
 	quit := make(chan bool)
 
-	// Active writers
-	activeWriters = []writers.Writer{
-		&writers.Count{},
-		&writers.Quartiles{},
-		&writers.Percentiles{},
-	}
-
 	// Start background Go routines
-	go listen(quit)
+	go ingest()
+	go func() {
+		listeners.Run(quit)
+		listenersDone <- true
+	}()
 	go stats(quit)
-	go dumper(activeWriters, quit)
-	go web.Start()
+	go dumper(quit)
+	web.HealthCheck = checkHealth
+	web.DegradedCheck = writers.Degraded
+	web.ReadinessCheck = checkReadiness
+	go web.Start(router)
 
 	// Handle signals
 	handleSignals(quit)
@@ -66,47 +127,135 @@ func initialize() {
 	log = config.Logger
 	log.Debug("%s", config.String())
 
+	// Initialize everything depending on configuration, which validates it
+	// the same way for -check-config and startup
+	cancelWrites = make(chan bool)
+	if error := setup(); error != nil {
+		log.Fatal("%s", error)
+		os.Exit(1)
+	}
+	if *checkConfigPath != "" || *testAndExit {
+		fmt.Println("Configuration is valid")
+		os.Exit(0)
+	}
+
+	// Initialize ingestion queue
+	events = make(chan *types.Event, config.IngestBufferSize)
+	ingestDone = make(chan bool)
+	listenersDone = make(chan bool)
+
 	// Ensure data directory exists
 	if _, err := os.Stat(config.DataDir); err != nil {
 		os.MkdirAll(config.DataDir, 0755)
 	}
 
-	// Resolve listen address
-	address, error := net.ResolveUDPAddr("udp", config.Listen)
-	if error != nil {
-		log.Fatal("Cannot parse \"%s\": %s", config.Listen, error)
-		os.Exit(1)
+	// Ensure rrdtool is available to render graphs (not needed to import
+	// or replay data, print rollups, or for dry runs)
+	if *importPath == "" && *replayPath == "" && !*printRollups && !config.DryRun {
+		if error := web.CheckRrdtool(); error != nil {
+			log.Fatal("Cannot initialize web interface: %s (see RrdtoolPath option)", error)
+			os.Exit(1)
+		}
 	}
-	config.UDPAddress = address
 
-	// Initialize slices structure
-	timeline = types.NewTimeline(config.SliceInterval)
+	// Initialize write jitter
+	if config.GetWriteJitter() != config.WriteJitter {
+		log.Warn("Write jitter %d is out of range, using %d seconds", config.WriteJitter, config.GetWriteJitter())
+	}
+	rand.Seed(time.Nanoseconds())
 
 	// Initialize host lookup cache
 	if config.LookupDns {
 		hostLookupCache = make(map[string]string)
+		hostLookupMutex = &sync.Mutex{}
 	}
 
 	// Disable memory profiling to prevent panics reporting
 	runtime.MemProfileRate = 0
 }
 
+// setup validates configuration, and initializes listeners, name templates,
+// timelines, and writers. Nothing is started, so it is safe to call setup
+// just to check the configuration.
+func setup() os.Error {
+	if error := config.Validate(); error != nil {
+		return os.NewError(fmt.Sprintf("Invalid configuration: %s", error))
+	}
+
+	// Initialize network listeners
+	manager, error := listener.NewManager(config.GetListeners(), process)
+	if error != nil {
+		return os.NewError(fmt.Sprintf("Cannot initialize listeners: %s", error))
+	}
+	listeners = manager
+
+	// Initialize name templates
+	templates, error := parser.NewNameTemplates(config.NameTemplates)
+	if error != nil {
+		return os.NewError(fmt.Sprintf("Cannot initialize name templates: %s", error))
+	}
+	nameTemplates = templates
+
+	// Initialize relabeling rules
+	rules, error := parser.NewRelabelRules(config.Relabel)
+	if error != nil {
+		return os.NewError(fmt.Sprintf("Cannot initialize relabeling rules: %s", error))
+	}
+	relabelRules = rules
+
+	// Initialize timelines and their active writers
+	if router, error = writers.NewRouter(cancelWrites); error != nil {
+		return os.NewError(fmt.Sprintf("Cannot initialize writer: %s", error))
+	}
+	for _, route := range router.Routes {
+		route.Timeline.Dedup = config.ImportDedup
+		route.Timeline.Recycle = config.RecycleSlices
+	}
+	timeline = router.Default().Timeline
+	return nil
+}
+
 func handleSignals(quit chan<- bool) {
 	for sig := range signal.Incoming {
 		var usig = sig.(os.UnixSignal)
+		if usig == os.SIGUSR1 {
+			log.Warn("Received signal: %s", sig)
+			go dumpTimeline()
+			continue
+		}
+		if usig == os.SIGUSR2 {
+			// Flushed in background, so other signals are handled during the
+			// pass (passes are serialised with the write loop by the router)
+			log.Warn("Received signal: %s", sig)
+			go router.FlushClosed()
+			continue
+		}
 		if usig == os.SIGHUP || usig == os.SIGINT || usig == os.SIGTERM {
 			log.Warn("Received signal: %s", sig)
 			if usig == os.SIGINT || usig == os.SIGTERM {
 				log.Warn("Shutting down everything...")
+				// Do not wait for stuck writes forever
+				go func() {
+					time.Sleep(int64(config.ShutdownTimeout) * 1e9)
+					log.Warn("Shutdown timeout expired, cancelling writes")
+					close(cancelWrites)
+				}()
 				// We have several background processes, so wait for all of them
 				for i := 1; i <= runningProcesses; i++ {
 					log.Debug("... waiting for process %d of %d", i, runningProcesses)
 					quit <- true
 				}
+				// All producers are stopped, flush the ingestion queue
+				<-listenersDone
+				close(events)
+				<-ingestDone
 				log.Warn("... done!")
 			}
-			rollupSlices(activeWriters, true)
+			rollupSlices(true)
 			if usig == os.SIGINT || usig == os.SIGTERM {
+				if config.PersistState {
+					saveWriterState()
+				}
 				return
 			}
 		}
@@ -115,39 +264,17 @@ func handleSignals(quit chan<- bool) {
 
 /***** Go routines ************************************************************/
 
-func listen(quit <-chan bool) {
-	log.Debug("Starting listener on %s", config.UDPAddress)
-
-	// Listen for requests
-	listener, error := net.ListenUDP("udp", config.UDPAddress)
-	if error != nil {
-		log.Fatal("Cannot listen: %s", error)
-		os.Exit(1)
-	}
-	// Ensure listener will be closed on return
-	defer listener.Close()
-
-	// Timeout is 0.1 second
-	listener.SetTimeout(1e8)
-	listener.SetReadTimeout(1e8)
-
-	data := make([]byte, 256)
-	for {
-		select {
-		case <-quit:
-			log.Debug("Shutting down listener...")
-			return
-		default:
-			n, addr, error := listener.ReadFromUDP(data)
-			if error != nil {
-				if addr != nil {
-					log.Debug("Cannot read UDP from %s: %s\n", addr, error)
-				}
-				continue
-			}
-			process(addr, string(data[0:n]))
-		}
+// ingest moves events from the ingestion queue to the timeline, so network
+// listener never waits for the timeline. It is not stopped by the quit
+// channel: it exits when the queue is closed and fully drained.
+func ingest() {
+	atomic.AddInt32(&ingesting, 1)
+	defer atomic.AddInt32(&ingesting, -1)
+	for event := range events {
+		router.Add(event)
 	}
+	log.Debug("Ingestion queue drained")
+	ingestDone <- true
 }
 
 func stats(quit <-chan bool) {
@@ -160,10 +287,62 @@ func stats(quit <-chan bool) {
 			log.Debug("Shutting down stats...")
 			return
 		case <-ticker.C:
-			timeline.Add(types.NewEvent("all", "metricsd.events.count", int(eventsReceived)))
-			timeline.Add(types.NewEvent("all", "metricsd.traffic_in", int(bytesReceived)))
-			timeline.Add(types.NewEvent("all", "metricsd.memory.used", int(runtime.MemStats.Alloc/1024)))
-			timeline.Add(types.NewEvent("all", "metricsd.memory.system", int(runtime.MemStats.Sys/1024)))
+			watchMemory()
+			enqueue(types.NewEvent("all", "metricsd.events.count", int(eventsReceived)))
+			enqueue(types.NewEvent("all", "metricsd.traffic_in", int(bytesReceived)))
+			enqueue(types.NewEvent("all", "metricsd.memory.used", int(runtime.MemStats.Alloc/1024)))
+			enqueue(types.NewEvent("all", "metricsd.memory.system", int(runtime.MemStats.Sys/1024)))
+			enqueue(types.NewEvent("all", "metricsd.memory.interned_keys", types.InternedKeys()))
+			enqueue(types.NewEvent("all", "metricsd.memory.intern_refused", int(resetCounter(&types.InternRefused))))
+			enqueue(types.NewEvent("all", "metricsd.memory.pressure_shed", int(resetCounter(&types.PressureShed))))
+			enqueue(types.NewEvent("all", "metricsd.ingest.queue_depth", len(events)))
+			lag := router.ExtractionLag()
+			extractionLag.Set(lag)
+			enqueue(types.NewEvent("all", "metricsd.extraction.lag", int(lag)))
+			enqueue(types.NewEvent("all", "metricsd.ingest.dropped", int(resetCounter(&eventsDropped))))
+			enqueue(types.NewEvent("all", "metricsd.ingest.discarded", int(resetCounter(&listener.Discarded))))
+			enqueue(types.NewEvent("all", "metricsd.ingest.decompression_errors", int(resetCounter(&listener.DecompressionErrors))))
+			enqueue(types.NewEvent("all", "metricsd.ingest.connection_panics", int(resetCounter(&listener.ConnectionPanics))))
+			var denied, dropped, limited, sampled, partial, transformed, steps int64
+			for _, route := range router.Routes {
+				denied += resetCounter(&route.Timeline.DeniedEvents)
+				dropped += resetCounter(&route.Timeline.DroppedValues)
+				limited += resetCounter(&route.Timeline.RateLimited)
+				sampled += resetCounter(&route.Timeline.SampledOut)
+				partial += resetCounter(&route.Timeline.DroppedSets)
+				transformed += resetCounter(&route.Timeline.FilteredOut)
+				steps += resetCounter(&route.Timeline.ClockSteps)
+			}
+			enqueue(types.NewEvent("all", "metricsd.events.denied", int(denied)))
+			enqueue(types.NewEvent("all", "metricsd.events.values_dropped", int(dropped)))
+			enqueue(types.NewEvent("all", "metricsd.events.coalesced", int(resetCounter(&types.CoalescedValues))))
+			enqueue(types.NewEvent("all", "metricsd.events.rate_limited", int(limited)))
+			enqueue(types.NewEvent("all", "metricsd.events.sampled_out", int(sampled)))
+			enqueue(types.NewEvent("all", "metricsd.events.partial_dropped", int(partial)))
+			enqueue(types.NewEvent("all", "metricsd.events.transform_dropped", int(transformed)))
+			enqueue(types.NewEvent("all", "metricsd.clock.backward_steps", int(steps)))
+			enqueue(types.NewEvent("all", "metricsd.events.unknown_type", int(resetCounter(&unknownTypes))))
+			enqueue(types.NewEvent("all", "metricsd.events.relabel_dropped", int(resetCounter(&relabelDropped))))
+			enqueue(types.NewEvent("all", "metricsd.events.invalid_values", int(resetCounter(&parser.InvalidValues))))
+			enqueue(types.NewEvent("all", "metricsd.writers.errors", int(resetCounter(&writers.UpdateErrors))))
+			enqueue(types.NewEvent("all", "metricsd.writers.dead_letters", int(resetCounter(&writers.DeadLetters))))
+			enqueue(types.NewEvent("all", "metricsd.writers.duplicates_skipped", int(resetCounter(&writers.DuplicateUpdates))))
+			enqueue(types.NewEvent("all", "metricsd.writers.degraded_skipped", int(resetCounter(&writers.DegradedSkipped))))
+			enqueue(types.NewEvent("all", "metricsd.writers.path_collisions", int(resetCounter(&writers.PathCollisions))))
+			enqueue(types.NewEvent("all", "metricsd.writers.negative_clamped", int(resetCounter(&writers.NegativeValuesClamped))))
+			enqueue(types.NewEvent("all", "metricsd.writers.negative_rejected", int(resetCounter(&writers.NegativeValuesRejected))))
+			enqueue(types.NewEvent("all", "metricsd.writers.warmup_suppressed", int(resetCounter(&writers.WarmupSuppressed))))
+			enqueue(types.NewEvent("all", "metricsd.writers.below_min_samples", int(resetCounter(&writers.BelowMinSamples))))
+			enqueue(types.NewEvent("all", "metricsd.writers.kafka_dropped", int(resetCounter(&writers.KafkaDropped))))
+			enqueue(types.NewEvent("all", "metricsd.writers.create_queue", writers.CreateQueueDepth()))
+			for name, count := range writers.ResetWriterPanics() {
+				enqueue(types.NewEvent("all", "metricsd.writers."+name+".panics", int(count)))
+			}
+			for name, duration := range writers.ResetRollupDurations() {
+				enqueue(types.NewEvent("all", "metricsd.writers."+name+".rollups", int(duration.Count)))
+				enqueue(types.NewEvent("all", "metricsd.writers."+name+".rollup_time", int(duration.Total/1e3)))
+				enqueue(types.NewEvent("all", "metricsd.writers."+name+".rollup_max", int(duration.Max/1e3)))
+			}
 
 			log.Debug("Processed %d events (%d bytes)", eventsReceived, bytesReceived)
 
@@ -173,32 +352,96 @@ func stats(quit <-chan bool) {
 	}
 }
 
-func dumper(activeWriters []writers.Writer, quit <-chan bool) {
+// watchMemory engages memory pressure mode when heap usage exceeds
+// MemoryLimit: sample sets of all timelines are shrunk to PressureValues
+// values, and further values are sampled (see types.SetPressureLimit). The
+// mode is disengaged when heap usage drops below 90% of the limit.
+func watchMemory() {
+	if config.MemoryLimit <= 0 {
+		return
+	}
+	used := int(runtime.MemStats.Alloc / 1024)
+	engaged := types.PressureLimit() > 0
+	switch {
+	case !engaged && used >= config.MemoryLimit:
+		types.SetPressureLimit(config.PressureValues)
+		var shrunk int
+		for _, route := range router.Routes {
+			shrunk += route.Timeline.Shed(config.PressureValues)
+		}
+		log.Warn("Heap usage %d KB exceeds memory limit %d KB, sampling %d values per sample set (%d sample sets shrunk)", used, config.MemoryLimit, config.PressureValues, shrunk)
+	case engaged && used < config.MemoryLimit/10*9:
+		types.SetPressureLimit(0)
+		log.Info("Heap usage %d KB is below memory limit %d KB, memory pressure mode disengaged", used, config.MemoryLimit)
+	}
+}
+
+// dumper writes closed slices every write interval, or as soon as there are
+// FlushSlices closed slices (if enabled), so catch-up writes after a stall
+// are split into smaller batches. Both triggers extract closed slices, so
+// every slice is written once.
+func dumper(quit <-chan bool) {
 	ticker := time.NewTicker(int64(config.WriteInterval) * 1e9)
 	defer ticker.Stop()
+	jitter := int64(config.GetWriteJitter()) * 1e9
+
+	atomic.AddInt32(&dumping, 1)
+	defer atomic.AddInt32(&dumping, -1)
+
+	var checks <-chan int64
+	if config.FlushSlices > 0 {
+		checker := time.NewTicker(1e9)
+		defer checker.Stop()
+		checks = checker.C
+	}
 
 	for {
 		select {
 		case <-quit:
 			log.Debug("Shutting down dumper...")
 			return
+		case <-checks:
+			if count := router.ClosedSliceCount(); count >= config.FlushSlices {
+				log.Debug("There are %d closed slices, flushing before write interval elapses", count)
+				rollupSlices(false)
+			}
 		case <-ticker.C:
-			rollupSlices(activeWriters, false)
+			// Spread writes of several instances within the write interval
+			if jitter > 0 {
+				select {
+				case <-quit:
+					log.Debug("Shutting down dumper...")
+					return
+				case <-time.After(rand.Int63n(jitter)):
+				}
+			}
+			rollupSlices(false)
 		}
 	}
 }
 
 /***** Helper functions *******************************************************/
 
-func process(addr *net.UDPAddr, buf string) {
+func process(addr net.Addr, buf string, parse parser.ParseFunc) {
 	atomic.AddInt64(&bytesReceived, int64(len(buf)))
 	atomic.AddInt64(&totalBytesReceived, int64(len(buf)))
-	parser.Parse(buf, func(event *types.Event, err os.Error) {
+	parse(buf, func(event *types.Event, err os.Error) {
 		if err == nil {
 			if event.Source == "" {
 				event.Source = lookupHost(addr)
 			}
-			timeline.Add(event)
+			if !parser.Relabel(relabelRules, event) {
+				atomic.AddInt64(&relabelDropped, 1)
+				return
+			}
+			parser.ExtractTags(nameTemplates, event)
+			if event.Type != "" && !config.KnownMetricType(event.Type) {
+				atomic.AddInt64(&unknownTypes, 1)
+			}
+			enqueue(event)
+			for _, alias := range parser.Aliases(config.Aliases, event) {
+				enqueue(alias)
+			}
 			atomic.AddInt64(&eventsReceived, 1)
 			atomic.AddInt64(&totalEventsReceived, 1)
 		} else {
@@ -207,15 +450,49 @@ func process(addr *net.UDPAddr, buf string) {
 	})
 }
 
-func lookupHost(addr *net.UDPAddr) (hostname string) {
-	ip := addr.IP.String()
+// enqueue puts the event into the ingestion queue. When the queue is full,
+// the event is either dropped or the caller waits, depending on IngestPolicy.
+func enqueue(event *types.Event) {
+	if config.IngestPolicy == config.INGEST_POLICY_BLOCK {
+		events <- event
+		return
+	}
+	select {
+	case events <- event:
+	default:
+		atomic.AddInt64(&eventsDropped, 1)
+	}
+}
+
+// resetCounter atomically sets the counter to zero and returns its
+// previous value.
+func resetCounter(counter *int64) int64 {
+	value := atomic.AddInt64(counter, 0)
+	atomic.AddInt64(counter, -value)
+	return value
+}
+
+func lookupHost(addr net.Addr) (hostname string) {
+	var ip string
+	switch address := addr.(type) {
+	case *net.UDPAddr:
+		ip = address.IP.String()
+	case *net.TCPAddr:
+		ip = address.IP.String()
+	default:
+		// Unix sockets have no remote address
+		return "localhost"
+	}
 	if !config.LookupDns {
 		return ip
 	}
 
 	// Do we have resolved this address before?
-	if _, found := hostLookupCache[ip]; found {
-		return hostLookupCache[ip]
+	hostLookupMutex.Lock()
+	hostname, found := hostLookupCache[ip]
+	hostLookupMutex.Unlock()
+	if found {
+		return
 	}
 
 	// Try to lookup
@@ -225,29 +502,190 @@ func lookupHost(addr *net.UDPAddr) (hostname string) {
 		return ip
 	}
 	// Cache the lookup result
+	hostLookupMutex.Lock()
 	hostLookupCache[ip] = hostname
+	hostLookupMutex.Unlock()
 
 	return
 }
 
-func rollupSlices(activeWriters []writers.Writer, force bool) {
-	log.Debug("Rolling up timeline")
-	startTime := time.Nanoseconds()
+// checkHealth returns an error when ingestion or dumper goroutine is not
+// running, or no write pass has been completed for StallTimeout seconds.
+func checkHealth() os.Error {
+	if atomic.AddInt32(&ingesting, 0) <= 0 {
+		return os.NewError("Ingestion is not running")
+	}
+	if atomic.AddInt32(&dumping, 0) <= 0 {
+		return os.NewError("Writes are not running")
+	}
+	if stalled := time.Seconds() - router.LastRun(); stalled > int64(config.StallTimeout) {
+		return os.NewError(fmt.Sprintf("Writes have stalled for %d seconds", stalled))
+	}
+	return nil
+}
 
-	if config.BatchWrites {
-		closedSampleSets := timeline.ExtractClosedSampleSets(force)
-		for _, writer := range activeWriters {
-			writers.BatchRollup(writer, closedSampleSets)
+// checkReadiness returns an error when some of listeners are not bound, or
+// writes are not scheduled yet.
+func checkReadiness() os.Error {
+	if !listeners.Bound() {
+		return os.NewError("Listeners are not bound")
+	}
+	if atomic.AddInt32(&dumping, 0) <= 0 {
+		return os.NewError("Writes are not scheduled")
+	}
+	return nil
+}
+
+// dumpTimeline writes the snapshot of all open slices of all timelines to a
+// file in the data directory (in SnapshotFormat), for post-mortem debugging
+// or warm restarts (see restoreTimeline).
+func dumpTimeline() {
+	codec, error := types.GetSnapshotCodec(config.SnapshotFormat)
+	if error != nil {
+		log.Error("Cannot dump timeline: %s", error)
+		return
+	}
+	snapshot := router.Snapshot()
+	path := fmt.Sprintf("%s/timeline-%d.%s", config.DataDir, snapshot.Time, config.SnapshotFormat)
+	file, error := os.Create(path)
+	if error != nil {
+		log.Error("Cannot dump timeline to %s: %s", path, error)
+		return
+	}
+	defer file.Close()
+
+	if error = snapshot.Write(file, codec); error != nil {
+		log.Error("Cannot dump timeline to %s: %s", path, error)
+		return
+	}
+	slices := len(snapshot.Slices)
+	for _, other := range snapshot.Timelines {
+		slices += len(other.Slices)
+	}
+	log.Info("Timeline dumped to %s (%d slices)", path, slices)
+}
+
+// restoreTimeline adds slices from the snapshot file to the timelines of
+// matching routes (see writers.Router.Restore). The format is detected by
+// the file extension ("json" or "gob"), so snapshots taken with another
+// SnapshotFormat could be restored as well.
+func restoreTimeline(path string) os.Error {
+	codec, error := types.GetSnapshotCodec(strings.TrimLeft(filepath.Ext(path), "."))
+	if error != nil {
+		return error
+	}
+	file, error := os.Open(path)
+	if error != nil {
+		return error
+	}
+	defer file.Close()
+
+	snapshot, error := codec.Decode(file)
+	if error != nil {
+		return error
+	}
+	restored, error := router.Restore(snapshot)
+	if error != nil {
+		return error
+	}
+	log.Info("Timeline restored from %s (%d slices)", path, restored)
+	return nil
+}
+
+// restoreRrdFiles creates RRD files from XML dumps in the directory, laid
+// out the same way as DataDir: <source>/<metric>-<writer>.xml is restored
+// to <DataDir>/<source>/<metric>-<writer>.rrd (see web.RestoreRrd). Dumps
+// of existing RRD files are skipped, so restarts do not overwrite data
+// written since the migration. Failures are logged.
+func restoreRrdFiles(dir string) {
+	sources, error := ioutil.ReadDir(dir)
+	if error != nil {
+		log.Error("Cannot read RRD restore directory %s: %s", dir, error)
+		return
+	}
+	var restored, skipped, failed int
+	for _, source := range sources {
+		if !source.IsDirectory() {
+			continue
 		}
-	} else {
-		closedSlices := timeline.ExtractClosedSlices(force)
-		for _, slice := range closedSlices {
-			for _, set := range slice.Sets {
-				for _, writer := range activeWriters {
-					writers.Rollup(writer, set)
-				}
+		files, error := ioutil.ReadDir(filepath.Join(dir, source.Name))
+		if error != nil {
+			log.Error("Cannot read RRD restore directory %s: %s", filepath.Join(dir, source.Name), error)
+			continue
+		}
+		for _, file := range files {
+			if !file.IsRegular() || filepath.Ext(file.Name) != ".xml" {
+				continue
+			}
+			dump := filepath.Join(dir, source.Name, file.Name)
+			rrd := filepath.Join(config.DataDir, source.Name, file.Name[:len(file.Name)-len(".xml")]+".rrd")
+			if _, error := os.Stat(rrd); error == nil {
+				skipped++
+				continue
+			}
+			if error := web.RestoreRrd(dump, rrd, false); error != nil {
+				log.Error("Cannot restore %s from %s: %s", rrd, dump, error)
+				failed++
+				continue
 			}
+			restored++
 		}
 	}
-	log.Debug("... timeline rolled up, took %v seconds", float64(time.Nanoseconds()-startTime)/1e9)
+	log.Info("Restored %d RRD files from %s (%d existing skipped, %d failed)", restored, dir, skipped, failed)
+}
+
+// writerStatePath returns the path of the file writer state is persisted
+// to (see saveWriterState).
+func writerStatePath() string {
+	return config.DataDir + "/writer-state.json"
+}
+
+// saveWriterState writes cross-interval state of writers to the data
+// directory, so they resume without warmup after restart. The state is
+// written to a temporary file first, so it is never left half-written.
+func saveWriterState() {
+	path := writerStatePath()
+	file, error := os.Create(path + ".tmp")
+	if error != nil {
+		log.Error("Cannot save writer state to %s: %s", path, error)
+		return
+	}
+	error = writers.SaveState(file)
+	file.Close()
+	if error == nil {
+		error = os.Rename(path+".tmp", path)
+	}
+	if error != nil {
+		log.Error("Cannot save writer state to %s: %s", path, error)
+		return
+	}
+	log.Info("Writer state saved to %s", path)
+}
+
+// loadWriterState restores cross-interval state of writers saved on the
+// last clean shutdown. The file is removed afterwards, so state is never
+// restored after a crash (writers warm up as after a fresh start).
+func loadWriterState() {
+	path := writerStatePath()
+	file, error := os.Open(path)
+	if error != nil {
+		log.Debug("No writer state to restore: %s", error)
+		return
+	}
+	restored, error := writers.LoadState(file)
+	file.Close()
+	os.Remove(path)
+	if error != nil {
+		log.Warn("Cannot restore writer state from %s: %s", path, error)
+		return
+	}
+	log.Info("Writer state restored from %s (%d entries)", path, restored)
+}
+
+// rollupSlices writes closed slices (or all slices, if force is true) of
+// all timelines using their active writers (see writers.Router).
+func rollupSlices(force bool) {
+	if error := router.RunOnce(force); error != nil {
+		log.Warn("... timeline roll up failed: %s", error)
+	}
 }
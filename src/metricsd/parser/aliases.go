@@ -0,0 +1,27 @@
+package parser
+
+import (
+	"metricsd/types"
+)
+
+// Aliases returns copies of the event renamed to every alias of its name
+// (see config.Aliases), so every alias is aggregated independently, with
+// its own per-metric options and writers. Empty and invalid alias names
+// (see types.ValidName) are skipped. Copies share tags of the event. Returns
+// nil when the name has no aliases.
+func Aliases(aliases map[string][]string, event *types.Event) (copies []*types.Event) {
+	names, found := aliases[event.Name]
+	if !found {
+		return
+	}
+	copies = make([]*types.Event, 0, len(names))
+	for _, name := range names {
+		if name == "" || name == event.Name || !types.ValidName(name) {
+			continue
+		}
+		alias := *event
+		alias.Name = name
+		copies = append(copies, &alias)
+	}
+	return
+}
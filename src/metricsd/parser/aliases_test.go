@@ -0,0 +1,28 @@
+package parser
+
+import (
+	"testing"
+	"metricsd/types"
+)
+
+func TestAliases(t *testing.T) {
+	aliases := map[string][]string{"app.requests": []string{"legacy.requests", "old requests", "app.requests", "requests"}}
+	event := types.NewWeightedEvent("src", "app.requests", 10, 2)
+	event.Tags = types.Tags{"status": "200"}
+	copies := Aliases(aliases, event)
+	if len(copies) != 2 {
+		t.Fatalf("Expected 2 copies, got %d", len(copies))
+	}
+	for idx, name := range []string{"legacy.requests", "requests"} {
+		alias := copies[idx]
+		if alias.Name != name || alias.Source != "src" || alias.Value != 10 || alias.Weight != 2 || alias.Tags.String() != "status=200" {
+			t.Errorf("Expected %s copy of the event, got %v", name, alias)
+		}
+	}
+	if event.Name != "app.requests" {
+		t.Errorf("Expected event name %q, got %q", "app.requests", event.Name)
+	}
+	if copies := Aliases(aliases, types.NewEvent("src", "other", 1)); copies != nil {
+		t.Errorf("Expected no copies, got %v", copies)
+	}
+}
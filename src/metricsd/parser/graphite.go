@@ -0,0 +1,53 @@
+package parser
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"metricsd/types"
+)
+
+// ParseGraphite parses source buffer in Graphite plaintext format and
+// invokes the given function for each parsed event or error, the same way
+// Parse does. Returns number of successfully processed events.
+//
+// Graphite plaintext format is:
+//     metric value [timestamp][\nevent]
+// Timestamp is validated, but ignored: events are always stored in the
+// current slice. Graphite events do not contain a source, so source is
+// always empty.
+func ParseGraphite(buf string, f func(event *types.Event, err os.Error)) int {
+	var count int
+	for _, msg := range strings.Split(buf, "\n") {
+		msg = strings.TrimSpace(msg)
+		if msg == "" {
+			continue
+		}
+
+		fields := strings.Fields(msg)
+		if len(fields) < 2 || len(fields) > 3 {
+			f(nil, os.NewError(fmt.Sprintf("Event format is invalid (event=%q)", msg)))
+			continue
+		}
+		name := fields[0]
+		if !types.ValidName(name) {
+			f(nil, os.NewError(fmt.Sprintf("Metric name is invalid: %q (event=%q)", name, msg)))
+			continue
+		}
+		if len(fields) == 3 {
+			if _, error := strconv.Atoi64(fields[2]); error != nil {
+				f(nil, os.NewError(fmt.Sprintf("Timestamp %q is invalid (event=%q)", fields[2], msg)))
+				continue
+			}
+		}
+
+		if value, error := parseValue(fields[1]); error != nil {
+			f(nil, os.NewError(fmt.Sprintf("Metric value %q is invalid (event=%q)", fields[1], msg)))
+		} else {
+			f(types.NewEvent("", name, value), nil)
+			count += 1
+		}
+	}
+	return count
+}
@@ -0,0 +1,39 @@
+package parser
+
+import (
+	"os"
+	"testing"
+	"metricsd/types"
+)
+
+var parseGraphiteTests = []eventTest{
+	// Valid events
+	{"metric 10", []testEntry{
+		{types.NewEvent("", "metric", 10), nil},
+	}},
+	{"group.metric -1 1313049600", []testEntry{
+		{types.NewEvent("", "group.metric", -1), nil},
+	}},
+	{"metric1 10 1313049600\r\nmetric2 20 1313049600\n", []testEntry{
+		{types.NewEvent("", "metric1", 10), nil},
+		{types.NewEvent("", "metric2", 20), nil},
+	}},
+
+	// Invalid events
+	{"metric", []testEntry{
+		{nil, os.NewError("Event format is invalid (event=\"metric\")")},
+	}},
+	{"metric! 10", []testEntry{
+		{nil, os.NewError("Metric name is invalid: \"metric!\" (event=\"metric! 10\")")},
+	}},
+	{"metric 10 yesterday", []testEntry{
+		{nil, os.NewError("Timestamp \"yesterday\" is invalid (event=\"metric 10 yesterday\")")},
+	}},
+	{"metric hello", []testEntry{
+		{nil, os.NewError("Metric value \"hello\" is invalid (event=\"metric hello\")")},
+	}},
+}
+
+func TestParseGraphite(t *testing.T) {
+	checkParser(t, ParseGraphite, parseGraphiteTests)
+}
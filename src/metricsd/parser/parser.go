@@ -1,20 +1,41 @@
 // The parser package implements MetricsD protocol events parsing.
 //
 // Basicly, event format is:
-//     [source@]metric:value[;event]
+//     [source@]metric:value[,value...][|type][;event]
 // where source is the event source, metric and value - metric's name and value,
-// and event is another event in the same format (you can send several metrics
-// updates in the same package).
+// type - the metric type (see config.TypeWriters), and event is another event
+// in the same format (you can send several metrics updates in the same package).
+// Several comma-separated values form a vector event (e.g. per-core CPU usage),
+// each value is added to the metric separately.
 package parser
 
 import (
 	"fmt"
 	"os"
-	"strconv"
 	"strings"
 	"metricsd/types"
 )
 
+// A ParseFunc parses source buffer and invokes the given function for each
+// parsed event or error. Returns number of successfully processed events.
+type ParseFunc func(buf string, f func(event *types.Event, err os.Error)) int
+
+// parsers holds all known protocol parsers, keyed by protocol name.
+var parsers = map[string]ParseFunc{
+	"metricsd": Parse,
+	"statsd":   ParseStatsd,
+	"graphite": ParseGraphite,
+}
+
+// Lookup returns the parser for the given protocol name.
+func Lookup(name string) (parse ParseFunc, err os.Error) {
+	parse, found := parsers[name]
+	if !found {
+		err = os.NewError(fmt.Sprintf("Unknown parser %q", name))
+	}
+	return
+}
+
 // Parse parses source buffer and invokes the given function, passing either parsed
 // event or an error (when failed to parse) for each event in the source buffer
 // (if there are several events in the a bundle). Returns number of successfully
@@ -42,13 +63,13 @@ func Parse(buf string, f func(event *types.Event, err os.Error)) int {
 			msg, str = str, str[:0]
 		}
 
-		var source, name, svalue string
+		var source, name, svalue, metricType string
 
 		// Check if the event contains a source name
 		if idx := strings.Index(msg, "@"); idx >= 0 {
 			source, msg = msg[:idx], msg[idx+1:]
 
-			if !validateMetric(source) {
+			if !types.ValidName(source) {
 				f(nil, os.NewError(fmt.Sprintf("Source is invalid: %q (event=%q)", source, buf)))
 				continue
 			}
@@ -58,7 +79,7 @@ func Parse(buf string, f func(event *types.Event, err os.Error)) int {
 		if idx := strings.Index(msg, ":"); idx >= 0 {
 			name, svalue = msg[:idx], msg[idx+1:]
 
-			if !validateMetric(name) {
+			if !types.ValidName(name) {
 				f(nil, os.NewError(fmt.Sprintf("Metric name is invalid: %q (event=%q)", name, buf)))
 				continue
 			}
@@ -71,37 +92,45 @@ func Parse(buf string, f func(event *types.Event, err os.Error)) int {
 			continue
 		}
 
+		// Retrieve the metric type
+		if idx := strings.Index(svalue, "|"); idx >= 0 {
+			svalue, metricType = svalue[:idx], svalue[idx+1:]
+
+			if len(metricType) == 0 || !types.ValidName(metricType) {
+				f(nil, os.NewError(fmt.Sprintf("Metric type is invalid: %q (event=%q)", metricType, buf)))
+				continue
+			}
+		}
+
 		// Parse the value
-		if value, error := strconv.Atoi(svalue); error != nil {
+		var event *types.Event
+		if strings.Index(svalue, ",") >= 0 {
+			if values, error := parseValues(svalue); error == nil {
+				event = types.NewVectorEvent(source, name, values)
+			}
+		} else if value, error := parseValue(svalue); error == nil {
+			event = types.NewEvent(source, name, value)
+		}
+		if event == nil {
 			f(nil, os.NewError(fmt.Sprintf("Metric value %q is invalid (event=%q)", svalue, buf)))
 			continue
-		} else {
-			f(types.NewEvent(source, name, value), nil)
-			count += 1
 		}
+		event.Type = metricType
+		f(event, nil)
+		count += 1
 	}
 	return count
 }
 
-/***** Helper functions *******************************************************/
-
-func validateMetric(name string) bool {
-	for _, rune := range name {
-		if rune > 0x7F {
-			return false
-		}
-
-		// Digits and Letters
-		if ('0' <= rune && rune <= '9') || ('a' <= rune && rune <= 'z') || ('A' <= rune && rune <= 'Z') {
-			continue
-		}
-		// Special characters
-		switch rune {
-		case '_', '-', '$', '.':
-			continue
-		default:
-			return false
+// parseValues parses comma-separated values of a vector event, returns an
+// error when any of them is invalid.
+func parseValues(s string) (values []int, err os.Error) {
+	items := strings.Split(s, ",")
+	values = make([]int, len(items))
+	for idx, item := range items {
+		if values[idx], err = parseValue(item); err != nil {
+			return nil, err
 		}
 	}
-	return true
+	return
 }
@@ -33,6 +33,13 @@ var parseTests = []eventTest{
 	{"app01@metric:10", []testEntry{
 		{types.NewEvent("app01", "metric", 10), nil},
 	}},
+	{"app01@metric:10|gauge", []testEntry{
+		{typedEvent(types.NewEvent("app01", "metric", 10), "gauge"), nil},
+	}},
+
+	{"cpu:10,20,30|gauge", []testEntry{
+		{typedEvent(types.NewVectorEvent("", "cpu", []int{10, 20, 30}), "gauge"), nil},
+	}},
 
 	// Invalid events with single metric
 	{":10", []testEntry{
@@ -50,6 +57,12 @@ var parseTests = []eventTest{
 	{"app01@metric:hello", []testEntry{
 		{nil, os.NewError("Metric value \"hello\" is invalid (event=\"app01@metric:hello\")")},
 	}},
+	{"cpu:10,,30", []testEntry{
+		{nil, os.NewError("Metric value \"10,,30\" is invalid (event=\"cpu:10,,30\")")},
+	}},
+	{"metric:10|", []testEntry{
+		{nil, os.NewError("Metric type is invalid: \"\" (event=\"metric:10|\")")},
+	}},
 
 	// Valid events with multiple metrics
 	{"metric1:10;metric2:20", []testEntry{
@@ -72,10 +85,33 @@ var parseTests = []eventTest{
 	}},
 }
 
+// typedEvent sets the declared type of the event.
+func typedEvent(event *types.Event, metricType string) *types.Event {
+	event.Type = metricType
+	return event
+}
+
 func TestParse(t *testing.T) {
-	for _, test := range parseTests {
+	checkParser(t, Parse, parseTests)
+}
+
+func TestLookup(t *testing.T) {
+	for _, name := range []string{"metricsd", "statsd", "graphite"} {
+		if parse, err := Lookup(name); parse == nil || err != nil {
+			t.Errorf("Expected parser %q to be found, got error %q", name, err)
+		}
+	}
+	if _, err := Lookup("unknown"); err == nil {
+		t.Errorf("Expected error for unknown parser, got no error")
+	}
+}
+
+// checkParser runs the given parser against the list of tests, and verifies
+// both callback invocations and the result.
+func checkParser(t *testing.T, parse ParseFunc, tests []eventTest) {
+	for _, test := range tests {
 		var idx = 0
-		count := Parse(test.buf, func(event *types.Event, err os.Error) {
+		count := parse(test.buf, func(event *types.Event, err os.Error) {
 			if idx == len(test.results) {
 				t.Errorf("Unexpected event #%d: event=%q, err=%q (buf=%q, idx=%d)", idx, event, err, test.buf, idx)
 				return
@@ -111,6 +147,15 @@ func TestParse(t *testing.T) {
 					if event.Value != expected.event.Value {
 						t.Errorf("Expected event value %q, got %q (buf=%q, idx=%d)", expected.event.Value, event.Name, test.buf, idx)
 					}
+					if !equalValues(event.Values, expected.event.Values) {
+						t.Errorf("Expected event values %v, got %v (buf=%q, idx=%d)", expected.event.Values, event.Values, test.buf, idx)
+					}
+					if event.Weight != expected.event.Weight {
+						t.Errorf("Expected event weight %d, got %d (buf=%q, idx=%d)", expected.event.Weight, event.Weight, test.buf, idx)
+					}
+					if event.Type != expected.event.Type {
+						t.Errorf("Expected event type %q, got %q (buf=%q, idx=%d)", expected.event.Type, event.Type, test.buf, idx)
+					}
 				}
 			}
 			idx++
@@ -128,6 +173,20 @@ func TestParse(t *testing.T) {
 	}
 }
 
+// equalValues returns a value indicating whether further values of vector
+// events are equal.
+func equalValues(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx, value := range a {
+		if value != b[idx] {
+			return false
+		}
+	}
+	return true
+}
+
 func BenchmarkParse(b *testing.B) {
 	b.StopTimer()
 	buf := "app01@group.metric:10;app02@group.metric:2;group.metric:2"
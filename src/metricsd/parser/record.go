@@ -0,0 +1,45 @@
+package parser
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"metricsd/types"
+)
+
+// ParseRecord parses a single record of historical data file and returns
+// parsed event along with the time it was taken at.
+//
+// Record format is (fields are separated by commas or tabs):
+//     metric,timestamp,value
+// Timestamp is a number of seconds since epoch. Records do not contain a
+// source, so source is always empty.
+func ParseRecord(line string) (event *types.Event, timestamp int64, err os.Error) {
+	line = strings.TrimSpace(line)
+	fields := strings.FieldsFunc(line, func(rune int) bool {
+		return rune == ',' || rune == '\t'
+	})
+	if len(fields) != 3 {
+		err = os.NewError(fmt.Sprintf("Record format is invalid (record=%q)", line))
+		return
+	}
+
+	name := strings.TrimSpace(fields[0])
+	if name == "" || !types.ValidName(name) {
+		err = os.NewError(fmt.Sprintf("Metric name is invalid: %q (record=%q)", name, line))
+		return
+	}
+	var error os.Error
+	if timestamp, error = strconv.Atoi64(strings.TrimSpace(fields[1])); error != nil || timestamp < 0 {
+		err = os.NewError(fmt.Sprintf("Timestamp %q is invalid (record=%q)", fields[1], line))
+		return
+	}
+	value, error := parseValue(strings.TrimSpace(fields[2]))
+	if error != nil {
+		err = os.NewError(fmt.Sprintf("Metric value %q is invalid (record=%q)", fields[2], line))
+		return
+	}
+	event = types.NewEvent("", name, value)
+	return
+}
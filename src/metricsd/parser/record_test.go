@@ -0,0 +1,47 @@
+package parser
+
+import (
+	"os"
+	"testing"
+	"metricsd/types"
+)
+
+type recordTest struct {
+	line      string
+	event     *types.Event
+	timestamp int64
+	err       os.Error
+}
+
+var parseRecordTests = []recordTest{
+	// Valid records
+	{"metric,1313049600,10", types.NewEvent("", "metric", 10), 1313049600, nil},
+	{"group.metric\t1313049600\t-1\n", types.NewEvent("", "group.metric", -1), 1313049600, nil},
+	{" metric , 1313049600 , 10\r\n", types.NewEvent("", "metric", 10), 1313049600, nil},
+
+	// Invalid records
+	{"metric,1313049600", nil, 0, os.NewError("Record format is invalid (record=\"metric,1313049600\")")},
+	{"name,timestamp,value", nil, 0, os.NewError("Timestamp \"timestamp\" is invalid (record=\"name,timestamp,value\")")},
+	{"metric!,1313049600,10", nil, 0, os.NewError("Metric name is invalid: \"metric!\" (record=\"metric!,1313049600,10\")")},
+	{"metric,-1,10", nil, 0, os.NewError("Timestamp \"-1\" is invalid (record=\"metric,-1,10\")")},
+	{"metric,1313049600,hello", nil, 0, os.NewError("Metric value \"hello\" is invalid (record=\"metric,1313049600,hello\")")},
+}
+
+func TestParseRecord(t *testing.T) {
+	for _, test := range parseRecordTests {
+		event, timestamp, err := ParseRecord(test.line)
+		if event.String() != test.event.String() {
+			t.Errorf("Expected event %q, got %q (line=%q)", test.event, event, test.line)
+		}
+		if test.event != nil && timestamp != test.timestamp {
+			t.Errorf("Expected timestamp %d, got %d (line=%q)", test.timestamp, timestamp, test.line)
+		}
+		if test.err == nil && err != nil {
+			t.Errorf("Expected no error, got error %q (line=%q)", err, test.line)
+		} else if test.err != nil && err == nil {
+			t.Errorf("Expected error %q, got no error (line=%q)", test.err, test.line)
+		} else if test.err != nil && err.String() != test.err.String() {
+			t.Errorf("Expected error %q, got error %q (line=%q)", test.err, err, test.line)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package parser
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+// A RelabelRule renames or drops metrics with names matching a regular
+// expression (the whole name should match). The new name is the
+// replacement, where $1...$9 are replaced with submatches of the
+// expression, and $$ with "$". For example, rule with match "legacy\.(.*)"
+// and replacement "app.$1" renames "legacy.requests" to "app.requests".
+type RelabelRule struct {
+	config *config.RelabelConfig
+	regexp *regexp.Regexp
+}
+
+// NewRelabelRule compiles the given relabeling rule.
+func NewRelabelRule(cfg *config.RelabelConfig) (rule *RelabelRule, err os.Error) {
+	// The whole expression is the first group (see Apply)
+	re, err := regexp.Compile("^(" + cfg.Match + ")$")
+	if err != nil {
+		err = os.NewError(fmt.Sprintf("Relabeling rule %s is invalid: %s", cfg, err))
+		return
+	}
+	if !cfg.Drop && cfg.Replacement == "" {
+		err = os.NewError(fmt.Sprintf("Relabeling rule %s should either drop metrics or have a replacement", cfg))
+		return
+	}
+	rule = &RelabelRule{config: cfg, regexp: re}
+	return
+}
+
+// NewRelabelRules compiles the given list of relabeling rules.
+func NewRelabelRules(configs []*config.RelabelConfig) (rules []*RelabelRule, err os.Error) {
+	rules = make([]*RelabelRule, 0, len(configs))
+	for _, cfg := range configs {
+		rule, err := NewRelabelRule(cfg)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return
+}
+
+// Apply returns the new name for the given metric name, and a value
+// indicating whether the name matches the rule.
+func (self *RelabelRule) Apply(name string) (renamed string, matched bool) {
+	submatches := self.regexp.FindStringSubmatch(name)
+	if submatches == nil {
+		return name, false
+	}
+	// Skip the group wrapping the whole expression
+	return expandReplacement(self.config.Replacement, submatches[1:]), true
+}
+
+// String returns the rule configuration.
+func (self *RelabelRule) String() string {
+	return self.config.String()
+}
+
+// Relabel applies the first rule matching the event name: the event is
+// either renamed, or should be dropped. Names not matching any rule, and
+// names which would become invalid (see types.ValidName), are not changed.
+// Returns false when the event should be dropped.
+func Relabel(rules []*RelabelRule, event *types.Event) bool {
+	for _, rule := range rules {
+		renamed, matched := rule.Apply(event.Name)
+		if !matched {
+			continue
+		}
+		if rule.config.Drop {
+			return false
+		}
+		if renamed != "" && types.ValidName(renamed) {
+			event.Name = renamed
+		}
+		return true
+	}
+	return true
+}
+
+// expandReplacement replaces $1...$9 in the replacement with submatches,
+// and $$ with "$".
+func expandReplacement(replacement string, submatches []string) string {
+	expanded := make([]byte, 0, len(replacement)+16)
+	for idx := 0; idx < len(replacement); idx++ {
+		if replacement[idx] != '$' || idx+1 == len(replacement) {
+			expanded = append(expanded, replacement[idx])
+			continue
+		}
+		next := replacement[idx+1]
+		switch {
+		case next == '$':
+			expanded = append(expanded, '$')
+			idx++
+		case '1' <= next && next <= '9':
+			if group := int(next - '0'); group < len(submatches) {
+				expanded = append(expanded, submatches[group]...)
+			}
+			idx++
+		default:
+			expanded = append(expanded, '$')
+		}
+	}
+	return string(expanded)
+}
@@ -0,0 +1,59 @@
+package parser
+
+import (
+	"testing"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+type relabelTest struct {
+	name    string
+	renamed string
+	kept    bool
+}
+
+var relabelConfigs = []*config.RelabelConfig{
+	&config.RelabelConfig{Match: `debug\..*`, Drop: true},
+	&config.RelabelConfig{Match: `legacy\.(.*)`, Replacement: "app.$1"},
+	&config.RelabelConfig{Match: `old_requests`, Replacement: "app.requests"},
+	&config.RelabelConfig{Match: `(.*)\.(.*)\.swap`, Replacement: "$2.$1$$"},
+	&config.RelabelConfig{Match: `bad\..*`, Replacement: "bad name"},
+}
+
+var relabelTests = []relabelTest{
+	{"debug.trace", "debug.trace", false},
+	{"legacy.requests", "app.requests", true},
+	{"legacy.db.queries", "app.db.queries", true},
+	{"old_requests", "app.requests", true},
+	{"old_requests.total", "old_requests.total", true},
+	{"a.b.swap", "b.a$", true},
+	{"bad.metric", "bad.metric", true},
+	{"metric", "metric", true},
+}
+
+func TestRelabel(t *testing.T) {
+	rules, err := NewRelabelRules(relabelConfigs)
+	if err != nil {
+		t.Fatalf("Expected no error, got error %q", err)
+	}
+	for _, test := range relabelTests {
+		event := types.NewEvent("", test.name, 1)
+		if kept := Relabel(rules, event); kept != test.kept {
+			t.Errorf("Expected kept=%t, got %t (name=%q)", test.kept, kept, test.name)
+		}
+		if event.Name != test.renamed {
+			t.Errorf("Expected name %q, got %q (name=%q)", test.renamed, event.Name, test.name)
+		}
+	}
+}
+
+func TestNewRelabelRuleErrors(t *testing.T) {
+	for _, cfg := range []*config.RelabelConfig{
+		&config.RelabelConfig{Match: "(unclosed", Replacement: "name"},
+		&config.RelabelConfig{Match: "metric"},
+	} {
+		if _, err := NewRelabelRule(cfg); err == nil {
+			t.Errorf("Expected error for rule %s", cfg)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package parser
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+// statsdTypes maps StatsD metric types to declared metric types.
+var statsdTypes = map[string]string{
+	"c":  config.METRIC_TYPE_COUNTER,
+	"ms": config.METRIC_TYPE_TIMER,
+	"g":  config.METRIC_TYPE_GAUGE,
+	"s":  config.METRIC_TYPE_SET,
+}
+
+// ParseStatsd parses source buffer in StatsD format and invokes the given
+// function for each parsed event or error, the same way Parse does. Returns
+// number of successfully processed events.
+//
+// StatsD format is:
+//     metric:value|type[|@rate][\nevent]
+// where type is one of "c" (counter), "ms" (timer), "g" (gauge) or
+// "s" (set), and rate is a sample rate. Metric type does not affect the
+// value, but selects writers processing the metric (see
+// config.TypeWriters). Sampled events (rate less than 1)
+// have weight 1/rate, since every received event represents several
+// observations. StatsD events do not contain a source, so source is always
+// empty.
+func ParseStatsd(buf string, f func(event *types.Event, err os.Error)) int {
+	var count int
+	for _, msg := range strings.Split(buf, "\n") {
+		msg = strings.TrimSpace(msg)
+		if msg == "" {
+			continue
+		}
+
+		idx := strings.Index(msg, ":")
+		if idx < 0 {
+			f(nil, os.NewError(fmt.Sprintf("Event format is invalid (event=%q)", msg)))
+			continue
+		}
+		name, rest := msg[:idx], msg[idx+1:]
+
+		fields := strings.Split(rest, "|")
+		if len(fields) < 2 || len(fields) > 3 {
+			f(nil, os.NewError(fmt.Sprintf("Event format is invalid (event=%q)", msg)))
+			continue
+		}
+		metricType, found := statsdTypes[fields[1]]
+		if !found {
+			f(nil, os.NewError(fmt.Sprintf("Metric type %q is invalid (event=%q)", fields[1], msg)))
+			continue
+		}
+		weight := 1
+		if len(fields) == 3 {
+			if !strings.HasPrefix(fields[2], "@") {
+				f(nil, os.NewError(fmt.Sprintf("Sample rate %q is invalid (event=%q)", fields[2], msg)))
+				continue
+			}
+			rate, error := strconv.Atof64(fields[2][1:])
+			if error != nil || rate <= 0 || rate > 1 {
+				f(nil, os.NewError(fmt.Sprintf("Sample rate %q is invalid (event=%q)", fields[2], msg)))
+				continue
+			}
+			weight = int(1/rate + 0.5)
+		}
+
+		if value, error := parseValue(fields[0]); error != nil {
+			f(nil, os.NewError(fmt.Sprintf("Metric value %q is invalid (event=%q)", fields[0], msg)))
+		} else if event, error := types.NewValidWeightedEvent("", name, value, weight); error != nil {
+			f(nil, os.NewError(fmt.Sprintf("%s (event=%q)", error, msg)))
+		} else {
+			event.Type = metricType
+			f(event, nil)
+			count += 1
+		}
+	}
+	return count
+}
@@ -0,0 +1,63 @@
+package parser
+
+import (
+	"os"
+	"testing"
+	"metricsd/types"
+)
+
+var parseStatsdTests = []eventTest{
+	// Valid events
+	{"metric:10|c", []testEntry{
+		{typedEvent(types.NewEvent("", "metric", 10), "counter"), nil},
+	}},
+	{"group.metric:-1|ms", []testEntry{
+		{typedEvent(types.NewEvent("", "group.metric", -1), "timer"), nil},
+	}},
+	{"metric:10|c|@0.1", []testEntry{
+		{typedEvent(types.NewWeightedEvent("", "metric", 10, 10), "counter"), nil},
+	}},
+	{"metric:10|ms|@1", []testEntry{
+		{typedEvent(types.NewEvent("", "metric", 10), "timer"), nil},
+	}},
+	{"metric1:10|g\nmetric2:20|s\n", []testEntry{
+		{typedEvent(types.NewEvent("", "metric1", 10), "gauge"), nil},
+		{typedEvent(types.NewEvent("", "metric2", 20), "set"), nil},
+	}},
+
+	// Invalid events
+	{":10|c", []testEntry{
+		{nil, os.NewError("Metric name is empty (event=\":10|c\")")},
+	}},
+	{"metric!:10|c", []testEntry{
+		{nil, os.NewError("Metric name is invalid: \"metric!\" (event=\"metric!:10|c\")")},
+	}},
+	{"metric:10", []testEntry{
+		{nil, os.NewError("Event format is invalid (event=\"metric:10\")")},
+	}},
+	{"metric|c", []testEntry{
+		{nil, os.NewError("Event format is invalid (event=\"metric|c\")")},
+	}},
+	{"metric:10|x", []testEntry{
+		{nil, os.NewError("Metric type \"x\" is invalid (event=\"metric:10|x\")")},
+	}},
+	{"metric:10|c|0.1", []testEntry{
+		{nil, os.NewError("Sample rate \"0.1\" is invalid (event=\"metric:10|c|0.1\")")},
+	}},
+	{"metric:10|c|@0", []testEntry{
+		{nil, os.NewError("Sample rate \"@0\" is invalid (event=\"metric:10|c|@0\")")},
+	}},
+	{"metric:hello|c", []testEntry{
+		{nil, os.NewError("Metric value \"hello\" is invalid (event=\"metric:hello|c\")")},
+	}},
+
+	// Semi-valid events
+	{"metric1:10|c\nmetric2:|c", []testEntry{
+		{typedEvent(types.NewEvent("", "metric1", 10), "counter"), nil},
+		{nil, os.NewError("Metric value \"\" is invalid (event=\"metric2:|c\")")},
+	}},
+}
+
+func TestParseStatsd(t *testing.T) {
+	checkParser(t, ParseStatsd, parseStatsdTests)
+}
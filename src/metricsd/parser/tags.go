@@ -0,0 +1,139 @@
+package parser
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"metricsd/types"
+)
+
+// A NameTemplate extracts tags from legacy metric names encoding dimensions
+// positionally. Template is a list of dot-separated segments matched
+// against segments of the metric name:
+//     {key} — the name segment becomes value of the tag "key";
+//     *     — any name segment, kept in the base name;
+//     other — the name segment should be equal, kept in the base name.
+// For example, template "http.{status}.{region}.*" converts metric
+// "http.200.us-east.latency" to "http.latency" with tags status=200 and
+// region=us-east. Names with a different number of segments do not match.
+type NameTemplate struct {
+	template string
+	segments []string
+}
+
+// NewNameTemplate parses the given template.
+func NewNameTemplate(template string) (nameTemplate *NameTemplate, err os.Error) {
+	segments := strings.Split(template, ".")
+	keys := make(map[string]bool)
+	named := false
+	for _, segment := range segments {
+		if key, isTag := tagKey(segment); isTag {
+			if key == "" || !types.ValidName(key) || keys[key] {
+				err = os.NewError(fmt.Sprintf("Tag %q is invalid or duplicated (template=%q)", segment, template))
+				return
+			}
+			keys[key] = true
+			continue
+		}
+		if segment == "" || (segment != "*" && !types.ValidName(segment)) {
+			err = os.NewError(fmt.Sprintf("Segment %q is invalid (template=%q)", segment, template))
+			return
+		}
+		named = true
+	}
+	if !named {
+		err = os.NewError(fmt.Sprintf("Template should keep at least one segment of the name (template=%q)", template))
+		return
+	}
+	nameTemplate = &NameTemplate{template: template, segments: segments}
+	return
+}
+
+// NewNameTemplates parses the given list of templates.
+func NewNameTemplates(templates []string) (nameTemplates []*NameTemplate, err os.Error) {
+	nameTemplates = make([]*NameTemplate, 0, len(templates))
+	for _, template := range templates {
+		nameTemplate, err := NewNameTemplate(template)
+		if err != nil {
+			return nil, err
+		}
+		nameTemplates = append(nameTemplates, nameTemplate)
+	}
+	return
+}
+
+// Extract returns the base name and tags extracted from the given metric
+// name, and a value indicating whether the name matches the template.
+func (self *NameTemplate) Extract(name string) (base string, tags types.Tags, matched bool) {
+	parts := strings.Split(name, ".")
+	if len(parts) != len(self.segments) {
+		return
+	}
+	kept := make([]string, 0, len(parts))
+	tags = make(types.Tags)
+	for idx, segment := range self.segments {
+		if key, isTag := tagKey(segment); isTag {
+			tags[key] = parts[idx]
+			continue
+		}
+		if segment != "*" && segment != parts[idx] {
+			return "", nil, false
+		}
+		kept = append(kept, parts[idx])
+	}
+	return strings.Join(kept, "."), tags, true
+}
+
+// String returns the template.
+func (self *NameTemplate) String() string {
+	return self.template
+}
+
+// ExtractTags applies the first template matching the event name: the
+// event is renamed to the base name, and extracted tags are added to the
+// event tags. Returns a value indicating whether any template matched.
+func ExtractTags(templates []*NameTemplate, event *types.Event) bool {
+	for _, template := range templates {
+		base, tags, matched := template.Extract(event.Name)
+		if !matched {
+			continue
+		}
+		for key, value := range event.Tags {
+			if _, found := tags[key]; !found {
+				tags[key] = value
+			}
+		}
+		event.Name = base
+		event.Tags = tags
+		return true
+	}
+	return false
+}
+
+// WithTags returns the parser adding static tags to every event parsed by
+// the given parser (e.g. source=edge1, to tell events of different
+// listeners apart). Static tags win over tags of the same key sent by the
+// producer, so producers could not forge them.
+func WithTags(parse ParseFunc, tags types.Tags) ParseFunc {
+	return func(buf string, f func(event *types.Event, err os.Error)) int {
+		return parse(buf, func(event *types.Event, err os.Error) {
+			if err == nil {
+				if event.Tags == nil {
+					event.Tags = make(types.Tags, len(tags))
+				}
+				for key, value := range tags {
+					event.Tags[key] = value
+				}
+			}
+			f(event, err)
+		})
+	}
+}
+
+// tagKey returns the tag key when the template segment is a tag placeholder.
+func tagKey(segment string) (key string, isTag bool) {
+	if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+		return segment[1 : len(segment)-1], true
+	}
+	return
+}
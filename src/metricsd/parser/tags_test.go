@@ -0,0 +1,86 @@
+package parser
+
+import (
+	"os"
+	"testing"
+	"metricsd/types"
+)
+
+type nameTemplateTest struct {
+	name    string
+	base    string
+	tags    string
+	matched bool
+}
+
+var nameTemplates = []string{"http.{status}.{region}.*", "db.*.{host}"}
+
+var nameTemplateTests = []nameTemplateTest{
+	{"http.200.us-east.latency", "http.latency", "region=us-east;status=200", true},
+	{"http.500.eu.count", "http.count", "region=eu;status=500", true},
+	{"db.queries.db01", "db.queries", "host=db01", true},
+	{"http.200.latency", "http.200.latency", "", false},
+	{"ftp.200.us-east.latency", "ftp.200.us-east.latency", "", false},
+	{"metric", "metric", "", false},
+}
+
+func TestExtractTags(t *testing.T) {
+	templates, err := NewNameTemplates(nameTemplates)
+	if err != nil {
+		t.Fatalf("Expected no error, got error %q", err)
+	}
+	for _, test := range nameTemplateTests {
+		event := types.NewEvent("", test.name, 1)
+		if matched := ExtractTags(templates, event); matched != test.matched {
+			t.Errorf("Expected matched=%t, got %t (name=%q)", test.matched, matched, test.name)
+		}
+		if event.Name != test.base {
+			t.Errorf("Expected name %q, got %q (name=%q)", test.base, event.Name, test.name)
+		}
+		if event.Tags.String() != test.tags {
+			t.Errorf("Expected tags %q, got %q (name=%q)", test.tags, event.Tags.String(), test.name)
+		}
+	}
+}
+
+func TestNewNameTemplateWithInvalidTemplate(t *testing.T) {
+	for _, template := range []string{"{a}.{b}", "http.{}.*", "http.{a}.{a}.*", "http..{a}", "http!.{a}"} {
+		if _, err := NewNameTemplate(template); err == nil {
+			t.Errorf("Expected error, got no error (template=%q)", template)
+		}
+	}
+}
+
+func TestWithTags(t *testing.T) {
+	parse := WithTags(Parse, types.Tags{"source": "edge1", "region": "eu"})
+	events := make([]*types.Event, 0)
+	parse("metric:1;metric:2;bad", func(event *types.Event, err os.Error) {
+		if err == nil {
+			events = append(events, event)
+		}
+	})
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(events))
+	}
+	events[0].Tags["host"] = "web01"
+	if tags := events[1].Tags.String(); tags != "region=eu;source=edge1" {
+		t.Errorf("Expected tags %q, got %q", "region=eu;source=edge1", tags)
+	}
+}
+
+func TestWithTagsOverridesProducerTags(t *testing.T) {
+	templates, err := NewNameTemplates([]string{"http.{source}.{status}.*"})
+	if err != nil {
+		t.Fatalf("Expected no error, got error %q", err)
+	}
+	parse := WithTags(ParseGraphite, types.Tags{"source": "edge1"})
+	parse("http.forged.200.latency 10", func(event *types.Event, err os.Error) {
+		if err != nil {
+			t.Fatalf("Expected no error, got error %q", err)
+		}
+		ExtractTags(templates, event)
+		if tags := event.Tags.String(); tags != "source=edge1;status=200" {
+			t.Errorf("Expected tags %q, got %q", "source=edge1;status=200", tags)
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package parser
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"metricsd/config"
+)
+
+var (
+	// Number of metric values dropped because they are malformed
+	InvalidValues int64
+)
+
+// parseValue parses a metric value used by all input parsers. Accepted
+// values are signed decimal integers, floats (rounded to the nearest
+// integer), and, when HexValues is enabled, signed hexadecimal integers
+// with "0x" prefix. Malformed values are counted in InvalidValues.
+func parseValue(s string) (value int, err os.Error) {
+	if value, err = convertValue(s); err != nil {
+		atomic.AddInt64(&InvalidValues, 1)
+	}
+	return
+}
+
+// convertValue converts the string to a metric value (see parseValue).
+func convertValue(s string) (int, os.Error) {
+	if value, err := strconv.Atoi(s); err == nil {
+		return value, nil
+	}
+
+	digits := strings.TrimLeft(s, "+-")
+	if config.HexValues && (strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X")) && len(s)-len(digits) <= 1 {
+		value, err := strconv.Btoi64(digits[2:], 16)
+		if err != nil || value != int64(int(value)) {
+			return 0, os.NewError("hexadecimal value is invalid")
+		}
+		if strings.HasPrefix(s, "-") {
+			value = -value
+		}
+		return int(value), nil
+	}
+
+	number, err := strconv.Atof64(s)
+	if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+		return 0, os.NewError("value is not a number")
+	}
+	rounded := math.Floor(number + 0.5)
+	if rounded != float64(int(rounded)) {
+		return 0, os.NewError("value is out of range")
+	}
+	return int(rounded), nil
+}
@@ -0,0 +1,59 @@
+package parser
+
+import (
+	"testing"
+	"metricsd/config"
+)
+
+type valueTest struct {
+	value    string
+	hex      bool
+	expected int
+	valid    bool
+}
+
+var parseValueTests = []valueTest{
+	// Decimal integers
+	{"10", false, 10, true},
+	{"-10", false, -10, true},
+	{"+10", false, 10, true},
+
+	// Floats are rounded
+	{"1.4", false, 1, true},
+	{"1.5", false, 2, true},
+	{"-2.5", false, -2, true},
+	{"1e3", false, 1000, true},
+
+	// Hexadecimal integers
+	{"0x1f", true, 31, true},
+	{"-0X1F", true, -31, true},
+	{"0x1f", false, 0, false},
+	{"0x", true, 0, false},
+	{"0xzz", true, 0, false},
+	{"--0x1f", true, 0, false},
+
+	// Malformed values
+	{"", false, 0, false},
+	{"hello", false, 0, false},
+	{"NaN", false, 0, false},
+	{"Inf", false, 0, false},
+	{"1e100", false, 0, false},
+}
+
+func TestParseValue(t *testing.T) {
+	defer func() { config.HexValues = config.DEFAULT_HEX_VALUES }()
+	for _, test := range parseValueTests {
+		config.HexValues = test.hex
+		invalid := InvalidValues
+		value, err := parseValue(test.value)
+		if test.valid && (err != nil || value != test.expected) {
+			t.Errorf("Expected %d, got %d, error %v (value=%q, hex=%t)", test.expected, value, err, test.value, test.hex)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("Expected error, got %d (value=%q, hex=%t)", value, test.value, test.hex)
+		}
+		if !test.valid && InvalidValues != invalid+1 {
+			t.Errorf("Expected invalid value to be counted (value=%q)", test.value)
+		}
+	}
+}
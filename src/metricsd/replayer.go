@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"metricsd/config"
+	"metricsd/parser"
+	"metricsd/types"
+)
+
+// replayFile feeds events from the file in CSV/TSV format (see
+// parser.ParseRecord) to the timeline the way the live daemon would have
+// received them: the clock (see types.Clock) is advanced to the timestamp
+// of every record, and closed slices are written at every crossed
+// WriteInterval boundary, so slices and rollups match what live ingestion
+// would have produced (write jitter is not applied). Records should be
+// sorted by timestamp, records older than already written slices are
+// skipped. When the file ends, the clock is advanced to the next write
+// boundary, and remaining slices are written as on shutdown. Files could be
+// gzip-compressed (see openHistory).
+func replayFile(path string) (err os.Error) {
+	reader, file, err := openHistory(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	var now, writeAt, flushed int64
+	defer func(clock func() int64) {
+		types.Clock = clock
+	}(types.Clock)
+	types.Clock = func() int64 {
+		return now
+	}
+	// advance moves the clock to the given time, writing closed slices at
+	// every write boundary on the way
+	advance := func(timestamp int64) {
+		interval := int64(config.WriteInterval)
+		if writeAt == 0 {
+			writeAt = timestamp - timestamp%interval + interval
+		}
+		for writeAt <= timestamp {
+			now = writeAt
+			rollupSlices(false)
+			flushed = writeAt - writeAt%int64(config.SliceInterval)
+			writeAt += interval
+		}
+		if timestamp > now {
+			now = timestamp
+		}
+	}
+
+	var replayed, skipped int
+	for lineNumber := 1; ; lineNumber++ {
+		line, error := reader.ReadString('\n')
+		if error != nil && error != os.EOF {
+			err = error
+			return
+		}
+
+		if record := strings.TrimSpace(line); record != "" && record[0] != '#' {
+			event, timestamp, parseError := parser.ParseRecord(record)
+			switch {
+			case parseError != nil && lineNumber == 1:
+				// Header line
+				log.Debug("Skipping header: %s", record)
+			case parseError != nil:
+				log.Warn("Skipping line %d: %s", lineNumber, parseError)
+				skipped++
+			case timestamp < flushed:
+				log.Warn("Skipping line %d: record is older than already written data (record=%q)", lineNumber, record)
+				skipped++
+			default:
+				advance(timestamp)
+				event.Source = "all"
+				parser.ExtractTags(nameTemplates, event)
+				router.AddAt(event, timestamp)
+				for _, alias := range parser.Aliases(config.Aliases, event) {
+					router.AddAt(alias, timestamp)
+				}
+				replayed++
+			}
+		}
+
+		if error == os.EOF {
+			break
+		}
+	}
+	if writeAt > 0 {
+		advance(writeAt)
+	}
+	rollupSlices(true)
+	log.Info("Replayed %d events from %s (%d skipped)", replayed, path, skipped)
+	return
+}
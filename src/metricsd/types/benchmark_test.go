@@ -0,0 +1,199 @@
+package types
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"metricsd/config"
+)
+
+// Number of distinct metrics used in Add benchmarks.
+const benchmarkMetrics = 1000
+
+// benchmarkEvents returns the given number of events for distinct metrics
+// from a few sources, created in advance to exclude allocations from
+// measurements.
+func benchmarkEvents(count int) []*Event {
+	events := make([]*Event, count)
+	for idx := range events {
+		events[idx] = NewEvent(fmt.Sprintf("app%02d", idx%10), fmt.Sprintf("group.metric%d", idx), idx)
+	}
+	return events
+}
+
+func BenchmarkTimelineAdd(b *testing.B) {
+	b.StopTimer()
+	timeline := NewTimeline(10)
+	events := benchmarkEvents(benchmarkMetrics)
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		timeline.Add(events[i%len(events)])
+	}
+}
+
+// benchmarkTimelineAddParallel measures Timeline.Add called concurrently
+// from the given number of goroutines (as many listeners do).
+func benchmarkTimelineAddParallel(b *testing.B, goroutines int) {
+	b.StopTimer()
+	timeline := NewTimeline(10)
+	events := benchmarkEvents(benchmarkMetrics)
+	procs := runtime.GOMAXPROCS(goroutines)
+	defer runtime.GOMAXPROCS(procs)
+	wg := &sync.WaitGroup{}
+	wg.Add(goroutines)
+	b.StartTimer()
+
+	for g := 0; g < goroutines; g++ {
+		go func(offset int) {
+			for i := offset; i < b.N; i += goroutines {
+				timeline.Add(events[i%len(events)])
+			}
+			wg.Done()
+		}(g)
+	}
+	wg.Wait()
+}
+
+func BenchmarkTimelineAdd4Goroutines(b *testing.B) {
+	benchmarkTimelineAddParallel(b, 4)
+}
+
+func BenchmarkTimelineAdd16Goroutines(b *testing.B) {
+	benchmarkTimelineAddParallel(b, 16)
+}
+
+// benchmarkSliceAdd measures adding events for repeated metric names to a
+// slice, with or without interning of sample set keys (see interner).
+func benchmarkSliceAdd(b *testing.B, limit int) {
+	b.StopTimer()
+	config.InternLimit = limit
+	defer func() { config.InternLimit = config.DEFAULT_INTERN_LIMIT }()
+	slice := NewSlice(10)
+	events := benchmarkEvents(benchmarkMetrics)
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		slice.Add(events[i%len(events)])
+	}
+}
+
+func BenchmarkSliceAdd(b *testing.B) {
+	benchmarkSliceAdd(b, 0)
+}
+
+func BenchmarkSliceAddInterned(b *testing.B) {
+	benchmarkSliceAdd(b, config.DEFAULT_INTERN_LIMIT)
+}
+
+// benchmarkSliceAddGauges measures adding single-value gauge updates to
+// new slices (every metric gets one value per slice), with or without
+// keeping only the latest values (see config.KeepsLatest).
+func benchmarkSliceAddGauges(b *testing.B, latest bool) {
+	b.StopTimer()
+	config.LatestGauges = latest
+	config.TypeWriters = map[string][]string{config.METRIC_TYPE_GAUGE: []string{"last"}}
+	defer func() {
+		config.LatestGauges = config.DEFAULT_LATEST_GAUGES
+		config.TypeWriters = config.DEFAULT_TYPE_WRITERS
+	}()
+	events := benchmarkEvents(benchmarkMetrics)
+	for _, event := range events {
+		event.Type = config.METRIC_TYPE_GAUGE
+	}
+	var slice *Slice
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		if i%len(events) == 0 {
+			slice = NewSlice(10)
+		}
+		slice.Add(events[i%len(events)])
+	}
+}
+
+func BenchmarkSliceAddGauges(b *testing.B) {
+	benchmarkSliceAddGauges(b, false)
+}
+
+func BenchmarkSliceAddLatestGauges(b *testing.B) {
+	benchmarkSliceAddGauges(b, true)
+}
+
+// BenchmarkTimelineSliceChurn measures creation of a new slice for every
+// event (the worst case of getCurrentSlice, when slices are extracted
+// more often than events arrive).
+func BenchmarkTimelineSliceChurn(b *testing.B) {
+	b.StopTimer()
+	timeline := NewTimeline(10)
+	event := NewEvent("app", "metric", 10)
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		timeline.mutex.Lock()
+		timeline.getSlice(int64(i)).Add(event)
+		timeline.mutex.Unlock()
+		if i%1000 == 999 {
+			b.StopTimer()
+			timeline.Slices = make(map[int64]*Slice)
+			b.StartTimer()
+		}
+	}
+}
+
+// benchmarkExtractClosedSampleSets measures extraction of the given number
+// of closed slices, each containing events for the given number of metrics.
+func benchmarkExtractClosedSampleSets(b *testing.B, slices, sets int) {
+	b.StopTimer()
+	events := benchmarkEvents(sets)
+	for i := 0; i < b.N; i++ {
+		timeline := NewTimeline(10)
+		for number := 0; number < slices; number++ {
+			for _, event := range events {
+				timeline.AddAt(event, int64(number)*10)
+			}
+		}
+		b.StartTimer()
+		timeline.ExtractClosedSampleSets(false)
+		b.StopTimer()
+	}
+}
+
+func BenchmarkExtractClosedSampleSets10x100(b *testing.B) {
+	benchmarkExtractClosedSampleSets(b, 10, 100)
+}
+
+func BenchmarkExtractClosedSampleSets100x100(b *testing.B) {
+	benchmarkExtractClosedSampleSets(b, 100, 100)
+}
+
+func BenchmarkExtractClosedSampleSets10x10000(b *testing.B) {
+	benchmarkExtractClosedSampleSets(b, 10, 10000)
+}
+
+// benchmarkExtractionCycle measures steady-state passes: events for the
+// given number of metrics are added to a slice, which is extracted and
+// released, with or without recycling (see Timeline.Release).
+func benchmarkExtractionCycle(b *testing.B, sets int, recycle bool) {
+	b.StopTimer()
+	events := benchmarkEvents(sets)
+	timeline := NewTimeline(10)
+	timeline.Recycle = recycle
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, event := range events {
+			timeline.AddAt(event, int64(i)*10)
+		}
+		timeline.Release(timeline.ExtractClosedSlices(true))
+	}
+}
+
+func BenchmarkExtractionCycle1000(b *testing.B) {
+	benchmarkExtractionCycle(b, 1000, false)
+}
+
+func BenchmarkExtractionCycle1000Recycled(b *testing.B) {
+	benchmarkExtractionCycle(b, 1000, true)
+}
@@ -0,0 +1,11 @@
+package types
+
+import (
+	"time"
+)
+
+// Clock returns the current time in seconds since epoch. It picks slices
+// for events added now, and decides which slices are closed, so replaying
+// recorded events could advance time to their timestamps instead of
+// following the wall clock.
+var Clock = time.Seconds
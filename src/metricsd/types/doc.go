@@ -33,6 +33,9 @@
 //     var closedSlices SlicesList = timeline.ExtractClosedSlices(false)
 //     // or retrieve all sample sets for all closed slices in a single list
 //     var closedSampleSets SampleSetsList = timeline.ExtractClosedSampleSets(false)
+// 3. Recycling processed slices, when timeline.Recycle is enabled:
+//     // slices (and their sample sets) must not be used after this
+//     timeline.Release(closedSlices)
 //
 
 package types
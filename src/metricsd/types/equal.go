@@ -0,0 +1,58 @@
+package types
+
+import (
+	"sort"
+)
+
+// SampleSetsEqual returns a value indicating whether sample sets have the
+// same time, source, name, values, accumulated totals, and numbers and sums
+// of summarized values. Order of values is ignored, since
+// writers sort values in place.
+func SampleSetsEqual(a, b *SampleSet) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Time != b.Time || a.Source != b.Source || a.SeriesName() != b.SeriesName() || a.Carried != b.Carried ||
+		a.Total != b.Total || a.Count != b.Count || a.Latest != b.Latest || (a.Latest && a.Last != b.Last) {
+		return false
+	}
+	if (a.Summary == nil) != (b.Summary == nil) ||
+		(a.Summary != nil && (a.Summary.Count != b.Summary.Count || a.Summary.Total != b.Summary.Total)) {
+		return false
+	}
+	if len(a.Values) != len(b.Values) {
+		return false
+	}
+	aValues, bValues := sortedValues(a), sortedValues(b)
+	for idx, value := range aValues {
+		if value != bValues[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+// SlicesEqual returns a value indicating whether slices have the same time,
+// and equal sample sets (see SampleSetsEqual).
+func SlicesEqual(a, b *Slice) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Time != b.Time || len(a.Sets) != len(b.Sets) {
+		return false
+	}
+	for key, set := range a.Sets {
+		if !SampleSetsEqual(set, b.Sets[key]) {
+			return false
+		}
+	}
+	return true
+}
+
+// sortedValues returns a sorted copy of sample set values.
+func sortedValues(set *SampleSet) []int {
+	values := make([]int, len(set.Values))
+	copy(values, set.Values)
+	sort.Ints(values)
+	return values
+}
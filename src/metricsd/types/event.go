@@ -2,6 +2,8 @@ package types
 
 import (
 	"fmt"
+	"os"
+	"strings"
 )
 
 type MetricValue int
@@ -11,11 +13,95 @@ type Event struct {
 	Source string // event source (IP address, DNS name, or custom string)
 	Name   string // metric's name
 	Value  int    // metric's value
+	Values []int  // further values of vector events (see NewVectorEvent), nil for single value events
+	Weight int    // number of observations the event represents (pre-aggregated events)
+	Tags   Tags   // metric's dimensions, nil for untagged metrics
+	Type   string // metric's type declared by producer (see config.TypeWriters), empty if not declared
 }
 
 // NewEvent returns a new Event with the given source, name, and value.
+// Neither source nor name are validated, use NewValidEvent to construct
+// events from untrusted input.
 func NewEvent(source string, name string, value int) *Event {
-	return &Event{Source: source, Name: name, Value: value}
+	return NewWeightedEvent(source, name, value, 1)
+}
+
+// NewWeightedEvent returns a new Event with the given source, name, value,
+// and weight (number of observations of the value the event represents).
+// Nothing is validated, use NewValidWeightedEvent to construct events from
+// untrusted input.
+func NewWeightedEvent(source string, name string, value int, weight int) *Event {
+	return &Event{Source: source, Name: name, Value: value, Weight: weight}
+}
+
+// NewVectorEvent returns a new Event with the given source, name, and
+// values (e.g. per-core CPU usage), each added to sample sets as a separate
+// value. The first value is stored in Value, the rest in Values. Values
+// should not be empty.
+func NewVectorEvent(source string, name string, values []int) *Event {
+	event := NewEvent(source, name, values[0])
+	if len(values) > 1 {
+		event.Values = values[1:]
+	}
+	return event
+}
+
+// NewValidEvent returns a new Event with the given source, name, and value,
+// or an error when source or name are invalid (see NewValidWeightedEvent).
+func NewValidEvent(source string, name string, value int) (event *Event, err os.Error) {
+	return NewValidWeightedEvent(source, name, value, 1)
+}
+
+// NewValidWeightedEvent returns a new Event with the given source, name,
+// value, and weight, or an error when source or name are invalid (see
+// ValidName), or weight is not positive. Surrounding whitespace is stripped
+// from both source and name. Empty source is allowed (source will be
+// detected by the receiver).
+func NewValidWeightedEvent(source string, name string, value int, weight int) (event *Event, err os.Error) {
+	source = strings.TrimSpace(source)
+	name = strings.TrimSpace(name)
+	if !ValidName(source) {
+		err = os.NewError(fmt.Sprintf("Source is invalid: %q", source))
+		return
+	}
+	if len(name) == 0 {
+		err = os.NewError("Metric name is empty")
+		return
+	}
+	if !ValidName(name) {
+		err = os.NewError(fmt.Sprintf("Metric name is invalid: %q", name))
+		return
+	}
+	if weight < 1 {
+		err = os.NewError(fmt.Sprintf("Weight is invalid: %d", weight))
+		return
+	}
+	event = NewWeightedEvent(source, name, value, weight)
+	return
+}
+
+// ValidName returns a value indicating whether the given string could be
+// used as a metric or source name: it could contain only ASCII letters,
+// digits, and "_", "-", "$", "." characters.
+func ValidName(name string) bool {
+	for _, rune := range name {
+		if rune > 0x7F {
+			return false
+		}
+
+		// Digits and Letters
+		if ('0' <= rune && rune <= '9') || ('a' <= rune && rune <= 'z') || ('A' <= rune && rune <= 'Z') {
+			continue
+		}
+		// Special characters
+		switch rune {
+		case '_', '-', '$', '.':
+			continue
+		default:
+			return false
+		}
+	}
+	return true
 }
 
 // String converts an instance of event struct to string.
@@ -26,7 +112,7 @@ func (event *Event) String() string {
 	return fmt.Sprintf(
 		"Event[source=%s, name=%s, value=%d]",
 		event.Source,
-		event.Name,
+		SeriesName(event.Name, event.Tags),
 		event.Value,
 	)
 }
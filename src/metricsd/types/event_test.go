@@ -2,6 +2,7 @@ package types
 
 import (
 	. "launchpad.net/gocheck"
+	"os"
 )
 
 type EventS struct{}
@@ -15,7 +16,63 @@ func (s *EventS) TestNewEvent(c *C) {
 	c.Check(event.Value, Equals, 10)
 }
 
+func (s *EventS) TestNewVectorEvent(c *C) {
+	event := NewVectorEvent("src", "cpu", []int{10, 20, 30})
+	c.Check(event.Value, Equals, 10)
+	c.Check(event.Values, Equals, []int{20, 30})
+	c.Check(NewVectorEvent("src", "cpu", []int{10}), Equals, NewEvent("src", "cpu", 10))
+}
+
 func (s *EventS) TestEventString(c *C) {
 	event := NewEvent("src", "msg", 10)
 	c.Check(event.String(), Equals, "Event[source=src, name=msg, value=10]")
 }
+
+func (s *EventS) TestNewValidEvent(c *C) {
+	event, err := NewValidEvent(" src ", " group.msg\n", 10)
+	c.Check(err, IsNil)
+	c.Check(event, Equals, NewEvent("src", "group.msg", 10))
+}
+
+func (s *EventS) TestNewValidEventWithEmptySource(c *C) {
+	event, err := NewValidEvent("", "msg", 10)
+	c.Check(err, IsNil)
+	c.Check(event, Equals, NewEvent("", "msg", 10))
+}
+
+func (s *EventS) TestNewValidEventWithInvalidSource(c *C) {
+	event, err := NewValidEvent("src!", "msg", 10)
+	c.Check(event, IsNil)
+	c.Check(err, Equals, os.NewError("Source is invalid: \"src!\""))
+}
+
+func (s *EventS) TestNewValidEventWithEmptyName(c *C) {
+	event, err := NewValidEvent("src", " ", 10)
+	c.Check(event, IsNil)
+	c.Check(err, Equals, os.NewError("Metric name is empty"))
+}
+
+func (s *EventS) TestNewValidEventWithInvalidName(c *C) {
+	event, err := NewValidEvent("src", "msg!", 10)
+	c.Check(event, IsNil)
+	c.Check(err, Equals, os.NewError("Metric name is invalid: \"msg!\""))
+}
+
+func (s *EventS) TestNewValidWeightedEvent(c *C) {
+	event, err := NewValidWeightedEvent(" src ", "msg", 10, 5)
+	c.Check(err, IsNil)
+	c.Check(event, Equals, NewWeightedEvent("src", "msg", 10, 5))
+	c.Check(NewEvent("src", "msg", 10).Weight, Equals, 1)
+}
+
+func (s *EventS) TestNewValidWeightedEventWithInvalidWeight(c *C) {
+	event, err := NewValidWeightedEvent("src", "msg", 10, 0)
+	c.Check(event, IsNil)
+	c.Check(err, Equals, os.NewError("Weight is invalid: 0"))
+}
+
+func (s *EventS) TestValidName(c *C) {
+	c.Check(ValidName("group$metric_name-1.time"), Equals, true)
+	c.Check(ValidName("metric name"), Equals, false)
+	c.Check(ValidName("метрика"), Equals, false)
+}
@@ -0,0 +1,115 @@
+package types
+
+import (
+	"metricsd/config"
+)
+
+// A trackedMetric is a metric seen in previous slices, which has to be
+// reported in slices without samples according to its gap policy.
+type trackedMetric struct {
+	source string
+	name   string
+	tags   Tags
+	kind   string // declared metric type
+	time   int64  // time of the last slice with samples
+	seen   int64  // time of the last received event
+	value  int    // the last received value
+}
+
+// fillGaps generates carried sample sets for tracked metrics, which have
+// no samples in the given closed slices (sorted by time). When there are
+// closed slices missing in the timeline because no events received at all,
+// empty slices are created for them. Returns the list of slices with gaps
+// filled.
+func (timeline *Timeline) fillGaps(slices []*Slice) []*Slice {
+	if len(slices) == 0 {
+		return slices
+	}
+
+	// Find slices missing between the last extracted slice and the last closed
+	first := slices[0].Time / timeline.Interval
+	if timeline.lastClosed > 0 && timeline.lastClosed+1 < first {
+		first = timeline.lastClosed + 1
+	}
+	last := slices[len(slices)-1].Time / timeline.Interval
+	timeline.lastClosed = last
+
+	// Empty slices are only needed while there are metrics to report
+	filled := make([]*Slice, 0, len(slices))
+	idx := 0
+	for number := first; number <= last; number++ {
+		var slice *Slice
+		if idx < len(slices) && slices[idx].Time == number*timeline.Interval {
+			slice = slices[idx]
+			idx++
+		} else if len(timeline.tracked) > 0 {
+			slice = NewSlice(number * timeline.Interval)
+		} else {
+			continue
+		}
+		timeline.fillSliceGaps(slice)
+		timeline.trackSlice(slice)
+		filled = append(filled, slice)
+	}
+	return filled
+}
+
+// fillSliceGaps adds carried sample sets to the slice for all tracked
+// metrics having no samples in it (with the last received value or the
+// default value, depending on gap policy). Metrics absent for longer than their
+// MaxStaleness (or StateTTL slice intervals, if less) are forgotten.
+func (timeline *Timeline) fillSliceGaps(slice *Slice) {
+	for key, metric := range timeline.tracked {
+		if _, found := slice.Sets[key]; found {
+			continue
+		}
+
+		options := config.MetricOptions(metric.name)
+		staleness := options.MaxStaleness
+		if ttl := config.StateTTL * int(timeline.Interval); ttl > 0 && ttl < staleness {
+			staleness = ttl
+		}
+		if slice.Time-metric.time > int64(staleness) {
+			timeline.tracked[key] = nil, false
+			continue
+		}
+
+		set := NewSampleSet(slice.Time, metric.source, metric.name)
+		set.Tags = metric.tags
+		set.Type = metric.kind
+		set.Carried = true
+		set.LastSeen = metric.seen
+		switch options.GapPolicy {
+		case config.GAP_POLICY_CARRY:
+			set.Add(metric.value)
+		case config.GAP_POLICY_DEFAULT:
+			set.Add(options.DefaultValue)
+		}
+		slice.Sets[key] = set
+	}
+}
+
+// trackSlice remembers the last values of metrics received in the slice,
+// which have a gap policy defined.
+func (timeline *Timeline) trackSlice(slice *Slice) {
+	for key, set := range slice.Sets {
+		if set.Carried || (len(set.Values) == 0 && !set.Accumulated && !set.Latest && set.Summary == nil) {
+			continue
+		}
+		if config.MetricOptions(set.Name).GapPolicy == config.GAP_POLICY_NONE {
+			continue
+		}
+		metric := &trackedMetric{
+			source: set.Source,
+			name:   set.Name,
+			tags:   set.Tags,
+			kind:   set.Type,
+			time:   slice.Time,
+			seen:   set.LastSeen,
+		}
+		if len(set.Values) > 0 || set.Latest || set.Summary != nil {
+			metric.value = set.Last
+		}
+		timeline.tracked[key] = metric
+	}
+}
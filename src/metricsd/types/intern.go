@@ -0,0 +1,78 @@
+package types
+
+import (
+	"sync"
+	"sync/atomic"
+	"metricsd/config"
+)
+
+var (
+	// Number of sample set keys not interned because of InternLimit
+	// (reset by stats reporting)
+	InternRefused int64
+	// Sample set keys shared by all timelines
+	sampleSetKeys = newInterner()
+)
+
+// An interner stores a single copy of every sample set key (source and
+// series key joined with "-"), so events of known metrics do not allocate
+// keys again, and sample sets of all slices share the same strings. It
+// holds at most config.InternLimit keys: keys of new metrics past the
+// limit are allocated for every event, and counted in InternRefused.
+type interner struct {
+	keys  map[string]map[string]string // keys by source and series key
+	size  int                          // number of interned keys
+	mutex *sync.RWMutex
+}
+
+func newInterner() *interner {
+	return &interner{keys: make(map[string]map[string]string), mutex: &sync.RWMutex{}}
+}
+
+// key returns the interned sample set key for the source and series key.
+func (interner *interner) key(source, name string) string {
+	if config.InternLimit <= 0 {
+		return source + "-" + name
+	}
+	interner.mutex.RLock()
+	key, found := interner.keys[source][name]
+	interner.mutex.RUnlock()
+	if found {
+		return key
+	}
+
+	key = source + "-" + name
+	interner.mutex.Lock()
+	defer interner.mutex.Unlock()
+	if existing, found := interner.keys[source][name]; found {
+		return existing
+	}
+	if interner.size >= config.InternLimit {
+		atomic.AddInt64(&InternRefused, 1)
+		return key
+	}
+	// Map keys share the interned key, so they do not hold buffers events
+	// have been parsed from
+	source, name = key[:len(source)], key[len(source)+1:]
+	bySource, found := interner.keys[source]
+	if !found {
+		bySource = make(map[string]string)
+		interner.keys[source] = bySource
+	}
+	bySource[name] = key
+	interner.size++
+	return key
+}
+
+// count returns the number of interned keys.
+func (interner *interner) count() int {
+	interner.mutex.RLock()
+	defer interner.mutex.RUnlock()
+	return interner.size
+}
+
+// InternedKeys returns the number of interned sample set keys (see
+// config.InternLimit).
+func InternedKeys() int {
+	return sampleSetKeys.count()
+}
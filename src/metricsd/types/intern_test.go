@@ -0,0 +1,64 @@
+package types
+
+import (
+	. "launchpad.net/gocheck"
+	"runtime"
+	"metricsd/config"
+)
+
+type InternerS struct {
+	interner *interner
+}
+
+var _ = Suite(&InternerS{})
+
+func (s *InternerS) SetUpTest(c *C) {
+	s.interner = newInterner()
+	InternRefused = 0
+}
+
+func (s *InternerS) TearDownTest(c *C) {
+	config.InternLimit = config.DEFAULT_INTERN_LIMIT
+}
+
+func (s *InternerS) TestKey(c *C) {
+	c.Check(s.interner.key("src", "metric"), Equals, "src-metric")
+	c.Check(s.interner.key("src", "metric"), Equals, "src-metric")
+	c.Check(s.interner.key("all", "metric"), Equals, "all-metric")
+	c.Check(s.interner.count(), Equals, 2)
+}
+
+func (s *InternerS) TestKeyPastLimit(c *C) {
+	config.InternLimit = 1
+	c.Check(s.interner.key("src", "metric1"), Equals, "src-metric1")
+	c.Check(s.interner.key("src", "metric2"), Equals, "src-metric2")
+	c.Check(s.interner.count(), Equals, 1)
+	c.Check(InternRefused, Equals, int64(1))
+}
+
+func (s *InternerS) TestKeyDisabled(c *C) {
+	config.InternLimit = 0
+	c.Check(s.interner.key("src", "metric"), Equals, "src-metric")
+	c.Check(s.interner.count(), Equals, 0)
+}
+
+func (s *InternerS) TestInternReducesAllocations(c *C) {
+	allocations := func(limit int) uint64 {
+		config.InternLimit = limit
+		slice := NewSlice(10)
+		events := benchmarkEvents(100)
+		runtime.UpdateMemStats()
+		before := runtime.MemStats.Mallocs
+		for i := 0; i < 100; i++ {
+			for _, event := range events {
+				slice.Add(event)
+			}
+		}
+		runtime.UpdateMemStats()
+		return runtime.MemStats.Mallocs - before
+	}
+	plain, interned := allocations(0), allocations(config.DEFAULT_INTERN_LIMIT)
+	if interned >= plain {
+		c.Errorf("Expected less allocations with interning: %d interned, %d not interned", interned, plain)
+	}
+}
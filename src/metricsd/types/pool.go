@@ -0,0 +1,100 @@
+package types
+
+import (
+	"sync"
+)
+
+const (
+	// Maximum number of slices kept in a pool.
+	maxPooledSlices = 64
+	// Maximum number of sample sets kept in a pool.
+	maxPooledSampleSets = 100000
+	// Sample sets with larger arrays of values are not recycled, so a
+	// single burst does not pin memory forever.
+	maxPooledValues = 1024
+)
+
+// A pool recycles slices and sample sets released after extraction (see
+// Timeline.Release), so steady-state workloads do not allocate them every
+// interval. Released objects are reset, so stale references see empty
+// objects, and marked as released, so they are never put into the pool
+// twice.
+type pool struct {
+	slices []*Slice
+	sets   []*SampleSet
+	mutex  *sync.Mutex
+}
+
+func newPool() *pool {
+	return &pool{mutex: &sync.Mutex{}}
+}
+
+// getSlice returns a recycled slice (or a new one, if the pool is empty)
+// starting at the given time.
+func (pool *pool) getSlice(time int64) (slice *Slice) {
+	pool.mutex.Lock()
+	if last := len(pool.slices) - 1; last >= 0 {
+		slice = pool.slices[last]
+		pool.slices[last] = nil
+		pool.slices = pool.slices[:last]
+	}
+	pool.mutex.Unlock()
+
+	if slice == nil {
+		slice = NewSlice(time)
+	}
+	slice.Time = time
+	slice.pool = pool
+	slice.released = false
+	return
+}
+
+// getSampleSet returns a recycled sample set (or a new one, if the pool is
+// empty) with the given time, source, and name.
+func (pool *pool) getSampleSet(time int64, source, name string) (set *SampleSet) {
+	pool.mutex.Lock()
+	if last := len(pool.sets) - 1; last >= 0 {
+		set = pool.sets[last]
+		pool.sets[last] = nil
+		pool.sets = pool.sets[:last]
+	}
+	pool.mutex.Unlock()
+
+	if set == nil {
+		return NewSampleSet(time, source, name)
+	}
+	set.Time = time
+	set.Source = source
+	set.Name = name
+	set.released = false
+	return
+}
+
+// release resets the slice and its sample sets, and puts them into the
+// pool. Slices released already are ignored.
+func (pool *pool) release(slice *Slice) {
+	if slice.released {
+		return
+	}
+	slice.released = true
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	for key, set := range slice.Sets {
+		if !set.released && cap(set.Values) <= maxPooledValues && len(pool.sets) < maxPooledSampleSets {
+			set.reset()
+			pool.sets = append(pool.sets, set)
+		}
+		slice.Sets[key] = nil, false
+	}
+	slice.seen = nil
+	if len(pool.slices) < maxPooledSlices {
+		pool.slices = append(pool.slices, slice)
+	}
+}
+
+// size returns the number of slices and sample sets in the pool.
+func (pool *pool) size() (slices, sets int) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	return len(pool.slices), len(pool.sets)
+}
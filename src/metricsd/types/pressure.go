@@ -0,0 +1,61 @@
+package types
+
+import (
+	"sync/atomic"
+)
+
+var (
+	// Number of values removed from sample sets when memory pressure mode
+	// was engaged (reset by stats reporting)
+	PressureShed int64
+	// Values limit of all sample sets in memory pressure mode, 0 when the
+	// mode is disengaged
+	pressureLimit int32
+)
+
+// SetPressureLimit engages memory pressure mode, when limit is positive:
+// values received after limit values are stored in a sample set replace
+// random values (see SampleSet.AddLimited), regardless of per-metric
+// MaxValues and Overflow, unless they are stricter. Limit 0 disengages it.
+func SetPressureLimit(limit int) {
+	for {
+		old := atomic.AddInt32(&pressureLimit, 0)
+		if atomic.CompareAndSwapInt32(&pressureLimit, old, int32(limit)) {
+			return
+		}
+	}
+}
+
+// PressureLimit returns values limit of memory pressure mode, 0 when it is
+// disengaged.
+func PressureLimit() int {
+	return int(atomic.AddInt32(&pressureLimit, 0))
+}
+
+// Shed shrinks sample sets of all slices (in all shards) to max values
+// (see SampleSet.Shrink), so the largest sets release memory when memory
+// pressure mode is engaged. Removed values are counted in PressureShed.
+// Returns the number of shrunk sample sets.
+func (timeline *Timeline) Shed(max int) (shrunk int) {
+	timeline.mutex.Lock()
+	for _, slice := range timeline.Slices {
+		shrunk += shedSlice(slice, max)
+	}
+	timeline.mutex.Unlock()
+	timeline.eachShardSlice(func(shard *timelineShard, number int64, slice *Slice) {
+		shrunk += shedSlice(slice, max)
+	})
+	return
+}
+
+// shedSlice shrinks sample sets of the slice to max values, and returns
+// the number of shrunk sample sets.
+func shedSlice(slice *Slice, max int) (shrunk int) {
+	for _, set := range slice.Sets {
+		if removed := set.Shrink(max); removed > 0 {
+			atomic.AddInt64(&PressureShed, int64(removed))
+			shrunk++
+		}
+	}
+	return
+}
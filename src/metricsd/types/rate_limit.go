@@ -0,0 +1,73 @@
+package types
+
+import (
+	"sync"
+	"metricsd/config"
+)
+
+// A tokenBucket holds tokens of a single metric name, one token per
+// accepted event.
+type tokenBucket struct {
+	tokens  float64 // number of available tokens
+	updated int64   // time tokens were refilled last time, in nanoseconds
+}
+
+// A rateLimiter limits the rate of events per metric name (see
+// config.RateLimit).
+type rateLimiter struct {
+	buckets map[string]*tokenBucket
+	mutex   *sync.Mutex
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*tokenBucket), mutex: &sync.Mutex{}}
+}
+
+// allow returns a value indicating whether an event of the metric received
+// at the given time (in nanoseconds) fits into the metric's rate limit.
+// Always returns true when the limit is disabled.
+func (limiter *rateLimiter) allow(name string, now int64) bool {
+	limit := config.RateLimit
+	if limit.Rate <= 0 {
+		return true
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	bucket, found := limiter.buckets[name]
+	if !found {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), updated: now}
+		limiter.buckets[name] = bucket
+	}
+	bucket.refill(limit, now)
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// prune forgets buckets refilled completely by the given time (in
+// nanoseconds), so names which are not received anymore do not hold
+// memory. Forgotten buckets are created full again.
+func (limiter *rateLimiter) prune(now int64) {
+	limit := config.RateLimit
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	for name, bucket := range limiter.buckets {
+		bucket.refill(limit, now)
+		if limit.Rate <= 0 || bucket.tokens >= float64(limit.Burst) {
+			limiter.buckets[name] = nil, false
+		}
+	}
+}
+
+// refill adds tokens accrued since the last refill, up to the burst size.
+func (bucket *tokenBucket) refill(limit *config.RateLimitConfig, now int64) {
+	if elapsed := now - bucket.updated; elapsed > 0 {
+		bucket.tokens += float64(elapsed) / 1e9 * limit.Rate
+		bucket.updated = now
+	}
+	if bucket.tokens > float64(limit.Burst) {
+		bucket.tokens = float64(limit.Burst)
+	}
+}
@@ -0,0 +1,54 @@
+package types
+
+import (
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+)
+
+type RateLimiterS struct {
+	limiter *rateLimiter
+}
+
+var _ = Suite(&RateLimiterS{})
+
+func (s *RateLimiterS) SetUpTest(c *C) {
+	config.RateLimit = &config.RateLimitConfig{Rate: 10, Burst: 2}
+	s.limiter = newRateLimiter()
+}
+
+func (s *RateLimiterS) TearDownTest(c *C) {
+	config.RateLimit = config.DEFAULT_RATE_LIMIT
+}
+
+func (s *RateLimiterS) TestAllowBurst(c *C) {
+	c.Check(s.limiter.allow("metric", 1e9), Equals, true)
+	c.Check(s.limiter.allow("metric", 1e9), Equals, true)
+	c.Check(s.limiter.allow("metric", 1e9), Equals, false)
+	// Other metrics have their own buckets
+	c.Check(s.limiter.allow("other", 1e9), Equals, true)
+}
+
+func (s *RateLimiterS) TestAllowRefills(c *C) {
+	s.limiter.allow("metric", 1e9)
+	s.limiter.allow("metric", 1e9)
+	c.Check(s.limiter.allow("metric", 1.05e9), Equals, false)
+	c.Check(s.limiter.allow("metric", 1.15e9), Equals, true)
+	c.Check(s.limiter.allow("metric", 1.15e9), Equals, false)
+}
+
+func (s *RateLimiterS) TestAllowDisabled(c *C) {
+	config.RateLimit = config.DEFAULT_RATE_LIMIT
+	for i := 0; i < 10; i++ {
+		c.Check(s.limiter.allow("metric", 1e9), Equals, true)
+	}
+	c.Check(len(s.limiter.buckets), Equals, 0)
+}
+
+func (s *RateLimiterS) TestPrune(c *C) {
+	s.limiter.allow("metric", 1e9)
+	s.limiter.allow("other", 1e9)
+	s.limiter.allow("other", 1.1e9)
+	s.limiter.prune(1.15e9)
+	c.Check(len(s.limiter.buckets), Equals, 1)
+	c.Check(s.limiter.buckets["other"], Not(IsNil))
+}
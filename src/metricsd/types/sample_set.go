@@ -2,13 +2,37 @@ package types
 
 import (
 	"fmt"
+	"rand"
+	"sort"
+	"sync/atomic"
 )
 
 type SampleSet struct {
-	Time   int64
-	Source string
-	Name   string
-	Values []int
+	Time    int64
+	Source  string
+	Name    string
+	Tags    Tags   // metric's dimensions, nil for untagged metrics
+	Type    string // metric's type declared by producer, empty if not declared
+	Values  []int
+	Weights []int   // weights of values, nil when all values have weight 1 (see AddWeighted)
+	Times   []int64 // timestamps of values (seconds since epoch), nil when not kept (see KeepTimes)
+	Carried bool    // set was not received, but generated for a slice without samples (see GapPolicy)
+	Partial bool    // set belongs to a slice extracted before its end (see config.PartialPolicy)
+	Dropped int     // number of values not stored because of the values limit (see AddLimited)
+	// Values of metrics with summary storage are summarized on arrival
+	// instead of being stored, nil for other metrics (see Summarize).
+	Summary *Summary
+	// Values of counters are summed on arrival instead of being stored when
+	// the set is accumulated (see Accumulate).
+	Accumulated bool
+	Total       int64 // sum of accumulated values multiplied by their weights
+	Count       int64 // number of accumulated observations (sum of weights)
+	Latest      bool  // only the value added last is kept, in Last (see AddLatest)
+	Last        int   // the value added last (including dropped values)
+	LastSeen    int64 // time of the most recent event (seconds since epoch), 0 when unknown (see Touch)
+	stamp       int64 // timestamp of values added next when timestamps are kept
+	released    bool  // set has been put into a pool (see pool)
+	sorted      bool  // values have been sorted (see Sort)
 }
 
 func NewSampleSet(time int64, source, name string) *SampleSet {
@@ -21,20 +45,298 @@ func NewSampleSet(time int64, source, name string) *SampleSet {
 }
 
 func (set *SampleSet) Add(value int) {
+	set.sorted = false
+	set.Last = value
 	set.Values = append(set.Values, value)
+	if set.Weights != nil {
+		set.Weights = append(set.Weights, 1)
+	}
+	if set.Times != nil {
+		set.Times = append(set.Times, set.stamp)
+	}
+}
+
+// AddWeighted appends the value with the given weight (number of
+// observations the value represents). Weights less than 1 are treated as 1.
+// Weights are stored only after the first value with weight greater than 1
+// has been added.
+func (set *SampleSet) AddWeighted(value, weight int) {
+	set.sorted = false
+	set.Last = value
+	if weight <= 1 && set.Weights == nil {
+		set.Add(value)
+		return
+	}
+	if set.Weights == nil {
+		set.initWeights()
+	}
+	if weight < 1 {
+		weight = 1
+	}
+	set.Values = append(set.Values, value)
+	set.Weights = append(set.Weights, weight)
+	if set.Times != nil {
+		set.Times = append(set.Times, set.stamp)
+	}
+}
+
+// initWeights stores weight 1 for all values added so far.
+func (set *SampleSet) initWeights() {
+	set.Weights = make([]int, len(set.Values), cap(set.Values))
+	for idx := range set.Weights {
+		set.Weights[idx] = 1
+	}
+}
+
+// KeepTimes starts keeping timestamps of values added to the set in Times,
+// and sets the timestamp (seconds since epoch) of values added next. Values
+// added before get the same timestamp.
+func (set *SampleSet) KeepTimes(timestamp int64) {
+	set.stamp = timestamp
+	if set.Times == nil {
+		set.Times = make([]int64, len(set.Values), cap(set.Values))
+		for idx := range set.Times {
+			set.Times[idx] = timestamp
+		}
+	}
+}
+
+// Coalesce adds the weight to the value added last, when it is equal to
+// the given value, so runs of identical values (e.g. a retry storm) are
+// stored as a single weighted value. Weights less than 1 are treated as 1.
+// Values are not coalesced once any value has been dropped, so MaxValues
+// limit applies as without coalescing, and when timestamps of values are
+// kept (see KeepTimes). Returns false when the value should be added as
+// usual.
+func (set *SampleSet) Coalesce(value, weight int) bool {
+	last := len(set.Values) - 1
+	if last < 0 || set.Dropped > 0 || set.Times != nil || set.Values[last] != value {
+		return false
+	}
+	if set.Weights == nil {
+		set.initWeights()
+	}
+	if weight < 1 {
+		weight = 1
+	}
+	set.Weights[last] += weight
+	set.Last = value
+	return true
+}
+
+// AddLimited appends the value with the given weight, unless there are
+// max values in the set already (max less than 1 means no limit). Then the
+// value is dropped, or, when sample is true, it replaces a random value
+// with probability keeping every received value equally likely to be in
+// the set (see Algorithm R: http://en.wikipedia.org/wiki/Reservoir_sampling).
+// Returns false when a value has been dropped or replaced.
+func (set *SampleSet) AddLimited(value, weight, max int, sample bool) bool {
+	if max < 1 || len(set.Values) < max {
+		set.AddWeighted(value, weight)
+		return true
+	}
+	set.Last = value
+	set.Dropped++
+	if !sample {
+		return false
+	}
+	if replace := rand.Intn(len(set.Values) + set.Dropped); replace < len(set.Values) {
+		set.Values[replace] = value
+		if weight > 1 && set.Weights == nil {
+			set.initWeights()
+		}
+		if set.Weights != nil {
+			if weight < 1 {
+				weight = 1
+			}
+			set.Weights[replace] = weight
+		}
+		if set.Times != nil {
+			set.Times[replace] = set.stamp
+		}
+	}
+	return false
+}
+
+// Shrink keeps a random sample of max values (with their weights and
+// timestamps), when there are more values in the set, so every value is
+// equally likely to be kept, as if they were added by AddLimited with
+// sampling. Removed values are counted as dropped, and the array of values
+// is reallocated to free memory. Returns the number of removed values.
+func (set *SampleSet) Shrink(max int) (removed int) {
+	count := len(set.Values)
+	if max < 1 || count <= max {
+		return
+	}
+	for idx := 0; idx < max; idx++ {
+		other := idx + rand.Intn(count-idx)
+		set.Values[idx], set.Values[other] = set.Values[other], set.Values[idx]
+		if set.Weights != nil {
+			set.Weights[idx], set.Weights[other] = set.Weights[other], set.Weights[idx]
+		}
+		if set.Times != nil {
+			set.Times[idx], set.Times[other] = set.Times[other], set.Times[idx]
+		}
+	}
+	values := make([]int, max)
+	copy(values, set.Values)
+	set.Values = values
+	if set.Weights != nil {
+		weights := make([]int, max)
+		copy(weights, set.Weights)
+		set.Weights = weights
+	}
+	if set.Times != nil {
+		times := make([]int64, max)
+		copy(times, set.Times)
+		set.Times = times
+	}
+	set.sorted = false
+	removed = count - max
+	set.Dropped += removed
+	return
+}
+
+// AddLatest replaces the value of the latest set (see config.KeepsLatest),
+// so no array of values is needed.
+func (set *SampleSet) AddLatest(value int) {
+	set.Latest = true
+	set.Last = value
+}
+
+// Header returns a copy of the sample set without values, which could be
+// kept after the sample set is recycled (see Timeline.Release).
+func (set *SampleSet) Header() *SampleSet {
+	return &SampleSet{Time: set.Time, Source: set.Source, Name: set.Name, Tags: set.Tags, Type: set.Type, Carried: set.Carried, Partial: set.Partial, LastSeen: set.LastSeen}
+}
+
+// reset removes values and metadata from the sample set, keeping the
+// array of values for reuse.
+func (set *SampleSet) reset() {
+	*set = SampleSet{Values: set.Values[:0], released: true}
+}
+
+// Accumulate adds the value with the given weight to the accumulated total.
+// Weights less than 1 are treated as 1. The total and the count saturate
+// instead of overflowing (see SaturatedAdd). It is safe to call Accumulate
+// concurrently on the same set.
+func (set *SampleSet) Accumulate(value, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	saturatedAddInt64(&set.Total, SaturatedMul(int64(value), int64(weight)))
+	saturatedAddInt64(&set.Count, int64(weight))
+}
+
+// Summarize adds the value with the given weight to the summary of the set
+// stored instead of values (created with the given sketch accuracy on first
+// use). Weights less than 1 are treated as 1.
+func (set *SampleSet) Summarize(value, weight int, accuracy float64) {
+	if weight < 1 {
+		weight = 1
+	}
+	if set.Summary == nil {
+		set.Summary = newSummary(accuracy)
+	}
+	set.Summary.add(value, weight)
+	set.Last = value
+}
+
+// Touch records the time of an event added to the set (seconds since
+// epoch), keeping the most recent one in LastSeen. It is safe to call Touch
+// concurrently on the same set.
+func (set *SampleSet) Touch(timestamp int64) {
+	for {
+		seen := atomic.AddInt64(&set.LastSeen, 0)
+		if timestamp <= seen || atomic.CompareAndSwapInt64(&set.LastSeen, seen, timestamp) {
+			return
+		}
+	}
+}
+
+// Weight returns the weight of the value with the given index.
+func (set *SampleSet) Weight(idx int) int {
+	if set.Weights == nil {
+		return 1
+	}
+	return set.Weights[idx]
+}
+
+// Len returns the number of values in the set.
+func (set *SampleSet) Len() int {
+	return len(set.Values)
+}
+
+// At returns the value with the given index. Values are in the order they
+// have been added, until the set is sorted (see Sorted). Values replaced by
+// sampling (see AddLimited) take positions of the replaced ones.
+func (set *SampleSet) At(idx int) int {
+	return set.Values[idx]
+}
+
+// DoIndexed calls function f for each value with its index and weight, in
+// the order of At. Writers needing positional access (e.g. first or last
+// values) should use DoIndexed or At instead of copying values.
+func (set *SampleSet) DoIndexed(f func(idx, value, weight int)) {
+	for idx, value := range set.Values {
+		f(idx, value, set.Weight(idx))
+	}
+}
+
+// Sorted returns a value indicating whether values have been sorted since
+// the last value was added, so they are not in the order they have been
+// added anymore. Writers share sample sets, so order-sensitive writers
+// should check it before relying on positions.
+func (set *SampleSet) Sorted() bool {
+	return set.sorted
+}
+
+// Sort sorts values in increasing order, keeping weights and timestamps
+// matching values. Writers should use Sort instead of sorting values
+// directly.
+func (set *SampleSet) Sort() {
+	set.sorted = true
+	if set.Weights == nil && set.Times == nil {
+		sort.Ints(set.Values)
+		return
+	}
+	sort.Sort((*weightedValues)(set))
+}
+
+// weightedValues attaches the methods of sort.Interface to SampleSet with
+// weights or timestamps, sorting values in increasing order.
+type weightedValues SampleSet
+
+func (p *weightedValues) Len() int           { return len(p.Values) }
+func (p *weightedValues) Less(i, j int) bool { return p.Values[i] < p.Values[j] }
+func (p *weightedValues) Swap(i, j int) {
+	p.Values[i], p.Values[j] = p.Values[j], p.Values[i]
+	if p.Weights != nil {
+		p.Weights[i], p.Weights[j] = p.Weights[j], p.Weights[i]
+	}
+	if p.Times != nil {
+		p.Times[i], p.Times[j] = p.Times[j], p.Times[i]
+	}
+}
+
+// SeriesName returns the name identifying the series of the sample set,
+// including tags (see SeriesName).
+func (set *SampleSet) SeriesName() string {
+	return SeriesName(set.Name, set.Tags)
 }
 
 func (set *SampleSet) Less(setToCompare *SampleSet) bool {
+	name, nameToCompare := set.SeriesName(), setToCompare.SeriesName()
 	return set.Source < setToCompare.Source ||
-		(set.Source == setToCompare.Source && set.Name < setToCompare.Name) ||
-		(set.Source == setToCompare.Source && set.Name == setToCompare.Name && set.Time < setToCompare.Time)
+		(set.Source == setToCompare.Source && name < nameToCompare) ||
+		(set.Source == setToCompare.Source && name == nameToCompare && set.Time < setToCompare.Time)
 }
 
 func (set *SampleSet) String() string {
 	return fmt.Sprintf(
 		"SampleSet[source=%s, name=%s, time=%d, size=%d]",
 		set.Source,
-		set.Name,
+		set.SeriesName(),
 		set.Time,
 		len(set.Values),
 	)
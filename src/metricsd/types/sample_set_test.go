@@ -9,6 +9,160 @@ type SampleSetS struct{}
 
 var _ = Suite(&SampleSetS{})
 
+func (s *SampleSetS) TestSampleSetsEqual(c *C) {
+	a := NewSampleSet(10, "src", "metric")
+	a.Add(10)
+	a.Add(20)
+	b := NewSampleSet(10, "src", "metric")
+	b.Add(20)
+	c.Check(SampleSetsEqual(a, b), Equals, false)
+	b.Add(10)
+	c.Check(SampleSetsEqual(a, b), Equals, true)
+	c.Check(a.Values, Equals, []int{10, 20})
+	c.Check(SampleSetsEqual(a, NewSampleSet(10, "src", "another")), Equals, false)
+	c.Check(SampleSetsEqual(nil, nil), Equals, true)
+}
+
+func (s *SampleSetS) TestAddWeighted(c *C) {
+	set := NewSampleSet(10, "src", "metric")
+	set.AddWeighted(10, 1)
+	c.Check(set.Weights, IsNil)
+	set.AddWeighted(20, 5)
+	set.Add(30)
+	c.Check(set.Values, Equals, []int{10, 20, 30})
+	c.Check(set.Weights, Equals, []int{1, 5, 1})
+	c.Check(set.Weight(1), Equals, 5)
+}
+
+func (s *SampleSetS) TestSortKeepsWeights(c *C) {
+	set := NewSampleSet(10, "src", "metric")
+	set.AddWeighted(30, 3)
+	set.AddWeighted(10, 1)
+	set.AddWeighted(20, 2)
+	set.Sort()
+	c.Check(set.Values, Equals, []int{10, 20, 30})
+	c.Check(set.Weights, Equals, []int{1, 2, 3})
+}
+
+func (s *SampleSetS) TestIndexedAccess(c *C) {
+	set := NewSampleSet(10, "src", "metric")
+	set.Add(30)
+	set.AddWeighted(10, 2)
+	set.Add(20)
+	c.Check(set.Len(), Equals, 3)
+	c.Check(set.At(0), Equals, 30)
+	c.Check(set.At(2), Equals, 20)
+
+	indices, values, weights := []int{}, []int{}, []int{}
+	set.DoIndexed(func(idx, value, weight int) {
+		indices = append(indices, idx)
+		values = append(values, value)
+		weights = append(weights, weight)
+	})
+	c.Check(indices, Equals, []int{0, 1, 2})
+	c.Check(values, Equals, []int{30, 10, 20})
+	c.Check(weights, Equals, []int{1, 2, 1})
+}
+
+func (s *SampleSetS) TestSorted(c *C) {
+	set := NewSampleSet(10, "src", "metric")
+	set.Add(30)
+	set.Add(10)
+	c.Check(set.Sorted(), Equals, false)
+	set.Sort()
+	c.Check(set.Sorted(), Equals, true)
+	c.Check(set.At(0), Equals, 10)
+	set.Add(20)
+	c.Check(set.Sorted(), Equals, false)
+}
+
+func (s *SampleSetS) TestAddLimitedDrop(c *C) {
+	set := NewSampleSet(10, "src", "metric")
+	c.Check(set.AddLimited(10, 1, 2, false), Equals, true)
+	c.Check(set.AddLimited(20, 1, 2, false), Equals, true)
+	c.Check(set.AddLimited(30, 1, 2, false), Equals, false)
+	c.Check(set.Values, Equals, []int{10, 20})
+	c.Check(set.Dropped, Equals, 1)
+}
+
+func (s *SampleSetS) TestAddLimitedSample(c *C) {
+	set := NewSampleSet(10, "src", "metric")
+	for i := 0; i < 1000; i++ {
+		set.AddLimited(i, 1, 10, true)
+	}
+	c.Check(len(set.Values), Equals, 10)
+	c.Check(set.Dropped, Equals, 990)
+	// Values received later should be sampled as well
+	late := 0
+	for _, value := range set.Values {
+		if value >= 10 {
+			late++
+		}
+	}
+	c.Check(late > 0, Equals, true)
+}
+
+func (s *SampleSetS) TestAddLimitedWithoutLimit(c *C) {
+	set := NewSampleSet(10, "src", "metric")
+	for i := 0; i < 100; i++ {
+		c.Check(set.AddLimited(i, 1, 0, false), Equals, true)
+	}
+	c.Check(len(set.Values), Equals, 100)
+}
+
+func (s *SampleSetS) TestCoalesce(c *C) {
+	set := NewSampleSet(10, "src", "metric")
+	c.Check(set.Coalesce(5, 1), Equals, false)
+	set.Add(5)
+	c.Check(set.Coalesce(5, 1), Equals, true)
+	c.Check(set.Coalesce(5, 3), Equals, true)
+	c.Check(set.Coalesce(7, 1), Equals, false)
+	set.Add(7)
+	c.Check(set.Values, Equals, []int{5, 7})
+	c.Check(set.Weights, Equals, []int{5, 1})
+
+	// Limits apply once values are dropped
+	set.Dropped = 1
+	c.Check(set.Coalesce(7, 1), Equals, false)
+}
+
+func (s *SampleSetS) TestShrink(c *C) {
+	set := NewSampleSet(10, "src", "metric")
+	for i := 0; i < 100; i++ {
+		set.AddWeighted(i, i+1)
+	}
+	c.Check(set.Shrink(10), Equals, 90)
+	c.Check(len(set.Values), Equals, 10)
+	c.Check(cap(set.Values), Equals, 10)
+	c.Check(set.Dropped, Equals, 90)
+	// Weights follow their values
+	for idx, value := range set.Values {
+		c.Check(set.Weights[idx], Equals, value+1)
+	}
+	c.Check(set.Shrink(10), Equals, 0)
+	c.Check(set.Shrink(0), Equals, 0)
+}
+
+func (s *SampleSetS) TestKeepTimes(c *C) {
+	set := NewSampleSet(10, "src", "metric")
+	set.Add(30)
+	c.Check(set.Times, IsNil)
+	set.KeepTimes(12)
+	set.AddWeighted(10, 2)
+	set.KeepTimes(15)
+	set.Add(20)
+	c.Check(set.Times, Equals, []int64{12, 12, 15})
+	c.Check(set.Coalesce(20, 1), Equals, false)
+
+	set.Sort()
+	c.Check(set.Values, Equals, []int{10, 20, 30})
+	c.Check(set.Weights, Equals, []int{2, 1, 1})
+	c.Check(set.Times, Equals, []int64{12, 15, 12})
+
+	set.Shrink(2)
+	c.Check(len(set.Times), Equals, 2)
+}
+
 func BenchmarkSampleSetAdd(b *testing.B) {
 	b.StopTimer()
 	ss := NewSampleSet(10, "src", "metric")
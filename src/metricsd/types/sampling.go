@@ -0,0 +1,71 @@
+package types
+
+import (
+	"math"
+	"strings"
+	"sync/atomic"
+	"time"
+	"metricsd/config"
+)
+
+var (
+	// State of the xorshift generator sampling events (see config.SampleRate),
+	// shared by all timelines without locking
+	samplingState = uint64(time.Nanoseconds()) | 1
+)
+
+// sampled returns a value indicating whether the event should be kept when
+// events are sampled with the given rate (see config.SampleRate). Own stats
+// of MetricsD ("metricsd.*" metrics) are always kept.
+func sampled(event *Event, rate float64) bool {
+	if rate >= 1 || strings.HasPrefix(event.Name, "metricsd.") {
+		return true
+	}
+	return randomFraction() < rate
+}
+
+// randomFraction returns a pseudo-random number in [0, 1) generated by
+// xorshift64, which is fast enough to be called for every event.
+func randomFraction() float64 {
+	for {
+		old := atomic.AddUint64(&samplingState, 0)
+		next := old
+		next ^= next << 13
+		next ^= next >> 7
+		next ^= next << 17
+		if atomic.CompareAndSwapUint64(&samplingState, old, next) {
+			return float64(next>>11) / (1 << 53)
+		}
+	}
+}
+
+// scaleSampled returns the copy of the counter event kept by sampling with
+// values scaled by 1/rate, so totals of counters stay approximately right.
+// Other events are returned as is.
+func scaleSampled(event *Event, rate float64) *Event {
+	if rate >= 1 || event.Type != config.METRIC_TYPE_COUNTER {
+		return event
+	}
+	scaled := *event
+	scaled.Value = scaleValue(event.Value, rate)
+	if len(event.Values) > 0 {
+		scaled.Values = make([]int, len(event.Values))
+		for idx, value := range event.Values {
+			scaled.Values[idx] = scaleValue(value, rate)
+		}
+	}
+	return &scaled
+}
+
+// scaleValue returns the value divided by the rate, rounded to the nearest
+// integer, and saturated at integer limits.
+func scaleValue(value int, rate float64) int {
+	scaled := math.Floor(float64(value)/rate + 0.5)
+	switch {
+	case scaled > math.MaxInt32:
+		return math.MaxInt32
+	case scaled < math.MinInt32:
+		return math.MinInt32
+	}
+	return int(scaled)
+}
@@ -0,0 +1,46 @@
+package types
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// SaturatedAdd returns the sum of a and b, or the nearest limit of int64
+// when the sum overflows: a busy interval should be reported as the
+// largest value, not wrap to a huge negative spike.
+func SaturatedAdd(a, b int64) int64 {
+	switch {
+	case b > 0 && a > math.MaxInt64-b:
+		return math.MaxInt64
+	case b < 0 && a < math.MinInt64-b:
+		return math.MinInt64
+	}
+	return a + b
+}
+
+// SaturatedMul returns the product of a and b, or the nearest limit of
+// int64 when the product overflows (see SaturatedAdd).
+func SaturatedMul(a, b int64) int64 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	product := a * b
+	if product/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		if (a > 0) == (b > 0) {
+			return math.MaxInt64
+		}
+		return math.MinInt64
+	}
+	return product
+}
+
+// saturatedAddInt64 atomically adds delta to *addr, saturating at limits
+// of int64 (see SaturatedAdd).
+func saturatedAddInt64(addr *int64, delta int64) {
+	for {
+		old := atomic.AddInt64(addr, 0)
+		if atomic.CompareAndSwapInt64(addr, old, SaturatedAdd(old, delta)) {
+			return
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package types
+
+import (
+	. "launchpad.net/gocheck"
+	"math"
+)
+
+type SaturateS struct{}
+
+var _ = Suite(&SaturateS{})
+
+func (s *SaturateS) TestSaturatedAdd(c *C) {
+	c.Check(SaturatedAdd(2, 3), Equals, int64(5))
+	c.Check(SaturatedAdd(-2, -3), Equals, int64(-5))
+	c.Check(SaturatedAdd(math.MaxInt64-1, 1), Equals, int64(math.MaxInt64))
+	c.Check(SaturatedAdd(math.MaxInt64-1, 2), Equals, int64(math.MaxInt64))
+	c.Check(SaturatedAdd(math.MinInt64+1, -2), Equals, int64(math.MinInt64))
+	c.Check(SaturatedAdd(math.MaxInt64, math.MinInt64), Equals, int64(-1))
+}
+
+func (s *SaturateS) TestSaturatedMul(c *C) {
+	c.Check(SaturatedMul(6, -7), Equals, int64(-42))
+	c.Check(SaturatedMul(0, math.MaxInt64), Equals, int64(0))
+	c.Check(SaturatedMul(math.MaxInt64/2, 3), Equals, int64(math.MaxInt64))
+	c.Check(SaturatedMul(math.MaxInt64/2, -3), Equals, int64(math.MinInt64))
+	c.Check(SaturatedMul(-1, math.MinInt64), Equals, int64(math.MaxInt64))
+	c.Check(SaturatedMul(math.MinInt64, -1), Equals, int64(math.MaxInt64))
+	c.Check(SaturatedMul(math.MinInt64, 1), Equals, int64(math.MinInt64))
+}
+
+func (s *SaturateS) TestAccumulateSaturates(c *C) {
+	set := NewSampleSet(10, "src", "metric")
+	set.Accumulate(math.MaxInt32, math.MaxInt32)
+	for i := 0; i < 4; i++ {
+		set.Accumulate(math.MaxInt32, math.MaxInt32)
+	}
+	c.Check(set.Total, Equals, int64(math.MaxInt64))
+	c.Check(set.Count, Equals, int64(5*math.MaxInt32))
+	set.Total = math.MaxInt64 - 1
+	set.Accumulate(10, 1)
+	c.Check(set.Total, Equals, int64(math.MaxInt64))
+}
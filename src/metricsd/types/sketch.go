@@ -0,0 +1,141 @@
+package types
+
+import (
+	"math"
+)
+
+// A Sketch counts values in buckets with bounds growing as powers of gamma,
+// so quantiles could be estimated with bounded relative error (see
+// DDSketch: http://arxiv.org/abs/1908.10693), which stays the same for
+// values of any magnitude. Bucket with index i holds values in
+// (gamma^(i-1), gamma^i], negative values are counted by their absolute
+// value in a separate store. Memory depends on the range of values, not on
+// their number.
+type Sketch struct {
+	Accuracy float64     // relative accuracy of estimated quantiles (e.g. 0.01 means 1%)
+	Positive SketchStore // buckets of positive values
+	Negative SketchStore // buckets of absolute values of negative values
+	Zeros    int64       // number of zeros
+	Count    int64       // number of values
+	gamma    float64     // derived from Accuracy on first use (see init)
+	logGamma float64
+}
+
+// SketchStore holds counts of values in consecutive buckets of a Sketch.
+type SketchStore struct {
+	Offset int     // index of the first bucket
+	Counts []int64 // counts of values per bucket, starting with Offset
+}
+
+// NewSketch returns an empty sketch with the given relative accuracy.
+func NewSketch(accuracy float64) *Sketch {
+	sketch := &Sketch{Accuracy: accuracy}
+	sketch.init()
+	return sketch
+}
+
+// init calculates gamma of the sketch, when it has not been calculated yet
+// (e.g. the sketch has been restored from a snapshot).
+func (sketch *Sketch) init() {
+	if sketch.gamma == 0 {
+		sketch.gamma = (1 + sketch.Accuracy) / (1 - sketch.Accuracy)
+		sketch.logGamma = math.Log(sketch.gamma)
+	}
+}
+
+// Add counts the value weight times.
+func (sketch *Sketch) Add(value, weight int) {
+	sketch.init()
+	switch {
+	case value > 0:
+		sketch.Positive.add(sketch.index(float64(value)), int64(weight))
+	case value < 0:
+		sketch.Negative.add(sketch.index(-float64(value)), int64(weight))
+	default:
+		sketch.Zeros += int64(weight)
+	}
+	sketch.Count += int64(weight)
+}
+
+// Merge adds all values counted by the other sketch, which should have the
+// same accuracy.
+func (sketch *Sketch) Merge(other *Sketch) {
+	for idx, count := range other.Positive.Counts {
+		sketch.Positive.add(other.Positive.Offset+idx, count)
+	}
+	for idx, count := range other.Negative.Counts {
+		sketch.Negative.add(other.Negative.Offset+idx, count)
+	}
+	sketch.Zeros += other.Zeros
+	sketch.Count += other.Count
+}
+
+// Copy returns a deep copy of the sketch.
+func (sketch *Sketch) Copy() *Sketch {
+	copied := NewSketch(sketch.Accuracy)
+	copied.Merge(sketch)
+	return copied
+}
+
+// index returns index of the bucket holding the given positive value.
+func (sketch *Sketch) index(value float64) int {
+	return int(math.Ceil(math.Log(value) / sketch.logGamma))
+}
+
+// value returns the estimate of values in the bucket with the given index,
+// which is within the relative accuracy from any of them.
+func (sketch *Sketch) value(index int) float64 {
+	return 2 * math.Pow(sketch.gamma, float64(index)) / (sketch.gamma + 1)
+}
+
+// Quantile returns the estimate of the q-th quantile using the nearest
+// rank method.
+func (sketch *Sketch) Quantile(q float64) float64 {
+	sketch.init()
+	rank := int64(math.Ceil(q * float64(sketch.Count)))
+	if rank < 1 {
+		rank = 1
+	}
+
+	// Negative values, from the largest absolute value
+	negative := sketch.Negative
+	for idx := len(negative.Counts) - 1; idx >= 0; idx-- {
+		if rank -= negative.Counts[idx]; rank <= 0 {
+			return -sketch.value(negative.Offset + idx)
+		}
+	}
+	if rank -= sketch.Zeros; rank <= 0 {
+		return 0
+	}
+	positive := sketch.Positive
+	for idx, count := range positive.Counts {
+		if rank -= count; rank <= 0 {
+			return sketch.value(positive.Offset + idx)
+		}
+	}
+	// Could happen only for quantiles greater than 1
+	if len(positive.Counts) == 0 {
+		return 0
+	}
+	return sketch.value(positive.Offset + len(positive.Counts) - 1)
+}
+
+// add counts the weight in the bucket with the given index, growing the
+// store in either direction when needed.
+func (store *SketchStore) add(index int, weight int64) {
+	switch {
+	case len(store.Counts) == 0:
+		store.Offset = index
+		store.Counts = []int64{0}
+	case index < store.Offset:
+		counts := make([]int64, store.Offset-index+len(store.Counts))
+		copy(counts[store.Offset-index:], store.Counts)
+		store.Offset = index
+		store.Counts = counts
+	case index >= store.Offset+len(store.Counts):
+		for index >= store.Offset+len(store.Counts) {
+			store.Counts = append(store.Counts, 0)
+		}
+	}
+	store.Counts[index-store.Offset] += weight
+}
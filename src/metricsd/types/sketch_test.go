@@ -0,0 +1,62 @@
+package types
+
+import (
+	"math"
+	. "launchpad.net/gocheck"
+)
+
+type SketchS struct{}
+
+var _ = Suite(&SketchS{})
+
+func (s *SketchS) TestQuantile(c *C) {
+	sketch := NewSketch(0.01)
+	for value := 1000; value >= -1000; value-- {
+		sketch.Add(value, 1)
+	}
+	c.Check(sketch.Count, Equals, int64(2001))
+	c.Check(sketch.Zeros, Equals, int64(1))
+	for q, expected := range map[float64]float64{0.01: -980, 0.5: 0, 0.75: 500, 1: 1000} {
+		if estimated := sketch.Quantile(q); math.Fabs(estimated-expected) > math.Fabs(expected)*0.01 {
+			c.Errorf("Quantile %v: expected %v, got %v", q, expected, estimated)
+		}
+	}
+}
+
+func (s *SketchS) TestMerge(c *C) {
+	a, b := NewSketch(0.01), NewSketch(0.01)
+	a.Add(100, 2)
+	b.Add(1, 1)
+	b.Add(10000, 1)
+	a.Merge(b)
+	c.Check(a.Count, Equals, int64(4))
+	c.Check(a.Positive.Counts[0], Equals, int64(1))
+	c.Check(a.Positive.Counts[len(a.Positive.Counts)-1], Equals, int64(1))
+	c.Check(math.Fabs(a.Quantile(0.5)-100) <= 1, Equals, true)
+
+	copied := a.Copy()
+	copied.Add(100, 1)
+	c.Check(a.Count, Equals, int64(4))
+	c.Check(copied.Count, Equals, int64(5))
+}
+
+func (s *SketchS) TestSummarize(c *C) {
+	set := &SampleSet{Time: 10, Source: "src", Name: "metric"}
+	set.Summarize(30, 1, 0.01)
+	set.Summarize(-10, 2, 0.01)
+	set.Summarize(20, 0, 0.01)
+	c.Check(set.Summary.Count, Equals, int64(4))
+	c.Check(set.Summary.Total, Equals, int64(30))
+	c.Check(set.Summary.Min, Equals, -10)
+	c.Check(set.Summary.Max, Equals, 30)
+	c.Check(set.Summary.Mean(), Equals, 7.5)
+	c.Check(set.Last, Equals, 20)
+
+	other := &SampleSet{Time: 10, Source: "src", Name: "metric"}
+	other.Summarize(50, 1, 0.01)
+	set.merge(other)
+	c.Check(set.Summary.Count, Equals, int64(5))
+	c.Check(set.Summary.Max, Equals, 50)
+	c.Check(set.Summary.Quantile(0), Equals, -10.0)
+	c.Check(other.Summary.Count, Equals, int64(1))
+}
@@ -2,11 +2,28 @@ package types
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"metricsd/config"
+)
+
+// Tag added to series of partial sample sets (see config.PartialPolicy).
+const PARTIAL_TAG = "partial"
+
+var (
+	// Number of values coalesced into identical values added last (see
+	// SampleSet.Coalesce), reset by stats reporting
+	CoalescedValues int64
 )
 
 type Slice struct {
-	Time int64
-	Sets map[string]*SampleSet
+	Time     int64
+	Sets     map[string]*SampleSet
+	seen     map[string]bool // events added to the slice (see markSeen)
+	pool     *pool           // pool of recycled sample sets, nil if not recycling
+	released bool            // slice has been put into a pool (see pool)
 }
 
 func NewSlice(time int64) *Slice {
@@ -20,29 +37,194 @@ func (slice *Slice) Less(sliceToCompare *Slice) bool {
 	return slice.Time < sliceToCompare.Time
 }
 
-func (slice *Slice) Add(event *Event) {
-	slice.getSampleSet(event.Source, event.Name).Add(event.Value)
+// Add appends the event received now to the slice (see AddAt).
+func (slice *Slice) Add(event *Event) (dropped int) {
+	return slice.AddAt(event, Clock())
+}
+
+// AddAt appends the event value (all values of vector events) to the sample
+// sets of the event source and "all" source, enforcing MaxValues limit of
+// the metric. Values of accumulated counters are summed instead (see
+// config.Accumulates), only the latest values of latest gauges are kept
+// (see config.KeepsLatest), and values of summarized metrics are added to
+// summaries (see config.Summarizes). Declared metric type is stored in
+// sample sets (the last declared type wins), along with the most recent
+// event timestamp (see SampleSet.Touch). Returns number of values dropped
+// because of the limit.
+func (slice *Slice) AddAt(event *Event, timestamp int64) (dropped int) {
+	options := config.MetricOptions(event.Name)
+	accumulate := config.Accumulates(event.Name, event.Type, event.Tags)
+	latest := config.KeepsLatest(event.Name, event.Type, event.Tags)
+	summarize := config.Summarizes(event.Name, event.Type, event.Tags)
+	dropped = addValues(slice.getSampleSet(event.Source, event.Name, event.Tags, latest || summarize), event, timestamp, options, accumulate, latest, summarize)
 	if event.Source != "all" {
-		slice.getSampleSet("all", event.Name).Add(event.Value)
+		dropped += addValues(slice.getSampleSet("all", event.Name, event.Tags, latest || summarize), event, timestamp, options, accumulate, latest, summarize)
 	}
+	return
 }
 
+// addValues adds the event value, and further values of vector events, to
+// the sample set (see addToSampleSet). Returns number of dropped values.
+func addValues(set *SampleSet, event *Event, timestamp int64, options *config.MetricConfig, accumulate, latest, summarize bool) (dropped int) {
+	if !addToSampleSet(set, event, event.Value, timestamp, options, accumulate, latest, summarize) {
+		dropped++
+	}
+	for _, value := range event.Values {
+		if !addToSampleSet(set, event, value, timestamp, options, accumulate, latest, summarize) {
+			dropped++
+		}
+	}
+	return
+}
+
+// addToSampleSet appends (accumulates, keeps as the latest, or summarizes)
+// the value of the event to the sample set, returns false when a value has
+// been dropped because of MaxValues limit (or the limit of memory pressure
+// mode, see SetPressureLimit). Values of metrics with Coalesce option
+// identical to the value added last are coalesced into it. Timestamps of
+// values of metrics with HalfLife option are kept (see
+// SampleSet.KeepTimes).
+func addToSampleSet(set *SampleSet, event *Event, value int, timestamp int64, options *config.MetricConfig, accumulate, latest, summarize bool) bool {
+	set.Touch(timestamp)
+	if event.Type != "" {
+		set.Type = event.Type
+	}
+	if accumulate {
+		set.Accumulated = true
+		set.Accumulate(value, event.Weight)
+		return true
+	}
+	if latest {
+		set.AddLatest(value)
+		return true
+	}
+	if summarize {
+		set.Summarize(value, event.Weight, config.SketchAccuracy)
+		return true
+	}
+	if options.HalfLife > 0 {
+		set.KeepTimes(timestamp)
+	}
+	if options.Coalesce && set.Coalesce(value, event.Weight) {
+		atomic.AddInt64(&CoalescedValues, 1)
+		return true
+	}
+	max, sample := options.MaxValues, options.Overflow == config.OVERFLOW_POLICY_SAMPLE
+	if limit := PressureLimit(); limit > 0 && (max < 1 || limit < max) {
+		max, sample = limit, true
+	}
+	return set.AddLimited(value, event.Weight, max, sample)
+}
+
+// accumulate adds the event value received at the given time to the
+// existing accumulated sample sets of the event source and "all" source.
+// It does not modify the slice, so the timeline could be locked for reading
+// only. Returns false when sample sets have to be created first (see Add).
+func (slice *Slice) accumulate(event *Event, timestamp int64) bool {
+	name := SeriesKey(event.Name, event.Tags)
+	set, found := slice.Sets[slice.getSampleSetKey(event.Source, name)]
+	if !found || !set.Accumulated {
+		return false
+	}
+	if event.Source != "all" {
+		all, found := slice.Sets[slice.getSampleSetKey("all", name)]
+		if !found || !all.Accumulated {
+			return false
+		}
+		accumulateValues(all, event)
+		all.Touch(timestamp)
+	}
+	accumulateValues(set, event)
+	set.Touch(timestamp)
+	return true
+}
+
+// accumulateValues adds the event value, and further values of vector
+// events, to the accumulated sample set.
+func accumulateValues(set *SampleSet, event *Event) {
+	set.Accumulate(event.Value, event.Weight)
+	for _, value := range event.Values {
+		set.Accumulate(value, event.Weight)
+	}
+}
+
+// markPartial marks all sample sets of the slice as partial, adding
+// "partial" tag to their series.
+func (slice *Slice) markPartial() {
+	for _, set := range slice.Sets {
+		tags := Tags{PARTIAL_TAG: "true"}
+		for key, value := range set.Tags {
+			tags[key] = value
+		}
+		set.Tags = tags
+		set.Partial = true
+	}
+}
+
+// String returns a string representation of the slice, listing all sample
+// sets (sorted by key) with number of values in them.
 func (slice *Slice) String() string {
+	keys := make([]string, 0, len(slice.Sets))
+	for key := range slice.Sets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	sets := make([]string, len(keys))
+	for idx, key := range keys {
+		sets[idx] = fmt.Sprintf("%s:%d", key, len(slice.Sets[key].Values))
+	}
 	return fmt.Sprintf(
-		"Slice[time=%d, size=%d]",
+		"Slice[time=%d, size=%d, sets=[%s]]",
 		slice.Time,
 		len(slice.Sets),
+		strings.Join(sets, ", "),
 	)
 }
 
-func (slice *Slice) getSampleSet(source, name string) *SampleSet {
-	key := slice.getSampleSetKey(source, name)
+// markSeen remembers the event taken at the given time, and returns false
+// when the same event has been seen in the slice already. Seen events are
+// forgotten along with the slice when it is extracted.
+func (slice *Slice) markSeen(event *Event, timestamp int64) bool {
+	if slice.seen == nil {
+		slice.seen = make(map[string]bool)
+	}
+	key := slice.getSampleSetKey(event.Source, SeriesKey(event.Name, event.Tags)) + " " + strconv.Itoa64(timestamp) + " " + strconv.Itoa(event.Value)
+	for _, value := range event.Values {
+		key += "," + strconv.Itoa(value)
+	}
+	if slice.seen[key] {
+		return false
+	}
+	slice.seen[key] = true
+	return true
+}
+
+// getSampleSet creates (if necessary) and returns the sample set of the
+// source and metric. Names of new sample sets share the interned key (see
+// interner), instead of referencing the event. New sets not storing values
+// (latest and summarized sets) are created without an array of values.
+func (slice *Slice) getSampleSet(source, name string, tags Tags, bare bool) *SampleSet {
+	key := slice.getSampleSetKey(source, SeriesKey(name, tags))
 	if _, found := slice.Sets[key]; !found {
-		slice.Sets[key] = NewSampleSet(slice.Time, source, name)
+		// The key is "<source>-<name>[;<tags>]"
+		source, name = key[:len(source)], key[len(source)+1:len(source)+1+len(name)]
+		var set *SampleSet
+		if slice.pool != nil {
+			set = slice.pool.getSampleSet(slice.Time, source, name)
+		} else if bare {
+			set = &SampleSet{Time: slice.Time, Source: source, Name: name}
+		} else {
+			set = NewSampleSet(slice.Time, source, name)
+		}
+		set.Tags = tags
+		slice.Sets[key] = set
 	}
 	return slice.Sets[key]
 }
 
+// getSampleSetKey returns the interned key of the sample set of the source
+// and series key in Sets.
 func (slice *Slice) getSampleSetKey(source, name string) string {
-	return source + "-" + name
+	return sampleSetKeys.key(source, name)
 }
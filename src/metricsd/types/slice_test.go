@@ -25,6 +25,68 @@ func (s *SliceS) TestGetMachineSampleSetKey(c *C) {
 	c.Check(key, Equals, "src-metric")
 }
 
+func (s *SliceS) TestString(c *C) {
+	s.slice.Add(NewEvent("src", "metric", 10))
+	s.slice.Add(NewEvent("src", "metric", 20))
+	s.slice.Add(NewEvent("all", "another", 30))
+	c.Check(s.slice.String(), Equals, "Slice[time=10, size=3, sets=[all-another:1, all-metric:2, src-metric:2]]")
+}
+
+func (s *SliceS) TestAddTaggedEvents(c *C) {
+	event := NewEvent("src", "metric", 10)
+	event.Tags = Tags{"status": "200", "region": "eu"}
+	s.slice.Add(event)
+	s.slice.Add(NewEvent("src", "metric", 20))
+	c.Check(s.slice.String(), Equals, "Slice[time=10, size=4, sets=[all-metric:1, all-metric;region=eu;status=200:1, src-metric:1, src-metric;region=eu;status=200:1]]")
+	set := s.slice.Sets["src-metric;region=eu;status=200"]
+	c.Check(set.Name, Equals, "metric")
+	c.Check(set.Tags, Equals, event.Tags)
+}
+
+func (s *SliceS) TestAddReorderedTags(c *C) {
+	first := NewEvent("src", "metric", 10)
+	first.Tags = Tags{}
+	first.Tags["a"] = "1"
+	first.Tags["b"] = "2"
+	second := NewEvent("src", "metric", 20)
+	second.Tags = Tags{}
+	second.Tags["b"] = "2"
+	second.Tags["a"] = "1"
+	s.slice.Add(first)
+	s.slice.Add(second)
+	c.Check(len(s.slice.Sets), Equals, 2)
+	c.Check(s.slice.Sets["src-metric;a=1;b=2"].Values, Equals, []int{10, 20})
+}
+
+func (s *SliceS) TestAddTagsWithSeparators(c *C) {
+	first := NewEvent("src", "metric", 10)
+	first.Tags = Tags{"a": "1;b=2"}
+	second := NewEvent("src", "metric", 20)
+	second.Tags = Tags{"a": "1", "b": "2"}
+	s.slice.Add(first)
+	s.slice.Add(second)
+	c.Check(len(s.slice.Sets), Equals, 4)
+	c.Check(s.slice.Sets[`src-metric;a=1\;b\=2`].Values, Equals, []int{10})
+	c.Check(s.slice.Sets["src-metric;a=1;b=2"].Values, Equals, []int{20})
+}
+
+func (s *SliceS) TestSeriesKey(c *C) {
+	c.Check(SeriesKey("metric", nil), Equals, "metric")
+	c.Check(SeriesKey("metric", Tags{"z": "1", "a": `x\y`}), Equals, `metric;a=x\\y;z=1`)
+}
+
+func (s *SliceS) TestSlicesEqual(c *C) {
+	s.slice.Add(NewEvent("src", "metric", 10))
+	s.slice.Add(NewEvent("src", "metric", 20))
+	another := NewSlice(10)
+	another.Add(NewEvent("src", "metric", 20))
+	c.Check(SlicesEqual(s.slice, another), Equals, false)
+	another.Add(NewEvent("src", "metric", 10))
+	c.Check(SlicesEqual(s.slice, another), Equals, true)
+	c.Check(SlicesEqual(s.slice, NewSlice(20)), Equals, false)
+	c.Check(SlicesEqual(s.slice, nil), Equals, false)
+}
+
 func BenchmarkSliceAdd(b *testing.B) {
 	b.StopTimer()
 	ss := NewSlice(10)
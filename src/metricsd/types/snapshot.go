@@ -0,0 +1,280 @@
+package types
+
+import (
+	"fmt"
+	"gob"
+	"io"
+	"json"
+	"os"
+	"sort"
+	"sync/atomic"
+)
+
+// A TimelineSnapshot is a copy of all open slices of a timeline, taken at
+// the given time.
+type TimelineSnapshot struct {
+	Time      int64               // time the snapshot was taken at (seconds since epoch)
+	Interval  int64               // slice interval in seconds
+	Prefix    string              // prefix of metric names of the timeline (see config.Timelines), empty for other timelines
+	Slices    []*Slice            // open slices, sorted by time
+	Timelines []*TimelineSnapshot // snapshots of other timelines taken along (see writers.Router.Snapshot)
+}
+
+// Snapshot returns a consistent copy of all open slices (merged with slices
+// of further shards, see NewShardedTimeline). Timeline is locked only while
+// slices are copied, so serializing the snapshot does not delay ingestion.
+func (timeline *Timeline) Snapshot() *TimelineSnapshot {
+	copies := make(map[int64]*Slice)
+	timeline.mutex.RLock()
+	for number, slice := range timeline.Slices {
+		copies[number] = slice.copy()
+	}
+	timeline.mutex.RUnlock()
+	timeline.copyShards(copies, func(number int64) bool {
+		return true
+	})
+
+	slices := make([]*Slice, 0, len(copies))
+	for _, slice := range copies {
+		slices = append(slices, slice)
+	}
+	SortSlices(slices)
+	return &TimelineSnapshot{Time: Clock(), Interval: timeline.Interval, Slices: slices}
+}
+
+// SnapshotClosed returns a copy of the most recent closed slice (the one
+// before the current slice), which could be extracted already. The copy is
+// made once per slice interval and shared by all callers, so it should not
+// be modified. Empty slice is returned when no events were received.
+func (timeline *Timeline) SnapshotClosed() *Slice {
+	number := timeline.getCurrentSliceNumber() - 1
+	sliceTime := number * timeline.Interval
+
+	timeline.closedMutex.Lock()
+	defer timeline.closedMutex.Unlock()
+	if timeline.closed != nil && timeline.closed.Time == sliceTime {
+		return timeline.closed
+	}
+
+	slice, found := timeline.copySlice(number)
+	if !found {
+		if timeline.lastExtracted != nil && timeline.lastExtracted.Time == sliceTime {
+			slice = timeline.lastExtracted
+		} else {
+			slice = NewSlice(sliceTime)
+		}
+	}
+	timeline.closed = slice
+	return slice
+}
+
+// SnapshotCurrent returns a copy of the current slice, which is still
+// receiving events, so its sample sets are marked as partial (see
+// Slice.markPartial). Timeline is locked for reading only while the slice
+// is copied. Empty slice is returned when no events were received in the
+// current slice interval.
+func (timeline *Timeline) SnapshotCurrent() *Slice {
+	number := timeline.getCurrentSliceNumber()
+	slice, found := timeline.copySlice(number)
+	if !found {
+		return NewSlice(number * timeline.Interval)
+	}
+	slice.markPartial()
+	return slice
+}
+
+// copySlice returns a copy of the slice with the given number, merged with
+// slices of further shards (see NewShardedTimeline), or false when there
+// is no such slice in any shard.
+func (timeline *Timeline) copySlice(number int64) (*Slice, bool) {
+	copies := make(map[int64]*Slice, 1)
+	timeline.mutex.RLock()
+	if slice, found := timeline.Slices[number]; found {
+		copies[number] = slice.copy()
+	}
+	timeline.mutex.RUnlock()
+	timeline.copyShards(copies, func(other int64) bool {
+		return other == number
+	})
+	slice, found := copies[number]
+	return slice, found
+}
+
+// A SnapshotCodec serializes timeline snapshots in a particular format.
+type SnapshotCodec interface {
+	Encode(w io.Writer, snapshot *TimelineSnapshot) os.Error
+	Decode(r io.Reader) (*TimelineSnapshot, os.Error)
+}
+
+// snapshotCodecs holds all known snapshot codecs, keyed by format name
+// (used as the snapshot file extension as well).
+var snapshotCodecs = map[string]SnapshotCodec{
+	"json": jsonSnapshotCodec{},
+	"gob":  gobSnapshotCodec{},
+}
+
+// GetSnapshotCodec returns the snapshot codec for the given format.
+func GetSnapshotCodec(format string) (codec SnapshotCodec, err os.Error) {
+	codec, found := snapshotCodecs[format]
+	if !found {
+		formats := make([]string, 0, len(snapshotCodecs))
+		for name := range snapshotCodecs {
+			formats = append(formats, name)
+		}
+		sort.Strings(formats)
+		err = os.NewError(fmt.Sprintf("Unknown snapshot format %q, available formats: %v", format, formats))
+	}
+	return
+}
+
+// jsonSnapshotCodec serializes snapshots to JSON, which is readable, but
+// slow and large.
+type jsonSnapshotCodec struct{}
+
+func (jsonSnapshotCodec) Encode(w io.Writer, snapshot *TimelineSnapshot) os.Error {
+	return json.NewEncoder(w).Encode(snapshot)
+}
+
+func (jsonSnapshotCodec) Decode(r io.Reader) (snapshot *TimelineSnapshot, err os.Error) {
+	snapshot = &TimelineSnapshot{}
+	err = json.NewDecoder(r).Decode(snapshot)
+	return
+}
+
+// gobSnapshotCodec serializes snapshots to gob, which is compact and fast.
+type gobSnapshotCodec struct{}
+
+func (gobSnapshotCodec) Encode(w io.Writer, snapshot *TimelineSnapshot) os.Error {
+	return gob.NewEncoder(w).Encode(snapshot)
+}
+
+func (gobSnapshotCodec) Decode(r io.Reader) (snapshot *TimelineSnapshot, err os.Error) {
+	snapshot = &TimelineSnapshot{}
+	err = gob.NewDecoder(r).Decode(snapshot)
+	return
+}
+
+// Write serializes the snapshot using the given codec.
+func (snapshot *TimelineSnapshot) Write(w io.Writer, codec SnapshotCodec) os.Error {
+	return codec.Encode(w, snapshot)
+}
+
+// Restore reads a snapshot using the given codec, and adds its slices to
+// the timeline (see RestoreSnapshot). Returns the number of restored
+// slices.
+func (timeline *Timeline) Restore(r io.Reader, codec SnapshotCodec) (restored int, err os.Error) {
+	snapshot, err := codec.Decode(r)
+	if err != nil {
+		return
+	}
+	return timeline.RestoreSnapshot(snapshot)
+}
+
+// RestoreSnapshot adds slices of the snapshot to the timeline, so intervals
+// open when the snapshot was taken are written as if there was no restart.
+// Slices which already exist in the timeline (e.g. events have been
+// received before the restore) are merged. Slices closed since the snapshot
+// was taken are written at the next write. Snapshots of other timelines
+// are not restored. Returns the number of restored slices.
+func (timeline *Timeline) RestoreSnapshot(snapshot *TimelineSnapshot) (restored int, err os.Error) {
+	if snapshot.Interval != timeline.Interval {
+		err = os.NewError(fmt.Sprintf("Snapshot slice interval %d does not match timeline slice interval %d", snapshot.Interval, timeline.Interval))
+		return
+	}
+
+	timeline.mutex.Lock()
+	defer timeline.mutex.Unlock()
+	for _, slice := range snapshot.Slices {
+		if slice.Sets == nil {
+			slice.Sets = make(map[string]*SampleSet)
+		}
+		number := slice.Time / timeline.Interval
+		if existing, found := timeline.Slices[number]; found {
+			existing.merge(slice)
+		} else {
+			timeline.Slices[number] = slice
+		}
+		restored++
+	}
+	return
+}
+
+// merge adds all sample sets of the other slice to the slice.
+func (slice *Slice) merge(other *Slice) {
+	for key, set := range other.Sets {
+		if existing, found := slice.Sets[key]; found {
+			existing.merge(set)
+		} else {
+			slice.Sets[key] = set
+		}
+	}
+}
+
+// merge adds all values (accumulated totals, and summaries) of the other
+// sample set to the set. The value added last is taken from the set which
+// has seen an event last.
+func (set *SampleSet) merge(other *SampleSet) {
+	last := set.Last
+	for idx, value := range other.Values {
+		if other.Times != nil {
+			set.KeepTimes(other.Times[idx])
+		}
+		set.AddWeighted(value, other.Weight(idx))
+	}
+	set.Last = last
+	if (len(other.Values) > 0 || other.Latest || other.Summary != nil) && other.LastSeen >= set.LastSeen {
+		set.Last = other.Last
+	}
+	if other.Summary != nil {
+		if set.Summary == nil {
+			set.Summary = other.Summary.copy()
+		} else {
+			set.Summary.merge(other.Summary)
+		}
+	}
+	set.Latest = set.Latest || other.Latest
+	set.Dropped += other.Dropped
+	set.Touch(other.LastSeen)
+	if other.Accumulated {
+		set.Accumulated = true
+		atomic.AddInt64(&set.Total, other.Total)
+		atomic.AddInt64(&set.Count, other.Count)
+	}
+	if set.Type == "" {
+		set.Type = other.Type
+	}
+}
+
+// copy returns a deep copy of the slice.
+func (slice *Slice) copy() *Slice {
+	copied := NewSlice(slice.Time)
+	for key, set := range slice.Sets {
+		copiedSet := NewSampleSet(set.Time, set.Source, set.Name)
+		copiedSet.Tags = set.Tags
+		copiedSet.Type = set.Type
+		copiedSet.Values = make([]int, len(set.Values))
+		copy(copiedSet.Values, set.Values)
+		if set.Weights != nil {
+			copiedSet.Weights = make([]int, len(set.Weights))
+			copy(copiedSet.Weights, set.Weights)
+		}
+		if set.Times != nil {
+			copiedSet.Times = make([]int64, len(set.Times))
+			copy(copiedSet.Times, set.Times)
+			copiedSet.stamp = set.stamp
+		}
+		copiedSet.Carried = set.Carried
+		copiedSet.Partial = set.Partial
+		copiedSet.Latest = set.Latest
+		copiedSet.Last = set.Last
+		copiedSet.Accumulated = set.Accumulated
+		if set.Summary != nil {
+			copiedSet.Summary = set.Summary.copy()
+		}
+		copiedSet.Total = atomic.AddInt64(&set.Total, 0)
+		copiedSet.Count = atomic.AddInt64(&set.Count, 0)
+		copiedSet.LastSeen = atomic.AddInt64(&set.LastSeen, 0)
+		copied.Sets[key] = copiedSet
+	}
+	return copied
+}
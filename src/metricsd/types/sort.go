@@ -2,6 +2,7 @@ package types
 
 import (
 	"sort"
+	"metricsd/config"
 )
 
 // SampleSetSlice attaches the methods of sort.Interface to []*SampleSet, sorting in increasing order.
@@ -18,7 +19,47 @@ func (p SliceSlice) Len() int           { return len(p) }
 func (p SliceSlice) Less(i, j int) bool { return p[i].Less(p[j]) }
 func (p SliceSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 
+// int64Slice attaches the methods of sort.Interface to []int64, sorting in increasing order.
+type int64Slice []int64
+
+func (p int64Slice) Len() int           { return len(p) }
+func (p int64Slice) Less(i, j int) bool { return p[i] < p[j] }
+func (p int64Slice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// prioritySampleSets sorts sample sets by write priority of their metrics
+// (see config.MetricConfig.Priority), the highest first, and then in
+// increasing order, so every series stays sorted by time.
+type prioritySampleSets struct {
+	sets       []*SampleSet
+	priorities []int
+}
+
+func (p *prioritySampleSets) Len() int { return len(p.sets) }
+func (p *prioritySampleSets) Less(i, j int) bool {
+	return p.priorities[i] > p.priorities[j] || (p.priorities[i] == p.priorities[j] && p.sets[i].Less(p.sets[j]))
+}
+func (p *prioritySampleSets) Swap(i, j int) {
+	p.sets[i], p.sets[j] = p.sets[j], p.sets[i]
+	p.priorities[i], p.priorities[j] = p.priorities[j], p.priorities[i]
+}
+
 // SortSampleSets sorts a slice of *SampleSet in increasing order.
 func SortSampleSets(a []*SampleSet) { sort.Sort(SampleSetSlice(a)) }
+
+// SortSampleSetsByPriority sorts a slice of *SampleSet by write priority,
+// and then in increasing order. Sample sets are sorted in increasing order
+// when no metrics have priorities.
+func SortSampleSetsByPriority(a []*SampleSet) {
+	if !config.UsesPriorities() {
+		SortSampleSets(a)
+		return
+	}
+	priorities := make([]int, len(a))
+	for idx, set := range a {
+		priorities[idx] = config.MetricOptions(set.Name).Priority
+	}
+	sort.Sort(&prioritySampleSets{a, priorities})
+}
+
 // SliceSlices sorts a slice of *Slice in increasing order.
 func SortSlices(a []*Slice) { sort.Sort(SliceSlice(a)) }
@@ -0,0 +1,76 @@
+package types
+
+// A Summary holds the number, the sum, the minimum, the maximum, and the
+// approximate distribution (see Sketch) of values of a sample set, stored
+// instead of the values for metrics with summary storage (see
+// config.Summarizes), so memory does not depend on the number of values.
+type Summary struct {
+	Count  int64   // number of values (sum of weights)
+	Total  int64   // sum of values multiplied by their weights (saturated instead of overflowing)
+	Min    int     // minimum value
+	Max    int     // maximum value
+	Sketch *Sketch // approximate distribution of values
+}
+
+// newSummary returns an empty summary with the given sketch accuracy.
+func newSummary(accuracy float64) *Summary {
+	return &Summary{Sketch: NewSketch(accuracy)}
+}
+
+// add adds the value with the given weight to the summary.
+func (summary *Summary) add(value, weight int) {
+	if summary.Count == 0 || value < summary.Min {
+		summary.Min = value
+	}
+	if summary.Count == 0 || value > summary.Max {
+		summary.Max = value
+	}
+	summary.Count = SaturatedAdd(summary.Count, int64(weight))
+	summary.Total = SaturatedAdd(summary.Total, SaturatedMul(int64(value), int64(weight)))
+	summary.Sketch.Add(value, weight)
+}
+
+// merge adds all values of the other summary to the summary.
+func (summary *Summary) merge(other *Summary) {
+	if other.Count == 0 {
+		return
+	}
+	if summary.Count == 0 || other.Min < summary.Min {
+		summary.Min = other.Min
+	}
+	if summary.Count == 0 || other.Max > summary.Max {
+		summary.Max = other.Max
+	}
+	summary.Count = SaturatedAdd(summary.Count, other.Count)
+	summary.Total = SaturatedAdd(summary.Total, other.Total)
+	summary.Sketch.Merge(other.Sketch)
+}
+
+// copy returns a deep copy of the summary.
+func (summary *Summary) copy() *Summary {
+	copied := *summary
+	copied.Sketch = summary.Sketch.Copy()
+	return &copied
+}
+
+// Mean returns the mean of values (weighted values are counted as many
+// times as their weight), or 0 for an empty summary.
+func (summary *Summary) Mean() float64 {
+	if summary.Count == 0 {
+		return 0
+	}
+	return float64(summary.Total) / float64(summary.Count)
+}
+
+// Quantile returns the estimate of the q-th quantile of values (see
+// Sketch.Quantile), within the minimum and the maximum.
+func (summary *Summary) Quantile(q float64) float64 {
+	value := summary.Sketch.Quantile(q)
+	if value < float64(summary.Min) {
+		return float64(summary.Min)
+	}
+	if value > float64(summary.Max) {
+		return float64(summary.Max)
+	}
+	return value
+}
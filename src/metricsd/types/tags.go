@@ -0,0 +1,71 @@
+package types
+
+import (
+	"sort"
+	"strings"
+)
+
+// Tags are dimensions of a metric (for example, status=200, region=us-east).
+// Metrics with the same name but different tags are different series.
+type Tags map[string]string
+
+// Keys returns sorted list of tag keys.
+func (tags Tags) Keys() []string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// String returns tags in canonical form: "key=value" pairs sorted by key,
+// separated with ";".
+func (tags Tags) String() string {
+	pairs := make([]string, 0, len(tags))
+	for _, key := range tags.Keys() {
+		pairs = append(pairs, key+"="+tags[key])
+	}
+	return strings.Join(pairs, ";")
+}
+
+// Key returns canonical serialization of tags, which does not depend on the
+// order tags were received in: escaped "key=value" pairs sorted by key,
+// separated with ";". Unlike String, keys of different tags never collide
+// (e.g. a="1;b=2" and a=1, b=2).
+func (tags Tags) Key() string {
+	pairs := make([]string, 0, len(tags))
+	for _, key := range tags.Keys() {
+		pairs = append(pairs, escapeTag(key)+"="+escapeTag(tags[key]))
+	}
+	return strings.Join(pairs, ";")
+}
+
+// escapeTag escapes separators in the tag key or value with backslash, so
+// different tags could not produce the same key (see Key).
+func escapeTag(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, ";", `\;`, -1)
+	return strings.Replace(s, "=", `\=`, -1)
+}
+
+// SeriesKey returns the key identifying the series of the metric with the
+// given name and tags in sample sets of a slice (see Tags.Key). The name is
+// returned as is when there are no tags.
+func SeriesKey(name string, tags Tags) string {
+	if len(tags) == 0 {
+		return name
+	}
+	return name + ";" + tags.Key()
+}
+
+// SeriesName returns the name identifying the series of the metric with
+// the given name and tags, in Graphite tagged format:
+//     <name>;<key>=<value>;...
+// The name is returned as is when there are no tags.
+func SeriesName(name string, tags Tags) string {
+	if len(tags) == 0 {
+		return name
+	}
+	return name + ";" + tags.String()
+}
@@ -2,32 +2,318 @@ package types
 
 import (
 	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
+	"metricsd/config"
 )
 
 // A Timeline is used to store events in a list of slices, divided by the
 // time they have been taken at.
 type Timeline struct {
-	Interval int64
-	Slices   map[int64]*Slice
-	mutex    *sync.Mutex
+	Interval      int64
+	Slices        map[int64]*Slice
+	DeniedEvents  int64         // number of events dropped because of denylist
+	DroppedValues int64         // number of values dropped because of per-metric MaxValues
+	Dedup         bool          // drop exact duplicates of events added with AddAt
+	Duplicates    int64         // number of duplicate events dropped by AddAt
+	RateLimited   int64         // number of events dropped because of per-metric rate limit
+	SampledOut    int64         // number of events dropped by global sampling (see config.SampleRate)
+	FilteredOut   int64         // number of events dropped by per-metric transforms
+	DroppedSets   int64         // number of sample sets of slices in progress dropped by forced extraction (see config.PartialPolicy)
+	ClockSteps    int64         // number of times the clock stepped back behind the latest slice (see config.ClockPolicy)
+	Recycle       bool          // reuse released slices and sample sets (see Release)
+	mutex         *sync.RWMutex // protects slices and their sample sets
+	denied        map[string]bool
+	deniedMutex   *sync.RWMutex
+	tracked       map[string]*trackedMetric // metrics which could be reported in slices without samples
+	lastClosed    int64                     // number of the last extracted slice
+	latestSlice   int64                     // number of the latest slice the clock has pointed to
+	behind        int32                     // 1 while the clock is behind the latest slice
+	lastExtracted *Slice                    // copy of the last extracted slice
+	closed        *Slice                    // cached copy of the most recent closed slice (see SnapshotClosed)
+	closedMutex   *sync.Mutex               // protects lastExtracted and closed
+	pool          *pool                     // recycled slices and sample sets
+	limiter       *rateLimiter              // limits the rate of events per metric name
+	shards        []*timelineShard          // further shards receiving events (see NewShardedTimeline)
+	added         uint32                    // number of events spread across shards
+}
+
+// A timelineShard holds slices of events added to a further shard of a
+// sharded timeline, until they are merged into slices of the timeline.
+type timelineShard struct {
+	slices map[int64]*Slice
+	mutex  *sync.Mutex
 }
 
 // NewTimeline returns a new timeline Timeline with the given slice interval.
 func NewTimeline(sliceInterval int) *Timeline {
 	return &Timeline{
-		Slices:   make(map[int64]*Slice),
-		Interval: int64(sliceInterval),
-		mutex:    &sync.Mutex{},
+		Slices:      make(map[int64]*Slice),
+		Interval:    int64(sliceInterval),
+		mutex:       &sync.RWMutex{},
+		denied:      make(map[string]bool),
+		deniedMutex: &sync.RWMutex{},
+		tracked:     make(map[string]*trackedMetric),
+		closedMutex: &sync.Mutex{},
+		pool:        newPool(),
+		limiter:     newRateLimiter(),
+	}
+}
+
+// NewShardedTimeline returns a new timeline with the given slice interval,
+// split into the given number of shards. Events are spread across shards in
+// turn, so concurrent callers of Add contend for separate locks. Slices of
+// all shards are merged into slices of the timeline when they are extracted
+// or copied (see Slice.merge), so sample sets of a metric from all shards
+// are written as a single sample set. Events added with AddAt (imports) and
+// events of accumulated counters (see Add) are not spread.
+func NewShardedTimeline(sliceInterval, shards int) *Timeline {
+	timeline := NewTimeline(sliceInterval)
+	for idx := 1; idx < shards; idx++ {
+		timeline.shards = append(timeline.shards, &timelineShard{slices: make(map[int64]*Slice), mutex: &sync.Mutex{}})
 	}
+	return timeline
 }
 
-// Add appends the given event to the current slice.
+// Add appends the given event to the current slice. Events for denied
+// metrics are dropped and counted in DeniedEvents, values beyond per-metric
+// MaxValues are counted in DroppedValues. Events exceeding per-metric rate
+// limit (see config.RateLimit) are dropped and counted in RateLimited.
+// With global sampling (see config.SampleRate), events are kept with the
+// sampling probability (others are counted in SampledOut), and values of
+// kept counters are scaled up (see scaleSampled). Values are transformed
+// according to per-metric Transforms (see transform). Values of accumulated
+// counters (see config.Accumulates) are summed holding the read lock only,
+// once their sample sets exist (created in the timeline itself, not in its
+// shards). Other events of sharded timelines are added to shards in turn
+// (see NewShardedTimeline).
 func (timeline *Timeline) Add(event *Event) {
-	timeline.getCurrentSlice().Add(event)
+	if timeline.IsDenied(event.Name) {
+		atomic.AddInt64(&timeline.DeniedEvents, 1)
+		return
+	}
+	if !timeline.limiter.allow(event.Name, time.Nanoseconds()) {
+		atomic.AddInt64(&timeline.RateLimited, 1)
+		return
+	}
+	if rate := config.SampleRate; rate < 1 {
+		if !sampled(event, rate) {
+			atomic.AddInt64(&timeline.SampledOut, 1)
+			return
+		}
+		event = scaleSampled(event, rate)
+	}
+	if event = timeline.transform(event); event == nil {
+		return
+	}
+	accumulated := config.Accumulates(event.Name, event.Type, event.Tags)
+	if accumulated && timeline.accumulate(event) {
+		return
+	}
+	// Accumulated sample sets are created in the timeline itself, where
+	// accumulate looks for them
+	var dropped int
+	if shard := timeline.nextShard(); shard != nil && !accumulated {
+		dropped = timeline.addToShard(shard, event)
+	} else {
+		timeline.mutex.Lock()
+		dropped = timeline.getCurrentSlice().Add(event)
+		timeline.mutex.Unlock()
+	}
+	if dropped > 0 {
+		atomic.AddInt64(&timeline.DroppedValues, int64(dropped))
+	}
+}
+
+// nextShard returns the further shard the next event should be added to,
+// or nil when it should be added to slices of the timeline itself.
+func (timeline *Timeline) nextShard() *timelineShard {
+	if len(timeline.shards) == 0 {
+		return nil
+	}
+	idx := atomic.AddUint32(&timeline.added, 1) % uint32(len(timeline.shards)+1)
+	if idx == 0 {
+		return nil
+	}
+	return timeline.shards[idx-1]
+}
+
+// addToShard appends the event to the current slice of the shard, and
+// returns the number of values dropped (see Slice.Add).
+func (timeline *Timeline) addToShard(shard *timelineShard, event *Event) int {
+	number := timeline.getCurrentSliceNumber()
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	slice, found := shard.slices[number]
+	if !found {
+		slice = NewSlice(number * timeline.Interval)
+		shard.slices[number] = slice
+	}
+	return slice.Add(event)
+}
+
+// mergeShards moves slices with the number less than current (all slices,
+// if current is negative) from further shards into the timeline, merging
+// them with slices of the timeline (see Slice.merge). Timeline should be
+// locked.
+func (timeline *Timeline) mergeShards(current int64) {
+	timeline.eachShardSlice(func(shard *timelineShard, number int64, slice *Slice) {
+		if number < current || current < 0 {
+			timeline.getSlice(number).merge(slice)
+			shard.slices[number] = nil, false
+		}
+	})
+}
+
+// copyShards merges copies of slices of further shards accepted by function
+// f into the given copies of slices (keyed by slice number), adding slices
+// missing in them.
+func (timeline *Timeline) copyShards(copies map[int64]*Slice, f func(number int64) bool) {
+	timeline.eachShardSlice(func(shard *timelineShard, number int64, slice *Slice) {
+		if !f(number) {
+			return
+		}
+		if existing, found := copies[number]; found {
+			existing.merge(slice.copy())
+		} else {
+			copies[number] = slice.copy()
+		}
+	})
+}
+
+// eachShardSlice calls function f for each slice of further shards, in no
+// particular order, holding the lock of the shard.
+func (timeline *Timeline) eachShardSlice(f func(shard *timelineShard, number int64, slice *Slice)) {
+	for _, shard := range timeline.shards {
+		shard.mutex.Lock()
+		for number, slice := range shard.slices {
+			f(shard, number, slice)
+		}
+		shard.mutex.Unlock()
+	}
+}
+
+// AddAt appends the given event to the slice containing the given time
+// (seconds since epoch). It is used to import historical data, so the
+// slice could be closed already: it is up to the caller to extract it.
+// Events for denied metrics are dropped and counted in DeniedEvents.
+// Values beyond per-metric MaxValues are counted in DroppedValues. When
+// Dedup is enabled, events with the same source, name, timestamp, and
+// (transformed) value as one already added to the slice are dropped and
+// counted in Duplicates.
+func (timeline *Timeline) AddAt(event *Event, timestamp int64) {
+	if timeline.IsDenied(event.Name) {
+		atomic.AddInt64(&timeline.DeniedEvents, 1)
+		return
+	}
+	if event = timeline.transform(event); event == nil {
+		return
+	}
+	timeline.mutex.Lock()
+	defer timeline.mutex.Unlock()
+	slice := timeline.getSlice(timestamp / timeline.Interval)
+	if timeline.Dedup && !slice.markSeen(event, timestamp) {
+		atomic.AddInt64(&timeline.Duplicates, 1)
+		return
+	}
+	if dropped := slice.AddAt(event, timestamp); dropped > 0 {
+		atomic.AddInt64(&timeline.DroppedValues, int64(dropped))
+	}
+}
+
+// transform returns the event with the value transformed by per-metric
+// Transforms (a copy, so the event is not modified), or the event itself
+// when the metric has no transforms. Returns nil when a transform dropped
+// the value, counting it in FilteredOut. Values of vector events are
+// transformed one by one, dropped values are removed from the copy, nil
+// is returned when all of them are dropped.
+func (timeline *Timeline) transform(event *Event) *Event {
+	transforms := config.MetricOptions(event.Name).Transforms
+	if len(transforms) == 0 {
+		return event
+	}
+	if len(event.Values) > 0 {
+		return timeline.transformVector(event, transforms)
+	}
+	value, ok := transforms.Apply(event.Value)
+	if !ok {
+		atomic.AddInt64(&timeline.FilteredOut, 1)
+		return nil
+	}
+	transformed := *event
+	transformed.Value = value
+	return &transformed
+}
+
+// transformVector returns the copy of the vector event with values
+// transformed (see transform).
+func (timeline *Timeline) transformVector(event *Event, transforms config.TransformPipeline) *Event {
+	values := make([]int, 0, len(event.Values)+1)
+	for _, value := range append([]int{event.Value}, event.Values...) {
+		if transformed, ok := transforms.Apply(value); ok {
+			values = append(values, transformed)
+		} else {
+			atomic.AddInt64(&timeline.FilteredOut, 1)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	transformed := *event
+	transformed.Value, transformed.Values = values[0], values[1:]
+	return &transformed
+}
+
+// accumulate adds the event value to accumulated sample sets of the current
+// slice, returns false when the slice or sample sets have to be created.
+func (timeline *Timeline) accumulate(event *Event) bool {
+	timeline.mutex.RLock()
+	defer timeline.mutex.RUnlock()
+	slice, found := timeline.Slices[timeline.getCurrentSliceNumber()]
+	return found && slice.accumulate(event, Clock())
+}
+
+// Deny stops accepting events for the given metric name.
+func (timeline *Timeline) Deny(name string) {
+	timeline.deniedMutex.Lock()
+	defer timeline.deniedMutex.Unlock()
+	timeline.denied[name] = true
+}
+
+// Allow resumes accepting events for the given metric name.
+func (timeline *Timeline) Allow(name string) {
+	timeline.deniedMutex.Lock()
+	defer timeline.deniedMutex.Unlock()
+	timeline.denied[name] = false, false
+}
+
+// IsDenied returns a value indicating whether events for the given metric
+// name are being dropped.
+func (timeline *Timeline) IsDenied(name string) bool {
+	timeline.deniedMutex.RLock()
+	defer timeline.deniedMutex.RUnlock()
+	return timeline.denied[name]
+}
+
+// DeniedNames returns sorted list of denied metric names.
+func (timeline *Timeline) DeniedNames() (names []string) {
+	timeline.deniedMutex.RLock()
+	defer timeline.deniedMutex.RUnlock()
+	names = make([]string, 0, len(timeline.denied))
+	for name := range timeline.denied {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
 }
 
+// ExtractClosedSlices removes closed slices (or all slices, if force is
+// true) from the timeline, and returns them sorted by time. The slice in
+// progress extracted by force is handled according to PartialPolicy: kept
+// as is, dropped (its sample sets are counted in DroppedSets), or marked
+// (see markPartial).
 func (timeline *Timeline) ExtractClosedSlices(force bool) (closedSlices []*Slice) {
 	var current int64
 	if force {
@@ -35,7 +321,10 @@ func (timeline *Timeline) ExtractClosedSlices(force bool) (closedSlices []*Slice
 	} else {
 		current = timeline.getCurrentSliceNumber()
 	}
+	inProgress := timeline.getCurrentSliceNumber()
 
+	timeline.mutex.Lock()
+	timeline.mergeShards(current)
 	// Calculate total number of closed timeline (to avoid vector reallocs)
 	totalClosedSlices := 0
 	timeline.eachClosedSlice(current, func(number int64, slice *Slice) {
@@ -45,45 +334,149 @@ func (timeline *Timeline) ExtractClosedSlices(force bool) (closedSlices []*Slice
 	// Create an array to store timeline
 	closedSlices = make([]*Slice, 0, totalClosedSlices)
 	timeline.eachClosedSlice(current, func(number int64, slice *Slice) {
-		closedSlices = append(closedSlices, slice)
-		timeline.mutex.Lock()
 		timeline.Slices[number] = nil, false
-		timeline.mutex.Unlock()
+		if number == inProgress {
+			switch config.PartialPolicy {
+			case config.PARTIAL_POLICY_DROP:
+				atomic.AddInt64(&timeline.DroppedSets, int64(len(slice.Sets)))
+				return
+			case config.PARTIAL_POLICY_MARK:
+				slice.markPartial()
+			}
+		}
+		closedSlices = append(closedSlices, slice)
 	})
+	timeline.mutex.Unlock()
+	timeline.limiter.prune(time.Nanoseconds())
+
+	return timeline.finishExtraction(closedSlices)
+}
+
+// ForceCloseOldest extracts the n oldest closed slices regardless of the
+// write interval, and returns all sample sets from them. The current slice
+// is never extracted, since events received later would be written as
+// another slice of the same time, so less than n slices are extracted when
+// there are not enough closed ones. Newer slices are left intact.
+func (timeline *Timeline) ForceCloseOldest(n int) []*SampleSet {
+	if n < 1 {
+		return nil
+	}
+	current := timeline.getCurrentSliceNumber()
+
+	timeline.mutex.Lock()
+	timeline.mergeShards(current)
+	numbers := make([]int64, 0, len(timeline.Slices))
+	timeline.eachClosedSlice(current, func(number int64, slice *Slice) {
+		numbers = append(numbers, number)
+	})
+	sort.Sort(int64Slice(numbers))
+	if n < len(numbers) {
+		numbers = numbers[:n]
+	}
+
+	closedSlices := make([]*Slice, 0, len(numbers))
+	for _, number := range numbers {
+		closedSlices = append(closedSlices, timeline.Slices[number])
+		timeline.Slices[number] = nil, false
+	}
+	timeline.mutex.Unlock()
+
+	return CollectSampleSets(timeline.finishExtraction(closedSlices))
+}
+
+// finishExtraction sorts slices removed from the timeline, fills gaps
+// between them (see fillGaps), and keeps a copy of the most recent one.
+func (timeline *Timeline) finishExtraction(closedSlices []*Slice) []*Slice {
 	SortSlices(closedSlices)
-	return
+	closedSlices = timeline.fillGaps(closedSlices)
+
+	// Keep a copy of the most recent slice for SnapshotClosed, since
+	// writers modify extracted slices
+	if len(closedSlices) > 0 {
+		timeline.closedMutex.Lock()
+		timeline.lastExtracted = closedSlices[len(closedSlices)-1].copy()
+		timeline.closedMutex.Unlock()
+	}
+	return closedSlices
+}
+
+// ClosedSliceCount returns the number of closed slices waiting for
+// extraction (in any shard).
+func (timeline *Timeline) ClosedSliceCount() int {
+	current := timeline.getCurrentSliceNumber()
+	closed := make(map[int64]bool)
+	timeline.mutex.RLock()
+	timeline.eachClosedSlice(current, func(number int64, slice *Slice) {
+		closed[number] = true
+	})
+	timeline.mutex.RUnlock()
+	timeline.eachShardSlice(func(shard *timelineShard, number int64, slice *Slice) {
+		if number < current {
+			closed[number] = true
+		}
+	})
+	return len(closed)
+}
+
+// OldestSliceTime returns the start time of the oldest slice waiting for
+// extraction (seconds since epoch, in any shard), or 0 when there are no
+// slices.
+func (timeline *Timeline) OldestSliceTime() int64 {
+	oldest, found := int64(0), false
+	timeline.mutex.RLock()
+	for number := range timeline.Slices {
+		if !found || number < oldest {
+			oldest, found = number, true
+		}
+	}
+	timeline.mutex.RUnlock()
+	timeline.eachShardSlice(func(shard *timelineShard, number int64, slice *Slice) {
+		if !found || number < oldest {
+			oldest, found = number, true
+		}
+	})
+	return oldest * timeline.Interval
 }
 
 // ExtractClosedSampleSets finds closed timeline, and stores all sample sets from them
 // in an array. Processed timeline will be removed from the list of active timeline.
-func (timeline *Timeline) ExtractClosedSampleSets(force bool) (closedSampleSets []*SampleSet) {
-	var current int64
-	if force {
-		current = -1
-	} else {
-		current = timeline.getCurrentSliceNumber()
-	}
+func (timeline *Timeline) ExtractClosedSampleSets(force bool) []*SampleSet {
+	return CollectSampleSets(timeline.ExtractClosedSlices(force))
+}
 
+// CollectSampleSets returns sample sets from all given slices, sorted by
+// write priority, source, name, and time (see SortSampleSetsByPriority).
+func CollectSampleSets(closedSlices []*Slice) (closedSampleSets []*SampleSet) {
 	// Calculate total number of closed sample sets (to avoid vector reallocs)
 	totalSampleSets := 0
-	timeline.eachClosedSlice(current, func(number int64, slice *Slice) {
+	for _, slice := range closedSlices {
 		totalSampleSets += len(slice.Sets)
-	})
+	}
 
 	// Create an array to store sample sets
 	closedSampleSets = make([]*SampleSet, 0, totalSampleSets)
-	timeline.eachClosedSlice(current, func(number int64, slice *Slice) {
+	for _, slice := range closedSlices {
 		for _, set := range slice.Sets {
 			closedSampleSets = append(closedSampleSets, set)
 		}
-		timeline.mutex.Lock()
-		timeline.Slices[number] = nil, false
-		timeline.mutex.Unlock()
-	})
-	SortSampleSets(closedSampleSets)
+	}
+	SortSampleSetsByPriority(closedSampleSets)
 	return
 }
 
+// Release recycles extracted slices and their sample sets, when Recycle is
+// enabled. Callers must not use released slices and sample sets (or keep
+// references to them) anymore: they are reset and reused for new events.
+// Slices released already are ignored.
+func (timeline *Timeline) Release(slices []*Slice) {
+	if !timeline.Recycle {
+		return
+	}
+	for _, slice := range slices {
+		timeline.pool.release(slice)
+	}
+}
+
 func (timeline *Timeline) String() string {
 	return fmt.Sprintf(
 		"Timeline[interval=%d, size=%d]",
@@ -93,21 +486,50 @@ func (timeline *Timeline) String() string {
 }
 
 // getCurrentSlice creates (if necessary) and returns the current slice
-// (see getCurrentSliceNumber for details).
+// (see getCurrentSliceNumber for details). Timeline should be locked.
 func (timeline *Timeline) getCurrentSlice() *Slice {
-	number := timeline.getCurrentSliceNumber()
+	return timeline.getSlice(timeline.getCurrentSliceNumber())
+}
+
+// getSlice creates (if necessary) and returns the slice with the given
+// number. Timeline should be locked.
+func (timeline *Timeline) getSlice(number int64) *Slice {
 	if _, found := timeline.Slices[number]; !found {
-		timeline.mutex.Lock()
-		timeline.Slices[number] = NewSlice(number * timeline.Interval)
-		timeline.mutex.Unlock()
+		if timeline.Recycle {
+			timeline.Slices[number] = timeline.pool.getSlice(number * timeline.Interval)
+		} else {
+			timeline.Slices[number] = NewSlice(number * timeline.Interval)
+		}
 	}
 	return timeline.Slices[number]
 }
 
 // getCurrentSliceNumber returns current slice number (time since epoc in
-// seconds, rounded to the slices interval).
+// seconds, rounded to the slices interval). The clock is not monotonic, so
+// the latest slice number is remembered: when the clock steps back behind
+// it (e.g. NTP correction), the step is counted in ClockSteps, and the
+// latest slice number is returned until the clock catches up, unless
+// ClockPolicy is "follow". Otherwise events would be added to slices which
+// have been extracted already, and their updates rejected by RRDTool.
 func (timeline *Timeline) getCurrentSliceNumber() int64 {
-	return time.Seconds() / timeline.Interval
+	number := Clock() / timeline.Interval
+	for {
+		latest := atomic.AddInt64(&timeline.latestSlice, 0)
+		if number >= latest {
+			if atomic.CompareAndSwapInt64(&timeline.latestSlice, latest, number) {
+				atomic.CompareAndSwapInt32(&timeline.behind, 1, 0)
+				return number
+			}
+			continue
+		}
+		if atomic.CompareAndSwapInt32(&timeline.behind, 0, 1) {
+			atomic.AddInt64(&timeline.ClockSteps, 1)
+		}
+		if config.ClockPolicy == config.CLOCK_POLICY_FOLLOW {
+			return number
+		}
+		return latest
+	}
 }
 
 // eachClosedSlice calls function f for each slice with the slice number less
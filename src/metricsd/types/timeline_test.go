@@ -0,0 +1,769 @@
+package types
+
+import (
+	"bytes"
+	. "launchpad.net/gocheck"
+	"math"
+	"os"
+	"runtime"
+	"metricsd/config"
+)
+
+type TimelineS struct {
+	timeline *Timeline
+}
+
+var _ = Suite(&TimelineS{})
+
+func (s *TimelineS) SetUpTest(c *C) {
+	s.timeline = NewTimeline(10)
+	config.SetMetrics([]*config.MetricConfig{
+		&config.MetricConfig{Pattern: "carried.*", GapPolicy: config.GAP_POLICY_CARRY, MaxStaleness: 20},
+		&config.MetricConfig{Pattern: "unknown.*", GapPolicy: config.GAP_POLICY_UNKNOWN, MaxStaleness: 20},
+		&config.MetricConfig{Pattern: "default.*", GapPolicy: config.GAP_POLICY_DEFAULT, DefaultValue: 7, MaxStaleness: 20},
+		&config.MetricConfig{Pattern: "limited.*", MaxValues: 2, Overflow: config.OVERFLOW_POLICY_DROP},
+		&config.MetricConfig{Pattern: "counted.*", Writers: []string{"sum"}},
+		&config.MetricConfig{Pattern: "coalesced.*", Coalesce: true},
+	})
+}
+
+func (s *TimelineS) TearDownTest(c *C) {
+	config.SetMetrics(nil)
+}
+
+// addAt adds the event to the slice with the given number.
+func (s *TimelineS) addAt(number int64, event *Event) {
+	if _, found := s.timeline.Slices[number]; !found {
+		s.timeline.Slices[number] = NewSlice(number * s.timeline.Interval)
+	}
+	s.timeline.Slices[number].Add(event)
+}
+
+func (s *TimelineS) TestAdd(c *C) {
+	s.timeline.Add(NewEvent("src", "metric", 10))
+	c.Check(len(s.timeline.Slices), Equals, 1)
+	c.Check(s.timeline.DeniedEvents, Equals, int64(0))
+}
+
+func (s *TimelineS) TestAddWithMaxValues(c *C) {
+	for i := 0; i < 3; i++ {
+		s.timeline.AddAt(NewEvent("src", "limited.metric", i), 100)
+		s.timeline.AddAt(NewEvent("src", "metric", i), 100)
+	}
+	c.Check(s.timeline.DroppedValues, Equals, int64(2))
+	slice := s.timeline.Slices[10]
+	c.Check(slice.Sets["src-limited.metric"].Values, Equals, []int{0, 1})
+	c.Check(slice.Sets["all-limited.metric"].Values, Equals, []int{0, 1})
+	c.Check(slice.Sets["src-metric"].Values, Equals, []int{0, 1, 2})
+}
+
+func (s *TimelineS) TestAddVectorEvent(c *C) {
+	s.timeline.AddAt(NewVectorEvent("src", "cpu", []int{10, 20, 30}), 100)
+	s.timeline.AddAt(NewVectorEvent("src", "limited.cpu", []int{10, 20, 30}), 100)
+	c.Check(s.timeline.DroppedValues, Equals, int64(2))
+	slice := s.timeline.Slices[10]
+	c.Check(slice.Sets["src-cpu"].Values, Equals, []int{10, 20, 30})
+	c.Check(slice.Sets["all-cpu"].Values, Equals, []int{10, 20, 30})
+	c.Check(slice.Sets["src-limited.cpu"].Values, Equals, []int{10, 20})
+}
+
+func (s *TimelineS) TestAddAtTracksLastSeen(c *C) {
+	s.timeline.AddAt(NewEvent("src", "metric", 1), 107)
+	s.timeline.AddAt(NewEvent("src", "metric", 2), 103)
+	s.timeline.AddAt(NewEvent("other", "metric", 3), 101)
+	slice := s.timeline.Slices[10]
+	c.Check(slice.Sets["src-metric"].LastSeen, Equals, int64(107))
+	c.Check(slice.Sets["other-metric"].LastSeen, Equals, int64(101))
+	c.Check(slice.Sets["all-metric"].LastSeen, Equals, int64(107))
+}
+
+func (s *TimelineS) TestAddDenied(c *C) {
+	s.timeline.Deny("metric")
+	s.timeline.Add(NewEvent("src", "metric", 10))
+	s.timeline.Add(NewEvent("src", "metric", 20))
+	c.Check(len(s.timeline.Slices), Equals, 0)
+	c.Check(s.timeline.DeniedEvents, Equals, int64(2))
+}
+
+func (s *TimelineS) TestAddSampled(c *C) {
+	config.SampleRate = 0.5
+	defer func() { config.SampleRate = config.DEFAULT_SAMPLE_RATE }()
+	for i := 0; i < 1000; i++ {
+		s.timeline.Add(NewEvent("src", "metric", 10))
+		counter := NewEvent("src", "requests", 3)
+		counter.Type = config.METRIC_TYPE_COUNTER
+		s.timeline.Add(counter)
+		s.timeline.Add(NewEvent("all", "metricsd.events.count", 1))
+	}
+	c.Check(s.timeline.SampledOut > 800 && s.timeline.SampledOut < 1200, Equals, true)
+
+	for _, set := range s.timeline.ExtractClosedSampleSets(true) {
+		switch set.Name {
+		case "requests":
+			c.Check(set.Values[0], Equals, 6)
+		case "metricsd.events.count":
+			c.Check(len(set.Values), Equals, 1000)
+		default:
+			c.Check(set.Values[0], Equals, 10)
+		}
+	}
+}
+
+func (s *TimelineS) TestScaleValue(c *C) {
+	c.Check(scaleValue(3, 0.4), Equals, 8)
+	c.Check(scaleValue(-3, 0.5), Equals, -6)
+	c.Check(scaleValue(math.MaxInt32, 0.5), Equals, math.MaxInt32)
+}
+
+func (s *TimelineS) TestAddRateLimited(c *C) {
+	config.RateLimit = &config.RateLimitConfig{Rate: 1, Burst: 2}
+	defer func() { config.RateLimit = config.DEFAULT_RATE_LIMIT }()
+	for i := 0; i < 3; i++ {
+		s.timeline.Add(NewEvent("src", "metric", i))
+	}
+	s.timeline.Add(NewEvent("src", "other", 1))
+	c.Check(s.timeline.RateLimited, Equals, int64(1))
+}
+
+func (s *TimelineS) TestAllow(c *C) {
+	s.timeline.Deny("metric")
+	s.timeline.Allow("metric")
+	c.Check(s.timeline.IsDenied("metric"), Equals, false)
+	s.timeline.Add(NewEvent("src", "metric", 10))
+	c.Check(len(s.timeline.Slices), Equals, 1)
+}
+
+func (s *TimelineS) TestDeniedNames(c *C) {
+	s.timeline.Deny("metric2")
+	s.timeline.Deny("metric1")
+	c.Check(s.timeline.DeniedNames(), Equals, []string{"metric1", "metric2"})
+}
+
+func (s *TimelineS) TestOldestSliceTime(c *C) {
+	c.Check(s.timeline.OldestSliceTime(), Equals, int64(0))
+	s.addAt(12, NewEvent("host", "metric", 1))
+	s.addAt(10, NewEvent("host", "metric", 2))
+	s.addAt(11, NewEvent("host", "metric", 3))
+	c.Check(s.timeline.OldestSliceTime(), Equals, int64(100))
+}
+
+func (s *TimelineS) TestExtractClosedSampleSets(c *C) {
+	s.addAt(1, NewEvent("all", "metric", 10))
+	s.addAt(3, NewEvent("all", "metric", 20))
+	sets := s.timeline.ExtractClosedSampleSets(false)
+	c.Check(len(sets), Equals, 2)
+	c.Check(sets[0].Time, Equals, int64(10))
+	c.Check(sets[1].Time, Equals, int64(30))
+	c.Check(len(s.timeline.Slices), Equals, 0)
+}
+
+func (s *TimelineS) TestExtractClosedSampleSetsCarriesGauges(c *C) {
+	s.addAt(1, NewEvent("all", "carried.metric", 10))
+	s.addAt(1, NewEvent("all", "carried.metric", 15))
+	s.addAt(5, NewEvent("all", "other.metric", 20))
+	sets := s.timeline.ExtractClosedSampleSets(false)
+	c.Check(len(sets), Equals, 4)
+	// Slices 2 and 3 are within staleness interval, slice 4 is not
+	c.Check(sets[0].String(), Equals, "SampleSet[source=all, name=carried.metric, time=10, size=2]")
+	c.Check(sets[1].String(), Equals, "SampleSet[source=all, name=carried.metric, time=20, size=1]")
+	c.Check(sets[1].Carried, Equals, true)
+	c.Check(sets[1].Values, Equals, []int{15})
+	c.Check(sets[2].String(), Equals, "SampleSet[source=all, name=carried.metric, time=30, size=1]")
+	c.Check(sets[3].String(), Equals, "SampleSet[source=all, name=other.metric, time=50, size=1]")
+}
+
+func (s *TimelineS) TestExtractClosedSampleSetsReportsUnknownGauges(c *C) {
+	s.addAt(1, NewEvent("all", "unknown.metric", 10))
+	sets := s.timeline.ExtractClosedSampleSets(false)
+	c.Check(len(sets), Equals, 1)
+
+	// Next extraction reports gap in a slice without samples
+	s.addAt(3, NewEvent("all", "other.metric", 20))
+	sets = s.timeline.ExtractClosedSampleSets(false)
+	c.Check(len(sets), Equals, 3)
+	c.Check(sets[0].String(), Equals, "SampleSet[source=all, name=other.metric, time=30, size=1]")
+	c.Check(sets[1].String(), Equals, "SampleSet[source=all, name=unknown.metric, time=20, size=0]")
+	c.Check(sets[1].Carried, Equals, true)
+	c.Check(sets[2].String(), Equals, "SampleSet[source=all, name=unknown.metric, time=30, size=0]")
+}
+
+func (s *TimelineS) TestExtractClosedSampleSetsReportsDefaultValue(c *C) {
+	s.addAt(1, NewEvent("all", "default.metric", 10))
+	s.addAt(3, NewEvent("all", "other.metric", 20))
+	sets := s.timeline.ExtractClosedSampleSets(false)
+	c.Check(len(sets), Equals, 4)
+	c.Check(sets[1].String(), Equals, "SampleSet[source=all, name=default.metric, time=20, size=1]")
+	c.Check(sets[1].Carried, Equals, true)
+	c.Check(sets[1].Values, Equals, []int{7})
+	c.Check(sets[2].Values, Equals, []int{7})
+}
+
+func (s *TimelineS) TestAddAt(c *C) {
+	s.timeline.AddAt(NewEvent("src", "metric", 10), 1313049605)
+	s.timeline.AddAt(NewEvent("src", "metric", 20), 1313049609)
+	s.timeline.AddAt(NewEvent("src", "metric", 30), 1313049610)
+	slices := s.timeline.ExtractClosedSlices(false)
+	c.Check(len(slices), Equals, 2)
+	c.Check(slices[0].Time, Equals, int64(1313049600))
+	c.Check(slices[0].Sets["src-metric"].Values, Equals, []int{10, 20})
+	c.Check(slices[1].Time, Equals, int64(1313049610))
+	c.Check(slices[1].Sets["src-metric"].Values, Equals, []int{30})
+}
+
+func (s *TimelineS) TestSnapshot(c *C) {
+	s.addAt(1, NewEvent("src", "metric", 10))
+	s.addAt(2, NewEvent("src", "metric", 20))
+	snapshot := s.timeline.Snapshot()
+	c.Check(snapshot.Interval, Equals, int64(10))
+	c.Check(len(snapshot.Slices), Equals, 2)
+	c.Check(SlicesEqual(snapshot.Slices[0], s.timeline.Slices[1]), Equals, true)
+	c.Check(SlicesEqual(snapshot.Slices[1], s.timeline.Slices[2]), Equals, true)
+
+	// Snapshot is not affected by new events
+	s.addAt(1, NewEvent("src", "metric", 30))
+	c.Check(snapshot.Slices[0].Sets["src-metric"].Values, Equals, []int{10})
+}
+
+func (s *TimelineS) TestRestore(c *C) {
+	s.addAt(1, NewEvent("src", "metric", 10))
+	s.addAt(2, NewWeightedEvent("src", "metric", 20, 2))
+	for _, format := range []string{"json", "gob"} {
+		codec, error := GetSnapshotCodec(format)
+		c.Assert(error, IsNil)
+		buffer := &bytes.Buffer{}
+		c.Assert(s.timeline.Snapshot().Write(buffer, codec), IsNil)
+
+		restored := NewTimeline(10)
+		count, error := restored.Restore(buffer, codec)
+		c.Check(error, IsNil)
+		c.Check(count, Equals, 2)
+		c.Check(SlicesEqual(restored.Slices[1], s.timeline.Slices[1]), Equals, true)
+		c.Check(SlicesEqual(restored.Slices[2], s.timeline.Slices[2]), Equals, true)
+	}
+}
+
+func (s *TimelineS) TestRestoreMergesSlices(c *C) {
+	s.addAt(1, NewEvent("src", "metric", 10))
+	codec, _ := GetSnapshotCodec("gob")
+	buffer := &bytes.Buffer{}
+	c.Assert(s.timeline.Snapshot().Write(buffer, codec), IsNil)
+
+	s.addAt(1, NewEvent("src", "metric", 20))
+	_, error := s.timeline.Restore(buffer, codec)
+	c.Check(error, IsNil)
+	c.Check(s.timeline.Slices[1].Sets["src-metric"].Values, Equals, []int{10, 20, 10})
+}
+
+func (s *TimelineS) TestRestoreWithDifferentInterval(c *C) {
+	codec, _ := GetSnapshotCodec("json")
+	buffer := &bytes.Buffer{}
+	c.Assert(NewTimeline(60).Snapshot().Write(buffer, codec), IsNil)
+	_, error := s.timeline.Restore(buffer, codec)
+	c.Check(error, Equals, os.NewError("Snapshot slice interval 60 does not match timeline slice interval 10"))
+}
+
+func (s *TimelineS) TestGetSnapshotCodecWithUnknownFormat(c *C) {
+	_, error := GetSnapshotCodec("xml")
+	c.Check(error.String(), Equals, `Unknown snapshot format "xml", available formats: [gob json]`)
+}
+
+func (s *TimelineS) TestSnapshotClosed(c *C) {
+	number := s.timeline.getCurrentSliceNumber() - 1
+	s.addAt(number, NewEvent("src", "metric", 10))
+	closed := s.timeline.SnapshotClosed()
+	c.Check(closed.Time, Equals, number*10)
+	c.Check(closed.Sets["src-metric"].Values, Equals, []int{10})
+
+	// The copy is cached until the next slice is closed
+	s.addAt(number, NewEvent("src", "metric", 20))
+	c.Check(s.timeline.SnapshotClosed() == closed, Equals, true)
+	c.Check(closed.Sets["src-metric"].Values, Equals, []int{10})
+}
+
+func (s *TimelineS) TestSnapshotClosedExtracted(c *C) {
+	number := s.timeline.getCurrentSliceNumber() - 1
+	s.addAt(number, NewEvent("src", "metric", 10))
+	// Writers could modify extracted sample sets
+	for _, set := range s.timeline.ExtractClosedSampleSets(false) {
+		set.Values[0] = 20
+	}
+	closed := s.timeline.SnapshotClosed()
+	c.Check(closed.Time, Equals, number*10)
+	c.Check(closed.Sets["src-metric"].Values, Equals, []int{10})
+}
+
+func (s *TimelineS) TestSnapshotClosedWithoutEvents(c *C) {
+	closed := s.timeline.SnapshotClosed()
+	c.Check(closed.Time, Equals, (s.timeline.getCurrentSliceNumber()-1)*10)
+	c.Check(len(closed.Sets), Equals, 0)
+}
+
+func (s *TimelineS) TestAddAtDedup(c *C) {
+	s.timeline.Dedup = true
+	s.timeline.AddAt(NewEvent("all", "metric", 10), 1313049600)
+	s.timeline.AddAt(NewEvent("all", "metric", 10), 1313049600)
+	s.timeline.AddAt(NewEvent("all", "metric", 10), 1313049601)
+	s.timeline.AddAt(NewEvent("all", "metric", 20), 1313049600)
+	c.Check(s.timeline.Duplicates, Equals, int64(1))
+	c.Check(s.timeline.Slices[131304960].Sets["all-metric"].Values, Equals, []int{10, 10, 20})
+}
+
+func (s *TimelineS) TestAddAtWithoutDedup(c *C) {
+	s.timeline.AddAt(NewEvent("all", "metric", 10), 1313049600)
+	s.timeline.AddAt(NewEvent("all", "metric", 10), 1313049600)
+	c.Check(s.timeline.Duplicates, Equals, int64(0))
+	c.Check(s.timeline.Slices[131304960].Sets["all-metric"].Values, Equals, []int{10, 10})
+}
+
+func (s *TimelineS) TestAddAccumulatesCounters(c *C) {
+	config.AtomicCounters = true
+	defer func() { config.AtomicCounters = config.DEFAULT_ATOMIC_COUNTERS }()
+
+	for i := 0; i < 3; i++ {
+		event := NewWeightedEvent("src", "counted.requests", 5, 2)
+		event.Type = config.METRIC_TYPE_COUNTER
+		s.timeline.Add(event)
+	}
+	event := NewEvent("src", "limited.requests", 5)
+	event.Type = config.METRIC_TYPE_COUNTER
+	s.timeline.Add(event)
+
+	sets := s.timeline.ExtractClosedSampleSets(true)
+	c.Assert(len(sets), Equals, 4)
+	for _, set := range sets {
+		if set.Name == "counted.requests" {
+			c.Check(set.Accumulated, Equals, true)
+			c.Check(set.Values, Equals, []int{})
+			c.Check(set.Total, Equals, int64(30))
+			c.Check(set.Count, Equals, int64(6))
+		} else {
+			c.Check(set.Accumulated, Equals, false)
+			c.Check(set.Values, Equals, []int{5})
+		}
+	}
+}
+
+func (s *TimelineS) TestAddKeepsLatestGauges(c *C) {
+	config.LatestGauges = true
+	config.TypeWriters = map[string][]string{config.METRIC_TYPE_GAUGE: []string{"last"}}
+	defer func() {
+		config.LatestGauges = config.DEFAULT_LATEST_GAUGES
+		config.TypeWriters = config.DEFAULT_TYPE_WRITERS
+	}()
+
+	for _, value := range []int{5, 7, 6} {
+		event := NewEvent("src", "queue.depth", value)
+		event.Type = config.METRIC_TYPE_GAUGE
+		s.timeline.Add(event)
+	}
+	s.timeline.Add(NewEvent("src", "untyped", 5))
+
+	sets := s.timeline.ExtractClosedSampleSets(true)
+	c.Assert(len(sets), Equals, 4)
+	for _, set := range sets {
+		if set.Name == "queue.depth" {
+			c.Check(set.Latest, Equals, true)
+			c.Check(len(set.Values), Equals, 0)
+			c.Check(set.Last, Equals, 6)
+		} else {
+			c.Check(set.Latest, Equals, false)
+			c.Check(set.Values, Equals, []int{5})
+		}
+	}
+}
+
+func (s *TimelineS) TestAddSummarizesMetrics(c *C) {
+	config.SetMetrics([]*config.MetricConfig{
+		&config.MetricConfig{Pattern: "summarized", Storage: config.STORAGE_SUMMARY, Writers: []string{"sum", "sketch"}},
+		&config.MetricConfig{Pattern: "mixed", Storage: config.STORAGE_SUMMARY, Writers: []string{"sum", "count"}},
+	})
+	defer config.SetMetrics(nil)
+
+	for _, value := range []int{5, -3, 12} {
+		s.timeline.Add(NewWeightedEvent("src", "summarized", value, 2))
+		s.timeline.Add(NewEvent("src", "mixed", value))
+	}
+
+	sets := s.timeline.ExtractClosedSampleSets(true)
+	c.Assert(len(sets), Equals, 4)
+	for _, set := range sets {
+		if set.Name == "summarized" {
+			c.Assert(set.Summary, Not(IsNil))
+			c.Check(len(set.Values), Equals, 0)
+			c.Check(set.Summary.Count, Equals, int64(6))
+			c.Check(set.Summary.Total, Equals, int64(28))
+			c.Check(set.Summary.Min, Equals, -3)
+			c.Check(set.Summary.Max, Equals, 12)
+			c.Check(set.Last, Equals, 12)
+		} else {
+			c.Check(set.Summary, IsNil)
+			c.Check(set.Values, Equals, []int{5, -3, 12})
+		}
+	}
+}
+
+func (s *TimelineS) TestForcedExtractionDropsPartialSlice(c *C) {
+	config.PartialPolicy = config.PARTIAL_POLICY_DROP
+	defer func() { config.PartialPolicy = config.DEFAULT_PARTIAL_POLICY }()
+	s.addAt(1, NewEvent("src", "metric", 10))
+	s.timeline.Add(NewEvent("src", "metric", 20))
+
+	slices := s.timeline.ExtractClosedSlices(true)
+	c.Assert(len(slices), Equals, 1)
+	c.Check(slices[0].Time, Equals, int64(10))
+	c.Check(s.timeline.DroppedSets, Equals, int64(2))
+	c.Check(len(s.timeline.Slices), Equals, 0)
+}
+
+func (s *TimelineS) TestForcedExtractionMarksPartialSlice(c *C) {
+	config.PartialPolicy = config.PARTIAL_POLICY_MARK
+	defer func() { config.PartialPolicy = config.DEFAULT_PARTIAL_POLICY }()
+	s.addAt(1, NewEvent("src", "metric", 10))
+	event := NewEvent("src", "metric", 20)
+	event.Tags = Tags{"host": "a"}
+	s.timeline.Add(event)
+
+	sets := s.timeline.ExtractClosedSampleSets(true)
+	c.Assert(len(sets), Equals, 4)
+	for _, set := range sets {
+		if set.Time == 10 {
+			c.Check(set.Partial, Equals, false)
+			c.Check(set.Tags, IsNil)
+		} else {
+			c.Check(set.Partial, Equals, true)
+			c.Check(set.Tags, Equals, Tags{"host": "a", PARTIAL_TAG: "true"})
+		}
+	}
+	// Tags of the event are not modified
+	c.Check(event.Tags, Equals, Tags{"host": "a"})
+}
+
+func (s *TimelineS) TestClockPicksSlices(c *C) {
+	defer func(clock func() int64) { Clock = clock }(Clock)
+	now := int64(1005)
+	Clock = func() int64 { return now }
+	s.timeline.Add(NewEvent("src", "metric", 10))
+	c.Check(s.timeline.OldestSliceTime(), Equals, int64(1000))
+	c.Check(len(s.timeline.ExtractClosedSlices(false)), Equals, 0)
+
+	now = 1010
+	slices := s.timeline.ExtractClosedSlices(false)
+	c.Assert(len(slices), Equals, 1)
+	c.Check(slices[0].Time, Equals, int64(1000))
+}
+
+func (s *TimelineS) TestSnapshotCurrent(c *C) {
+	defer func(clock func() int64) { Clock = clock }(Clock)
+	now := int64(1005)
+	Clock = func() int64 { return now }
+	s.timeline.Add(NewEvent("src", "metric", 10))
+	slice := s.timeline.SnapshotCurrent()
+	c.Check(slice.Time, Equals, int64(1000))
+	c.Assert(len(slice.Sets), Equals, 1)
+
+	// The copy is not affected by later events
+	s.timeline.Add(NewEvent("src", "metric", 20))
+	for _, set := range slice.Sets {
+		c.Check(set.Values, Equals, []int{10})
+		c.Check(set.Partial, Equals, true)
+		c.Check(set.Tags, Equals, Tags{PARTIAL_TAG: "true"})
+	}
+
+	now = 1010
+	slice = s.timeline.SnapshotCurrent()
+	c.Check(slice.Time, Equals, int64(1010))
+	c.Check(len(slice.Sets), Equals, 0)
+}
+
+func (s *TimelineS) TestClockSteppingBackwards(c *C) {
+	defer func(clock func() int64) { Clock = clock }(Clock)
+	now := int64(1025)
+	Clock = func() int64 { return now }
+	s.timeline.Add(NewEvent("src", "metric", 10))
+	now = 1030
+	c.Assert(len(s.timeline.ExtractClosedSlices(false)), Equals, 1)
+
+	// Events are added to the latest slice until the clock catches up
+	now = 1015
+	s.timeline.Add(NewEvent("src", "metric", 20))
+	s.timeline.Add(NewEvent("src", "metric", 30))
+	c.Check(s.timeline.ClockSteps, Equals, int64(1))
+	c.Check(s.timeline.OldestSliceTime(), Equals, int64(1030))
+	c.Check(len(s.timeline.ExtractClosedSlices(false)), Equals, 0)
+
+	now = 1040
+	slices := s.timeline.ExtractClosedSlices(false)
+	c.Assert(len(slices), Equals, 1)
+	c.Check(slices[0].Time, Equals, int64(1030))
+	for _, set := range slices[0].Sets {
+		c.Check(set.Values, Equals, []int{20, 30})
+	}
+
+	config.ClockPolicy = config.CLOCK_POLICY_FOLLOW
+	defer func() { config.ClockPolicy = config.DEFAULT_CLOCK_POLICY }()
+	now = 1015
+	s.timeline.Add(NewEvent("src", "metric", 40))
+	c.Check(s.timeline.ClockSteps, Equals, int64(2))
+	c.Check(s.timeline.OldestSliceTime(), Equals, int64(1010))
+}
+
+func (s *TimelineS) TestAddInPressureMode(c *C) {
+	for i := 0; i < 10; i++ {
+		s.addAt(1, NewEvent("src", "metric", i))
+		s.addAt(1, NewEvent("src", "limited.metric", i))
+	}
+	// Sets of both sources are shrunk, sets below the limit are intact
+	c.Check(s.timeline.Shed(5), Equals, 2)
+	c.Check(PressureShed, Equals, int64(10))
+	PressureShed = 0
+
+	SetPressureLimit(5)
+	defer SetPressureLimit(0)
+	s.timeline.Add(NewEvent("src", "other", 1))
+	for i := 0; i < 10; i++ {
+		s.timeline.Add(NewEvent("src", "other", i))
+	}
+	for _, set := range s.timeline.ExtractClosedSampleSets(true) {
+		switch set.Name {
+		case "limited.metric":
+			// Stricter per-metric limit is kept
+			c.Check(len(set.Values), Equals, 2)
+		default:
+			c.Check(len(set.Values), Equals, 5)
+		}
+	}
+}
+
+func (s *TimelineS) TestAddCoalesced(c *C) {
+	for _, value := range []int{3, 3, 3, 5, 3} {
+		s.addAt(1, NewEvent("src", "coalesced.metric", value))
+	}
+	for _, set := range s.timeline.ExtractClosedSampleSets(true) {
+		c.Check(set.Values, Equals, []int{3, 5, 3})
+		c.Check(set.Weights, Equals, []int{3, 1, 1})
+	}
+	// Sets of the source and "all"
+	c.Check(CoalescedValues, Equals, int64(4))
+	CoalescedValues = 0
+}
+
+// transforms returns the pipeline of the given transform specs.
+func transforms(c *C, specs ...string) (pipeline config.TransformPipeline) {
+	for _, spec := range specs {
+		transform, err := config.ParseTransform(spec)
+		c.Assert(err, IsNil)
+		pipeline = append(pipeline, transform)
+	}
+	return
+}
+
+func (s *TimelineS) TestAddTransformsValues(c *C) {
+	config.SetMetrics([]*config.MetricConfig{
+		&config.MetricConfig{Pattern: "latency", Transforms: transforms(c, "abs", "scale:0.001", "clamp:0:5")},
+		&config.MetricConfig{Pattern: "size", Transforms: transforms(c, "log", "scale:100")},
+	})
+	for _, value := range []int{-1500, 2400, 9000} {
+		s.timeline.Add(NewEvent("src", "latency", value))
+	}
+	for _, value := range []int{1000, 0, -10} {
+		s.timeline.AddAt(NewEvent("src", "size", value), 1313049600)
+	}
+	event := NewEvent("src", "other", -5)
+	s.timeline.Add(event)
+	c.Check(s.timeline.FilteredOut, Equals, int64(2))
+
+	for _, set := range s.timeline.ExtractClosedSampleSets(true) {
+		switch set.Name {
+		case "latency":
+			c.Check(set.Values, Equals, []int{2, 2, 5})
+		case "size":
+			c.Check(set.Values, Equals, []int{300})
+		default:
+			c.Check(set.Values, Equals, []int{-5})
+		}
+	}
+	c.Check(event.Value, Equals, -5)
+}
+
+func (s *TimelineS) TestAddTransformsVectorValues(c *C) {
+	config.SetMetrics([]*config.MetricConfig{
+		&config.MetricConfig{Pattern: "size", Transforms: transforms(c, "log", "scale:100")},
+	})
+	event := NewVectorEvent("src", "size", []int{0, 1000, -10, 100})
+	s.timeline.Add(event)
+	s.timeline.Add(NewVectorEvent("src", "size", []int{0, -10}))
+	c.Check(s.timeline.FilteredOut, Equals, int64(4))
+
+	sets := s.timeline.ExtractClosedSampleSets(true)
+	c.Assert(len(sets), Equals, 2)
+	c.Check(sets[0].Values, Equals, []int{300, 200})
+	c.Check(event.Values, Equals, []int{1000, -10, 100})
+}
+
+func (s *TimelineS) TestParseTransform(c *C) {
+	_, err := config.ParseTransform("round")
+	c.Check(err, Not(IsNil))
+	_, err = config.ParseTransform("clamp:5:0")
+	c.Check(err, Not(IsNil))
+	_, err = config.ParseTransform("scale:x")
+	c.Check(err, Not(IsNil))
+
+	config.RegisterTransform("negate", func(args []float64) (config.TransformFunc, os.Error) {
+		return func(value float64) (float64, bool) { return -value, true }, nil
+	})
+	value, ok := transforms(c, "negate").Apply(7)
+	c.Check(ok, Equals, true)
+	c.Check(value, Equals, -7)
+}
+
+func (s *TimelineS) TestClosedSliceCount(c *C) {
+	c.Check(s.timeline.ClosedSliceCount(), Equals, 0)
+	s.addAt(1, NewEvent("src", "metric", 10))
+	s.addAt(2, NewEvent("src", "metric", 10))
+	s.timeline.Add(NewEvent("src", "metric", 10))
+	c.Check(s.timeline.ClosedSliceCount(), Equals, 2)
+	s.timeline.ExtractClosedSlices(false)
+	c.Check(s.timeline.ClosedSliceCount(), Equals, 0)
+}
+
+func (s *TimelineS) TestForceCloseOldest(c *C) {
+	s.addAt(3, NewEvent("all", "metric", 30))
+	s.addAt(1, NewEvent("all", "metric", 10))
+	s.timeline.Add(NewEvent("all", "metric", 40))
+	sets := s.timeline.ForceCloseOldest(1)
+	c.Check(len(sets), Equals, 1)
+	c.Check(sets[0].Time, Equals, int64(10))
+	c.Check(len(s.timeline.Slices), Equals, 2)
+
+	sets = s.timeline.ForceCloseOldest(1)
+	c.Check(len(sets), Equals, 1)
+	c.Check(sets[0].Time, Equals, int64(30))
+	c.Check(len(s.timeline.Slices), Equals, 1)
+
+	// Current slice is never extracted
+	c.Check(len(s.timeline.ForceCloseOldest(5)), Equals, 0)
+	c.Check(len(s.timeline.Slices), Equals, 1)
+	s.timeline.Add(NewEvent("all", "metric", 50))
+	sets = s.timeline.ExtractClosedSampleSets(true)
+	c.Check(len(sets), Equals, 1)
+	c.Check(sets[0].Values, Equals, []int{40, 50})
+}
+
+func (s *TimelineS) TestExtractClosedSampleSetsExpiresStateWithTTL(c *C) {
+	config.StateTTL = 1
+	defer func() { config.StateTTL = config.DEFAULT_STATE_TTL }()
+
+	s.addAt(1, NewEvent("all", "carried.metric", 10))
+	s.addAt(5, NewEvent("all", "other.metric", 20))
+	sets := s.timeline.ExtractClosedSampleSets(false)
+	// Only slice 2 is within TTL (staleness is 20 seconds)
+	c.Check(len(sets), Equals, 3)
+	c.Check(sets[1].String(), Equals, "SampleSet[source=all, name=carried.metric, time=20, size=1]")
+	c.Check(len(s.timeline.tracked), Equals, 0)
+}
+
+func (s *TimelineS) TestReleaseRecyclesSlices(c *C) {
+	s.timeline.Recycle = true
+	s.timeline.AddAt(NewEvent("src", "metric", 10), 100)
+	closed := s.timeline.ExtractClosedSlices(true)
+	c.Assert(closed, HasLen, 1)
+	slice, set := closed[0], closed[0].Sets["src-metric"]
+
+	s.timeline.Release(closed)
+	c.Check(slice.Sets, HasLen, 0)
+	c.Check(set.Name, Equals, "")
+	c.Check(set.Values, HasLen, 0)
+	c.Check(s.timeline.pool.slices, HasLen, 1)
+	c.Check(s.timeline.pool.sets, HasLen, 2)
+
+	// Released slices are ignored
+	s.timeline.Release(closed)
+	c.Check(s.timeline.pool.slices, HasLen, 1)
+
+	s.timeline.AddAt(NewEvent("src", "other", 20), 200)
+	c.Check(s.timeline.Slices[20], Equals, slice)
+	c.Check(s.timeline.Slices[20].Time, Equals, int64(200))
+	c.Check(s.timeline.Slices[20].Sets["all-other"].Values, Equals, []int{20})
+	c.Check(s.timeline.pool.sets, HasLen, 0)
+}
+
+func (s *TimelineS) TestReleaseWithoutRecycle(c *C) {
+	s.timeline.AddAt(NewEvent("src", "metric", 10), 100)
+	closed := s.timeline.ExtractClosedSlices(true)
+	s.timeline.Release(closed)
+	c.Check(closed[0].Sets["src-metric"].Values, Equals, []int{10})
+	c.Check(s.timeline.pool.slices, HasLen, 0)
+}
+
+func (s *TimelineS) TestRecycleReducesAllocations(c *C) {
+	allocations := func(recycle bool) uint64 {
+		timeline := NewTimeline(10)
+		timeline.Recycle = recycle
+		events := benchmarkEvents(100)
+		runtime.UpdateMemStats()
+		before := runtime.MemStats.Mallocs
+		for number := int64(0); number < 100; number++ {
+			for _, event := range events {
+				timeline.AddAt(event, number*10)
+			}
+			timeline.Release(timeline.ExtractClosedSlices(true))
+		}
+		runtime.UpdateMemStats()
+		return runtime.MemStats.Mallocs - before
+	}
+	fresh, recycled := allocations(false), allocations(true)
+	if recycled >= fresh {
+		c.Errorf("Expected less allocations with recycling: %d recycled, %d fresh", recycled, fresh)
+	}
+}
+
+func (s *TimelineS) TestAddToShardedTimeline(c *C) {
+	defer func(clock func() int64) { Clock = clock }(Clock)
+	now := int64(1005)
+	Clock = func() int64 { return now }
+	timeline := NewShardedTimeline(10, 3)
+	for value := 1; value <= 6; value++ {
+		timeline.Add(NewEvent("src", "metric", value))
+	}
+	timeline.Add(NewEvent("src", "limited.metric", 1))
+	c.Check(timeline.Slices[100].Sets["src-metric"].Values, Equals, []int{3, 6})
+	snapshot := timeline.Snapshot()
+	c.Assert(len(snapshot.Slices), Equals, 1)
+	current := snapshot.Slices[0]
+	c.Check(len(current.Sets["src-metric"].Values), Equals, 6)
+	c.Check(current.Sets["src-limited.metric"].Values, Equals, []int{1})
+
+	now = 1010
+	c.Check(timeline.ClosedSliceCount(), Equals, 1)
+	c.Check(timeline.OldestSliceTime(), Equals, int64(1000))
+	slices := timeline.ExtractClosedSlices(false)
+	c.Assert(len(slices), Equals, 1)
+	set := slices[0].Sets["src-metric"]
+	set.Sort()
+	c.Check(set.Values, Equals, []int{1, 2, 3, 4, 5, 6})
+	c.Check(slices[0].Sets["src-limited.metric"].Values, Equals, []int{1})
+	c.Check(timeline.ClosedSliceCount(), Equals, 0)
+	c.Check(timeline.OldestSliceTime(), Equals, int64(0))
+}
+
+func (s *TimelineS) TestAddAccumulatesCountersOfShardedTimeline(c *C) {
+	config.AtomicCounters = true
+	defer func() { config.AtomicCounters = config.DEFAULT_ATOMIC_COUNTERS }()
+	defer func(clock func() int64) { Clock = clock }(Clock)
+	Clock = func() int64 { return 1005 }
+
+	timeline := NewShardedTimeline(10, 3)
+	for i := 0; i < 6; i++ {
+		event := NewEvent("src", "counted.requests", 5)
+		event.Type = config.METRIC_TYPE_COUNTER
+		timeline.Add(event)
+	}
+	// Sample sets are not created in shards, so all events are accumulated
+	set := timeline.Slices[100].Sets["src-counted.requests"]
+	c.Check(set.Accumulated, Equals, true)
+	c.Check(set.Total, Equals, int64(30))
+	c.Check(set.Count, Equals, int64(6))
+	for _, shard := range timeline.shards {
+		c.Check(len(shard.slices), Equals, 0)
+	}
+}
@@ -0,0 +1,158 @@
+package web
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"metricsd/config"
+	"github.com/hoisie/web.go"
+)
+
+// Header carrying the admin token (see config.AdminToken).
+const adminTokenHeader = "X-Admin-Token"
+
+// authorized returns true when the request carries the admin token, and
+// responds with 403 Forbidden otherwise. Requests are rejected when the
+// token is not configured.
+func authorized(ctx *web.Context) bool {
+	if config.AdminToken == "" {
+		ctx.Abort(403, "Admin token is not configured (see AdminToken option)\n")
+		return false
+	}
+	token := ctx.Request.Headers.Get(adminTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) != 1 {
+		ctx.Abort(403, fmt.Sprintf("Invalid admin token (%s header)\n", adminTokenHeader))
+		return false
+	}
+	return true
+}
+
+// rrdFile returns the path of the RRD file of the writer for the metric of
+// the source, the same as used to render graphs. Returns false when any of
+// the parts could escape DataDir.
+func rrdFile(source, metric, writer string) (string, bool) {
+	for _, part := range []string{source, metric, writer} {
+		if part == "" || strings.Contains(part, "..") || strings.Contains(part, "/") {
+			return "", false
+		}
+	}
+	return fmt.Sprintf("%s/%s/%s-%s.rrd", config.DataDir, source, metric, writer), true
+}
+
+// dumpRrd streams the RRD file of the writer for the metric of the source
+// in XML format (see rrdtool dump).
+func dumpRrd(ctx *web.Context, source, metric, writer string) {
+	if !authorized(ctx) {
+		return
+	}
+	file, ok := rrdFile(source, metric, writer)
+	if !ok {
+		ctx.Abort(400, "Invalid RRD file path\n")
+		return
+	}
+	if _, err := os.Stat(file); err != nil {
+		ctx.Abort(404, fmt.Sprintf("RRD file %s/%s-%s.rrd does not exist\n", source, metric, writer))
+		return
+	}
+	ctx.SetHeader("Content-Type", "application/xml", true)
+	if err := rrdtool(ctx, false, "dump", file); err != nil {
+		config.Logger.Error("Cannot dump %s: %s", file, err)
+	}
+}
+
+// restoreRrd creates the RRD file of the writer for the metric of the
+// source from XML in the request body (see rrdtool restore). Existing files
+// are replaced only with force=true parameter.
+func restoreRrd(ctx *web.Context, source, metric, writer string) string {
+	if !authorized(ctx) {
+		return ""
+	}
+	file, ok := rrdFile(source, metric, writer)
+	if !ok {
+		ctx.Abort(400, "Invalid RRD file path\n")
+		return ""
+	}
+	force := ctx.Request.Params["force"] == "true"
+	if _, err := os.Stat(file); err == nil && !force {
+		ctx.Abort(409, fmt.Sprintf("RRD file %s/%s-%s.rrd exists, use force=true to replace it\n", source, metric, writer))
+		return ""
+	}
+
+	// RRDTool reads XML from a file, so the body is saved to DataDir first
+	dump, err := ioutil.TempFile(config.DataDir, "restore-")
+	if err != nil {
+		ctx.Abort(500, fmt.Sprintf("Cannot save XML dump: %s\n", err))
+		return ""
+	}
+	defer os.Remove(dump.Name())
+	_, err = io.Copy(dump, ctx.Request.Body)
+	dump.Close()
+	if err != nil {
+		ctx.Abort(500, fmt.Sprintf("Cannot save XML dump: %s\n", err))
+		return ""
+	}
+	if err = RestoreRrd(dump.Name(), file, force); err != nil {
+		config.Logger.Error("Cannot restore %s: %s", file, err)
+		ctx.Abort(500, fmt.Sprintf("Cannot restore RRD file: %s\n", err))
+		return ""
+	}
+	config.Logger.Warn("RRD file %s has been restored from XML dump", file)
+	ctx.SetHeader("Content-Type", "text/plain", true)
+	return "OK\n"
+}
+
+// RestoreRrd creates the RRD file from the XML dump (see rrdtool
+// restore), creating its directory if necessary. Existing file is replaced
+// only when force is true.
+func RestoreRrd(dump, file string, force bool) os.Error {
+	if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+		return err
+	}
+	args := []string{"restore"}
+	if force {
+		args = append(args, "-f")
+	}
+	output := &bytes.Buffer{}
+	if err := rrdtool(output, true, append(args, dump, file)...); err != nil {
+		return os.NewError(fmt.Sprintf("%s: %s", err, strings.TrimSpace(output.String())))
+	}
+	return nil
+}
+
+// rrdtool runs rrdtool command with the given arguments, copying its
+// standard output (and standard error, when stderr is true) to out.
+// Returns an error when the command fails.
+func rrdtool(out io.Writer, stderr bool, args ...string) os.Error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	files := []*os.File{nil, w, nil}
+	if stderr {
+		files[2] = w
+	}
+	attr := &os.ProcAttr{Dir: "", Env: os.Environ(), Files: files}
+	process, err := os.StartProcess(config.RrdtoolPath, append([]string{config.RrdtoolPath}, args...), attr)
+	w.Close()
+	if err != nil {
+		r.Close()
+		return err
+	}
+	defer process.Release()
+	io.Copy(out, r)
+	r.Close()
+
+	wait, err := process.Wait(0)
+	if err != nil {
+		return err
+	}
+	if !wait.Exited() || wait.ExitStatus() != 0 {
+		return os.NewError(fmt.Sprintf("rrdtool %s failed: %v", args[0], wait))
+	}
+	return nil
+}
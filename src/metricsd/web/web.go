@@ -1,19 +1,41 @@
 package web
 
 import (
+	"bytes"
+	"expvar"
 	"fmt"
 	"io"
+	"json"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"metricsd/config"
+	"metricsd/writers"
 	"github.com/hoisie/web.go"
 	"github.com/hoisie/mustache.go"
 )
 
+var (
+	router *writers.Router
+)
+
+var (
+	// Returns an error when MetricsD should be restarted (see /healthz)
+	HealthCheck func() os.Error
+	// Returns an error when MetricsD is not ready to receive events yet
+	// (see /ready)
+	ReadinessCheck func() os.Error
+	// Returns an error when MetricsD works in degraded mode, which does not
+	// require restart (see /healthz)
+	DegradedCheck func() os.Error
+)
+
 /***** Web routines ***********************************************************/
 
-func Start() {
+func Start(r *writers.Router) {
+	router = r
+
 	web.Config.StaticDir = path.Join(config.RootDir, "public")
 	web.Get("/", summary)
 	web.Get("/metric/(.*)/(.*)/(.*)", metric_graph)
@@ -22,6 +44,19 @@ func Start() {
 	web.Get("/graph/(.*)/(.*)/(.*)\\.png", graph)
 	web.Get("/graph/(.*)/(.*)/(.*)", graph)
 	web.Get("/host/(.*)", host)
+	web.Get("/metrics", prometheus)
+	web.Get("/metric", latestRollups)
+	web.Get("/admin/denylist", denylist)
+	web.Post("/admin/denylist/(.*)", deny)
+	web.Delete("/admin/denylist/(.*)", allow)
+	web.Get("/admin/errors", updateErrors)
+	web.Get("/admin/closed", closedSlice)
+	web.Post("/admin/flush", flush)
+	web.Get("/admin/rrd/(.*)/(.*)/(.*)", dumpRrd)
+	web.Post("/admin/rrd/(.*)/(.*)/(.*)", restoreRrd)
+	web.Get("/healthz", healthz)
+	web.Get("/ready", ready)
+	web.Get("/debug/vars", debugVars)
 	web.Run(config.Listen)
 }
 
@@ -61,6 +96,61 @@ func metric_graph(metric, source, writer string) string {
 	})
 }
 
+// prometheus exports the most recent rollups in Prometheus text format,
+// followed by partial rollups of current slices when live parameter is
+// true (see writers.Router.WriteLivePrometheus).
+func prometheus(ctx *web.Context) {
+	params := struct {
+		Live bool
+	}{}
+	ctx.Request.UnmarshalParams(&params)
+	ctx.SetHeader("Content-Type", "text/plain; version=0.0.4", true)
+	if params.Live {
+		router.WriteLivePrometheus(ctx)
+		return
+	}
+	writers.WritePrometheus(ctx)
+}
+
+// latestRollups responds with the most recent rollups of the metric given
+// by name parameter (of all sources and writers) in JSON, or with recent
+// rollups kept in memory when recent parameter is true (see
+// config.RecentRollups). Partial rollups of current slices are included
+// when live parameter is true, unless recent rollups are requested.
+func latestRollups(ctx *web.Context) string {
+	params := struct {
+		Name   string
+		Recent bool
+		Live   bool
+	}{}
+	ctx.Request.UnmarshalParams(&params)
+	if params.Name == "" {
+		ctx.Abort(400, "Metric name is required (name parameter)\n")
+		return ""
+	}
+	buffer := &bytes.Buffer{}
+	write := writers.WriteLatestRollups
+	if params.Recent {
+		write = writers.WriteRecentRollups
+	} else if params.Live {
+		write = func(w io.Writer, name string) (int, os.Error) {
+			return router.WriteLiveRollups(w, name)
+		}
+	}
+	count, err := write(buffer, params.Name)
+	if err != nil {
+		config.Logger.Error("Cannot encode rollups of %s: %s", params.Name, err)
+		ctx.Abort(500, fmt.Sprintf("Cannot encode rollups of %s: %s\n", params.Name, err))
+		return ""
+	}
+	if count == 0 {
+		ctx.Abort(404, fmt.Sprintf("No rollups of %s\n", params.Name))
+		return ""
+	}
+	ctx.SetHeader("Content-Type", "application/json", true)
+	return buffer.String()
+}
+
 func graph(ctx *web.Context, source, metric, writer string) {
 	ctx.SetHeader("Content-Type", "image/png", true)
 
@@ -109,17 +199,21 @@ func graph(ctx *web.Context, source, metric, writer string) {
 
 	rrd_file := fmt.Sprintf("%s/%s/%s-%s.rrd", config.DataDir, source, metric, writer)
 	args := mustache.RenderFile(template("writers/"+writer), map[string]interface{}{
-		"source":   source,
-		"metric":   metric,
-		"writer":   writer,
-		"rrd_file": rrd_file,
-		"start":    params.Start,
-		"end":      params.End,
-		"width":    params.Width,
-		"height":   params.Height,
-		"rra":      params.Rra,
-		"interval": config.SliceInterval,
-		"dark":     params.Dark,
+		"source":    source,
+		"metric":    metric,
+		"writer":    writer,
+		"rrdtool":   config.RrdtoolPath,
+		"rrd_file":  rrd_file,
+		"start":     params.Start,
+		"end":       params.End,
+		"width":     params.Width,
+		"height":    params.Height,
+		"rra":       params.Rra,
+		"interval":  config.SliceInterval,
+		"dark":      params.Dark,
+		"buckets":   histogramBuckets(),
+		"classes":   classLabels(),
+		"quantiles": sketchQuantiles(),
 	})
 	r, w, err := os.Pipe()
 	if err != nil {
@@ -129,7 +223,14 @@ func graph(ctx *web.Context, source, metric, writer string) {
 
 	// config.Logger.Debug("started, %s", strings.Split(args, "\n", -1))
 	attr := &os.ProcAttr{Dir: "", Env: os.Environ(), Files: []*os.File{nil, w, w}}
-	process, err := os.StartProcess("/usr/bin/rrdtool", strings.Split(args, "\n"), attr)
+	process, err := os.StartProcess(config.RrdtoolPath, rrdtoolArgs(args), attr)
+	if err != nil {
+		w.Close()
+		r.Close()
+		config.Logger.Error("StartProcess: %s", err)
+		ctx.Abort(500, fmt.Sprintf("Cannot start rrdtool: %s\n", err))
+		return
+	}
 	defer process.Release()
 	w.Close()
 	io.Copy(ctx, r)
@@ -147,8 +248,195 @@ func graph(ctx *web.Context, source, metric, writer string) {
 	return
 }
 
+/***** Admin routines *********************************************************/
+
+// denylist returns the list of metrics being dropped on ingestion (in all
+// timelines), one per line.
+func denylist(ctx *web.Context) string {
+	ctx.SetHeader("Content-Type", "text/plain", true)
+	names := make([]string, 0)
+	for _, route := range router.Routes {
+		names = append(names, route.Timeline.DeniedNames()...)
+	}
+	sort.Strings(names)
+	return strings.Join(names, "\n") + "\n"
+}
+
+// deny stops ingestion of the given metric without a restart.
+func deny(ctx *web.Context, metric string) string {
+	ctx.SetHeader("Content-Type", "text/plain", true)
+	router.Route(metric).Timeline.Deny(metric)
+	config.Logger.Warn("Metric %s has been added to denylist", metric)
+	return "OK\n"
+}
+
+// allow resumes ingestion of the given metric.
+func allow(ctx *web.Context, metric string) string {
+	ctx.SetHeader("Content-Type", "text/plain", true)
+	router.Route(metric).Timeline.Allow(metric)
+	config.Logger.Warn("Metric %s has been removed from denylist", metric)
+	return "OK\n"
+}
+
+// updateErrors returns the list of metrics with failed RRD updates, along
+// with the number of failures, one per line.
+func updateErrors(ctx *web.Context) string {
+	ctx.SetHeader("Content-Type", "text/plain", true)
+	names := writers.UpdateErrorNames()
+	if len(names) == 0 {
+		return ""
+	}
+	return strings.Join(names, "\n") + "\n"
+}
+
+// closedSlice returns sample sets of the most recent closed slices of all
+// timelines in JSON (see writers.Router.SnapshotClosed).
+func closedSlice(ctx *web.Context) {
+	ctx.SetHeader("Content-Type", "application/json", true)
+	if err := json.NewEncoder(ctx).Encode(router.SnapshotClosed()); err != nil {
+		config.Logger.Error("Cannot encode closed slice: %s", err)
+	}
+}
+
+// flush writes closed slices immediately, without waiting for the write
+// interval to elapse.
+func flush(ctx *web.Context) string {
+	ctx.SetHeader("Content-Type", "text/plain", true)
+	if err := router.FlushClosed(); err != nil {
+		ctx.Abort(500, fmt.Sprintf("Cannot flush closed slices: %s\n", err))
+		return ""
+	}
+	return "OK\n"
+}
+
+// debugVars responds with exported variables (see expvar package) in JSON
+// format, as /debug/vars of the standard HTTP server does.
+func debugVars(ctx *web.Context) string {
+	ctx.SetHeader("Content-Type", "application/json; charset=utf-8", true)
+	buffer := &bytes.Buffer{}
+	buffer.WriteString("{\n")
+	first := true
+	for kv := range expvar.Iter() {
+		if !first {
+			buffer.WriteString(",\n")
+		}
+		first = false
+		fmt.Fprintf(buffer, "%q: %s", kv.Key, kv.Value)
+	}
+	buffer.WriteString("\n}\n")
+	return buffer.String()
+}
+
+/***** Probes *****************************************************************/
+
+// healthz responds with 200 OK when MetricsD is alive, or 503 Service
+// Unavailable when it should be restarted (see HealthCheck). When MetricsD
+// is alive, but works in degraded mode, the response is 200 DEGRADED with
+// the reason (see DegradedCheck).
+func healthz(ctx *web.Context) string {
+	response := probe(ctx, HealthCheck)
+	if response == "" || DegradedCheck == nil {
+		return response
+	}
+	if err := DegradedCheck(); err != nil {
+		return "DEGRADED: " + err.String() + "\n"
+	}
+	return response
+}
+
+// ready responds with 200 OK when MetricsD is ready to receive events, or
+// 503 Service Unavailable otherwise (see ReadinessCheck).
+func ready(ctx *web.Context) string {
+	return probe(ctx, ReadinessCheck)
+}
+
+// probe responds with the result of the check (missing checks pass).
+func probe(ctx *web.Context, check func() os.Error) string {
+	ctx.SetHeader("Content-Type", "text/plain", true)
+	if check != nil {
+		if err := check(); err != nil {
+			ctx.Abort(503, err.String()+"\n")
+			return ""
+		}
+	}
+	return "OK\n"
+}
+
 /***** Helper functions *******************************************************/
 
+// histogramBuckets returns the list of histogram buckets with colors to
+// render histogram graphs.
+func histogramBuckets() []map[string]interface{} {
+	colors := []string{"00CF00", "96E78A", "FFD966", "FF897C", "CC3525", "8F2A8F", "4D4D4D"}
+	names := writers.HistogramDataSources()
+	buckets := make([]map[string]interface{}, len(names))
+	for idx, name := range names {
+		buckets[idx] = map[string]interface{}{
+			"name":  name,
+			"color": colors[idx%len(colors)],
+			"first": idx == 0,
+		}
+	}
+	return buckets
+}
+
+// classLabels returns the list of classes writer labels with colors to
+// render classes graphs.
+func classLabels() []map[string]interface{} {
+	colors := []string{"00CF00", "FFD966", "FF897C", "CC3525", "8F2A8F", "4D4D4D"}
+	names := writers.ClassesDataSources()
+	classes := make([]map[string]interface{}, len(names))
+	for idx, name := range names {
+		classes[idx] = map[string]interface{}{
+			"name":  name,
+			"color": colors[idx%len(colors)],
+			"first": idx == 0,
+		}
+	}
+	return classes
+}
+
+// sketchQuantiles returns the list of sketch writer quantiles with colors
+// to render sketch graphs.
+func sketchQuantiles() []map[string]interface{} {
+	colors := []string{"157419", "FFD966", "FF897C", "CC3525", "8F2A8F", "4D4D4D"}
+	names := writers.SketchDataSources()
+	quantiles := make([]map[string]interface{}, len(names))
+	for idx, name := range names {
+		quantiles[idx] = map[string]interface{}{
+			"name":  name,
+			"color": colors[idx%len(colors)],
+		}
+	}
+	return quantiles
+}
+
+// rrdtoolArgs splits rendered graph template into rrdtool arguments, and
+// inserts RrdtoolArgs after the command and output file name.
+func rrdtoolArgs(rendered string) []string {
+	args := strings.Split(rendered, "\n")
+	if len(config.RrdtoolArgs) == 0 || len(args) < 3 {
+		return args
+	}
+	result := make([]string, 0, len(args)+len(config.RrdtoolArgs))
+	result = append(result, args[:3]...)
+	result = append(result, config.RrdtoolArgs...)
+	return append(result, args[3:]...)
+}
+
+// CheckRrdtool returns an error when rrdtool binary used to render graphs
+// does not exist or is not executable.
+func CheckRrdtool() os.Error {
+	info, err := os.Stat(config.RrdtoolPath)
+	if err != nil {
+		return os.NewError(fmt.Sprintf("rrdtool binary %s is not found: %s", config.RrdtoolPath, err))
+	}
+	if !info.IsRegular() || info.Mode&0111 == 0 {
+		return os.NewError(fmt.Sprintf("rrdtool binary %s is not executable", config.RrdtoolPath))
+	}
+	return nil
+}
+
 func template(name string) string {
 	return path.Join(config.RootDir, fmt.Sprintf("templates/%s.mustache", name))
 }
@@ -0,0 +1,142 @@
+package writers
+
+import (
+	"flag"
+	"math"
+	"rand"
+	"sort"
+	"testing"
+	. "launchpad.net/gocheck"
+	"metricsd/types"
+)
+
+// Maximum relative error of approximate quantiles, could be changed with
+// -quantile.error command line argument.
+var quantileErrorBound = flag.Float64("quantile.error", 0.01, "Maximum relative error of approximate quantiles")
+
+// Number of samples generated for accuracy tests and benchmarks.
+const accuracySamples = 10000
+
+// A distribution generates random values with a known distribution.
+type distribution struct {
+	name     string
+	generate func(r *rand.Rand) int
+}
+
+var distributions = []distribution{
+	{"uniform", func(r *rand.Rand) int {
+		return r.Intn(100000)
+	}},
+	{"normal", func(r *rand.Rand) int {
+		return int(50000 + 10000*r.NormFloat64())
+	}},
+	{"pareto", func(r *rand.Rand) int {
+		// Scale 100, shape 1.5
+		return int(100 / math.Pow(1-r.Float64(), 1/1.5))
+	}},
+}
+
+// A quantileEstimator describes a writer calculating quantiles.
+type quantileEstimator struct {
+	// Writer to test.
+	writer Writer
+	// Maximum relative error (0 means -quantile.error value).
+	bound float64
+	// Quantiles returns calculated quantiles from the writer data item.
+	quantiles func(data dataItem) map[float64]float64
+}
+
+// Writers calculating quantiles. Exact writers have their own bound (they
+// interpolate between nearest ranks), approximate writers are checked
+// against the configured error bound unless it depends on distribution
+// (reservoir error grows with the tail heaviness).
+var quantileEstimators = []quantileEstimator{
+	{&Percentiles{}, 0.005, func(data dataItem) map[float64]float64 {
+		item := data.(*percentilesItem)
+		return map[float64]float64{0.90: float64(item.pct90), 0.95: float64(item.pct95)}
+	}},
+	{&Reservoir{Size: 5000}, 0.1, func(data dataItem) map[float64]float64 {
+		item := data.(*percentilesItem)
+		return map[float64]float64{0.90: float64(item.pct90), 0.95: float64(item.pct95)}
+	}},
+}
+
+type AccuracyS struct{}
+
+var _ = Suite(&AccuracyS{})
+
+func (s *AccuracyS) TestQuantileAccuracy(c *C) {
+	for _, estimator := range quantileEstimators {
+		bound := estimator.bound
+		if bound == 0 {
+			bound = *quantileErrorBound
+		}
+		for _, dist := range distributions {
+			set := createDistributionSampleSet(dist, accuracySamples)
+			exact := make([]int, len(set.Values))
+			copy(exact, set.Values)
+			sort.Ints(exact)
+
+			data := estimator.writer.rollupData(set)
+			for q, estimated := range estimator.quantiles(data) {
+				expected := exactQuantile(exact, q)
+				error := math.Fabs(estimated-expected) / math.Fabs(expected)
+				if error > bound {
+					c.Errorf("%s: %s distribution, quantile %v: expected %v, got %v (error %v > %v)", estimator.writer.Name(), dist.name, q, expected, estimated, error, bound)
+				}
+			}
+		}
+	}
+}
+
+// createDistributionSampleSet returns a sample set with the given number
+// of values generated using the distribution. Random generator is seeded
+// with a constant, so results are reproducible.
+func createDistributionSampleSet(dist distribution, size int) *types.SampleSet {
+	r := rand.New(rand.NewSource(42))
+	set := createSampleSet(1000)
+	for i := 0; i < size; i++ {
+		set.Add(dist.generate(r))
+	}
+	return set
+}
+
+// exactQuantile returns the q-th quantile of sorted values using the
+// nearest rank method.
+func exactQuantile(sorted []int, q float64) float64 {
+	rank := int(math.Ceil(q * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return float64(sorted[rank-1])
+}
+
+// benchmarkQuantileEstimators measures performance of all quantile
+// estimators on the given distribution.
+func benchmarkQuantileEstimators(b *testing.B, idx int) {
+	b.StopTimer()
+	values := createDistributionSampleSet(distributions[idx], accuracySamples).Values
+	set := createSampleSet(1000)
+	set.Values = make([]int, len(values))
+
+	for i := 0; i < b.N; i++ {
+		for _, estimator := range quantileEstimators {
+			copy(set.Values, values)
+			b.StartTimer()
+			estimator.writer.rollupData(set)
+			b.StopTimer()
+		}
+	}
+}
+
+func BenchmarkQuantilesUniform(b *testing.B) {
+	benchmarkQuantileEstimators(b, 0)
+}
+
+func BenchmarkQuantilesNormal(b *testing.B) {
+	benchmarkQuantileEstimators(b, 1)
+}
+
+func BenchmarkQuantilesPareto(b *testing.B) {
+	benchmarkQuantileEstimators(b, 2)
+}
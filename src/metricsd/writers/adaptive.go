@@ -0,0 +1,136 @@
+package writers
+
+import (
+	"math"
+	"sync"
+	"metricsd/config"
+)
+
+const (
+	// Number of inter-arrival times of a series measured before a slice
+	// interval is picked for the metric.
+	adaptiveSamples = 8
+	// Maximum coefficient of variation of inter-arrival times of a regular
+	// series, and the allowed jitter of arrivals.
+	adaptiveTolerance = 0.25
+)
+
+// arrivalStats accumulates inter-arrival times of a series.
+type arrivalStats struct {
+	last       int64   // time of the latest arrival (seconds since epoch)
+	count      int     // number of measured inter-arrival times
+	sum        float64 // sum of inter-arrival times
+	sumSquares float64 // sum of squares of inter-arrival times
+}
+
+// metricArrivals holds arrivals of a metric, which interval has not been
+// picked yet.
+type metricArrivals struct {
+	first  int64                    // time of the first arrival
+	series map[string]*arrivalStats // keyed by source name
+}
+
+var (
+	// Arrivals of metrics being measured, keyed by metric name
+	arrivals = make(map[string]*metricArrivals)
+	// Mutex protecting arrivals
+	arrivalsMutex = &sync.Mutex{}
+)
+
+// observeArrival measures inter-arrival times of the series of the metric
+// received at the given time (seconds since epoch), returns the interval
+// picked for the metric as soon as its first series is measured (see
+// pickInterval), or SliceInterval when the metric is not measured until
+// the deadline (see arrivalDeadline). Events received in the same second
+// are counted once, so bursts do not look irregular. Returns false until
+// the interval is picked.
+func observeArrival(source, name string, now int64) (interval int, picked bool) {
+	arrivalsMutex.Lock()
+	defer arrivalsMutex.Unlock()
+	metric, found := arrivals[name]
+	if !found {
+		metric = &metricArrivals{first: now, series: make(map[string]*arrivalStats)}
+		arrivals[name] = metric
+	}
+	stats, found := metric.series[source]
+	if !found {
+		stats = &arrivalStats{last: now}
+		metric.series[source] = stats
+	}
+	if gap := float64(now - stats.last); gap > 0 {
+		stats.last = now
+		stats.count++
+		stats.sum += gap
+		stats.sumSquares += gap * gap
+	}
+
+	switch {
+	case stats.count >= adaptiveSamples:
+		interval = pickInterval(stats)
+	case now-metric.first > arrivalDeadline():
+		interval = config.SliceInterval
+	default:
+		return 0, false
+	}
+	arrivals[name] = nil, false
+	return interval, true
+}
+
+// pickInterval returns the longest of AdaptiveIntervals not exceeding the
+// mean inter-arrival time of the series (with adaptiveTolerance jitter), so
+// every slice receives samples. SliceInterval is returned for irregular
+// series, and when no adaptive interval is longer than SliceInterval.
+func pickInterval(stats *arrivalStats) int {
+	mean := stats.sum / float64(stats.count)
+	variance := stats.sumSquares/float64(stats.count) - mean*mean
+	if variance > 0 && math.Sqrt(variance)/mean > adaptiveTolerance {
+		return config.SliceInterval
+	}
+	interval := config.SliceInterval
+	for _, candidate := range config.AdaptiveIntervals {
+		if candidate > interval && float64(candidate) <= mean*(1+adaptiveTolerance) {
+			interval = candidate
+		}
+	}
+	return interval
+}
+
+// arrivalDeadline returns the maximum time in seconds a metric is measured:
+// enough to measure a regular series of the longest adaptive interval.
+func arrivalDeadline() int64 {
+	longest := config.SliceInterval
+	if count := len(config.AdaptiveIntervals); count > 0 && config.AdaptiveIntervals[count-1] > longest {
+		longest = config.AdaptiveIntervals[count-1]
+	}
+	return int64(longest) * (adaptiveSamples + 1)
+}
+
+// intervalPending returns a value indicating whether the interval of the
+// metric is being measured at the given time (seconds since epoch), so RRD
+// files of the metric should not be created yet: they would get the step
+// of the default timeline.
+func intervalPending(name string, now int64) bool {
+	if len(config.AdaptiveIntervals) == 0 {
+		return false
+	}
+	arrivalsMutex.Lock()
+	defer arrivalsMutex.Unlock()
+	metric, found := arrivals[name]
+	return found && now-metric.first <= arrivalDeadline()
+}
+
+// expireArrivals forgets arrivals of metrics measured for longer than the
+// deadline at the given time (seconds since epoch), so metrics sending a
+// few events do not stay forever. Such metrics are measured again when
+// they reappear. Returns the number of forgotten metrics.
+func expireArrivals(now int64) (expired int) {
+	arrivalsMutex.Lock()
+	defer arrivalsMutex.Unlock()
+	for name, metric := range arrivals {
+		if now-metric.first > arrivalDeadline() {
+			arrivals[name] = nil, false
+			expired++
+		}
+	}
+	return
+}
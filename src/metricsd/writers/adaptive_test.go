@@ -0,0 +1,109 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+	"metricsd/logger"
+	"metricsd/types"
+)
+
+type AdaptiveS struct{}
+
+var _ = Suite(&AdaptiveS{})
+
+func (s *AdaptiveS) SetUpTest(c *C) {
+	config.Logger = logger.NewConsoleLogger(logger.UNKNOWN)
+	config.AdaptiveIntervals = []int{60, 300}
+	config.ResetAdaptedIntervals()
+	arrivals = make(map[string]*metricArrivals)
+}
+
+func (s *AdaptiveS) TearDownTest(c *C) {
+	config.AdaptiveIntervals = nil
+	config.ResetAdaptedIntervals()
+}
+
+// observe reports arrivals of the series at the given times, returns the
+// result of the last one.
+func observe(source, name string, times ...int64) (interval int, picked bool) {
+	for _, now := range times {
+		interval, picked = observeArrival(source, name, now)
+	}
+	return
+}
+
+func (s *AdaptiveS) TestObserveArrivalRegular(c *C) {
+	times := make([]int64, 0, adaptiveSamples+1)
+	for idx := int64(0); idx <= adaptiveSamples; idx++ {
+		times = append(times, 1000+idx*60+idx%2)
+	}
+	_, picked := observe("src", "metric", times[:adaptiveSamples]...)
+	c.Check(picked, Equals, false)
+	c.Check(intervalPending("metric", times[adaptiveSamples-1]), Equals, true)
+
+	interval, picked := observe("src", "metric", times[adaptiveSamples])
+	c.Check(picked, Equals, true)
+	c.Check(interval, Equals, 60)
+	c.Check(intervalPending("metric", times[adaptiveSamples]), Equals, false)
+}
+
+func (s *AdaptiveS) TestObserveArrivalCountsBurstsOnce(c *C) {
+	times := make([]int64, 0, 2*adaptiveSamples+2)
+	for idx := int64(0); idx <= adaptiveSamples; idx++ {
+		times = append(times, 1000+idx*300, 1000+idx*300)
+	}
+	interval, picked := observe("src", "metric", times...)
+	c.Check(picked, Equals, true)
+	c.Check(interval, Equals, 300)
+}
+
+func (s *AdaptiveS) TestObserveArrivalIrregular(c *C) {
+	times := []int64{1000, 1010, 1100, 1105, 1300, 1310, 1320, 1500, 1800}
+	interval, picked := observe("src", "metric", times...)
+	c.Check(picked, Equals, true)
+	c.Check(interval, Equals, config.SliceInterval)
+}
+
+func (s *AdaptiveS) TestObserveArrivalFast(c *C) {
+	times := make([]int64, 0, adaptiveSamples+1)
+	for idx := int64(0); idx <= adaptiveSamples; idx++ {
+		times = append(times, 1000+idx*20)
+	}
+	interval, picked := observe("src", "metric", times...)
+	c.Check(picked, Equals, true)
+	c.Check(interval, Equals, config.SliceInterval)
+}
+
+func (s *AdaptiveS) TestObserveArrivalDeadline(c *C) {
+	_, picked := observe("src", "metric", 1000)
+	c.Check(picked, Equals, false)
+	interval, picked := observe("src", "metric", 1001+arrivalDeadline())
+	c.Check(picked, Equals, true)
+	c.Check(interval, Equals, config.SliceInterval)
+}
+
+func (s *AdaptiveS) TestExpireArrivals(c *C) {
+	observe("src", "metric", 1000)
+	c.Check(expireArrivals(1000+arrivalDeadline()), Equals, 0)
+	c.Check(expireArrivals(1001+arrivalDeadline()), Equals, 1)
+	c.Check(intervalPending("metric", 1000), Equals, false)
+}
+
+func (s *AdaptiveS) TestRouterRoutesAdaptedMetrics(c *C) {
+	router, err := NewRouter(nil)
+	c.Assert(err, IsNil)
+	c.Check(len(router.Routes), Equals, 3)
+	c.Check(router.Routes[0].Timeline.Interval, Equals, int64(60))
+	c.Check(router.Routes[1].Timeline.Interval, Equals, int64(300))
+	c.Check(router.Default().Timeline.Interval, Equals, int64(config.SliceInterval))
+
+	router.Add(types.NewEvent("src", "slow.metric", 1))
+	c.Check(router.Route("slow.metric"), Equals, router.Default())
+	c.Check(intervalPending("slow.metric", 0), Equals, true)
+
+	config.SetAdaptedInterval("slow.metric", 300)
+	c.Check(router.Route("slow.metric"), Equals, router.Routes[1])
+	c.Check(config.MetricInterval("slow.metric"), Equals, 300)
+	config.SetAdaptedInterval("fast.metric", config.SliceInterval)
+	c.Check(router.Route("fast.metric"), Equals, router.Default())
+}
@@ -0,0 +1,169 @@
+package writers
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+// An Aggregator performs extraction-and-write passes: closed slices are
+// extracted from the timeline, and summarized by all writers. Scheduling of
+// passes is up to the caller (see RunOnce).
+type Aggregator struct {
+	Timeline *types.Timeline
+	Writers  []Writer
+	Batch    bool            // use BatchRollup instead of Rollup (see BatchWrites config option)
+	Done     <-chan bool     // writes are stopped when the channel is closed
+	latest   int64           // time of the latest extracted slice
+	onFlush  []FlushCallback // called after every pass (see OnFlush)
+	finished int64           // time the last pass finished (see LastRun)
+	mutex    *sync.Mutex     // serializes passes
+}
+
+// NewAggregator returns a new Aggregator writing slices of the timeline
+// using the given writers. Batch writes are used when enabled in
+// configuration.
+func NewAggregator(timeline *types.Timeline, writers []Writer, done <-chan bool) *Aggregator {
+	return &Aggregator{
+		Timeline: timeline,
+		Writers:  writers,
+		Batch:    config.BatchWrites,
+		Done:     done,
+		finished: time.Seconds(),
+		mutex:    &sync.Mutex{},
+	}
+}
+
+// LastRun returns the time (seconds since epoch) the last pass finished,
+// or the aggregator has been created, if there were no passes yet.
+func (aggregator *Aggregator) LastRun() int64 {
+	return atomic.AddInt64(&aggregator.finished, 0)
+}
+
+// RunOnce writes closed slices (or all slices, if force is true) using the
+// writers. Sample sets are written in the order of priorities of their
+// metrics (see writeOrder). Passes started concurrently (e.g. by the write
+// timer and by the admin interface) are performed one after another. In
+// dry-run mode a summary of computed rollups is logged (see config.DryRun).
+// State of metrics absent for StateTTL slice intervals is forgotten after
+// every pass (see expireState). Extracted slices are recycled after
+// successful passes (see types.Timeline.Release). Rollups forwarded to
+// Kafka during the pass are produced as a batch (see flushKafka). Flush
+// callbacks are called with the summary of the pass at the end (see
+// OnFlush). Passes are batched, when rollups of any metrics are
+// consolidated (see config.UsesConsolidation). Returns the first error
+// occurred.
+func (aggregator *Aggregator) RunOnce(force bool) (error os.Error) {
+	aggregator.mutex.Lock()
+	defer aggregator.mutex.Unlock()
+	defer func() {
+		// Passes are serialized, so there are no concurrent updates
+		atomic.AddInt64(&aggregator.finished, time.Seconds()-aggregator.finished)
+	}()
+
+	config.Logger.Debug("Rolling up timeline")
+	startTime := time.Nanoseconds()
+
+	var closedSampleSets []*types.SampleSet
+	written := 0
+	closedSlices := aggregator.Timeline.ExtractClosedSlices(force)
+	// Consolidated rollups need all slices of a series at once
+	if aggregator.Batch || config.UsesConsolidation() {
+		closedSampleSets = types.CollectSampleSets(closedSlices)
+		for _, set := range closedSampleSets {
+			if set.Time > aggregator.latest {
+				aggregator.latest = set.Time
+			}
+		}
+		for _, writer := range aggregator.Writers {
+			if error == nil {
+				error = BatchRollup(writer, closedSampleSets, aggregator.Done)
+			}
+		}
+		if error == nil {
+			written = len(closedSampleSets)
+		}
+	} else {
+		if len(closedSlices) > 0 {
+			aggregator.latest = closedSlices[len(closedSlices)-1].Time
+		}
+		closedSampleSets = writeOrder(closedSlices)
+		for idx, set := range closedSampleSets {
+			for _, writer := range aggregator.Writers {
+				if error == nil {
+					error = Rollup(writer, set, aggregator.Done)
+				}
+			}
+			if error == nil {
+				written = idx + 1
+			}
+		}
+	}
+	// Summarized before sample sets are recycled
+	summary := aggregator.flushSummary(closedSlices, closedSampleSets, written, error)
+	// Cancelled writes could still use sample sets
+	if error == nil {
+		aggregator.Timeline.Release(closedSlices)
+	}
+	aggregator.expireState()
+	// Rollups of the pass are produced to Kafka as a single batch
+	flushKafka()
+	if config.DryRun {
+		rollups := atomic.AddInt64(&dryRunRollups, 0)
+		atomic.AddInt64(&dryRunRollups, -rollups)
+		config.Logger.Info("Dry run: %d sample sets extracted, %d rollups computed, nothing written", len(closedSampleSets), rollups)
+	}
+	if error == nil {
+		config.Logger.Debug("... timeline rolled up, took %v seconds", float64(time.Nanoseconds()-startTime)/1e9)
+	}
+	aggregator.notifyFlush(summary)
+	return
+}
+
+// writeOrder returns sample sets of slices in the order they are written:
+// slice by slice, unless metrics have write priorities (see
+// config.MetricConfig.Priority), then sample sets of metrics with higher
+// priority are written first (see types.CollectSampleSets), so important
+// metrics recover faster after a backlog.
+func writeOrder(slices []*types.Slice) []*types.SampleSet {
+	if config.UsesPriorities() {
+		return types.CollectSampleSets(slices)
+	}
+	sets := make([]*types.SampleSet, 0, 100)
+	for _, slice := range slices {
+		for _, set := range slice.Sets {
+			sets = append(sets, set)
+		}
+	}
+	return sets
+}
+
+// expireState forgets state kept by writers (latest rollups, previous
+// means, summaries, streaks over rate thresholds, and warmup state) for
+// metrics absent for more than StateTTL slice intervals (counting from the
+// latest extracted slice, so imported history expires the same way as live
+// data).
+func (aggregator *Aggregator) expireState() {
+	if config.StateTTL <= 0 || aggregator.latest == 0 {
+		return
+	}
+	before := aggregator.latest - int64(config.StateTTL)*aggregator.Timeline.Interval
+	if expired := expireRollups(before); expired > 0 {
+		config.Logger.Debug("Forgot %d rollups of metrics absent since %d", expired, before)
+	}
+	if expired := expireChanges(before); expired > 0 {
+		config.Logger.Debug("Forgot previous means of %d metrics absent since %d", expired, before)
+	}
+	if expired := expireSummaries(before); expired > 0 {
+		config.Logger.Debug("Forgot summaries of %d metrics absent since %d", expired, before)
+	}
+	if expired := expireStreaks(before); expired > 0 {
+		config.Logger.Debug("Forgot streaks of %d metrics not over rate thresholds since %d", expired, before)
+	}
+	if expired := expireWarmups(before); expired > 0 {
+		config.Logger.Debug("Forgot warmup state of %d metrics absent since %d", expired, before)
+	}
+}
@@ -0,0 +1,149 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+	"metricsd/logger"
+	"metricsd/types"
+)
+
+// recordingWriter remembers sample sets passed to it, without producing any
+// data to write.
+type recordingWriter struct {
+	*BaseWriter
+	sets []*types.SampleSet
+}
+
+func (*recordingWriter) Name() string {
+	return "recording"
+}
+
+func (self *recordingWriter) rollupData(set *types.SampleSet) dataItem {
+	self.sets = append(self.sets, set)
+	return nil
+}
+
+type AggregatorS struct {
+	timeline   *types.Timeline
+	writer     *recordingWriter
+	aggregator *Aggregator
+}
+
+var _ = Suite(&AggregatorS{})
+
+func (s *AggregatorS) SetUpTest(c *C) {
+	config.Logger = logger.NewConsoleLogger(logger.UNKNOWN)
+	s.timeline = types.NewTimeline(10)
+	s.writer = &recordingWriter{}
+	s.aggregator = NewAggregator(s.timeline, []Writer{s.writer}, nil)
+}
+
+func (s *AggregatorS) TestRunOnceSkipsOpenSlices(c *C) {
+	s.timeline.Add(types.NewEvent("src", "metric", 10))
+	c.Check(s.aggregator.RunOnce(false), IsNil)
+	c.Check(len(s.writer.sets), Equals, 0)
+	c.Check(len(s.timeline.Slices), Equals, 1)
+}
+
+func (s *AggregatorS) TestRunOnceWritesClosedSlices(c *C) {
+	s.timeline.AddAt(types.NewEvent("src", "metric", 10), 1000)
+	s.timeline.Add(types.NewEvent("src", "metric", 20))
+	c.Check(s.aggregator.RunOnce(false), IsNil)
+	c.Check(len(s.writer.sets), Equals, 2) // "src" and "all" sources
+	c.Check(s.writer.sets[0].Time, Equals, int64(1000))
+	c.Check(len(s.timeline.Slices), Equals, 1)
+}
+
+func (s *AggregatorS) TestRunOnceForce(c *C) {
+	s.aggregator.Batch = true
+	s.timeline.AddAt(types.NewEvent("src", "metric", 10), 1000)
+	s.timeline.Add(types.NewEvent("src", "metric", 20))
+	c.Check(s.aggregator.RunOnce(true), IsNil)
+	c.Check(len(s.writer.sets), Equals, 4)
+	c.Check(len(s.timeline.Slices), Equals, 0)
+}
+
+func (s *AggregatorS) TestRunOnceWritesByPriority(c *C) {
+	config.SetMetrics([]*config.MetricConfig{&config.MetricConfig{Pattern: "sla.*", Priority: 10}})
+	defer config.SetMetrics(nil)
+
+	for _, batch := range []bool{false, true} {
+		s.aggregator.Batch = batch
+		s.writer.sets = nil
+		for _, time := range []int64{1000, 1010} {
+			s.timeline.AddAt(types.NewEvent("all", "bulk", 10), time)
+			s.timeline.AddAt(types.NewEvent("all", "sla.latency", 10), time)
+		}
+		c.Check(s.aggregator.RunOnce(true), IsNil)
+		c.Assert(len(s.writer.sets), Equals, 4)
+		for idx, name := range []string{"sla.latency", "sla.latency", "bulk", "bulk"} {
+			c.Check(s.writer.sets[idx].Name, Equals, name)
+		}
+		c.Check(s.writer.sets[0].Time < s.writer.sets[1].Time, Equals, true)
+	}
+}
+
+func (s *AggregatorS) TestRunOnceCallsFlushCallbacks(c *C) {
+	var summaries []*FlushSummary
+	s.aggregator.OnFlush(func(summary *FlushSummary) {
+		summaries = append(summaries, summary)
+	})
+	s.timeline.AddAt(types.NewEvent("src", "b.metric", 10), 1000)
+	s.timeline.AddAt(types.NewEvent("src", "a.metric", 10), 1010)
+	c.Check(s.aggregator.RunOnce(true), IsNil)
+	c.Assert(len(summaries), Equals, 1)
+	c.Check(summaries[0].Slices, Equals, []int64{1000, 1010})
+	c.Check(summaries[0].Metrics, Equals, []string{"a.metric", "b.metric"})
+	c.Check(summaries[0].Failed, Equals, []string{})
+	c.Check(summaries[0].Error, IsNil)
+
+	// Passes extracting nothing are reported too
+	c.Check(s.aggregator.RunOnce(true), IsNil)
+	c.Assert(len(summaries), Equals, 2)
+	c.Check(summaries[1].Slices, Equals, []int64{})
+	c.Check(summaries[1].Metrics, Equals, []string{})
+}
+
+func (s *AggregatorS) TestRunOnceDryRun(c *C) {
+	config.DryRun = true
+	defer func() { config.DryRun = config.DEFAULT_DRY_RUN }()
+
+	s.aggregator.Writers = []Writer{&Count{}}
+	s.timeline.AddAt(types.NewEvent("src", "dry.metric", 10), 1000)
+	c.Check(s.aggregator.RunOnce(false), IsNil)
+	c.Check(dryRunRollups, Equals, int64(0))
+
+	set := createSampleSet(1000, 1)
+	c.Check(Rollup(&Count{}, set, nil), IsNil)
+	c.Check(dryRunRollups, Equals, int64(1))
+	c.Check(queuedRrdUpdates(), Equals, 0)
+	dryRunRollups = 0
+}
+
+func (s *AggregatorS) TestRunOnceExpiresState(c *C) {
+	config.StateTTL = 2
+	defer func() { config.StateTTL = config.DEFAULT_STATE_TTL }()
+
+	old := createSampleSet(970, 1)
+	old.Name = "old.metric"
+	remember(s.writer, old, &countItem{time: 970})
+	remember(s.writer, createSampleSet(990, 1), &countItem{time: 990})
+	c.Check(expireRollups(0), Equals, 0)
+
+	s.timeline.AddAt(types.NewEvent("src", "metric", 10), 1000)
+	c.Check(s.aggregator.RunOnce(false), IsNil)
+	latestRollupsMutex.RLock()
+	_, oldFound := latestRollups["src-old.metric-recording"]
+	_, found := latestRollups["src-metric-recording"]
+	latestRollupsMutex.RUnlock()
+	c.Check(oldFound, Equals, false)
+	c.Check(found, Equals, true)
+}
+
+func (s *AggregatorS) TestLastRun(c *C) {
+	s.aggregator.finished = 0
+	c.Check(s.aggregator.RunOnce(false), IsNil)
+	if s.aggregator.LastRun() == 0 {
+		c.Errorf("LastRun should be updated after a pass")
+	}
+}
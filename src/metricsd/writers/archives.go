@@ -0,0 +1,178 @@
+package writers
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+// archiveRow consolidates rollups of a series into rows of an RRD archive
+// the same way RRDTool does (unknown values are ignored), so exports could
+// surface the archive selected by ExportArchives.
+type archiveRow struct {
+	function   string
+	resolution int64     // seconds per row
+	start      int64     // start of the row in progress
+	values     []float64 // consolidated values of the row in progress
+	counts     []int     // number of known values of the row in progress
+	last       *archiveItem
+}
+
+// archiveItem is a completed row of an archive, exported instead of the
+// most recent rollup.
+type archiveItem struct {
+	time     int64     // end of the row, 0 when there are no completed rows
+	data     dataItem  // a rollup of the series, defining data sources
+	function string    // consolidation function of the archive
+	values   []float64 // consolidated values, NaN when unknown
+}
+
+var (
+	// Writers with export archives they do not produce, reported already
+	missingArchives = make(map[string]bool)
+)
+
+// archiveSteps returns numbers of slices per row of archives of the data
+// item consolidated with the function, in the order they are created.
+func archiveSteps(data dataItem, function string) (steps []int) {
+	for _, info := range data.rrdInfo() {
+		// RRA:<consolidation function>:<xff>:<steps>:<rows>
+		parts := strings.Split(info, ":")
+		if len(parts) != 5 || parts[0] != "RRA" || parts[1] != function {
+			continue
+		}
+		if count, error := strconv.Atoi(parts[3]); error == nil {
+			steps = append(steps, count)
+		}
+	}
+	return
+}
+
+// newArchiveRow returns the row of the export archive of the writer for
+// the sample set (see config.GetExportArchive), nil when the most recent
+// rollups are exported. The finest archive of the function is used unless
+// the resolution is configured. Writers not creating archives with the
+// function export the most recent rollups, a warning is logged once per
+// writer. latestRollupsMutex should be locked.
+func newArchiveRow(writer Writer, set *types.SampleSet, data dataItem) *archiveRow {
+	archive := config.GetExportArchive(writer.Name())
+	if archive == nil {
+		return nil
+	}
+	if _, ok := data.(prometheusItem); ok {
+		return nil
+	}
+	steps := archiveSteps(data, archive.Function)
+	if len(steps) == 0 {
+		if !missingArchives[writer.Name()] {
+			missingArchives[writer.Name()] = true
+			config.Logger.Warn("Writer %s does not create %s archives, exporting its latest rollups", writer.Name(), archive.Function)
+		}
+		return nil
+	}
+	resolution := int64(archive.Resolution)
+	if resolution == 0 {
+		finest := steps[0]
+		for _, count := range steps[1:] {
+			if count < finest {
+				finest = count
+			}
+		}
+		resolution = int64(finest * config.MetricInterval(set.Name))
+	}
+	return &archiveRow{function: archive.Function, resolution: resolution}
+}
+
+// add consolidates the rollup into the row in progress. The row is
+// completed when the rollup belongs to a later row.
+func (self *archiveRow) add(time int64, data dataItem) {
+	start := time - time%self.resolution
+	_, values := dataFields(data)
+	if self.values == nil || start > self.start {
+		if self.values != nil {
+			self.last = &archiveItem{time: self.start + self.resolution, data: data, function: self.function, values: self.consolidated()}
+		}
+		self.start = start
+		self.values = make([]float64, len(values))
+		self.counts = make([]int, len(values))
+	}
+	for idx, value := range values {
+		number, error := strconv.Atof64(value)
+		if error != nil || idx >= len(self.values) {
+			continue
+		}
+		switch {
+		case self.counts[idx] == 0, self.function == config.CF_LAST:
+			self.values[idx] = number
+		case self.function == config.CF_MAX:
+			self.values[idx] = math.Fmax(self.values[idx], number)
+		case self.function == config.CF_MIN:
+			self.values[idx] = math.Fmin(self.values[idx], number)
+		default:
+			self.values[idx] += number
+		}
+		self.counts[idx]++
+	}
+}
+
+// consolidated returns consolidated values of the row in progress.
+func (self *archiveRow) consolidated() []float64 {
+	values := make([]float64, len(self.values))
+	for idx, value := range self.values {
+		switch {
+		case self.counts[idx] == 0:
+			values[idx] = math.NaN()
+		case self.function == config.CF_AVERAGE:
+			values[idx] = value / float64(self.counts[idx])
+		default:
+			values[idx] = value
+		}
+	}
+	return values
+}
+
+// exported returns the data item surfaced by exports: the last completed
+// row, or unknown values when there are no completed rows yet.
+func (self *archiveRow) exported(data dataItem) dataItem {
+	if self.last != nil {
+		return self.last
+	}
+	_, values := dataFields(data)
+	unknown := make([]float64, len(values))
+	for idx := range unknown {
+		unknown[idx] = math.NaN()
+	}
+	return &archiveItem{data: data, function: self.function, values: unknown}
+}
+
+// String returns string representation of the given archiveItem.
+func (self *archiveItem) String() string {
+	return fmt.Sprintf("archiveItem[time=%d, function=%s, values=%v]", self.time, self.function, self.values)
+}
+
+// rrdInfo returns the list of parameters of the consolidated rollups.
+func (self *archiveItem) rrdInfo() []string {
+	return self.data.rrdInfo()
+}
+
+// rrdTemplate returns names of data sources of the consolidated rollups.
+func (self *archiveItem) rrdTemplate() string {
+	return self.data.rrdTemplate()
+}
+
+// rrdString returns a string matching template format with consolidated
+// values.
+func (self *archiveItem) rrdString() string {
+	result := fmt.Sprintf("%d", self.time)
+	for _, value := range self.values {
+		if math.IsNaN(value) {
+			result += ":" + UnknownValue
+		} else {
+			result += fmt.Sprintf(":%.2f", value)
+		}
+	}
+	return result
+}
@@ -0,0 +1,81 @@
+package writers
+
+import (
+	"bytes"
+	"json"
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+	"metricsd/logger"
+)
+
+type ArchivesS struct{}
+
+var _ = Suite(&ArchivesS{})
+
+func (s *ArchivesS) SetUpTest(c *C) {
+	config.Logger = logger.NewConsoleLogger(logger.UNKNOWN)
+	latestRollups = make(map[string]*latestRollup)
+	missingArchives = make(map[string]bool)
+}
+
+func (s *ArchivesS) TearDownTest(c *C) {
+	config.ExportArchives = nil
+	latestRollups = make(map[string]*latestRollup)
+}
+
+// exportedRecord returns the only record of rollups of the metric exported
+// in JSON.
+func exportedRecord(c *C) map[string]interface{} {
+	buffer := &bytes.Buffer{}
+	count, err := WriteLatestRollups(buffer, "metric")
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, 1)
+	var records []map[string]interface{}
+	c.Assert(json.Unmarshal(buffer.Bytes(), &records), IsNil)
+	return records[0]
+}
+
+func (s *ArchivesS) TestArchiveSteps(c *C) {
+	data := (&Quartiles{}).rollupData(createSampleSet(1000, 1))
+	c.Check(archiveSteps(data, config.CF_MAX), Equals, []int{1, 60, 2880})
+	c.Check(archiveSteps(data, config.CF_LAST), IsNil)
+}
+
+func (s *ArchivesS) TestExportArchive(c *C) {
+	config.ExportArchives = map[string]*config.ExportArchive{"quartiles": &config.ExportArchive{Function: config.CF_MAX, Resolution: 30}}
+	writer := &Quartiles{}
+	set := createSampleSet(1000, 1, 2, 3, 4, 5)
+	remember(writer, set, writer.rollupData(set))
+	// There are no completed rows yet
+	record := exportedRecord(c)
+	c.Check(record["values"].(map[string]interface{})["hi"], IsNil)
+
+	set = createSampleSet(1010, 7, 8, 9)
+	remember(writer, set, writer.rollupData(set))
+	set = createSampleSet(1020, 1, 2, 3)
+	remember(writer, set, writer.rollupData(set))
+	record = exportedRecord(c)
+	c.Check(record["time"], Equals, float64(1020))
+	c.Check(record["values"].(map[string]interface{})["hi"], Equals, float64(9))
+	c.Check(record["values"].(map[string]interface{})["lo"], Equals, float64(7))
+}
+
+func (s *ArchivesS) TestExportArchiveNotCreatedByWriter(c *C) {
+	config.ExportArchives = map[string]*config.ExportArchive{config.ALL_WRITERS: &config.ExportArchive{Function: config.CF_MAX}}
+	writer := &Count{}
+	set := createSampleSet(1000, 1, -1, 1)
+	remember(writer, set, writer.rollupData(set))
+	record := exportedRecord(c)
+	c.Check(record["values"], Equals, map[string]interface{}{"ok": float64(2), "fail": float64(1)})
+	c.Check(missingArchives["count"], Equals, true)
+}
+
+func (s *ArchivesS) TestParseExportArchive(c *C) {
+	archive, err := config.ParseExportArchive("max:10m")
+	c.Assert(err, IsNil)
+	c.Check(archive, Equals, &config.ExportArchive{Function: config.CF_MAX, Resolution: 600})
+	_, err = config.ParseExportArchive("MEDIAN")
+	c.Check(err, Not(IsNil))
+	_, err = config.ParseExportArchive("MAX:10m:1d")
+	c.Check(err, Not(IsNil))
+}
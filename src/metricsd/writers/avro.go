@@ -0,0 +1,89 @@
+package writers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"sort"
+)
+
+// KafkaAvroSchema is the Avro schema of rollups produced to Kafka in Avro
+// format (see config.KAFKA_FORMAT_AVRO). Unknown and non-numeric values are
+// null.
+const KafkaAvroSchema = `{"type": "record", "name": "Rollup", "namespace": "metricsd", "fields": [
+  {"name": "time", "type": "long"},
+  {"name": "source", "type": "string"},
+  {"name": "name", "type": "string"},
+  {"name": "tags", "type": {"type": "map", "values": "string"}},
+  {"name": "writer", "type": "string"},
+  {"name": "unit", "type": "string"},
+  {"name": "values", "type": {"type": "map", "values": ["null", "double"]}}
+]}`
+
+// avroEncoder encodes values in Avro binary encoding.
+type avroEncoder struct {
+	bytes.Buffer
+}
+
+// putLong encodes the number as zig-zag variable-length integer.
+func (self *avroEncoder) putLong(value int64) {
+	encoded := uint64((value << 1) ^ (value >> 63))
+	for encoded >= 0x80 {
+		self.WriteByte(byte(encoded) | 0x80)
+		encoded >>= 7
+	}
+	self.WriteByte(byte(encoded))
+}
+
+func (self *avroEncoder) putString(value string) {
+	self.putLong(int64(len(value)))
+	self.WriteString(value)
+}
+
+// putDouble encodes the number in IEEE 754 format (little endian).
+func (self *avroEncoder) putDouble(value float64) {
+	var data [8]byte
+	binary.LittleEndian.PutUint64(data[:], math.Float64bits(value))
+	self.Write(data[:])
+}
+
+// putMap encodes keys of the map (sorted, so encoding is stable) in a
+// single block, values are encoded with the given function.
+func (self *avroEncoder) putMap(keys []string, value func(key string)) {
+	sort.Strings(keys)
+	if len(keys) > 0 {
+		self.putLong(int64(len(keys)))
+		for _, key := range keys {
+			self.putString(key)
+			value(key)
+		}
+	}
+	self.putLong(0)
+}
+
+// avroRecord returns the record in Avro binary encoding (see
+// KafkaAvroSchema).
+func avroRecord(record *debugRecord) []byte {
+	encoder := &avroEncoder{}
+	encoder.putLong(record.Time)
+	encoder.putString(record.Source)
+	encoder.putString(record.Name)
+	encoder.putMap(record.Tags.Keys(), func(key string) {
+		encoder.putString(record.Tags[key])
+	})
+	encoder.putString(record.Writer)
+	encoder.putString(record.Unit)
+	keys := make([]string, 0, len(record.Values))
+	for key := range record.Values {
+		keys = append(keys, key)
+	}
+	encoder.putMap(keys, func(key string) {
+		if number, ok := record.Values[key].(float64); ok {
+			encoder.putLong(1)
+			encoder.putDouble(number)
+		} else {
+			encoder.putLong(0)
+		}
+	})
+	return encoder.Bytes()
+}
@@ -0,0 +1,38 @@
+package writers
+
+import (
+	"math"
+	"rand"
+	"metricsd/config"
+)
+
+// A backoff calculates delays between reconnection attempts of network
+// outputs (see config.BackoffConfig).
+type backoff struct {
+	config   *config.BackoffConfig
+	failures int // number of consecutive failures
+}
+
+// newBackoff returns a new backoff using the configured reconnection
+// settings.
+func newBackoff() *backoff {
+	return &backoff{config: config.Reconnect}
+}
+
+// next registers a failure, and returns the delay before the next attempt
+// in nanoseconds.
+func (b *backoff) next() int64 {
+	delay := b.config.Initial * math.Pow(b.config.Factor, float64(b.failures))
+	if delay > b.config.Max {
+		delay = b.config.Max
+	} else {
+		b.failures++
+	}
+	delay -= delay * b.config.Jitter * rand.Float64()
+	return int64(delay * 1e9)
+}
+
+// reset registers a success, so the next failure gets the initial delay.
+func (b *backoff) reset() {
+	b.failures = 0
+}
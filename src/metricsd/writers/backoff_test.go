@@ -0,0 +1,37 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+)
+
+type BackoffS struct{}
+
+var _ = Suite(&BackoffS{})
+
+func (s *BackoffS) TestNext(c *C) {
+	b := &backoff{config: &config.BackoffConfig{Initial: 1, Max: 5, Factor: 2, Jitter: 0}}
+	delays := make([]int64, 0, 5)
+	for i := 0; i < 5; i++ {
+		delays = append(delays, b.next())
+	}
+	c.Check(delays, Equals, []int64{1e9, 2e9, 4e9, 5e9, 5e9})
+}
+
+func (s *BackoffS) TestNextWithJitter(c *C) {
+	b := &backoff{config: &config.BackoffConfig{Initial: 4, Max: 4, Factor: 2, Jitter: 0.5}}
+	for i := 0; i < 100; i++ {
+		delay := b.next()
+		if delay < 2e9 || delay > 4e9 {
+			c.Errorf("Delay %d is out of [2e9, 4e9]", delay)
+		}
+	}
+}
+
+func (s *BackoffS) TestReset(c *C) {
+	b := &backoff{config: &config.BackoffConfig{Initial: 1, Max: 5, Factor: 2, Jitter: 0}}
+	b.next()
+	b.next()
+	b.reset()
+	c.Check(b.next(), Equals, int64(1e9))
+}
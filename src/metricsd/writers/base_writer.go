@@ -1,13 +1,17 @@
 package writers
 
 import (
+	"os"
 	"metricsd/types"
 )
 
+// A Writer summarizes sample sets and writes results to outputs. Failures
+// of writes are reported as ErrTransient, ErrPermanent, or ErrBadData (see
+// updateFailed), rollup functions return only Cancelled.
 type Writer interface {
 	Name() string
-	Rollup(set *types.SampleSet)
-	BatchRollup(sets []*types.SampleSet)
+	Rollup(set *types.SampleSet, done <-chan bool) os.Error
+	BatchRollup(sets []*types.SampleSet, done <-chan bool) os.Error
 	// Private methods
 	rollupData(set *types.SampleSet) dataItem
 }
@@ -15,15 +19,16 @@ type Writer interface {
 type BaseWriter struct{}
 
 // Rollup performs summarization on the given sample set and writes
-// results to RRD file.
-func (writer *BaseWriter) Rollup(set *types.SampleSet) {
-	Rollup(writer, set)
+// results to RRD file. Writing is stopped when done channel is closed.
+func (writer *BaseWriter) Rollup(set *types.SampleSet, done <-chan bool) os.Error {
+	return Rollup(writer, set, done)
 }
 
 // BatchRollup performs summarization on the given list of sample sets and
-// writes results to RRD files.
-func (writer *BaseWriter) BatchRollup(sets []*types.SampleSet) {
-	BatchRollup(writer, sets)
+// writes results to RRD files. Writing is stopped when done channel is
+// closed.
+func (writer *BaseWriter) BatchRollup(sets []*types.SampleSet, done <-chan bool) os.Error {
+	return BatchRollup(writer, sets, done)
 }
 
 func (writer *BaseWriter) Name() string {
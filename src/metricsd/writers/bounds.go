@@ -0,0 +1,47 @@
+package writers
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"metricsd/config"
+)
+
+// rrdBoundedInfo returns the list of parameters used to create RRD file of
+// the metric, with minimum and maximum of data sources replaced with
+// per-metric Bounds of the writer (or of all writers, "*"). COMPUTE data
+// sources have no bounds, and the samples data source (see
+// config.SampleCountWriters) keeps its own. Returns an error when the
+// resulting minimum is not less than the maximum.
+func rrdBoundedInfo(writer Writer, name string, info []string) ([]string, os.Error) {
+	bounds := config.MetricOptions(name).Bounds
+	bound, found := bounds[writer.Name()]
+	if !found {
+		bound, found = bounds["*"]
+	}
+	if !found {
+		return info, nil
+	}
+
+	result := make([]string, 0, len(info))
+	for _, item := range info {
+		// DS:<name>:<type>:<heartbeat>:<min>:<max>
+		fields := strings.Split(item, ":")
+		if fields[0] != "DS" || len(fields) != 6 || fields[2] == "COMPUTE" ||
+			(fields[1] == "samples" && config.CountsSamples(writer.Name())) {
+			result = append(result, item)
+			continue
+		}
+		if bound.Min != "" {
+			fields[4] = bound.Min
+		}
+		if bound.Max != "" {
+			fields[5] = bound.Max
+		}
+		if err := config.CheckBounds(fields[4], fields[5]); err != nil {
+			return nil, os.NewError(fmt.Sprintf("Data source %q of %s writer: %s", fields[1], writer.Name(), err))
+		}
+		result = append(result, strings.Join(fields, ":"))
+	}
+	return result, nil
+}
@@ -0,0 +1,67 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+)
+
+type BoundsS struct{}
+
+var _ = Suite(&BoundsS{})
+
+func (s *BoundsS) TearDownTest(c *C) {
+	config.SetMetrics(nil)
+	config.SampleCountWriters = nil
+}
+
+func (s *BoundsS) TestCheckBounds(c *C) {
+	c.Check(config.CheckBounds("-10", "10"), IsNil)
+	c.Check(config.CheckBounds("U", "U"), IsNil)
+	c.Check(config.CheckBounds("-10", "U"), IsNil)
+	c.Check(config.CheckBounds("10", "10"), Not(IsNil))
+	c.Check(config.CheckBounds("10", "-10"), Not(IsNil))
+}
+
+func (s *BoundsS) TestRrdBoundedInfoWithoutBounds(c *C) {
+	info := (&countItem{}).rrdInfo()
+	bounded, err := rrdBoundedInfo(&Count{}, "metric", info)
+	c.Assert(err, IsNil)
+	c.Check(bounded, Equals, info)
+}
+
+func (s *BoundsS) TestRrdBoundedInfo(c *C) {
+	config.SetMetrics([]*config.MetricConfig{&config.MetricConfig{Pattern: "metric", Bounds: map[string]*config.Bounds{
+		"count": &config.Bounds{Min: "-100"},
+		"*":     &config.Bounds{Min: "U", Max: "1000"},
+	}}})
+	info, err := rrdBoundedInfo(&Count{}, "metric", (&countItem{}).rrdInfo())
+	c.Assert(err, IsNil)
+	c.Check(info[:3], Equals, []string{
+		"DS:ok:ABSOLUTE:600:-100:U",
+		"DS:fail:ABSOLUTE:600:-100:U",
+		"DS:error_ratio:COMPUTE:fail,ok,fail,+,/",
+	})
+
+	info, err = rrdBoundedInfo(&Cov{}, "metric", (&covItem{}).rrdInfo())
+	c.Assert(err, IsNil)
+	c.Check(info[0], Equals, "DS:cov:GAUGE:600:U:1000")
+}
+
+func (s *BoundsS) TestRrdBoundedInfoKeepsSamples(c *C) {
+	config.SampleCountWriters = []string{"cov"}
+	config.SetMetrics([]*config.MetricConfig{&config.MetricConfig{Pattern: "metric", Bounds: map[string]*config.Bounds{
+		"cov": &config.Bounds{Min: "-1", Max: "1"},
+	}}})
+	data := &samplesItem{dataItem: &covItem{}}
+	info, err := rrdBoundedInfo(&Cov{}, "metric", data.rrdInfo())
+	c.Assert(err, IsNil)
+	c.Check(info[:2], Equals, []string{"DS:cov:GAUGE:600:-1:1", "DS:samples:GAUGE:600:0:U"})
+}
+
+func (s *BoundsS) TestRrdBoundedInfoInvalid(c *C) {
+	config.SetMetrics([]*config.MetricConfig{&config.MetricConfig{Pattern: "metric", Bounds: map[string]*config.Bounds{
+		"count": &config.Bounds{Max: "0"},
+	}}})
+	_, err := rrdBoundedInfo(&Count{}, "metric", (&countItem{}).rrdInfo())
+	c.Check(err, Not(IsNil))
+}
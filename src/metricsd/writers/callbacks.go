@@ -0,0 +1,77 @@
+package writers
+
+import (
+	"os"
+	"sort"
+	"time"
+	"metricsd/types"
+)
+
+// A FlushSummary describes a completed extraction-and-write pass of an
+// Aggregator, passed to flush callbacks (see OnFlush).
+type FlushSummary struct {
+	Time    int64    // time the pass finished (seconds since epoch)
+	Slices  []int64  // times of extracted slices in increasing order (see types.Timeline.ExtractClosedSlices)
+	Metrics []string // sorted names of metrics with extracted sample sets
+	Failed  []string // sorted names of metrics with sample sets not written by all writers
+	Error   os.Error // the first error of the pass, nil when all writes succeeded
+}
+
+// A FlushCallback is called after every extraction-and-write pass.
+type FlushCallback func(summary *FlushSummary)
+
+// OnFlush registers the callback called after every pass of the
+// aggregator, once all writers have finished, including passes with
+// failures and passes extracting nothing. Callbacks are called one after
+// another in the order of registration, before the next pass starts, so
+// they should not block for long, and should not register callbacks.
+func (aggregator *Aggregator) OnFlush(callback FlushCallback) {
+	aggregator.mutex.Lock()
+	defer aggregator.mutex.Unlock()
+	aggregator.onFlush = append(aggregator.onFlush, callback)
+}
+
+// flushSummary returns the summary of the pass passed to flush callbacks
+// of the aggregator (nil when there are no callbacks): slices and sample
+// sets extracted, the number of sample sets written by all writers (sample
+// sets are written in order, so the rest is failed), and the first error
+// occurred. It should be called before extracted slices are recycled.
+func (aggregator *Aggregator) flushSummary(slices []*types.Slice, sets []*types.SampleSet, written int, error os.Error) *FlushSummary {
+	if len(aggregator.onFlush) == 0 {
+		return nil
+	}
+	return newFlushSummary(slices, sets, written, error)
+}
+
+// notifyFlush calls flush callbacks of the aggregator with the summary of
+// the pass.
+func (aggregator *Aggregator) notifyFlush(summary *FlushSummary) {
+	for _, callback := range aggregator.onFlush {
+		callback(summary)
+	}
+}
+
+// newFlushSummary returns the summary of a pass (see flushSummary).
+func newFlushSummary(slices []*types.Slice, sets []*types.SampleSet, written int, error os.Error) *FlushSummary {
+	summary := &FlushSummary{Time: time.Seconds(), Slices: make([]int64, len(slices)), Error: error}
+	for idx, slice := range slices {
+		summary.Slices[idx] = slice.Time
+	}
+	summary.Metrics = metricNames(sets)
+	summary.Failed = metricNames(sets[written:])
+	return summary
+}
+
+// metricNames returns sorted distinct names of metrics of sample sets.
+func metricNames(sets []*types.SampleSet) []string {
+	seen := make(map[string]bool)
+	names := make([]string, 0, len(sets))
+	for _, set := range sets {
+		if !seen[set.Name] {
+			seen[set.Name] = true
+			names = append(names, set.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
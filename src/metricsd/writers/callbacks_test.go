@@ -0,0 +1,27 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+	"metricsd/types"
+)
+
+type CallbacksS struct{}
+
+var _ = Suite(&CallbacksS{})
+
+func (s *CallbacksS) TestNewFlushSummaryWithFailure(c *C) {
+	sets := []*types.SampleSet{
+		types.NewSampleSet(1000, "all", "a"),
+		types.NewSampleSet(1000, "src", "a"),
+		types.NewSampleSet(1000, "all", "c"),
+		types.NewSampleSet(1000, "all", "b"),
+	}
+	summary := newFlushSummary([]*types.Slice{types.NewSlice(1000)}, sets, 1, Cancelled)
+	c.Check(summary.Slices, Equals, []int64{1000})
+	c.Check(summary.Metrics, Equals, []string{"a", "b", "c"})
+	c.Check(summary.Failed, Equals, []string{"a", "b", "c"})
+	c.Check(summary.Error, Equals, Cancelled)
+
+	summary = newFlushSummary(nil, sets, 2, Cancelled)
+	c.Check(summary.Failed, Equals, []string{"b", "c"})
+}
@@ -0,0 +1,140 @@
+package writers
+
+import (
+	"fmt"
+	"sync"
+	"metricsd/types"
+)
+
+// Change writer is used to calculate the percentage change of the mean of
+// values relative to the previous slice of the same metric, which allows
+// to alert on sudden shifts regardless of the metric scale.
+type Change struct {
+	*BaseWriter
+}
+
+// changeItem stores the percentage change calculated by Change writer.
+type changeItem struct {
+	// Timestamp of the sample set.
+	time int64
+	// Change of the mean in percents.
+	change float64
+	// Value indicating whether the change is defined (there is a previous
+	// mean, and it is not zero).
+	known bool
+}
+
+// previousMean is the mean of the latest slice of a metric, seen by Change
+// writer.
+type previousMean struct {
+	mean float64
+	time int64 // timestamp of the sample set
+}
+
+var (
+	// Means of the latest slices, keyed by source and series names
+	previousMeans = make(map[string]*previousMean)
+	// Mutex protecting previousMeans
+	previousMeansMutex = &sync.Mutex{}
+)
+
+// Name returns the name of the writer.
+func (self *Change) Name() string {
+	return "change"
+}
+
+// stateful returns true: rollups remember means of series (see
+// statefulWriter).
+func (self *Change) stateful() bool {
+	return true
+}
+
+// unit returns the unit of the writer rollups.
+func (self *Change) unit() string {
+	return "percent"
+}
+
+// rollupData performs summarization on the given sample set and returns
+// changeItem with statistics. The mean of the set is remembered for the
+// next slice of the metric. Pre-aggregated events are counted as many
+// times as their weight.
+func (self *Change) rollupData(set *types.SampleSet) (data dataItem) {
+	total, count := float64(set.Total), float64(set.Count)
+	for idx, value := range set.Values {
+		weight := set.Weight(idx)
+		total += float64(value) * float64(weight)
+		count += float64(weight)
+	}
+	if count == 0 {
+		return
+	}
+	mean := total / count
+
+	key := set.Source + "-" + set.SeriesName()
+	item := &changeItem{time: set.Time}
+	previousMeansMutex.Lock()
+	previous, found := previousMeans[key]
+	if found && previous.time < set.Time && previous.mean != 0 {
+		item.change = (mean - previous.mean) / previous.mean * 100
+		item.known = true
+	}
+	if !found || previous.time < set.Time {
+		previousMeans[key] = &previousMean{mean: mean, time: set.Time}
+	}
+	previousMeansMutex.Unlock()
+
+	data = item
+	return
+}
+
+// expireChanges forgets means of metrics not received since the given time
+// (seconds since epoch), returns the number of forgotten means.
+func expireChanges(before int64) (expired int) {
+	previousMeansMutex.Lock()
+	defer previousMeansMutex.Unlock()
+	for key, previous := range previousMeans {
+		if previous.time < before {
+			previousMeans[key] = nil, false
+			expired++
+		}
+	}
+	return
+}
+
+// String returns string representation of the given changeItem.
+func (self *changeItem) String() string {
+	return fmt.Sprintf("changeItem[time=%d, change=%s]", self.time, self.value())
+}
+
+// rrdInfo returns the list of parameters used to create RRD file.
+func (*changeItem) rrdInfo() []string {
+	return []string{
+		"DS:change:GAUGE:600:U:U",
+		"RRA:AVERAGE:0.5:1:25920",   // 72 hours at 1 sample per 10 secs
+		"RRA:AVERAGE:0.5:60:4320",   // 1 month at 1 sample per 10 mins
+		"RRA:AVERAGE:0.5:2880:5475", // 5 years at 1 sample per 8 hours
+		"RRA:MAX:0.5:1:25920",       // 72 hours at 1 sample per 10 secs
+		"RRA:MAX:0.5:60:4320",       // 1 month at 1 sample per 10 mins
+		"RRA:MAX:0.5:2880:5475",     // 5 years at 1 sample per 8 hours
+	}
+}
+
+// rrdTemplate returns template for RRDTool used to update data.
+func (*changeItem) rrdTemplate() string {
+	return "change"
+}
+
+// rrdString returns a string matching template format with the data to
+// update RRD files.
+func (self *changeItem) rrdString() string {
+	return fmt.Sprintf("%d:%s", self.time, self.value())
+}
+
+// value returns formatted percentage change, or UnknownValue when it is not
+// defined.
+func (self *changeItem) value() string {
+	if !self.known {
+		return UnknownValue
+	}
+	return fmt.Sprintf("%.6f", self.change)
+}
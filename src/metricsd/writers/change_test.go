@@ -0,0 +1,59 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+type ChangeS struct {
+	change *Change
+}
+
+var _ = Suite(&ChangeS{})
+
+func (s *ChangeS) SetUpTest(c *C) {
+	s.change = &Change{}
+	previousMeans = make(map[string]*previousMean)
+}
+
+func (s *ChangeS) TestRollupDataWithEmptySampleSet(c *C) {
+	c.Check(s.change.rollupData(createSampleSet(1000)), IsNil)
+}
+
+func (s *ChangeS) TestRollupDataWithoutPreviousMean(c *C) {
+	data := s.change.rollupData(createSampleSet(1000, 10, 20))
+	c.Check(data, Equals, &changeItem{time: 1000, known: false})
+	c.Check(data.rrdString(), Equals, "1000:U")
+}
+
+func (s *ChangeS) TestRollupData(c *C) {
+	s.change.rollupData(createSampleSet(1000, 10, 30))
+	data := s.change.rollupData(createSampleSet(1010, 25))
+	c.Check(data, Equals, &changeItem{time: 1010, change: 25, known: true})
+	c.Check(data.rrdString(), Equals, "1010:25.000000")
+
+	data = s.change.rollupData(createSampleSet(1020, 20))
+	c.Check(data, Equals, &changeItem{time: 1020, change: -20, known: true})
+}
+
+func (s *ChangeS) TestRollupDataWithZeroPreviousMean(c *C) {
+	s.change.rollupData(createSampleSet(1000, -10, 10))
+	data := s.change.rollupData(createSampleSet(1010, 5))
+	c.Check(data, Equals, &changeItem{time: 1010, known: false})
+}
+
+func (s *ChangeS) TestRollupDataWithWeights(c *C) {
+	s.change.rollupData(createSampleSet(1000, 10))
+	set := createSampleSet(1010)
+	set.AddWeighted(10, 1)
+	set.AddWeighted(20, 3)
+	data := s.change.rollupData(set)
+	c.Check(data, Equals, &changeItem{time: 1010, change: 75, known: true})
+}
+
+func (s *ChangeS) TestExpireChanges(c *C) {
+	s.change.rollupData(createSampleSet(1000, 10))
+	c.Check(expireChanges(1000), Equals, 0)
+	c.Check(expireChanges(1001), Equals, 1)
+	data := s.change.rollupData(createSampleSet(1010, 20))
+	c.Check(data.(*changeItem).known, Equals, false)
+}
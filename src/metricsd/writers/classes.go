@@ -0,0 +1,108 @@
+package writers
+
+import (
+	"fmt"
+	"strings"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+// Classes writer is used to count values falling into labeled classes
+// separated by thresholds (see Classes config option), e.g. "fast", "ok",
+// and "slow" requests. By default it counts positive and negative values,
+// the same way as Count writer.
+type Classes struct {
+	*BaseWriter
+	// Thresholds and labels of classes.
+	Config *config.ClassesConfig
+}
+
+// NewClasses returns a new Classes writer with classes defined in
+// configuration.
+func NewClasses() *Classes {
+	return &Classes{Config: config.Classes}
+}
+
+// classesItem stores number of values in every counted class of the
+// sample set.
+type classesItem struct {
+	// Timestamp of the sample set.
+	time int64
+	// Labels of counted classes.
+	labels []string
+	// Number of values in every counted class.
+	counts []uint64
+}
+
+// Name returns the name of the writer.
+func (*Classes) Name() string {
+	return "classes"
+}
+
+// unit returns the unit of the writer rollups.
+func (*Classes) unit() string {
+	return "count"
+}
+
+// rollupData performs summarization on the given sample set and returns
+// classesItem with statistics. Pre-aggregated or coalesced values are
+// counted as many times as their weight.
+func (self *Classes) rollupData(set *types.SampleSet) (data dataItem) {
+	labels := self.Config.CountedLabels()
+	item := &classesItem{time: set.Time, labels: labels, counts: make([]uint64, len(labels))}
+	indexes := make(map[string]int, len(labels))
+	for idx, label := range labels {
+		indexes[label] = idx
+	}
+	for pos, elem := range set.Values {
+		if label := self.Config.Class(elem); label != "" {
+			item.counts[indexes[label]] += uint64(set.Weight(pos))
+		}
+	}
+	data = item
+	return
+}
+
+// String returns string representation of the given classesItem.
+func (self *classesItem) String() string {
+	return fmt.Sprintf("classesItem[time=%d, labels=%v, counts=%v]", self.time, self.labels, self.counts)
+}
+
+// rrdInfo returns the list of parameters used to create RRD file.
+func (self *classesItem) rrdInfo() []string {
+	info := make([]string, 0, len(self.labels)+3)
+	for _, label := range self.labels {
+		info = append(info, fmt.Sprintf("DS:%s:GAUGE:600:0:U", label))
+	}
+	return append(info,
+		"RRA:AVERAGE:0.5:1:25920",   // 72 hours at 1 sample per 10 secs
+		"RRA:AVERAGE:0.5:60:4320",   // 1 month at 1 sample per 10 mins
+		"RRA:AVERAGE:0.5:2880:5475", // 5 years at 1 sample per 8 hours
+	)
+}
+
+// rrdTemplate returns template for RRDTool used to update data.
+func (self *classesItem) rrdTemplate() string {
+	return strings.Join(self.labels, ":")
+}
+
+// rrdString returns a string matching template format with the data to
+// update RRD files.
+func (self *classesItem) rrdString() string {
+	result := fmt.Sprintf("%d", self.time)
+	for _, count := range self.counts {
+		result += fmt.Sprintf(":%d", capCount(count))
+	}
+	return result
+}
+
+// dataSources returns names of RRD data sources of the writer rollups.
+func (self *Classes) dataSources() []string {
+	return self.Config.CountedLabels()
+}
+
+// ClassesDataSources returns RRD data source names for classes defined in
+// configuration.
+func ClassesDataSources() []string {
+	return config.Classes.CountedLabels()
+}
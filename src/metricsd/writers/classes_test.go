@@ -0,0 +1,38 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+)
+
+type ClassesS struct{}
+
+var _ = Suite(&ClassesS{})
+
+func (s *ClassesS) TestRollupDataWithDefaultClasses(c *C) {
+	writer := NewClasses()
+	data := writer.rollupData(createSampleSet(1000, 1, -1, 0, 5))
+	c.Check(data, Equals, &classesItem{time: 1000, labels: []string{"fail", "ok"}, counts: []uint64{1, 2}})
+	c.Check(data.rrdTemplate(), Equals, "fail:ok")
+	c.Check(data.rrdString(), Equals, "1000:1:2")
+}
+
+func (s *ClassesS) TestRollupDataWithLabeledClasses(c *C) {
+	writer := &Classes{Config: &config.ClassesConfig{Thresholds: []int{100, 500}, Labels: []string{"fast", "ok", "slow"}}}
+	data := writer.rollupData(createSampleSet(1000, 10, 100, 101, 500, 501, 9000))
+	c.Check(data, Equals, &classesItem{time: 1000, labels: []string{"fast", "ok", "slow"}, counts: []uint64{2, 2, 2}})
+	c.Check(data.rrdInfo()[0], Equals, "DS:fast:GAUGE:600:0:U")
+
+	data = writer.rollupData(createSampleSet(1010))
+	c.Check(data.rrdString(), Equals, "1010:0:0:0")
+}
+
+func (s *ClassesS) TestRollupDataWithCoalescedValues(c *C) {
+	writer := &Classes{Config: &config.ClassesConfig{Thresholds: []int{100, 500}, Labels: []string{"fast", "ok", "slow"}}}
+	values := []int{10, 10, 200, 200, 200, 9000}
+	coalesced := createCoalescedSampleSet(1000, values...)
+	c.Check(coalesced.Values, Equals, []int{10, 200, 9000})
+	data := writer.rollupData(coalesced)
+	c.Check(data, Equals, writer.rollupData(createSampleSet(1000, values...)))
+	c.Check(data, Equals, &classesItem{time: 1000, labels: []string{"fast", "ok", "slow"}, counts: []uint64{2, 3, 1}})
+}
@@ -0,0 +1,60 @@
+package writers
+
+import (
+	"fmt"
+	"hash/crc32"
+	"hash/crc64"
+	"sync"
+	"sync/atomic"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+var (
+	// Number of RRD updates dropped because of path collisions (reset by
+	// stats reporting)
+	PathCollisions int64
+	// Checksums of series owning RRD files, keyed by checksums of RRD file
+	// paths (checksums keep memory usage low with many series)
+	rrdOwners = make(map[uint64]uint32)
+	// RRD files with collisions reported already
+	rrdCollisions = make(map[uint64]bool)
+	// Mutex protecting rrdOwners and rrdCollisions
+	rrdOwnersMutex = &sync.Mutex{}
+	// Table used to calculate checksums of RRD file paths
+	rrdPathTable = crc64.MakeTable(crc64.ISO)
+)
+
+// claimRrdFile returns the path of the RRD file of the sample set series
+// written by the writer (see getRrdFile). The first series written to a
+// path since startup owns it. Different series mapped to the same path
+// (e.g. "a$b" and "a.b", or tags with separators in values) are handled
+// according to CollisionPolicy: their updates are dropped and counted in
+// PathCollisions ("error"), or written to a file with a suffix derived from
+// the series ("rename"). Returns false when the update should be dropped.
+func claimRrdFile(writer Writer, set *types.SampleSet) (file string, ok bool) {
+	file = getRrdFile(writer, set)
+	series := crc32.ChecksumIEEE([]byte(set.Source + " " + types.SeriesKey(set.Name, set.Tags)))
+	path := crc64.Checksum([]byte(file), rrdPathTable)
+
+	rrdOwnersMutex.Lock()
+	defer rrdOwnersMutex.Unlock()
+	owner, found := rrdOwners[path]
+	if !found {
+		rrdOwners[path] = series
+		return file, true
+	}
+	if owner == series {
+		return file, true
+	}
+
+	if config.CollisionPolicy == config.COLLISION_POLICY_RENAME {
+		return fmt.Sprintf("%s-%08x.rrd", file[:len(file)-len(".rrd")], series), true
+	}
+	atomic.AddInt64(&PathCollisions, 1)
+	if !rrdCollisions[path] {
+		rrdCollisions[path] = true
+		config.Logger.Error("Series %s of %s collides with another series in %s, dropping its updates", set.SeriesName(), set.Source, file)
+	}
+	return "", false
+}
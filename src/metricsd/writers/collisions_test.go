@@ -0,0 +1,86 @@
+package writers
+
+import (
+	"io/ioutil"
+	"os"
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+	"metricsd/logger"
+)
+
+type CollisionsS struct{}
+
+var _ = Suite(&CollisionsS{})
+
+func (s *CollisionsS) SetUpTest(c *C) {
+	dir, err := ioutil.TempDir("", "metricsd")
+	c.Assert(err, IsNil)
+	config.DataDir = dir
+	config.Logger = logger.NewConsoleLogger(logger.UNKNOWN)
+	rrdOwners = make(map[uint64]uint32)
+	rrdCollisions = make(map[uint64]bool)
+	PathCollisions = 0
+}
+
+func (s *CollisionsS) TearDownTest(c *C) {
+	os.RemoveAll(config.DataDir)
+	config.DataDir = config.DEFAULT_DATA_DIR
+	config.CollisionPolicy = config.DEFAULT_COLLISION_POLICY
+}
+
+func (s *CollisionsS) TestClaimRrdFile(c *C) {
+	set := createSampleSet(1000, 1)
+	file, ok := claimRrdFile(&Count{}, set)
+	c.Check(ok, Equals, true)
+	c.Check(file, Equals, config.DataDir+"/src/metric-count.rrd")
+
+	// The same series, and the same metric written by other writers
+	file, ok = claimRrdFile(&Count{}, createSampleSet(1010, 1))
+	c.Check(ok, Equals, true)
+	c.Check(file, Equals, config.DataDir+"/src/metric-count.rrd")
+	_, ok = claimRrdFile(&Cov{}, set)
+	c.Check(ok, Equals, true)
+	c.Check(PathCollisions, Equals, int64(0))
+}
+
+func (s *CollisionsS) TestClaimRrdFileCollision(c *C) {
+	set := createSampleSet(1000, 1)
+	set.Name = "app.metric"
+	claimRrdFile(&Count{}, set)
+
+	other := createSampleSet(1000, 1)
+	other.Name = "app$metric"
+	_, ok := claimRrdFile(&Count{}, other)
+	c.Check(ok, Equals, false)
+	_, ok = claimRrdFile(&Count{}, other)
+	c.Check(ok, Equals, false)
+	c.Check(PathCollisions, Equals, int64(2))
+
+	// The owner is not affected
+	_, ok = claimRrdFile(&Count{}, set)
+	c.Check(ok, Equals, true)
+}
+
+func (s *CollisionsS) TestClaimRrdFileRename(c *C) {
+	config.CollisionPolicy = config.COLLISION_POLICY_RENAME
+	set := createSampleSet(1000, 1)
+	set.Name = "app.metric"
+	claimRrdFile(&Count{}, set)
+
+	other := createSampleSet(1000, 1)
+	other.Name = "app$metric"
+	file, ok := claimRrdFile(&Count{}, other)
+	c.Check(ok, Equals, true)
+	c.Check(file, Matches, config.DataDir+"/src/app.metric-count-[0-9a-f]{8}\\.rrd")
+	c.Check(PathCollisions, Equals, int64(0))
+}
+
+func (s *CollisionsS) TestNewRouteWithDuplicateWriters(c *C) {
+	_, err := NewRoute("", 10, []string{"count", "count"}, nil)
+	c.Check(err, Not(IsNil))
+
+	config.CollisionPolicy = config.COLLISION_POLICY_RENAME
+	route, err := NewRoute("", 10, []string{"count", "count"}, nil)
+	c.Assert(err, IsNil)
+	c.Check(len(route.Aggregator.Writers), Equals, 1)
+}
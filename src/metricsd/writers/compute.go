@@ -0,0 +1,57 @@
+package writers
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Operators and constants of RPN expressions of COMPUTE data sources (see
+// rrdcreate and rrdgraph_rpn man pages).
+var rpnOperators = make(map[string]bool)
+
+func init() {
+	for _, op := range strings.Fields(`
+		+ - * / % ADDNAN LT LE GT GE EQ NE UN ISINF IF MIN MAX MINNAN MAXNAN
+		LIMIT SIN COS LOG EXP SQRT ATAN ATAN2 FLOOR CEIL ROUND DEG2RAD RAD2DEG
+		ABS POW SORT REV AVG SMIN SMAX MEDIAN STDEV PERCENT TREND TRENDNAN
+		PREDICT PREDICTSIGMA UNKN INF NEGINF PREV COUNT NOW TIME LTIME DUP POP
+		EXC DEPTH COPY INDEX ROLL`) {
+		rpnOperators[op] = true
+	}
+}
+
+// validateRrdInfo checks COMPUTE data sources in the list of parameters
+// used to create RRD file ("DS:name:COMPUTE:rpn-expression"): every name
+// referenced by the expression should be a data source defined before it.
+// Values of COMPUTE data sources are calculated by RRDTool, so they are
+// never included in update templates.
+func validateRrdInfo(info []string) os.Error {
+	defined := make(map[string]bool)
+	for _, item := range info {
+		if !strings.HasPrefix(item, "DS:") {
+			continue
+		}
+		fields := strings.SplitN(item, ":", 4)
+		if len(fields) < 3 {
+			return os.NewError(fmt.Sprintf("Invalid data source %q", item))
+		}
+		if fields[2] == "COMPUTE" {
+			if len(fields) < 4 || fields[3] == "" {
+				return os.NewError(fmt.Sprintf("COMPUTE data source %q has no expression", fields[1]))
+			}
+			for _, token := range strings.Split(fields[3], ",") {
+				if rpnOperators[token] || defined[token] {
+					continue
+				}
+				if _, err := strconv.Atof64(token); err == nil {
+					continue
+				}
+				return os.NewError(fmt.Sprintf("COMPUTE data source %q references unknown data source %q", fields[1], token))
+			}
+		}
+		defined[fields[1]] = true
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+type ComputeS struct{}
+
+var _ = Suite(&ComputeS{})
+
+func (s *ComputeS) TestValidateRrdInfo(c *C) {
+	c.Check(validateRrdInfo((&countItem{}).rrdInfo()), IsNil)
+	c.Check(validateRrdInfo([]string{
+		"DS:sum:GAUGE:600:U:U",
+		"DS:double:COMPUTE:sum,2,*",
+		"DS:quadruple:COMPUTE:double,2.0,*",
+		"RRA:AVERAGE:0.5:1:25920",
+	}), IsNil)
+}
+
+func (s *ComputeS) TestValidateRrdInfoWithUnknownDataSource(c *C) {
+	err := validateRrdInfo([]string{"DS:ok:ABSOLUTE:600:0:U", "DS:ratio:COMPUTE:fail,ok,/"})
+	c.Check(err.String(), Equals, `COMPUTE data source "ratio" references unknown data source "fail"`)
+}
+
+func (s *ComputeS) TestValidateRrdInfoWithDataSourceDefinedLater(c *C) {
+	err := validateRrdInfo([]string{"DS:ratio:COMPUTE:ok,2,/", "DS:ok:ABSOLUTE:600:0:U"})
+	c.Check(err, Not(IsNil))
+}
+
+func (s *ComputeS) TestValidateRrdInfoWithoutExpression(c *C) {
+	err := validateRrdInfo([]string{"DS:ratio:COMPUTE:"})
+	c.Check(err.String(), Equals, `COMPUTE data source "ratio" has no expression`)
+}
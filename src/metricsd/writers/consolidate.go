@@ -0,0 +1,99 @@
+package writers
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+// consolidatedItem is the rollup of the latest slice of a series written in
+// a pass, with values consolidated over rollups of all slices of the series
+// written in the pass (see config.MetricConfig.Consolidation).
+type consolidatedItem struct {
+	dataItem
+	// Consolidated values, in the order of the RRD template.
+	values []string
+	// Number of consolidated rollups.
+	count int
+}
+
+// consolidates returns a value indicating whether rollups of the sample set
+// metric are consolidated across slices written in the same pass.
+func consolidates(set *types.SampleSet) bool {
+	return config.MetricOptions(set.Name).Consolidation != config.CONSOLIDATION_NONE
+}
+
+// consolidate combines rollups of slices of a series (oldest first) into a
+// single data item at the time of the latest one using the method (see
+// config.MetricConfig.Consolidation). Unknown values are skipped, values
+// unknown in every rollup stay unknown. Values formatted as integers in
+// every rollup stay integers, unless averaging leaves a fraction.
+func consolidate(method string, data []dataItem) dataItem {
+	latest := data[len(data)-1]
+	if len(data) == 1 || method == config.CONSOLIDATION_LAST {
+		return latest
+	}
+	_, latestValues := dataFields(latest)
+	results := make([]float64, len(latestValues))
+	known := make([]int, len(latestValues))
+	fractional := make([]bool, len(latestValues))
+	for _, item := range data {
+		_, values := dataFields(item)
+		for idx, value := range values {
+			if idx >= len(results) || value == UnknownValue {
+				continue
+			}
+			number, error := strconv.Atof64(value)
+			if error != nil {
+				continue
+			}
+			switch {
+			case known[idx] == 0:
+				results[idx] = number
+			case method == config.CONSOLIDATION_MAX:
+				results[idx] = math.Fmax(results[idx], number)
+			default:
+				results[idx] += number
+			}
+			known[idx]++
+			fractional[idx] = fractional[idx] || strings.IndexAny(value, ".eE") >= 0
+		}
+	}
+	item := &consolidatedItem{dataItem: latest, values: make([]string, len(results)), count: len(data)}
+	for idx, result := range results {
+		switch {
+		case known[idx] == 0:
+			item.values[idx] = UnknownValue
+		case method == config.CONSOLIDATION_AVERAGE:
+			item.values[idx] = consolidatedValue(result/float64(known[idx]), fractional[idx])
+		default:
+			item.values[idx] = consolidatedValue(result, fractional[idx])
+		}
+	}
+	return item
+}
+
+// consolidatedValue returns the formatted value, integral values of
+// fields without fractions are formatted as integers, so consolidated
+// counts stay counts.
+func consolidatedValue(value float64, fractional bool) string {
+	if !fractional && value == math.Floor(value) && math.Fabs(value) < 1e15 {
+		return fmt.Sprintf("%d", int64(value))
+	}
+	return fmt.Sprintf("%f", value)
+}
+
+// String returns string representation of the given consolidatedItem.
+func (self *consolidatedItem) String() string {
+	return fmt.Sprintf("consolidatedItem[latest=%s, values=%v, count=%d]", self.dataItem, self.values, self.count)
+}
+
+// rrdString returns a string matching template format with the
+// consolidated data to update RRD files.
+func (self *consolidatedItem) rrdString() string {
+	time := strings.SplitN(self.dataItem.rrdString(), ":", 2)[0]
+	return time + ":" + strings.Join(self.values, ":")
+}
@@ -0,0 +1,67 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+	"os"
+	"sync"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+type ConsolidateS struct {
+	data []dataItem
+}
+
+var _ = Suite(&ConsolidateS{})
+
+func (s *ConsolidateS) SetUpTest(c *C) {
+	sum := &Sum{Interval: 10}
+	s.data = []dataItem{sum.item(1000, 1), sum.item(1010, 5), sum.item(1020, 3)}
+}
+
+func (s *ConsolidateS) TestConsolidate(c *C) {
+	c.Check(consolidate(config.CONSOLIDATION_SUM, s.data).rrdString(), Equals, "1020:9:0.900000")
+	c.Check(consolidate(config.CONSOLIDATION_AVERAGE, s.data).rrdString(), Equals, "1020:3:0.300000")
+	c.Check(consolidate(config.CONSOLIDATION_MAX, s.data).rrdString(), Equals, "1020:5:0.500000")
+	c.Check(consolidate(config.CONSOLIDATION_LAST, s.data), Equals, s.data[2])
+}
+
+func (s *ConsolidateS) TestConsolidateKeepsRrdParameters(c *C) {
+	data := consolidate(config.CONSOLIDATION_SUM, s.data)
+	c.Check(data.rrdTemplate(), Equals, "sum:rate")
+	c.Check(data.rrdInfo(), Equals, s.data[2].rrdInfo())
+}
+
+func (s *ConsolidateS) TestConsolidateSingleRollup(c *C) {
+	c.Check(consolidate(config.CONSOLIDATION_SUM, s.data[:1]), Equals, s.data[0])
+}
+
+func (s *ConsolidateS) TestConsolidateSkipsUnknownValues(c *C) {
+	data := append(s.data, &unknownItem{time: 1030, prototype: &sumItem{}})
+	c.Check(consolidate(config.CONSOLIDATION_AVERAGE, data).rrdString(), Equals, "1030:3:0.300000")
+
+	unknown := []dataItem{&unknownItem{time: 1000, prototype: &sumItem{}}, &unknownItem{time: 1010, prototype: &sumItem{}}}
+	c.Check(consolidate(config.CONSOLIDATION_SUM, unknown).rrdString(), Equals, "1010:U:U")
+}
+
+func (s *ConsolidateS) TestBatchRollupConsolidates(c *C) {
+	config.SetMetrics([]*config.MetricConfig{&config.MetricConfig{Pattern: "metric", Consolidation: config.CONSOLIDATION_SUM}})
+	defer config.SetMetrics(nil)
+	mutex := &sync.Mutex{}
+	updates := make(map[string][]string)
+	updateRrdFile = func(writer Writer, set *types.SampleSet, data dataItem, args []string) os.Error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		updates[set.Name] = append(updates[set.Name], args...)
+		return nil
+	}
+	defer func() { updateRrdFile = safeUpdateRrd }()
+
+	// Slices of the consolidated metric are written once, another one as is
+	other := types.NewSampleSet(1000, "src", "other")
+	other.Add(4)
+	sets := []*types.SampleSet{createSampleSet(1000, 1), createSampleSet(1010, 2, 3), createSampleSet(1020, 4), other}
+
+	c.Check(BatchRollup(&Sum{Interval: 10}, sets, nil), IsNil)
+	c.Check(updates, Equals, map[string][]string{"metric": []string{"1020:10:1.000000"}, "other": []string{"1000:4:0.400000"}})
+}
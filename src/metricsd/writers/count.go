@@ -2,10 +2,16 @@ package writers
 
 import (
 	"fmt"
+	"math"
+	"metricsd/config"
 	"metricsd/types"
 )
 
-// Count writer is used to calculate positive and negative numbers.
+// Count writer is used to calculate positive and negative numbers, or
+// values matching and not matching the per-metric Success predicate. Both
+// sides are swapped for metrics with Invert per-metric option. Rollups of
+// metrics with MaxErrorRate per-metric option are exported with a flag
+// indicating whether the ratio of failed values exceeds it.
 type Count struct {
 	*BaseWriter
 }
@@ -18,6 +24,9 @@ type countItem struct {
 	ok uint64
 	// Number of negative values.
 	fail uint64
+	// Ratio of failed values above which the item is over threshold, 0
+	// means no threshold.
+	threshold float64
 }
 
 // Name returns the name of the writer.
@@ -25,18 +34,47 @@ func (*Count) Name() string {
 	return "count"
 }
 
+// unit returns the unit of the writer rollups.
+func (*Count) unit() string {
+	return "count"
+}
+
 // rollupData performs summarization on the given sample set and returns
-// countItem with statistics.
+// countItem with statistics. When Success predicate is configured for the
+// metric, every value is either successful or failed, otherwise positive
+// values are successful, negative values are failed, and zeros are counted
+// according to Zeros per-metric option (not counted by default). With
+// Invert per-metric option failed values are counted as successful and
+// vice versa (zeros are counted as configured). Pre-aggregated or coalesced
+// values are counted as many times as their weight.
 func (self *Count) rollupData(set *types.SampleSet) (data dataItem) {
 	var ok, fail uint64
-	for _, elem := range set.Values {
-		if elem > 0 {
-			ok++
-		} else if elem < 0 {
-			fail++
+	options := config.MetricOptions(set.Name)
+	if success := options.Success; success != nil {
+		for idx, elem := range set.Values {
+			if success.Match(elem) != options.Invert {
+				ok += uint64(set.Weight(idx))
+			} else {
+				fail += uint64(set.Weight(idx))
+			}
 		}
+		return &countItem{time: set.Time, ok: ok, fail: fail, threshold: options.MaxErrorRate}
 	}
-	data = &countItem{time: set.Time, ok: ok, fail: fail}
+	for idx, elem := range set.Values {
+		weight := uint64(set.Weight(idx))
+		switch {
+		case elem == 0 && options.Zeros == config.ZERO_POLICY_OK:
+			ok += weight
+		case elem == 0 && options.Zeros == config.ZERO_POLICY_FAIL:
+			fail += weight
+		case elem == 0:
+		case (elem > 0) != options.Invert:
+			ok += weight
+		default:
+			fail += weight
+		}
+	}
+	data = &countItem{time: set.Time, ok: ok, fail: fail, threshold: options.MaxErrorRate}
 	return
 }
 
@@ -45,11 +83,23 @@ func (self *countItem) String() string {
 	return fmt.Sprintf("countItem[time=%d, ok=%d, fail=%d]", self.time, self.ok, self.fail)
 }
 
+// overThreshold returns a value indicating whether the ratio of failed
+// values exceeds the threshold (never for items without values), and
+// whether the threshold is set.
+func (self *countItem) overThreshold() (over, defined bool) {
+	if self.threshold <= 0 {
+		return false, false
+	}
+	total := self.ok + self.fail
+	return total > 0 && float64(self.fail)/float64(total) > self.threshold, true
+}
+
 // rrdInfo returns the list of parameters used to create RRD file.
 func (*countItem) rrdInfo() []string {
 	return []string{
 		"DS:ok:ABSOLUTE:600:0:U",
 		"DS:fail:ABSOLUTE:600:0:U",
+		"DS:error_ratio:COMPUTE:fail,ok,fail,+,/",
 		"RRA:AVERAGE:0.5:1:25920",   // 72 hours at 1 sample per 10 secs
 		"RRA:AVERAGE:0.5:60:4320",   // 1 month at 1 sample per 10 mins
 		"RRA:AVERAGE:0.5:2880:5475", // 5 years at 1 sample per 8 hours
@@ -62,7 +112,16 @@ func (*countItem) rrdTemplate() string {
 }
 
 // rrdString returns a string matching template format with the data to
-// update RRD files.
+// update RRD files. Counts are capped at the largest int64, so consumers
+// parsing signed integers (RRDTool, InfluxDB) never see a wrapped value.
 func (self *countItem) rrdString() string {
-	return fmt.Sprintf("%d:%d:%d", self.time, self.ok, self.fail)
+	return fmt.Sprintf("%d:%d:%d", self.time, capCount(self.ok), capCount(self.fail))
+}
+
+// capCount returns the count, or the largest int64 when it exceeds it.
+func capCount(count uint64) uint64 {
+	if count > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return count
 }
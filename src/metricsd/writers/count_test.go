@@ -2,6 +2,8 @@ package writers
 
 import (
 	. "launchpad.net/gocheck"
+	"math"
+	"metricsd/config"
 )
 
 type CountS struct {
@@ -14,6 +16,10 @@ func (s *CountS) SetUpTest(c *C) {
 	s.count = &Count{}
 }
 
+func (s *CountS) TearDownTest(c *C) {
+	config.SetMetrics(nil)
+}
+
 func (s *CountS) TestRollupDataWithEmptySampleSet(c *C) {
 	ss := createSampleSet(1000)
 	data := s.count.rollupData(ss)
@@ -26,6 +32,44 @@ func (s *CountS) TestRollupDataWithSampleSetContainsZero(c *C) {
 	c.Check(data, Equals, &countItem{time: 2000, ok: 0, fail: 0})
 }
 
+func (s *CountS) TestRollupDataWithInvert(c *C) {
+	config.SetMetrics([]*config.MetricConfig{&config.MetricConfig{Pattern: "metric", Invert: true}})
+	data := s.count.rollupData(createSampleSet(3000, 1, -1, -5, 0))
+	c.Check(data, Equals, &countItem{time: 3000, ok: 2, fail: 1})
+
+	success, err := config.ParsePredicate(">= 10")
+	c.Assert(err, IsNil)
+	config.SetMetrics([]*config.MetricConfig{&config.MetricConfig{Pattern: "metric", Invert: true, Success: success}})
+	data = s.count.rollupData(createSampleSet(3000, 10, 20, 0))
+	c.Check(data, Equals, &countItem{time: 3000, ok: 1, fail: 2})
+}
+
+func (s *CountS) TestRollupDataWithZeroPolicy(c *C) {
+	config.SetMetrics([]*config.MetricConfig{&config.MetricConfig{Pattern: "metric", Zeros: config.ZERO_POLICY_FAIL}})
+	data := s.count.rollupData(createSampleSet(3000, 1, 0, 0, -1))
+	c.Check(data, Equals, &countItem{time: 3000, ok: 1, fail: 3})
+
+	config.SetMetrics([]*config.MetricConfig{&config.MetricConfig{Pattern: "metric", Zeros: config.ZERO_POLICY_OK, Invert: true}})
+	data = s.count.rollupData(createSampleSet(3000, 1, 0, 0, -1))
+	c.Check(data, Equals, &countItem{time: 3000, ok: 3, fail: 1})
+}
+
+func (s *CountS) TestRollupDataWithMaxErrorRate(c *C) {
+	config.SetMetrics([]*config.MetricConfig{&config.MetricConfig{Pattern: "metric", MaxErrorRate: 0.25}})
+	data := s.count.rollupData(createSampleSet(3000, 1, 1, -1))
+	c.Check(data, Equals, &countItem{time: 3000, ok: 2, fail: 1, threshold: 0.25})
+	c.Check(debugValues(data), Equals, map[string]interface{}{"ok": 2.0, "fail": 1.0, "over_threshold": 1.0})
+
+	data = s.count.rollupData(createSampleSet(3000, 1, 1, 1, -1))
+	c.Check(debugValues(data), Equals, map[string]interface{}{"ok": 3.0, "fail": 1.0, "over_threshold": 0.0})
+	data = s.count.rollupData(createSampleSet(3000))
+	c.Check(debugValues(data), Equals, map[string]interface{}{"ok": 0.0, "fail": 0.0, "over_threshold": 0.0})
+
+	config.SetMetrics(nil)
+	data = s.count.rollupData(createSampleSet(3000, -1))
+	c.Check(debugValues(data), Equals, map[string]interface{}{"ok": 0.0, "fail": 1.0})
+}
+
 func (s *CountS) TestRollupDataWithSimpleSampleSet(c *C) {
 	ss := createSampleSet(3000, 1, -1)
 	data := s.count.rollupData(ss)
@@ -37,3 +81,50 @@ func (s *CountS) TestRollupDataWithComplexSampleSet(c *C) {
 	data := s.count.rollupData(ss)
 	c.Check(data, Equals, &countItem{time: 4000, ok: 3, fail: 1})
 }
+
+func (s *CountS) TestRollupDataWithSuccessPredicate(c *C) {
+	success, err := config.ParsePredicate("in [200, 299] or in {301, 304}")
+	c.Assert(err, IsNil)
+	config.SetMetrics([]*config.MetricConfig{&config.MetricConfig{Pattern: "metric", Success: success}})
+	ss := createSampleSet(5000, 200, 204, 299, 304, 302, 404, 500, 0)
+	data := s.count.rollupData(ss)
+	c.Check(data, Equals, &countItem{time: 5000, ok: 4, fail: 4})
+}
+
+func (s *CountS) TestRollupDataWithComparisonPredicate(c *C) {
+	success, err := config.ParsePredicate(">= 0")
+	c.Assert(err, IsNil)
+	config.SetMetrics([]*config.MetricConfig{&config.MetricConfig{Pattern: "metric", Success: success}})
+	ss := createSampleSet(6000, 5, 0, -1)
+	data := s.count.rollupData(ss)
+	c.Check(data, Equals, &countItem{time: 6000, ok: 2, fail: 1})
+}
+
+func (s *CountS) TestParseInvalidPredicates(c *C) {
+	for _, spec := range []string{"", "~ 1", ">= x", "in [2, 1]", "in [1]", "in (1, 2)", "in {1, a}", "> 1 or"} {
+		_, err := config.ParsePredicate(spec)
+		if err == nil {
+			c.Errorf("Predicate %q should be invalid", spec)
+		}
+	}
+}
+
+func (s *CountS) TestRrdStringWithNearMaxCounts(c *C) {
+	data := &countItem{time: 1000, ok: math.MaxInt64 - 1, fail: math.MaxUint64}
+	c.Check(data.rrdString(), Equals, "1000:9223372036854775806:9223372036854775807")
+}
+
+func (s *CountS) TestRollupDataWithCoalescedValues(c *C) {
+	values := []int{5, 5, 5, -1, -1, 0, 0, 3}
+	coalesced := createCoalescedSampleSet(6000, values...)
+	c.Check(coalesced.Values, Equals, []int{5, -1, 0, 3})
+	data := s.count.rollupData(coalesced)
+	c.Check(data, Equals, s.count.rollupData(createSampleSet(6000, values...)))
+	c.Check(data, Equals, &countItem{time: 6000, ok: 4, fail: 2})
+
+	success, err := config.ParsePredicate(">= 3")
+	c.Assert(err, IsNil)
+	config.SetMetrics([]*config.MetricConfig{&config.MetricConfig{Pattern: "metric", Success: success}})
+	data = s.count.rollupData(createCoalescedSampleSet(6000, values...))
+	c.Check(data, Equals, s.count.rollupData(createSampleSet(6000, values...)))
+}
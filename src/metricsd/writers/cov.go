@@ -0,0 +1,114 @@
+package writers
+
+import (
+	"fmt"
+	"math"
+	"metricsd/types"
+)
+
+// Cov writer is used to calculate coefficient of variation (standard
+// deviation divided by mean), which allows to compare variability of
+// metrics with different scales.
+type Cov struct {
+	*BaseWriter
+}
+
+// covItem stores coefficient of variation calculated by Cov writer.
+type covItem struct {
+	// Timestamp of the sample set.
+	time int64
+	// Coefficient of variation.
+	cov float64
+	// Value indicating whether coefficient of variation is defined (there
+	// are at least two samples and mean is not zero).
+	known bool
+}
+
+// Name returns the name of the writer.
+func (self *Cov) Name() string {
+	return "cov"
+}
+
+// unit returns the unit of the writer rollups.
+func (self *Cov) unit() string {
+	return "ratio"
+}
+
+// rollupData performs summarization on the given sample set and returns
+// covItem with statistics. Pre-aggregated or coalesced values are counted
+// as many times as their weight.
+func (self *Cov) rollupData(set *types.SampleSet) (data dataItem) {
+	if len(set.Values) == 0 {
+		return
+	}
+
+	item := &covItem{time: set.Time}
+	number, mean, deviation := meanAndDeviation(set)
+	if number >= 2 && mean != 0 {
+		item.cov = deviation / mean
+		item.known = true
+	}
+	data = item
+	return
+}
+
+// prototype returns an empty data item used to report unknown values.
+func (*Cov) prototype() dataItem {
+	return &covItem{}
+}
+
+// String returns string representation of the given covItem.
+func (self *covItem) String() string {
+	return fmt.Sprintf("covItem[time=%d, cov=%s]", self.time, self.value())
+}
+
+// rrdInfo returns the list of parameters used to create RRD file.
+func (*covItem) rrdInfo() []string {
+	return []string{
+		"DS:cov:GAUGE:600:U:U",
+		"RRA:AVERAGE:0.5:1:25920",   // 72 hours at 1 sample per 10 secs
+		"RRA:AVERAGE:0.5:60:4320",   // 1 month at 1 sample per 10 mins
+		"RRA:AVERAGE:0.5:2880:5475", // 5 years at 1 sample per 8 hours
+		"RRA:MAX:0.5:1:25920",       // 72 hours at 1 sample per 10 secs
+		"RRA:MAX:0.5:60:4320",       // 1 month at 1 sample per 10 mins
+		"RRA:MAX:0.5:2880:5475",     // 5 years at 1 sample per 8 hours
+	}
+}
+
+// rrdTemplate returns template for RRDTool used to update data.
+func (*covItem) rrdTemplate() string {
+	return "cov"
+}
+
+// rrdString returns a string matching template format with the data to
+// update RRD files.
+func (self *covItem) rrdString() string {
+	return fmt.Sprintf("%d:%s", self.time, self.value())
+}
+
+// value returns formatted coefficient of variation, or UnknownValue when it
+// is not defined.
+func (self *covItem) value() string {
+	if !self.known {
+		return UnknownValue
+	}
+	return fmt.Sprintf("%.6f", self.cov)
+}
+
+// meanAndDeviation calculates mean and population standard deviation of the
+// values of the sample set in a single pass (using weighted Welford's
+// method), and the number of values counting weights.
+func meanAndDeviation(set *types.SampleSet) (number int64, mean, deviation float64) {
+	var sqdiff float64 = 0
+	for idx, elem := range set.Values {
+		weight := int64(set.Weight(idx))
+		number += weight
+		delta := float64(elem) - mean
+		mean += delta * float64(weight) / float64(number)
+		sqdiff += float64(weight) * delta * (float64(elem) - mean)
+	}
+	if number > 0 {
+		deviation = math.Sqrt(sqdiff / float64(number))
+	}
+	return
+}
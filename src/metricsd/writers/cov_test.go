@@ -0,0 +1,58 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+	"math"
+)
+
+type CovS struct {
+	cov *Cov
+}
+
+var _ = Suite(&CovS{})
+
+func (s *CovS) SetUpTest(c *C) {
+	s.cov = &Cov{}
+}
+
+func (s *CovS) TestRollupDataWithEmptySampleSet(c *C) {
+	ss := createSampleSet(1000)
+	data := s.cov.rollupData(ss)
+	c.Check(data, IsNil)
+}
+
+func (s *CovS) TestRollupDataWithSampleSetWith1Item(c *C) {
+	ss := createSampleSet(2000, 10)
+	data := s.cov.rollupData(ss)
+	c.Check(data, Equals, &covItem{time: 2000, cov: 0, known: false})
+	c.Check(data.rrdString(), Equals, "2000:U")
+}
+
+func (s *CovS) TestRollupDataWithZeroMean(c *C) {
+	ss := createSampleSet(3000, -5, 5)
+	data := s.cov.rollupData(ss)
+	c.Check(data, Equals, &covItem{time: 3000, cov: 0, known: false})
+}
+
+func (s *CovS) TestRollupDataWithSampleSetWith2Items(c *C) {
+	ss := createSampleSet(4000, 10, 20)
+	data := s.cov.rollupData(ss)
+	c.Check(data, Equals, &covItem{time: 4000, cov: 5.0 / 15.0, known: true})
+	c.Check(data.rrdString(), Equals, "4000:0.333333")
+}
+
+func (s *CovS) TestRollupDataWithComplexSampleSet(c *C) {
+	ss := createSampleSet(5000, 2, 4, 4, 4, 5, 5, 7, 9)
+	data := s.cov.rollupData(ss).(*covItem)
+	c.Check(data.known, Equals, true)
+	c.Check(math.Fabs(data.cov-0.4) < 1e-9, Equals, true)
+}
+
+func (s *CovS) TestRollupDataWithCoalescedValues(c *C) {
+	values := []int{10, 10, 10, 20, 30, 30}
+	coalesced := createCoalescedSampleSet(7000, values...)
+	c.Check(coalesced.Values, Equals, []int{10, 20, 30})
+	data := s.cov.rollupData(coalesced)
+	c.Check(data.rrdString(), Equals, s.cov.rollupData(createSampleSet(7000, values...)).rrdString())
+	c.Check(data.rrdString(), Equals, "7000:0.489560")
+}
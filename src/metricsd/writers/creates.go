@@ -0,0 +1,51 @@
+package writers
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"metricsd/config"
+)
+
+var (
+	// Number of RRD file creations waiting for a free slot
+	createQueueDepth int64
+	// Slots of concurrent RRD file creations (see config.RrdCreateLimit),
+	// nil when creations are not limited
+	createSlots chan bool
+	// Mutex protecting createSlots
+	createSlotsMutex = &sync.Mutex{}
+)
+
+// limitCreate calls the function creating an RRD file when fewer than
+// RrdCreateLimit files are being created, otherwise waits for a free slot,
+// so a flood of new metrics does not fork hundreds of rrdtool processes at
+// once. Updates of existing files are not limited.
+func limitCreate(create func() os.Error) os.Error {
+	slots := getCreateSlots()
+	if slots == nil {
+		return create()
+	}
+	atomic.AddInt64(&createQueueDepth, 1)
+	slots <- true
+	atomic.AddInt64(&createQueueDepth, -1)
+	defer func() { <-slots }()
+	return create()
+}
+
+// getCreateSlots returns slots of concurrent RRD file creations, allocated
+// on first use.
+func getCreateSlots() chan bool {
+	createSlotsMutex.Lock()
+	defer createSlotsMutex.Unlock()
+	if createSlots == nil && config.RrdCreateLimit > 0 {
+		createSlots = make(chan bool, config.RrdCreateLimit)
+	}
+	return createSlots
+}
+
+// CreateQueueDepth returns the number of RRD file creations waiting for
+// a free slot.
+func CreateQueueDepth() int {
+	return int(atomic.AddInt64(&createQueueDepth, 0))
+}
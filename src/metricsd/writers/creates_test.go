@@ -0,0 +1,58 @@
+package writers
+
+import (
+	"os"
+	"time"
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+)
+
+type CreatesS struct{}
+
+var _ = Suite(&CreatesS{})
+
+func (s *CreatesS) SetUpTest(c *C) {
+	createSlots = nil
+}
+
+func (s *CreatesS) TearDownTest(c *C) {
+	config.RrdCreateLimit = config.DEFAULT_RRD_CREATE_LIMIT
+	createSlots = nil
+}
+
+func (s *CreatesS) TestLimitCreateWithoutLimit(c *C) {
+	called := false
+	err := limitCreate(func() os.Error {
+		called = true
+		return os.NewError("failed")
+	})
+	c.Check(called, Equals, true)
+	c.Check(err, Not(IsNil))
+	c.Check(createSlots == nil, Equals, true)
+}
+
+func (s *CreatesS) TestLimitCreateQueuesCreations(c *C) {
+	config.RrdCreateLimit = 1
+	started := make(chan bool)
+	release := make(chan bool)
+	go limitCreate(func() os.Error {
+		started <- true
+		<-release
+		return nil
+	})
+	<-started
+
+	done := make(chan bool)
+	go func() {
+		limitCreate(func() os.Error { return nil })
+		done <- true
+	}()
+	for CreateQueueDepth() == 0 {
+		time.Sleep(1e6)
+	}
+	c.Check(CreateQueueDepth(), Equals, 1)
+
+	release <- true
+	<-done
+	c.Check(CreateQueueDepth(), Equals, 0)
+}
@@ -0,0 +1,107 @@
+package writers
+
+import (
+	"fmt"
+	"io"
+	"json"
+	"os"
+	"strconv"
+	"sync"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+var (
+	// Debug file opened on first use
+	debugFile *os.File
+	// Mutex protecting debugFile
+	debugFileMutex = &sync.Mutex{}
+)
+
+// debugRecord is a rollup in JSON debug format.
+type debugRecord struct {
+	Time   int64                  `json:"time"`
+	Source string                 `json:"source"`
+	Name   string                 `json:"name"`
+	Tags   types.Tags             `json:"tags"`
+	Writer string                 `json:"writer"`
+	Unit   string                 `json:"unit"`
+	Values map[string]interface{} `json:"values"`
+}
+
+// printToStdout prints the data item to the standard output (see debugLine).
+func printToStdout(writer Writer, set *types.SampleSet, data dataItem) {
+	fmt.Print(debugLine(writer, set, data))
+}
+
+// writeToDebugFile appends the data item to DebugFile (see debugLine).
+func writeToDebugFile(writer Writer, set *types.SampleSet, data dataItem) {
+	if config.DebugFile == "" {
+		return
+	}
+	debugFileMutex.Lock()
+	defer debugFileMutex.Unlock()
+	if debugFile == nil {
+		file, error := os.OpenFile(config.DebugFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if error != nil {
+			config.Logger.Error("Cannot open debug file %s: %s", config.DebugFile, error)
+			return
+		}
+		debugFile = file
+	}
+	io.WriteString(debugFile, debugLine(writer, set, data))
+}
+
+// debugLine returns the data item as a line in DebugFormat. Text format is:
+//
+//	<source> <metric> <writer> <template> <rrd string>
+//
+// JSON format is an object with time, source, name, tags, writer, unit
+// (see seriesUnit), and values keyed by RRD data source names (unknown
+// values are null by default, see config.UnknownValues).
+func debugLine(writer Writer, set *types.SampleSet, data dataItem) string {
+	if config.DebugFormat != config.DEBUG_FORMAT_JSON {
+		return fmt.Sprintf("%s %s %s %s %s\n", set.Source, set.SeriesName(), writer.Name(), data.rrdTemplate(), data.rrdString())
+	}
+
+	record := &debugRecord{Time: set.Time, Source: set.Source, Name: set.Name, Tags: set.Tags, Writer: writer.Name(), Unit: seriesUnit(writer, set.Name), Values: debugValues(data)}
+	line, error := json.Marshal(record)
+	if error != nil {
+		return fmt.Sprintf("{\"error\":%q}\n", error.String())
+	}
+	return string(line) + "\n"
+}
+
+// debugValues returns values of the data item keyed by RRD data source
+// names, as numbers when possible. Low confidence items (see
+// confidenceItem) have low_confidence value set to true, items compared
+// with a threshold (see thresholdItem) have over_threshold value.
+func debugValues(data dataItem) map[string]interface{} {
+	result := make(map[string]interface{})
+	fields, values := dataFields(data)
+	for idx, field := range fields {
+		value, ok := renderValue(config.DEBUG_FORMAT_JSON, values[idx])
+		if !ok {
+			continue
+		}
+		if number, error := strconv.Atof64(value); error == nil {
+			result[field] = number
+		} else if value == "null" {
+			result[field] = nil
+		} else {
+			result[field] = value
+		}
+	}
+	if item, ok := data.(confidenceItem); ok && item.lowConfidence() {
+		result["low_confidence"] = true
+	}
+	if item, ok := data.(thresholdItem); ok {
+		if over, defined := item.overThreshold(); defined {
+			result["over_threshold"] = 0.0
+			if over {
+				result["over_threshold"] = 1.0
+			}
+		}
+	}
+	return result
+}
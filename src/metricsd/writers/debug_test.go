@@ -0,0 +1,54 @@
+package writers
+
+import (
+	"json"
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+type DebugS struct{}
+
+var _ = Suite(&DebugS{})
+
+func (s *DebugS) TearDownTest(c *C) {
+	config.DebugFormat = config.DEFAULT_DEBUG_FORMAT
+	config.UnknownValues = config.DEFAULT_UNKNOWN_VALUES
+}
+
+func (s *DebugS) TestDebugLineText(c *C) {
+	set := createSampleSet(1000, 1, -1)
+	writer := &Count{}
+	line := debugLine(writer, set, writer.rollupData(set))
+	c.Check(line, Equals, "src metric count ok:fail 1000:1:1\n")
+}
+
+func (s *DebugS) TestDebugLineJson(c *C) {
+	config.DebugFormat = config.DEBUG_FORMAT_JSON
+	set := createSampleSet(1000, 10)
+	set.Tags = types.Tags{"region": "eu"}
+	writer := &Cov{}
+	line := debugLine(writer, set, writer.rollupData(set))
+
+	var record map[string]interface{}
+	c.Assert(json.Unmarshal([]byte(line), &record), IsNil)
+	c.Check(record["time"], Equals, float64(1000))
+	c.Check(record["source"], Equals, "src")
+	c.Check(record["name"], Equals, "metric")
+	c.Check(record["writer"], Equals, "cov")
+	c.Check(record["unit"], Equals, "ratio")
+	c.Check(record["tags"], Equals, map[string]interface{}{"region": "eu"})
+	c.Check(record["values"], Equals, map[string]interface{}{"cov": nil})
+}
+
+func (s *DebugS) TestDebugLineJsonSkipUnknownValues(c *C) {
+	config.DebugFormat = config.DEBUG_FORMAT_JSON
+	config.UnknownValues = map[string]string{config.DEBUG_FORMAT_JSON: ""}
+	set := createSampleSet(1000, 10)
+	writer := &Cov{}
+	line := debugLine(writer, set, writer.rollupData(set))
+
+	var record map[string]interface{}
+	c.Assert(json.Unmarshal([]byte(line), &record), IsNil)
+	c.Check(record["values"], Equals, map[string]interface{}{})
+}
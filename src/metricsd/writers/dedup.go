@@ -0,0 +1,115 @@
+package writers
+
+import (
+	"exec"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"metricsd/config"
+)
+
+var (
+	// Number of RRD updates skipped as duplicates (reset by stats reporting)
+	DuplicateUpdates int64
+	// Last update times of RRD files, keyed by file name (see dedupRrdUpdate)
+	rrdLastUpdates = make(map[string]int64)
+	// Mutex protecting rrdLastUpdates
+	rrdLastUpdatesMutex = &sync.Mutex{}
+	// Function used to query the last update time of RRD files
+	queryRrdLast = rrdtoolLast
+)
+
+// dedupRrdUpdate returns update arguments ("<timestamp>:<values>") of the
+// RRD file without updates not newer than the last update of the file, or
+// than previous arguments, when config.DedupRrdUpdates is enabled. RRDTool
+// rejects such updates, which happens when the same metric is written to
+// the same RRD files by several aggregators (e.g. during a migration).
+// Skipped updates are counted in DuplicateUpdates. The last update time is
+// queried using "rrdtool last" once, and cached afterwards (see
+// rrdUpdated). Arguments are returned as is when it cannot be queried.
+func dedupRrdUpdate(file string, args []string) []string {
+	if !config.DedupRrdUpdates {
+		return args
+	}
+	last, ok := rrdLastUpdate(file)
+	if !ok {
+		return args
+	}
+	result := args[:0]
+	for _, arg := range args {
+		timestamp, ok := rrdUpdateTime(arg)
+		if ok && timestamp <= last {
+			atomic.AddInt64(&DuplicateUpdates, 1)
+			config.Logger.Debug("Skipping update of %s at %d, the file is updated at %d already", file, timestamp, last)
+			continue
+		}
+		if ok {
+			last = timestamp
+		}
+		result = append(result, arg)
+	}
+	return result
+}
+
+// rrdLastUpdate returns the cached last update time of the RRD file, or
+// queries it using queryRrdLast.
+func rrdLastUpdate(file string) (last int64, ok bool) {
+	rrdLastUpdatesMutex.Lock()
+	last, ok = rrdLastUpdates[file]
+	rrdLastUpdatesMutex.Unlock()
+	if ok {
+		return
+	}
+
+	last, err := queryRrdLast(file)
+	if err != nil {
+		config.Logger.Debug("Cannot query the last update of %s: %s", file, err)
+		return 0, false
+	}
+	rrdLastUpdatesMutex.Lock()
+	rrdLastUpdates[file] = last
+	rrdLastUpdatesMutex.Unlock()
+	return last, true
+}
+
+// rrdUpdated caches the last update time of the RRD file after the file
+// has been created (with its start time) or updated.
+func rrdUpdated(file string, last int64) {
+	if !config.DedupRrdUpdates {
+		return
+	}
+	rrdLastUpdatesMutex.Lock()
+	defer rrdLastUpdatesMutex.Unlock()
+	rrdLastUpdates[file] = last
+}
+
+// rrdUpdateFailed forgets the cached last update time of the RRD file, so
+// it is queried again before the next update, in case the file has been
+// updated by someone else.
+func rrdUpdateFailed(file string) {
+	rrdLastUpdatesMutex.Lock()
+	defer rrdLastUpdatesMutex.Unlock()
+	rrdLastUpdates[file] = 0, false
+}
+
+// rrdUpdateTime returns the timestamp of the update argument.
+func rrdUpdateTime(arg string) (timestamp int64, ok bool) {
+	idx := strings.Index(arg, ":")
+	if idx < 0 {
+		return 0, false
+	}
+	timestamp, err := strconv.Atoi64(arg[:idx])
+	return timestamp, err == nil
+}
+
+// rrdtoolLast returns the last update time of the RRD file, using
+// "rrdtool last".
+func rrdtoolLast(file string) (int64, os.Error) {
+	output, err := exec.Command(config.RrdtoolPath, "last", file).Output()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi64(strings.TrimSpace(string(output)))
+}
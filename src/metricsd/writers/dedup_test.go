@@ -0,0 +1,64 @@
+package writers
+
+import (
+	"os"
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+)
+
+type DedupS struct {
+	queries int
+}
+
+var _ = Suite(&DedupS{})
+
+func (s *DedupS) SetUpTest(c *C) {
+	config.DedupRrdUpdates = true
+	s.queries = 0
+	queryRrdLast = func(file string) (int64, os.Error) {
+		s.queries++
+		if file == "broken.rrd" {
+			return 0, os.NewError("not an RRD file")
+		}
+		return 1010, nil
+	}
+	rrdLastUpdates = make(map[string]int64)
+	DuplicateUpdates = 0
+}
+
+func (s *DedupS) TearDownTest(c *C) {
+	config.DedupRrdUpdates = config.DEFAULT_DEDUP_RRD_UPDATES
+	queryRrdLast = rrdtoolLast
+	rrdLastUpdates = make(map[string]int64)
+	DuplicateUpdates = 0
+}
+
+func (s *DedupS) TestDedupRrdUpdate(c *C) {
+	args := dedupRrdUpdate("metric.rrd", []string{"1000:1", "1010:2", "1020:3", "1020:4", "1030:5"})
+	c.Check(args, Equals, []string{"1020:3", "1030:5"})
+	c.Check(DuplicateUpdates, Equals, int64(3))
+
+	rrdUpdated("metric.rrd", 1030)
+	c.Check(dedupRrdUpdate("metric.rrd", []string{"1030:5"}), Equals, []string{})
+	c.Check(dedupRrdUpdate("metric.rrd", []string{"1040:6"}), Equals, []string{"1040:6"})
+	c.Check(s.queries, Equals, 1)
+	c.Check(DuplicateUpdates, Equals, int64(4))
+}
+
+func (s *DedupS) TestDedupRrdUpdateQueriesAgainAfterFailure(c *C) {
+	rrdUpdated("metric.rrd", 1030)
+	rrdUpdateFailed("metric.rrd")
+	c.Check(dedupRrdUpdate("metric.rrd", []string{"1020:3"}), Equals, []string{"1020:3"})
+	c.Check(s.queries, Equals, 1)
+}
+
+func (s *DedupS) TestDedupRrdUpdateWithUnknownLastUpdate(c *C) {
+	c.Check(dedupRrdUpdate("broken.rrd", []string{"1000:1"}), Equals, []string{"1000:1"})
+	c.Check(DuplicateUpdates, Equals, int64(0))
+}
+
+func (s *DedupS) TestDedupRrdUpdateDisabled(c *C) {
+	config.DedupRrdUpdates = false
+	c.Check(dedupRrdUpdate("metric.rrd", []string{"1000:1"}), Equals, []string{"1000:1"})
+	c.Check(s.queries, Equals, 0)
+}
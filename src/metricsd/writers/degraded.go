@@ -0,0 +1,103 @@
+package writers
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"metricsd/config"
+)
+
+// Messages of errors caused by broken storage rather than by a single RRD
+// file.
+var storageErrors = []string{"No space left on device", "Disk quota exceeded", "Read-only file system"}
+
+var (
+	// Number of RRD updates skipped in degraded mode (reset by stats
+	// reporting)
+	DegradedSkipped int64
+	// State of degraded mode (see storageFailed)
+	degraded = &degradedState{mutex: &sync.Mutex{}}
+)
+
+// degradedState tracks consecutive storage errors of RRD updates. After
+// DegradedAfter consecutive errors RRD updates are skipped, and attempted
+// again every DegradedRetry seconds, until one of them succeeds. Rollups
+// are still published to other outputs and export endpoints meanwhile.
+type degradedState struct {
+	failures int    // number of consecutive storage errors
+	since    int64  // time degraded mode has been entered, 0 when not degraded
+	retryAt  int64  // time of the next attempt to update RRD files
+	reason   string // the last storage error
+	mutex    *sync.Mutex
+}
+
+// isStorageError returns a value indicating whether the error is caused by
+// broken storage (e.g. disk is full).
+func isStorageError(err os.Error) bool {
+	for _, message := range storageErrors {
+		if strings.Contains(err.String(), message) {
+			return true
+		}
+	}
+	return false
+}
+
+// storageFailed registers a failed RRD update, entering degraded mode after
+// DegradedAfter consecutive storage errors (0 disables degraded mode).
+// Failed attempts in degraded mode postpone the next one.
+func storageFailed(err os.Error) {
+	if config.DegradedAfter <= 0 || !isStorageError(err) {
+		return
+	}
+	degraded.mutex.Lock()
+	defer degraded.mutex.Unlock()
+	degraded.failures++
+	degraded.reason = err.String()
+	if degraded.failures < config.DegradedAfter {
+		return
+	}
+	now := time.Seconds()
+	if degraded.since == 0 {
+		degraded.since = now
+		config.Logger.Error("RRD updates failed %d times in a row, entering degraded mode (RRD updates are retried every %d seconds): %s", degraded.failures, config.DegradedRetry, err)
+	}
+	degraded.retryAt = now + int64(config.DegradedRetry)
+}
+
+// storageSucceeded registers a successful RRD update, leaving degraded mode.
+func storageSucceeded() {
+	degraded.mutex.Lock()
+	defer degraded.mutex.Unlock()
+	if degraded.since != 0 {
+		config.Logger.Info("RRD updates succeeded, leaving degraded mode after %d seconds", time.Seconds()-degraded.since)
+	}
+	degraded.failures = 0
+	degraded.since = 0
+}
+
+// skipRrdUpdate returns a value indicating whether RRD updates should be
+// skipped, because storage is broken and the next attempt is not due yet.
+// Skipped updates are counted in DegradedSkipped.
+func skipRrdUpdate() bool {
+	degraded.mutex.Lock()
+	defer degraded.mutex.Unlock()
+	if degraded.since == 0 || time.Seconds() >= degraded.retryAt {
+		return false
+	}
+	atomic.AddInt64(&DegradedSkipped, 1)
+	return true
+}
+
+// Degraded returns an error describing broken storage when RRD updates are
+// skipped in degraded mode, nil otherwise.
+func Degraded() os.Error {
+	degraded.mutex.Lock()
+	defer degraded.mutex.Unlock()
+	if degraded.since == 0 {
+		return nil
+	}
+	return os.NewError(fmt.Sprintf("RRD updates are failing since %d: %s", degraded.since, degraded.reason))
+}
@@ -0,0 +1,61 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+	"os"
+	"sync"
+	"metricsd/config"
+)
+
+type DegradedS struct{}
+
+var _ = Suite(&DegradedS{})
+
+func (s *DegradedS) SetUpTest(c *C) {
+	config.DegradedAfter = 2
+	config.DegradedRetry = 60
+}
+
+func (s *DegradedS) TearDownTest(c *C) {
+	config.DegradedAfter = config.DEFAULT_DEGRADED_AFTER
+	config.DegradedRetry = config.DEFAULT_DEGRADED_RETRY
+	degraded = &degradedState{mutex: &sync.Mutex{}}
+	DegradedSkipped = 0
+}
+
+func (s *DegradedS) TestEnterDegradedMode(c *C) {
+	err := os.NewError("opening 'a.rrd': No space left on device")
+	storageFailed(err)
+	c.Check(Degraded(), IsNil)
+	c.Check(skipRrdUpdate(), Equals, false)
+
+	storageFailed(err)
+	c.Check(Degraded(), Not(IsNil))
+	c.Check(skipRrdUpdate(), Equals, true)
+	c.Check(DegradedSkipped, Equals, int64(1))
+}
+
+func (s *DegradedS) TestIgnoreOtherErrors(c *C) {
+	for i := 0; i < 5; i++ {
+		storageFailed(os.NewError("illegal attempt to update using time 10"))
+	}
+	c.Check(Degraded(), IsNil)
+	c.Check(skipRrdUpdate(), Equals, false)
+}
+
+func (s *DegradedS) TestLeaveDegradedMode(c *C) {
+	err := os.NewError("opening 'a.rrd': Read-only file system")
+	storageFailed(err)
+	storageFailed(err)
+	storageSucceeded()
+	c.Check(Degraded(), IsNil)
+	c.Check(skipRrdUpdate(), Equals, false)
+}
+
+func (s *DegradedS) TestDisabled(c *C) {
+	config.DegradedAfter = 0
+	err := os.NewError("opening 'a.rrd': No space left on device")
+	storageFailed(err)
+	storageFailed(err)
+	c.Check(Degraded(), IsNil)
+}
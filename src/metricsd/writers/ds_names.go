@@ -0,0 +1,61 @@
+package writers
+
+import (
+	"fmt"
+	"os"
+)
+
+// Maximum length of RRD data source names.
+const maxDataSourceName = 19
+
+// dataSourcesWriter is implemented by writers with data source names
+// derived from configuration (e.g. histogram buckets, quantiles), which
+// are validated when routes are created (see validateDataSources), so
+// invalid settings are reported on startup instead of the first write.
+type dataSourcesWriter interface {
+	// dataSources returns names of RRD data sources of the writer rollups.
+	dataSources() []string
+}
+
+// dataSourceName returns the legal RRD data source name for the given
+// name: characters other than ASCII letters, digits, and underscores are
+// replaced with underscores, and the name is truncated to 19 characters.
+// Names are converted deterministically, so RRD files of the same settings
+// always have the same data sources.
+func dataSourceName(name string) string {
+	buf := make([]byte, 0, len(name))
+	for idx := 0; idx < len(name) && len(buf) < maxDataSourceName; idx++ {
+		char := name[idx]
+		if ('0' <= char && char <= '9') || ('a' <= char && char <= 'z') || ('A' <= char && char <= 'Z') || char == '_' {
+			buf = append(buf, char)
+		} else {
+			buf = append(buf, '_')
+		}
+	}
+	if len(buf) == 0 {
+		return "_"
+	}
+	return string(buf)
+}
+
+// validateDataSources returns an error when data source names of the
+// writer are not legal RRD data source names (see dataSourceName), or
+// are not unique, e.g. when different quantiles are truncated to the same
+// name.
+func validateDataSources(writer Writer) os.Error {
+	configured, ok := writer.(dataSourcesWriter)
+	if !ok {
+		return nil
+	}
+	seen := make(map[string]bool)
+	for _, name := range configured.dataSources() {
+		if dataSourceName(name) != name {
+			return os.NewError(fmt.Sprintf("Data source %q of writer %s is invalid, should be 1 to 19 letters, digits, or underscores", name, writer.Name()))
+		}
+		if seen[name] {
+			return os.NewError(fmt.Sprintf("Data source %q of writer %s is defined twice, check its settings", name, writer.Name()))
+		}
+		seen[name] = true
+	}
+	return nil
+}
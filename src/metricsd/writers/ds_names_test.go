@@ -0,0 +1,31 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+)
+
+type DataSourcesS struct{}
+
+var _ = Suite(&DataSourcesS{})
+
+func (s *DataSourcesS) TestDataSourceName(c *C) {
+	c.Check(dataSourceName("p99_9"), Equals, "p99_9")
+	c.Check(dataSourceName("p1e-05"), Equals, "p1e_05")
+	c.Check(dataSourceName("p12_3456789012345678"), Equals, "p12_345678901234567")
+	c.Check(dataSourceName(""), Equals, "_")
+}
+
+func (s *DataSourcesS) TestSketchDataSources(c *C) {
+	c.Check(sketchDataSources([]float64{0.5, 0.999, 1e-7}), Equals, []string{"p50", "p99_9", "p1e_05"})
+}
+
+func (s *DataSourcesS) TestValidateDataSources(c *C) {
+	c.Check(validateDataSources(&Count{}), IsNil)
+	c.Check(validateDataSources(&Histogram{Bounds: []int{-10, 0, 500}}), IsNil)
+	c.Check(validateDataSources(&Sketch{Quantiles: []float64{0.5, 0.99}}), IsNil)
+
+	c.Check(validateDataSources(&Sketch{Quantiles: []float64{0.99, 0.990}}), Not(IsNil))
+	c.Check(validateDataSources(&Classes{Config: &config.ClassesConfig{Labels: []string{"sum", "sum"}}}), Not(IsNil))
+	c.Check(validateDataSources(&Classes{Config: &config.ClassesConfig{Labels: []string{"very-slow"}}}), Not(IsNil))
+}
@@ -0,0 +1,54 @@
+package writers
+
+import (
+	"sync"
+	"time"
+)
+
+// A RollupDuration accumulates time spent by a writer summarizing sample
+// sets (see summarize), excluding writes to outputs.
+type RollupDuration struct {
+	Count int64 // number of summarized sample sets
+	Total int64 // total time in nanoseconds
+	Max   int64 // the longest time of a single sample set in nanoseconds
+}
+
+var (
+	// Durations of rollups since the last reset, keyed by writer name
+	rollupDurations = make(map[string]*RollupDuration)
+	// Mutex protecting rollupDurations
+	rollupDurationsMutex = &sync.Mutex{}
+)
+
+// observeRollup adds the time spent by the writer summarizing a sample set
+// since the given start (nanoseconds since epoch).
+func observeRollup(writer Writer, started int64) {
+	elapsed := time.Nanoseconds() - started
+	rollupDurationsMutex.Lock()
+	defer rollupDurationsMutex.Unlock()
+	duration, found := rollupDurations[writer.Name()]
+	if !found {
+		duration = &RollupDuration{}
+		rollupDurations[writer.Name()] = duration
+	}
+	duration.Count++
+	duration.Total += elapsed
+	if elapsed > duration.Max {
+		duration.Max = elapsed
+	}
+}
+
+// ResetRollupDurations returns durations of rollups since the previous
+// call, keyed by writer name, and starts over. Writers seen before are
+// reported with zero durations, when they have not summarized anything
+// since then.
+func ResetRollupDurations() map[string]*RollupDuration {
+	rollupDurationsMutex.Lock()
+	defer rollupDurationsMutex.Unlock()
+	durations := make(map[string]*RollupDuration, len(rollupDurations))
+	for name, duration := range rollupDurations {
+		durations[name] = duration
+		rollupDurations[name] = &RollupDuration{}
+	}
+	return durations
+}
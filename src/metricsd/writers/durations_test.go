@@ -0,0 +1,35 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+	"time"
+)
+
+type DurationsS struct{}
+
+var _ = Suite(&DurationsS{})
+
+func (s *DurationsS) SetUpTest(c *C) {
+	rollupDurations = make(map[string]*RollupDuration)
+}
+
+func (s *DurationsS) TestObserveRollup(c *C) {
+	writer := &Count{}
+	observeRollup(writer, time.Nanoseconds()-2000)
+	observeRollup(writer, time.Nanoseconds()-1000)
+
+	durations := ResetRollupDurations()
+	c.Assert(durations["count"], Not(IsNil))
+	c.Check(durations["count"].Count, Equals, int64(2))
+	c.Check(durations["count"].Total >= 3000, Equals, true)
+	c.Check(durations["count"].Max >= 2000, Equals, true)
+	c.Check(durations["count"].Max <= durations["count"].Total, Equals, true)
+
+	// Known writers are reported with zero durations
+	c.Check(ResetRollupDurations(), Equals, map[string]*RollupDuration{"count": &RollupDuration{}})
+}
+
+func (s *DurationsS) TestSummarizeObservesRollup(c *C) {
+	summarize(&Quartiles{}, createSampleSet(1000, 1, 2, 3))
+	c.Check(ResetRollupDurations()["quartiles"].Count, Equals, int64(1))
+}
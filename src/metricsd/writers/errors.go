@@ -0,0 +1,183 @@
+package writers
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"metricsd/config"
+)
+
+// Failed writes are handled according to the type of the error returned by
+// the writer (see updateFailed). Errors of other types are considered
+// transient.
+type (
+	// ErrTransient is a failure expected to go away (e.g. a locked RRD file,
+	// or a full disk), the write is retried.
+	ErrTransient struct{ os.Error }
+	// ErrPermanent is a failure retries would not fix (e.g. a broken RRD
+	// file), the write is given up on immediately.
+	ErrPermanent struct{ os.Error }
+	// ErrBadData is a write rejected because of its data (e.g. values not
+	// matching data sources of RRD file), the write is dropped.
+	ErrBadData struct{ os.Error }
+)
+
+var (
+	// Messages of RRDTool errors caused by RRD files rather than by updates
+	permanentRrdErrors = []string{"is not an RRD file", "created on another architecture", "Permission denied", "Is a directory"}
+	// Messages of RRDTool errors caused by updates
+	badDataRrdErrors = []string{"illegal attempt to update", "data source readings", "conversion of", "found extra data", "unknown DS name"}
+)
+
+var (
+	// Number of failed RRD updates (reset by stats reporting)
+	UpdateErrors int64
+	// Number of failed RRD updates, keyed by source, metric, and writer names
+	updateErrorCounts = make(map[string]int64)
+	// Number of failed RRD updates given up on (reset by stats reporting)
+	DeadLetters int64
+	// Failed RRD updates waiting for retries (at most RetryQueueSize)
+	failedUpdates = make([]*rrdUpdateTask, 0, 10)
+	// Dead letter file opened on first use
+	deadLetterFile *os.File
+	// Mutex protecting updateErrorCounts, failedUpdates, and deadLetterFile
+	updateErrorsMutex = &sync.Mutex{}
+)
+
+// classifyRrdError returns the error of RRDTool as ErrPermanent or
+// ErrBadData when its message is known to be caused by the RRD file or by
+// the update, and as ErrTransient otherwise. Errors classified already are
+// returned as is.
+func classifyRrdError(err os.Error) os.Error {
+	switch err.(type) {
+	case ErrTransient, ErrPermanent, ErrBadData:
+		return err
+	}
+	for _, message := range permanentRrdErrors {
+		if strings.Contains(err.String(), message) {
+			return ErrPermanent{err}
+		}
+	}
+	for _, message := range badDataRrdErrors {
+		if strings.Contains(err.String(), message) {
+			return ErrBadData{err}
+		}
+	}
+	return ErrTransient{err}
+}
+
+// updateFailed logs and counts failed RRD update, and handles it according
+// to the type of the error. Updates with bad data (ErrBadData) are dropped,
+// unless they have been retried already (RRDTool rejects retried updates
+// older than the latest update of the file), and updates failed
+// permanently (ErrPermanent) are given up on (see deadLetter). Other
+// failures are transient: when task has not been retried WriteRetries
+// times yet, it is scheduled for a retry with exponential backoff: the n-th
+// retry happens 2^(n-1) writes after the failure. Tasks retried
+// WriteRetries times, and tasks failed while the retry queue is full (see
+// RetryQueueSize), are given up on.
+func updateFailed(task *rrdUpdateTask, err os.Error) {
+	key := fmt.Sprintf("%s-%s-%s", task.firstSampleSet.Source, task.firstSampleSet.SeriesName(), task.writer.Name())
+	atomic.AddInt64(&UpdateErrors, 1)
+
+	updateErrorsMutex.Lock()
+	defer updateErrorsMutex.Unlock()
+	updateErrorCounts[key]++
+	_, badData := err.(ErrBadData)
+	_, permanent := err.(ErrPermanent)
+	switch {
+	case badData && task.attempts == 0:
+		config.Logger.Error("Failed to update %s, dropping the update: %s", key, err)
+	case badData || permanent || task.attempts >= config.WriteRetries:
+		config.Logger.Error("Failed to update %s: %s", key, err)
+		deadLetter(task)
+	case len(failedUpdates) >= config.RetryQueueSize:
+		config.Logger.Error("Failed to update %s, retry queue is full: %s", key, err)
+		deadLetter(task)
+	default:
+		task.attempts++
+		task.skips = 1<<uint(task.attempts-1) - 1
+		// Sample sets could be recycled before the retry
+		task.firstSampleSet = task.firstSampleSet.Header()
+		failedUpdates = append(failedUpdates, task)
+		config.Logger.Warn("Failed to update %s (attempt %d, will retry in %d writes): %s", key, task.attempts, task.skips+1, err)
+	}
+}
+
+// deadLetter counts the RRD update given up on, and appends it to
+// DeadLetterFile, so it could be inspected or replayed with rrdtool:
+//
+//	<source> <metric> <writer> <template> <rrd string> [<rrd string> ...]
+//
+// The caller should hold updateErrorsMutex.
+func deadLetter(task *rrdUpdateTask) {
+	atomic.AddInt64(&DeadLetters, 1)
+	if config.DeadLetterFile == "" {
+		return
+	}
+	if deadLetterFile == nil {
+		file, error := os.OpenFile(config.DeadLetterFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if error != nil {
+			config.Logger.Error("Cannot open dead letter file %s: %s", config.DeadLetterFile, error)
+			return
+		}
+		deadLetterFile = file
+	}
+	set := task.firstSampleSet
+	updates := strings.Join(task.f(nil), " ")
+	if _, error := fmt.Fprintf(deadLetterFile, "%s %s %s %s %s\n", set.Source, set.SeriesName(), task.writer.Name(), task.firstDataItem.rrdTemplate(), updates); error != nil {
+		config.Logger.Error("Cannot write to dead letter file %s: %s", config.DeadLetterFile, error)
+	}
+}
+
+// RetryFailedUpdates retries RRD updates failed during previous writes,
+// which backoff has elapsed (updates waiting for later writes stay in the
+// queue). It should be called before writing new data, so RRD files are
+// updated in order. Please note: RRDTool rejects retried updates older than
+// the latest update of the file, so updates postponed behind new data end
+// up in the dead letter file. Returns Cancelled when done channel is closed
+// before retries complete.
+func RetryFailedUpdates(done <-chan bool) os.Error {
+	updateErrorsMutex.Lock()
+	tasks := make([]*rrdUpdateTask, 0, len(failedUpdates))
+	waiting := make([]*rrdUpdateTask, 0, 10)
+	for _, task := range failedUpdates {
+		if task.skips > 0 {
+			task.skips--
+			waiting = append(waiting, task)
+		} else {
+			tasks = append(tasks, task)
+		}
+	}
+	failedUpdates = waiting
+	updateErrorsMutex.Unlock()
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	prepareRrdUpdateThreads()
+	wg := &sync.WaitGroup{}
+	for _, task := range tasks {
+		task.wg = wg
+		if error := queueRrdUpdate(task, done); error != nil {
+			return error
+		}
+	}
+	return wait(wg, done)
+}
+
+// UpdateErrorNames returns sorted list of source, metric, and writer names
+// with failed RRD updates, along with the number of failures.
+func UpdateErrorNames() []string {
+	updateErrorsMutex.Lock()
+	defer updateErrorsMutex.Unlock()
+	names := make([]string, 0, len(updateErrorCounts))
+	for key, count := range updateErrorCounts {
+		names = append(names, fmt.Sprintf("%s %d", key, count))
+	}
+	sort.Strings(names)
+	return names
+}
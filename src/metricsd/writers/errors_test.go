@@ -0,0 +1,151 @@
+package writers
+
+import (
+	"io/ioutil"
+	"os"
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+	"metricsd/logger"
+	"metricsd/types"
+)
+
+type ErrorsS struct{}
+
+var _ = Suite(&ErrorsS{})
+
+func (s *ErrorsS) SetUpTest(c *C) {
+	config.Logger = logger.NewConsoleLogger(logger.UNKNOWN)
+	config.WriteRetries = 1
+	UpdateErrors = 0
+	DeadLetters = 0
+	updateErrorCounts = make(map[string]int64)
+	failedUpdates = failedUpdates[:0]
+}
+
+func (s *ErrorsS) TearDownTest(c *C) {
+	config.WriteRetries = config.DEFAULT_WRITE_RETRIES
+	config.RetryQueueSize = config.DEFAULT_RETRY_QUEUE_SIZE
+	config.DeadLetterFile = config.DEFAULT_DEAD_LETTER_FILE
+	failedUpdates = failedUpdates[:0]
+	if deadLetterFile != nil {
+		deadLetterFile.Close()
+		deadLetterFile = nil
+	}
+}
+
+func (s *ErrorsS) TestUpdateFailed(c *C) {
+	task := &rrdUpdateTask{writer: &Count{}, firstSampleSet: createSampleSet(1000, 1)}
+	updateFailed(task, os.NewError("broken"))
+	c.Check(UpdateErrors, Equals, int64(1))
+	c.Check(len(failedUpdates), Equals, 1)
+	c.Check(task.attempts, Equals, 1)
+
+	// The task has been retried already
+	updateFailed(task, os.NewError("broken"))
+	c.Check(UpdateErrors, Equals, int64(2))
+	c.Check(len(failedUpdates), Equals, 1)
+	c.Check(UpdateErrorNames(), Equals, []string{"src-metric-count 2"})
+	c.Check(DeadLetters, Equals, int64(1))
+}
+
+func (s *ErrorsS) TestUpdateFailedWithTags(c *C) {
+	first, second := createSampleSet(1000, 1), createSampleSet(1000, 1)
+	first.Tags = types.Tags{"region": "eu"}
+	second.Tags = types.Tags{"region": "us"}
+	updateFailed(&rrdUpdateTask{writer: &Count{}, firstSampleSet: first}, os.NewError("broken"))
+	updateFailed(&rrdUpdateTask{writer: &Count{}, firstSampleSet: second}, os.NewError("broken"))
+	c.Check(UpdateErrorNames(), Equals, []string{"src-metric;region=eu-count 1", "src-metric;region=us-count 1"})
+}
+
+func (s *ErrorsS) TestUpdateFailedWithBadData(c *C) {
+	config.WriteRetries = 3
+	task := &rrdUpdateTask{writer: &Count{}, firstSampleSet: createSampleSet(1000, 1)}
+	updateFailed(task, ErrBadData{os.NewError("broken")})
+	c.Check(UpdateErrors, Equals, int64(1))
+	c.Check(len(failedUpdates), Equals, 0)
+	c.Check(DeadLetters, Equals, int64(0))
+
+	// Retried updates are given up on
+	task.attempts = 1
+	updateFailed(task, ErrBadData{os.NewError("broken")})
+	c.Check(len(failedUpdates), Equals, 0)
+	c.Check(DeadLetters, Equals, int64(1))
+}
+
+func (s *ErrorsS) TestUpdateFailedPermanently(c *C) {
+	config.WriteRetries = 3
+	updateFailed(&rrdUpdateTask{writer: &Count{}, firstSampleSet: createSampleSet(1000, 1)}, ErrPermanent{os.NewError("broken")})
+	c.Check(len(failedUpdates), Equals, 0)
+	c.Check(DeadLetters, Equals, int64(1))
+}
+
+func (s *ErrorsS) TestClassifyRrdError(c *C) {
+	_, ok := classifyRrdError(os.NewError("opening '/data/a.rrd': Permission denied")).(ErrPermanent)
+	c.Check(ok, Equals, true)
+	_, ok = classifyRrdError(os.NewError("illegal attempt to update using time 1000 when last update time is 1010 (minimum one second step)")).(ErrBadData)
+	c.Check(ok, Equals, true)
+	_, ok = classifyRrdError(os.NewError("could not lock RRD")).(ErrTransient)
+	c.Check(ok, Equals, true)
+	_, ok = classifyRrdError(ErrPermanent{os.NewError("broken")}).(ErrPermanent)
+	c.Check(ok, Equals, true)
+}
+
+func (s *ErrorsS) TestUpdateFailedBacksOff(c *C) {
+	config.WriteRetries = 3
+	task := &rrdUpdateTask{writer: &Count{}, firstSampleSet: createSampleSet(1000, 1), f: func(args []string) []string { return args }}
+	for _, skips := range []int{0, 1, 3} {
+		updateFailed(task, os.NewError("broken"))
+		c.Check(task.skips, Equals, skips)
+	}
+	failedUpdates = []*rrdUpdateTask{task}
+	task.skips = 1
+
+	updates := 0
+	updateRrdFile = func(writer Writer, set *types.SampleSet, data dataItem, args []string) os.Error {
+		updates++
+		return nil
+	}
+	defer func() { updateRrdFile = safeUpdateRrd }()
+	done := make(chan bool)
+	c.Assert(RetryFailedUpdates(done), IsNil)
+	c.Check(updates, Equals, 0)
+	c.Check(len(failedUpdates), Equals, 1)
+	c.Assert(RetryFailedUpdates(done), IsNil)
+	c.Check(updates, Equals, 1)
+	c.Check(len(failedUpdates), Equals, 0)
+}
+
+func (s *ErrorsS) TestUpdateFailedWithFullQueue(c *C) {
+	config.RetryQueueSize = 1
+	updateFailed(&rrdUpdateTask{writer: &Count{}, firstSampleSet: createSampleSet(1000, 1)}, os.NewError("broken"))
+	updateFailed(&rrdUpdateTask{writer: &Count{}, firstSampleSet: createSampleSet(1010, 1)}, os.NewError("broken"))
+	c.Check(len(failedUpdates), Equals, 1)
+	c.Check(failedUpdates[0].firstSampleSet.Time, Equals, int64(1000))
+	c.Check(DeadLetters, Equals, int64(1))
+}
+
+func (s *ErrorsS) TestDeadLetterFile(c *C) {
+	dir, err := ioutil.TempDir("", "metricsd")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	config.WriteRetries = 0
+	config.DeadLetterFile = dir + "/dead.txt"
+
+	set := createSampleSet(1000, 1)
+	task := &rrdUpdateTask{writer: &Count{}, firstSampleSet: set, firstDataItem: &countItem{time: 1000, ok: 1}, f: func(args []string) []string {
+		return append(args, "1000:1:0", "1010:2:0")
+	}}
+	updateFailed(task, os.NewError("broken"))
+	c.Check(len(failedUpdates), Equals, 0)
+	data, err := ioutil.ReadFile(config.DeadLetterFile)
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, "src metric count ok:fail 1000:1:0 1010:2:0\n")
+}
+
+func (s *ErrorsS) TestSafeUpdateRrdRecoversPanics(c *C) {
+	// Nil sample set makes getRrdFile panic
+	err := safeUpdateRrd(&Count{}, nil, nil, nil)
+	c.Check(err, Not(IsNil))
+	_, ok := err.(ErrPermanent)
+	c.Check(ok, Equals, true)
+}
@@ -0,0 +1,320 @@
+package writers
+
+import (
+	"fmt"
+	"io"
+	"json"
+	"metricsd/config"
+	"metricsd/types"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// latestRollup is the most recent data item calculated by a writer for
+// a metric from a given source.
+type latestRollup struct {
+	source string
+	name   string
+	tags   types.Tags
+	writer string
+	unit   string // unit of the writer rollups (see seriesUnit)
+	data   dataItem
+	time   int64          // timestamp of the sample set
+	row    *archiveRow    // export archive of the series, nil when data is exported
+	recent *recentRollups // the most recent rollups, nil when not kept (see config.RecentRollups)
+}
+
+var (
+	// The most recent rollups, keyed by source, metric, and writer names
+	latestRollups = make(map[string]*latestRollup)
+	// Mutex protecting latestRollups
+	latestRollupsMutex = &sync.RWMutex{}
+)
+
+// prometheusItem is implemented by data items having their own Prometheus
+// representation (for example, histograms). All other items are exported
+// as a set of gauges, one per RRD data source.
+type prometheusItem interface {
+	prometheusType() string
+	prometheusSamples(name, labels string) []string
+}
+
+// confidenceItem is implemented by data items which could be calculated
+// from too few values to be meaningful (see TailLatency). Exports surface
+// the flag as low_confidence value.
+type confidenceItem interface {
+	lowConfidence() bool
+}
+
+// thresholdItem is implemented by data items which could be compared with
+// a configured threshold (see Count). Exports surface the result of the
+// comparison as over_threshold value (1 or 0) when the threshold is set.
+type thresholdItem interface {
+	overThreshold() (over, defined bool)
+}
+
+// prometheusFamily is a group of samples sharing the same metric name.
+type prometheusFamily struct {
+	kind    string
+	unit    string
+	samples []string
+}
+
+// remember stores the data item as the most recent rollup for the sample
+// set source and series name, and consolidates it into the export archive
+// of the writer, if any (see config.ExportArchives). The RecentRollups most
+// recent rollups are kept as well.
+func remember(writer Writer, set *types.SampleSet, data dataItem) {
+	latestRollupsMutex.Lock()
+	defer latestRollupsMutex.Unlock()
+	key := set.Source + "-" + set.SeriesName() + "-" + writer.Name()
+	rollup := &latestRollup{set.Source, set.Name, set.Tags, writer.Name(), seriesUnit(writer, set.Name), data, set.Time, nil, nil}
+	previous, found := latestRollups[key]
+	if found && previous.row != nil {
+		rollup.row = previous.row
+	} else {
+		rollup.row = newArchiveRow(writer, set, data)
+	}
+	if rollup.row != nil {
+		rollup.row.add(set.Time, data)
+	}
+	if found {
+		rollup.recent = previous.recent
+	} else if config.RecentRollups > 0 {
+		rollup.recent = newRecentRollups(config.RecentRollups)
+	}
+	if rollup.recent != nil {
+		rollup.recent.add(set.Time, data)
+	}
+	latestRollups[key] = rollup
+}
+
+// exported returns the data item surfaced by exports and its timestamp:
+// the last completed row of the export archive, if any, otherwise the most
+// recent rollup.
+func (self *latestRollup) exported() (data dataItem, time int64) {
+	if self.row == nil {
+		return self.data, self.time
+	}
+	data = self.row.exported(self.data)
+	if item := data.(*archiveItem); item.time > 0 {
+		return data, item.time
+	}
+	return data, self.time
+}
+
+// expireRollups forgets the latest rollups of metrics not received since
+// the given time (seconds since epoch), returns the number of forgotten
+// rollups.
+func expireRollups(before int64) (expired int) {
+	latestRollupsMutex.Lock()
+	defer latestRollupsMutex.Unlock()
+	for key, rollup := range latestRollups {
+		if rollup.time < before {
+			latestRollups[key] = nil, false
+			expired++
+		}
+	}
+	return
+}
+
+// WritePrometheus writes the most recent rollups of all metrics in
+// Prometheus text exposition format. Units of rollups are exported in
+// help lines. Please note: values are the rollups of the latest slice, not
+// the counters accumulated since startup.
+func WritePrometheus(w io.Writer) {
+	writePrometheus(w, nil)
+}
+
+// WriteLivePrometheus writes the most recent rollups of all metrics (see
+// WritePrometheus), and live rollups of current slices of all routes of
+// the router (see liveRollups), with partial label set to true.
+func (router *Router) WriteLivePrometheus(w io.Writer) {
+	writePrometheus(w, router.liveRollups(""))
+}
+
+// writePrometheus writes the most recent rollups of all metrics followed
+// by the given live rollups in Prometheus text exposition format.
+func writePrometheus(w io.Writer, live []*latestRollup) {
+	families := make(map[string]*prometheusFamily)
+	addSample := func(name, kind, unit, sample string) {
+		family, found := families[name]
+		if !found {
+			family = &prometheusFamily{kind: kind, unit: unit, samples: make([]string, 0, 10)}
+			families[name] = family
+		}
+		family.samples = append(family.samples, sample)
+	}
+
+	addRollup := func(rollup *latestRollup) {
+		name := prometheusName(rollup.name + "_" + rollup.writer)
+		labels := fmt.Sprintf("source=%q", rollup.source)
+		for _, key := range rollup.tags.Keys() {
+			labels += fmt.Sprintf(",%s=%q", prometheusLabelName(key), rollup.tags[key])
+		}
+		data, _ := rollup.exported()
+		if item, ok := data.(prometheusItem); ok {
+			for _, sample := range item.prometheusSamples(name, labels) {
+				addSample(name, item.prometheusType(), rollup.unit, sample)
+			}
+			return
+		}
+		fields, values := dataFields(data)
+		for idx, field := range fields {
+			// Unknown values are skipped by default
+			value, ok := renderValue(config.EXPORT_PROMETHEUS, values[idx])
+			if !ok {
+				continue
+			}
+			addSample(name+"_"+field, "gauge", rollup.unit, fmt.Sprintf("%s_%s{%s} %s", name, field, labels, value))
+		}
+		if item, ok := data.(confidenceItem); ok {
+			value := 0
+			if item.lowConfidence() {
+				value = 1
+			}
+			addSample(name+"_low_confidence", "gauge", "", fmt.Sprintf("%s_low_confidence{%s} %d", name, labels, value))
+		}
+		if item, ok := data.(thresholdItem); ok {
+			if over, defined := item.overThreshold(); defined {
+				value := 0
+				if over {
+					value = 1
+				}
+				addSample(name+"_over_threshold", "gauge", "", fmt.Sprintf("%s_over_threshold{%s} %d", name, labels, value))
+			}
+		}
+	}
+
+	latestRollupsMutex.RLock()
+	keys := make([]string, 0, len(latestRollups))
+	for key := range latestRollups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		addRollup(latestRollups[key])
+	}
+	latestRollupsMutex.RUnlock()
+	for _, rollup := range live {
+		addRollup(rollup)
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		family := families[name]
+		if family.unit != "" {
+			fmt.Fprintf(w, "# HELP %s Unit: %s\n", name, family.unit)
+		}
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, family.kind)
+		for _, sample := range family.samples {
+			fmt.Fprintf(w, "%s\n", sample)
+		}
+	}
+}
+
+// WriteLatestRollups writes the most recent rollups of the metric with the
+// given name (of all sources, tags, and writers) as a JSON array of records
+// in debug format (see debugLine), sorted by source, series, and writer
+// names. Returns the number of written rollups.
+func WriteLatestRollups(w io.Writer, name string) (count int, error os.Error) {
+	records := latestRecords(name)
+	return len(records), json.NewEncoder(w).Encode(records)
+}
+
+// WriteLiveRollups writes the most recent rollups of the metric with the
+// given name (see WriteLatestRollups), followed by live rollups of current
+// slices of all routes of the router, tagged with types.PARTIAL_TAG (see
+// liveRollups). Returns the number of written rollups.
+func (router *Router) WriteLiveRollups(w io.Writer, name string) (count int, error os.Error) {
+	records := latestRecords(name)
+	for _, rollup := range router.liveRollups(name) {
+		records = append(records, &debugRecord{Time: rollup.time, Source: rollup.source, Name: rollup.name, Tags: rollup.tags, Writer: rollup.writer, Unit: rollup.unit, Values: debugValues(rollup.data)})
+	}
+	return len(records), json.NewEncoder(w).Encode(records)
+}
+
+// latestRecords returns the most recent rollups of the metric with the
+// given name as records in debug format, sorted by source, series, and
+// writer names.
+func latestRecords(name string) []*debugRecord {
+	latestRollupsMutex.RLock()
+	keys := make([]string, 0, 10)
+	for key, rollup := range latestRollups {
+		if rollup.name == name {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	records := make([]*debugRecord, 0, len(keys))
+	for _, key := range keys {
+		rollup := latestRollups[key]
+		data, time := rollup.exported()
+		records = append(records, &debugRecord{Time: time, Source: rollup.source, Name: rollup.name, Tags: rollup.tags, Writer: rollup.writer, Unit: rollup.unit, Values: debugValues(data)})
+	}
+	latestRollupsMutex.RUnlock()
+	return records
+}
+
+// WriteRecentRollups writes the RecentRollups most recent rollups of the
+// metric with the given name (of all sources, tags, and writers) as a JSON
+// array of records in debug format, sorted by source, series, and writer
+// names, the oldest rollups first. Rollups are not consolidated into export
+// archives. Only the latest rollups are written when RecentRollups is not
+// set. Returns the number of written rollups.
+func WriteRecentRollups(w io.Writer, name string) (count int, error os.Error) {
+	latestRollupsMutex.RLock()
+	keys := make([]string, 0, 10)
+	for key, rollup := range latestRollups {
+		if rollup.name == name {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	records := make([]*debugRecord, 0, len(keys)*(config.RecentRollups+1))
+	for _, key := range keys {
+		rollup := latestRollups[key]
+		if rollup.recent == nil {
+			data, time := rollup.exported()
+			records = append(records, &debugRecord{Time: time, Source: rollup.source, Name: rollup.name, Tags: rollup.tags, Writer: rollup.writer, Unit: rollup.unit, Values: debugValues(data)})
+			continue
+		}
+		for _, recent := range rollup.recent.list() {
+			records = append(records, &debugRecord{Time: recent.time, Source: rollup.source, Name: rollup.name, Tags: rollup.tags, Writer: rollup.writer, Unit: rollup.unit, Values: debugValues(recent.data)})
+		}
+	}
+	latestRollupsMutex.RUnlock()
+
+	return len(records), json.NewEncoder(w).Encode(records)
+}
+
+// dataFields returns names of RRD data sources, and corresponding values
+// of the given data item.
+func dataFields(data dataItem) (fields, values []string) {
+	fields = strings.Split(data.rrdTemplate(), ":")
+	// The first value is a timestamp
+	values = strings.Split(data.rrdString(), ":")[1:]
+	return
+}
+
+// prometheusName converts metric name to a valid Prometheus metric name.
+func prometheusName(name string) string {
+	return "metricsd_" + prometheusLabelName(name)
+}
+
+// prometheusLabelName replaces characters not allowed in Prometheus metric
+// and label names with "_".
+func prometheusLabelName(name string) string {
+	return strings.Map(func(rune int) int {
+		if ('0' <= rune && rune <= '9') || ('a' <= rune && rune <= 'z') || ('A' <= rune && rune <= 'Z') || rune == '_' {
+			return rune
+		}
+		return '_'
+	}, name)
+}
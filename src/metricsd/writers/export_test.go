@@ -0,0 +1,69 @@
+package writers
+
+import (
+	"bytes"
+	"json"
+	"strings"
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+)
+
+type ExportS struct{}
+
+var _ = Suite(&ExportS{})
+
+func (s *ExportS) SetUpTest(c *C) {
+	latestRollups = make(map[string]*latestRollup)
+}
+
+func (s *ExportS) TearDownTest(c *C) {
+	latestRollups = make(map[string]*latestRollup)
+	config.SetMetrics(nil)
+}
+
+func (s *ExportS) TestWriteLatestRollups(c *C) {
+	writer := &Count{}
+	set := createSampleSet(1000, 1, -1, 1)
+	remember(writer, set, writer.rollupData(set))
+	other := createSampleSet(1000, 1)
+	other.Name = "other"
+	remember(writer, other, writer.rollupData(other))
+
+	buffer := &bytes.Buffer{}
+	count, err := WriteLatestRollups(buffer, "metric")
+	c.Assert(err, IsNil)
+	c.Check(count, Equals, 1)
+
+	var records []map[string]interface{}
+	c.Assert(json.Unmarshal(buffer.Bytes(), &records), IsNil)
+	c.Assert(len(records), Equals, 1)
+	c.Check(records[0]["time"], Equals, float64(1000))
+	c.Check(records[0]["source"], Equals, "src")
+	c.Check(records[0]["name"], Equals, "metric")
+	c.Check(records[0]["writer"], Equals, "count")
+	c.Check(records[0]["values"], Equals, map[string]interface{}{"ok": float64(2), "fail": float64(1)})
+}
+
+func (s *ExportS) TestWriteLatestRollupsOfUnknownMetric(c *C) {
+	buffer := &bytes.Buffer{}
+	count, err := WriteLatestRollups(buffer, "metric")
+	c.Assert(err, IsNil)
+	c.Check(count, Equals, 0)
+	c.Check(buffer.String(), Equals, "[]\n")
+}
+
+func (s *ExportS) TestWritePrometheusOverThreshold(c *C) {
+	config.SetMetrics([]*config.MetricConfig{&config.MetricConfig{Pattern: "metric", MaxErrorRate: 0.5}})
+	writer := &Count{}
+	set := createSampleSet(1000, 1, -1, -1)
+	remember(writer, set, writer.rollupData(set))
+	other := createSampleSet(1000, -1)
+	other.Name = "other"
+	remember(writer, other, writer.rollupData(other))
+
+	buffer := &bytes.Buffer{}
+	WritePrometheus(buffer)
+	output := buffer.String()
+	c.Check(strings.Contains(output, "# TYPE metricsd_metric_count_over_threshold gauge\nmetricsd_metric_count_over_threshold{source=\"src\"} 1\n"), Equals, true)
+	c.Check(strings.Contains(output, "metricsd_other_count_over_threshold"), Equals, false)
+}
@@ -0,0 +1,95 @@
+package writers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"metricsd/types"
+)
+
+// gaugeWriter is implemented by writers producing gauges (as opposed to
+// counters). Only gauge writers report carried sample sets, generated for
+// slices without samples (see GapPolicy config option).
+type gaugeWriter interface {
+	// prototype returns an empty data item used to report unknown values.
+	prototype() dataItem
+}
+
+// zeroWriter is implemented by counter writers reporting zero (instead of
+// nothing) for slices without samples, so rates are computed cleanly.
+type zeroWriter interface {
+	// zero returns the data item reported for a slice without samples.
+	zero(time int64) dataItem
+}
+
+// unknownItem reports unknown values for all data sources of a writer.
+type unknownItem struct {
+	// Timestamp of the sample set.
+	time int64
+	// Data item of the writer, used to get RRD parameters.
+	prototype dataItem
+}
+
+// summarize performs summarization on the given sample set using the
+// writer. Carried sample sets are ignored by all writers except gauges and
+// zero writers; empty carried sample sets are reported by gauges as unknown
+// values, and all carried sample sets are reported by zero writers as zero.
+// Negative values are handled according to the writer's policy (see
+// applyNegativePolicy). Rollups of rate writers are suppressed during
+// metric warmup (see warmingUp). Quantile writers report unknown values
+// for sample sets with too few samples (see belowMinSamples). The number
+// of samples is appended for writers counting them (see withSampleCount).
+// Time spent is accumulated per writer (see observeRollup). Panics of the
+// writer are recovered, nothing is reported then (see recoverRollup).
+func summarize(writer Writer, set *types.SampleSet) (data dataItem) {
+	defer observeRollup(writer, time.Nanoseconds())
+	defer recoverRollup(writer, set, &data)
+	return withSampleCount(writer, set, summarizeSampleSet(writer, set))
+}
+
+// summarizeSampleSet returns the data item of the writer for the sample
+// set, or nil when nothing should be reported (see summarize).
+func summarizeSampleSet(writer Writer, set *types.SampleSet) dataItem {
+	if warmingUp(writer, set) {
+		return nil
+	}
+	if set.Carried {
+		if zero, ok := writer.(zeroWriter); ok {
+			return zero.zero(set.Time)
+		}
+		gauge, ok := writer.(gaugeWriter)
+		if !ok {
+			return nil
+		}
+		if len(set.Values) == 0 {
+			return &unknownItem{time: set.Time, prototype: gauge.prototype()}
+		}
+	}
+	set = applyNegativePolicy(writer.Name(), set)
+	if unknown := belowMinSamples(writer, set); unknown != nil {
+		return unknown
+	}
+	return writer.rollupData(set)
+}
+
+// String returns string representation of the given unknownItem.
+func (self *unknownItem) String() string {
+	return fmt.Sprintf("unknownItem[time=%d, prototype=%s]", self.time, self.prototype)
+}
+
+// rrdInfo returns the list of parameters used to create RRD file.
+func (self *unknownItem) rrdInfo() []string {
+	return self.prototype.rrdInfo()
+}
+
+// rrdTemplate returns template for RRDTool used to update data.
+func (self *unknownItem) rrdTemplate() string {
+	return self.prototype.rrdTemplate()
+}
+
+// rrdString returns a string matching template format with unknown values
+// for all data sources.
+func (self *unknownItem) rrdString() string {
+	fields := len(strings.Split(self.rrdTemplate(), ":"))
+	return fmt.Sprintf("%d%s", self.time, strings.Repeat(":"+UnknownValue, fields))
+}
@@ -0,0 +1,35 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+type GapsS struct{}
+
+var _ = Suite(&GapsS{})
+
+func (s *GapsS) TestSummarizeReceivedSampleSet(c *C) {
+	ss := createSampleSet(1000, 1, -1)
+	c.Check(summarize(&Count{}, ss), Equals, &countItem{time: 1000, ok: 1, fail: 1})
+}
+
+func (s *GapsS) TestSummarizeCarriedSampleSetWithCounter(c *C) {
+	ss := createSampleSet(2000, 10)
+	ss.Carried = true
+	c.Check(summarize(&Count{}, ss), IsNil)
+}
+
+func (s *GapsS) TestSummarizeCarriedSampleSetWithGauge(c *C) {
+	ss := createSampleSet(3000, 10)
+	ss.Carried = true
+	data := summarize(&Quartiles{}, ss)
+	c.Check(data, Equals, &quartilesItem{time: 3000, lo: 10, q1: 10, q2: 10, q3: 10, hi: 10, total: 1})
+}
+
+func (s *GapsS) TestSummarizeEmptyCarriedSampleSetWithGauge(c *C) {
+	ss := createSampleSet(4000)
+	ss.Carried = true
+	data := summarize(&Percentiles{}, ss)
+	c.Check(data.rrdTemplate(), Equals, "pct90:pct90mean:pct90dev:pct95:pct95mean:pct95dev")
+	c.Check(data.rrdString(), Equals, "4000:U:U:U:U:U:U")
+}
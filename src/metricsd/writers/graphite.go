@@ -0,0 +1,56 @@
+package writers
+
+import (
+	"fmt"
+	"strings"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+var (
+	// Sender of lines to Graphite (created on first use)
+	graphiteSender *shardedSender
+)
+
+// forwardToGraphite sends the data item to Graphite (Carbon) in plaintext
+// format, when GraphiteAddress is configured (see lineSender). With several
+// addresses every metric is sent to the same one (see shardedSender).
+func forwardToGraphite(writer Writer, set *types.SampleSet, data dataItem) {
+	if config.GraphiteAddress == "" {
+		return
+	}
+	if graphiteSender == nil {
+		graphiteSender = newShardedSender("Graphite", "tcp", config.GraphiteAddress, config.HashReplicas)
+	}
+	for _, line := range graphiteLines(writer, set, data) {
+		graphiteSender.send(set.Name, line)
+	}
+}
+
+// graphiteLines returns the data item in Graphite plaintext format, one
+// line per RRD data source. Unknown values are skipped by default (see
+// config.UnknownValues).
+func graphiteLines(writer Writer, set *types.SampleSet, data dataItem) []string {
+	fields, values := dataFields(data)
+	lines := make([]string, 0, len(fields))
+	for idx, field := range fields {
+		value, ok := renderValue(config.OUTPUT_GRAPHITE, values[idx])
+		if !ok {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %d\n", graphitePath(writer, set, field), value, set.Time))
+	}
+	return lines
+}
+
+// graphitePath returns Graphite metric path for the data source:
+//     <prefix><source>.<metric><suffix>.<data source>[;<tag>=<value>...]
+// Dots in source (IP addresses, host names) are replaced with "_", so
+// source is a single node of the path. "{writer}" in suffix is replaced
+// with the writer name. Tags are appended in Graphite tagged format.
+func graphitePath(writer Writer, set *types.SampleSet, field string) string {
+	source := strings.Replace(set.Source, ".", "_", -1)
+	name := strings.Replace(set.Name, "$", ".", -1)
+	suffix := strings.Replace(config.GraphiteSuffix, "{writer}", writer.Name(), -1)
+	return types.SeriesName(config.GraphitePrefix+source+"."+name+suffix+"."+field, set.Tags)
+}
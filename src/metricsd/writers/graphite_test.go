@@ -0,0 +1,48 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+)
+
+type GraphiteS struct{}
+
+var _ = Suite(&GraphiteS{})
+
+func (s *GraphiteS) TearDownTest(c *C) {
+	config.GraphitePrefix = config.DEFAULT_GRAPHITE_PREFIX
+	config.GraphiteSuffix = config.DEFAULT_GRAPHITE_SUFFIX
+	config.UnknownValues = config.DEFAULT_UNKNOWN_VALUES
+}
+
+func (s *GraphiteS) TestGraphiteLines(c *C) {
+	set := createSampleSet(1000, 1, -1)
+	set.Source = "10.0.0.1"
+	writer := &Count{}
+	lines := graphiteLines(writer, set, writer.rollupData(set))
+	c.Check(lines, Equals, []string{"10_0_0_1.metric.ok 1 1000\n", "10_0_0_1.metric.fail 1 1000\n"})
+}
+
+func (s *GraphiteS) TestGraphiteLinesWithPrefixAndSuffix(c *C) {
+	config.GraphitePrefix = "prod.dc1."
+	config.GraphiteSuffix = ".{writer}"
+	set := createSampleSet(1000, 1)
+	writer := &Count{}
+	lines := graphiteLines(writer, set, writer.rollupData(set))
+	c.Check(lines, Equals, []string{"prod.dc1.src.metric.count.ok 1 1000\n", "prod.dc1.src.metric.count.fail 0 1000\n"})
+}
+
+func (s *GraphiteS) TestGraphiteLinesSkipUnknownValues(c *C) {
+	set := createSampleSet(1000, 10)
+	writer := &Cov{}
+	lines := graphiteLines(writer, set, writer.rollupData(set))
+	c.Check(len(lines), Equals, 0)
+}
+
+func (s *GraphiteS) TestGraphiteLinesRenderUnknownValues(c *C) {
+	config.UnknownValues = map[string]string{config.OUTPUT_GRAPHITE: "nan"}
+	set := createSampleSet(1000, 10)
+	writer := &Cov{}
+	lines := graphiteLines(writer, set, writer.rollupData(set))
+	c.Check(lines, Equals, []string{"src.metric.cov nan 1000\n"})
+}
@@ -0,0 +1,38 @@
+package writers
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"metricsd/config"
+)
+
+// rrdHeartbeatInfo returns the list of parameters used to create RRD file
+// of the metric, with heartbeats of data sources replaced with per-metric
+// Heartbeat, so metrics reporting irregularly by design (e.g. batch jobs)
+// are not stored as unknown between updates. COMPUTE data sources have no
+// heartbeat. Returns an error when the heartbeat is shorter than the step of
+// the file (the slice interval of the metric).
+func rrdHeartbeatInfo(name string, step int64, info []string) ([]string, os.Error) {
+	heartbeat := config.MetricOptions(name).Heartbeat
+	if heartbeat == 0 {
+		return info, nil
+	}
+	if int64(heartbeat) < step {
+		return nil, os.NewError(fmt.Sprintf("Heartbeat %d is shorter than step %d", heartbeat, step))
+	}
+
+	result := make([]string, 0, len(info))
+	for _, item := range info {
+		// DS:<name>:<type>:<heartbeat>:<min>:<max>
+		fields := strings.Split(item, ":")
+		if fields[0] != "DS" || len(fields) != 6 || fields[2] == "COMPUTE" {
+			result = append(result, item)
+			continue
+		}
+		fields[3] = strconv.Itoa(heartbeat)
+		result = append(result, strings.Join(fields, ":"))
+	}
+	return result, nil
+}
@@ -0,0 +1,50 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+)
+
+type HeartbeatS struct{}
+
+var _ = Suite(&HeartbeatS{})
+
+func (s *HeartbeatS) TearDownTest(c *C) {
+	config.SetMetrics(nil)
+}
+
+func (s *HeartbeatS) TestRrdHeartbeatInfoWithoutOverride(c *C) {
+	info := (&countItem{}).rrdInfo()
+	result, err := rrdHeartbeatInfo("metric", 10, info)
+	c.Assert(err, IsNil)
+	c.Check(result, Equals, info)
+}
+
+func (s *HeartbeatS) TestRrdHeartbeatInfo(c *C) {
+	config.SetMetrics([]*config.MetricConfig{&config.MetricConfig{Pattern: "batch.*", Heartbeat: 1800}})
+	info := []string{
+		"DS:ok:ABSOLUTE:600:0:U",
+		"DS:error_ratio:COMPUTE:fail,ok,fail,+,/",
+		"RRA:AVERAGE:0.5:1:25920",
+	}
+	result, err := rrdHeartbeatInfo("batch.job", 10, info)
+	c.Assert(err, IsNil)
+	c.Check(result, Equals, []string{
+		"DS:ok:ABSOLUTE:1800:0:U",
+		"DS:error_ratio:COMPUTE:fail,ok,fail,+,/",
+		"RRA:AVERAGE:0.5:1:25920",
+	})
+
+	// Other metrics keep heartbeats of writers
+	result, err = rrdHeartbeatInfo("metric", 10, info)
+	c.Assert(err, IsNil)
+	c.Check(result, Equals, info)
+}
+
+func (s *HeartbeatS) TestRrdHeartbeatInfoShorterThanStep(c *C) {
+	config.SetMetrics([]*config.MetricConfig{&config.MetricConfig{Pattern: "metric", Heartbeat: 30}})
+	_, err := rrdHeartbeatInfo("metric", 60, (&countItem{}).rrdInfo())
+	c.Check(err, Not(IsNil))
+	_, err = rrdHeartbeatInfo("metric", 30, (&countItem{}).rrdInfo())
+	c.Check(err, IsNil)
+}
@@ -0,0 +1,152 @@
+package writers
+
+import (
+	"fmt"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+// Histogram writer is used to count values falling into configured buckets
+// (see HistogramBuckets config option). Every bucket counts values less
+// than or equal to its upper bound, and greater than the previous bound;
+// the last implicit bucket counts values greater than all bounds.
+type Histogram struct {
+	*BaseWriter
+	// Upper bounds of buckets, in increasing order.
+	Bounds []int
+}
+
+// NewHistogram returns a new Histogram writer with buckets defined in
+// configuration.
+func NewHistogram() *Histogram {
+	return &Histogram{Bounds: config.HistogramBuckets}
+}
+
+// histogramItem stores number of values in every bucket of the sample set.
+type histogramItem struct {
+	// Timestamp of the sample set.
+	time int64
+	// Upper bounds of buckets.
+	bounds []int
+	// Number of values in every bucket (not cumulative). The last item is
+	// the number of values greater than all bounds.
+	counts []uint64
+	// Sum of all values.
+	sum int64
+}
+
+// Name returns the name of the writer.
+func (self *Histogram) Name() string {
+	return "histogram"
+}
+
+// rollupData performs summarization on the given sample set and returns
+// histogramItem with statistics. Pre-aggregated or coalesced values are
+// counted as many times as their weight.
+func (self *Histogram) rollupData(set *types.SampleSet) (data dataItem) {
+	if len(set.Values) == 0 {
+		return
+	}
+
+	item := &histogramItem{time: set.Time, bounds: self.Bounds, counts: make([]uint64, len(self.Bounds)+1)}
+	for pos, elem := range set.Values {
+		weight := set.Weight(pos)
+		idx := len(self.Bounds)
+		for i, bound := range self.Bounds {
+			if elem <= bound {
+				idx = i
+				break
+			}
+		}
+		item.counts[idx] += uint64(weight)
+		item.sum = types.SaturatedAdd(item.sum, int64(elem)*int64(weight))
+	}
+	data = item
+	return
+}
+
+// String returns string representation of the given histogramItem.
+func (self *histogramItem) String() string {
+	return fmt.Sprintf("histogramItem[time=%d, bounds=%v, counts=%v, sum=%d]", self.time, self.bounds, self.counts, self.sum)
+}
+
+// rrdInfo returns the list of parameters used to create RRD file.
+func (self *histogramItem) rrdInfo() []string {
+	info := make([]string, 0, len(self.bounds)+5)
+	for _, name := range histogramDataSources(self.bounds) {
+		info = append(info, fmt.Sprintf("DS:%s:ABSOLUTE:600:0:U", name))
+	}
+	return append(info,
+		"DS:sum:GAUGE:600:U:U",
+		"RRA:AVERAGE:0.5:1:25920",   // 72 hours at 1 sample per 10 secs
+		"RRA:AVERAGE:0.5:60:4320",   // 1 month at 1 sample per 10 mins
+		"RRA:AVERAGE:0.5:2880:5475", // 5 years at 1 sample per 8 hours
+	)
+}
+
+// rrdTemplate returns template for RRDTool used to update data.
+func (self *histogramItem) rrdTemplate() string {
+	template := ""
+	for _, name := range histogramDataSources(self.bounds) {
+		template += name + ":"
+	}
+	return template + "sum"
+}
+
+// rrdString returns a string matching template format with the data to
+// update RRD files.
+func (self *histogramItem) rrdString() string {
+	result := fmt.Sprintf("%d", self.time)
+	for _, count := range self.counts {
+		result += fmt.Sprintf(":%d", capCount(count))
+	}
+	return result + fmt.Sprintf(":%d", self.sum)
+}
+
+// prometheusType returns Prometheus metric type of the item.
+func (*histogramItem) prometheusType() string {
+	return "histogram"
+}
+
+// prometheusSamples returns the histogram in Prometheus format: cumulative
+// buckets with "le" label, sum and count of values.
+func (self *histogramItem) prometheusSamples(name, labels string) []string {
+	samples := make([]string, 0, len(self.counts)+2)
+	var cumulative uint64
+	for idx, count := range self.counts {
+		cumulative += count
+		le := "+Inf"
+		if idx < len(self.bounds) {
+			le = fmt.Sprintf("%d", self.bounds[idx])
+		}
+		samples = append(samples, fmt.Sprintf("%s_bucket{%s,le=%q} %d", name, labels, le, capCount(cumulative)))
+	}
+	samples = append(samples, fmt.Sprintf("%s_sum{%s} %d", name, labels, self.sum))
+	samples = append(samples, fmt.Sprintf("%s_count{%s} %d", name, labels, capCount(cumulative)))
+	return samples
+}
+
+// histogramDataSources returns RRD data source names for buckets with the
+// given upper bounds.
+func histogramDataSources(bounds []int) []string {
+	names := make([]string, 0, len(bounds)+1)
+	for _, bound := range bounds {
+		if bound < 0 {
+			names = append(names, dataSourceName(fmt.Sprintf("lem%d", -bound)))
+		} else {
+			names = append(names, dataSourceName(fmt.Sprintf("le%d", bound)))
+		}
+	}
+	return append(names, "inf")
+}
+
+// dataSources returns names of RRD data sources of the writer rollups.
+func (self *Histogram) dataSources() []string {
+	return append(histogramDataSources(self.Bounds), "sum")
+}
+
+// HistogramDataSources returns RRD data source names for buckets defined
+// in configuration.
+func HistogramDataSources() []string {
+	return histogramDataSources(config.HistogramBuckets)
+}
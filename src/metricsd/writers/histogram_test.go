@@ -0,0 +1,63 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+type HistogramS struct {
+	histogram *Histogram
+}
+
+var _ = Suite(&HistogramS{})
+
+func (s *HistogramS) SetUpTest(c *C) {
+	s.histogram = &Histogram{Bounds: []int{10, 100}}
+}
+
+func (s *HistogramS) TestRollupDataWithEmptySampleSet(c *C) {
+	ss := createSampleSet(1000)
+	data := s.histogram.rollupData(ss)
+	c.Check(data, IsNil)
+}
+
+func (s *HistogramS) TestRollupDataWithSimpleSampleSet(c *C) {
+	ss := createSampleSet(2000, 5, 10, 11, 100, 1000)
+	data := s.histogram.rollupData(ss)
+	c.Check(data, Equals, &histogramItem{time: 2000, bounds: []int{10, 100}, counts: []uint64{2, 2, 1}, sum: 1126})
+	c.Check(data.rrdTemplate(), Equals, "le10:le100:inf:sum")
+	c.Check(data.rrdString(), Equals, "2000:2:2:1:1126")
+}
+
+func (s *HistogramS) TestRollupDataWithNegativeBounds(c *C) {
+	s.histogram.Bounds = []int{-10, 0}
+	ss := createSampleSet(3000, -20, -5, 5)
+	data := s.histogram.rollupData(ss)
+	c.Check(data.rrdTemplate(), Equals, "lem10:le0:inf:sum")
+	c.Check(data.rrdString(), Equals, "3000:1:1:1:-20")
+}
+
+func (s *HistogramS) TestPrometheusSamples(c *C) {
+	ss := createSampleSet(4000, 5, 10, 11, 100, 1000)
+	data := s.histogram.rollupData(ss).(*histogramItem)
+	c.Check(data.prometheusType(), Equals, "histogram")
+	c.Check(data.prometheusSamples("metricsd_metric_histogram", "source=\"src\""), Equals, []string{
+		"metricsd_metric_histogram_bucket{source=\"src\",le=\"10\"} 2",
+		"metricsd_metric_histogram_bucket{source=\"src\",le=\"100\"} 4",
+		"metricsd_metric_histogram_bucket{source=\"src\",le=\"+Inf\"} 5",
+		"metricsd_metric_histogram_sum{source=\"src\"} 1126",
+		"metricsd_metric_histogram_count{source=\"src\"} 5",
+	})
+}
+
+func (s *HistogramS) TestPrometheusName(c *C) {
+	c.Check(prometheusName("group.metric-name$1_histogram"), Equals, "metricsd_group_metric_name_1_histogram")
+}
+
+func (s *HistogramS) TestRollupDataWithCoalescedValues(c *C) {
+	values := []int{5, 5, 5, 50, 1000, 1000}
+	coalesced := createCoalescedSampleSet(5000, values...)
+	c.Check(coalesced.Values, Equals, []int{5, 50, 1000})
+	data := s.histogram.rollupData(coalesced)
+	c.Check(data, Equals, s.histogram.rollupData(createSampleSet(5000, values...)))
+	c.Check(data.rrdString(), Equals, "5000:3:1:2:2065")
+}
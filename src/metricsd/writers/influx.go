@@ -0,0 +1,53 @@
+package writers
+
+import (
+	"fmt"
+	"strings"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+var (
+	// Sender of lines to InfluxDB (created on first use)
+	influxSender *shardedSender
+)
+
+// forwardToInflux sends the data item to InfluxDB UDP listener in line
+// protocol format, when InfluxAddress is configured (see lineSender). With
+// several addresses every metric is sent to the same one (see
+// shardedSender).
+func forwardToInflux(writer Writer, set *types.SampleSet, data dataItem) {
+	if config.InfluxAddress == "" {
+		return
+	}
+	if influxSender == nil {
+		influxSender = newShardedSender("InfluxDB", "udp", config.InfluxAddress, config.HashReplicas)
+	}
+	if line := influxLine(writer, set, data); line != "" {
+		influxSender.send(set.Name, line)
+	}
+}
+
+// influxLine returns the data item in InfluxDB line protocol format:
+//     <metric>,source=<source>,writer=<writer>[,<tag>=<value>...] <data source>=<value>,... <time>
+// Unknown values are skipped by default (see config.UnknownValues), empty
+// string is returned when all values are skipped.
+func influxLine(writer Writer, set *types.SampleSet, data dataItem) string {
+	fields, values := dataFields(data)
+	pairs := make([]string, 0, len(fields))
+	for idx, field := range fields {
+		value, ok := renderValue(config.OUTPUT_INFLUX, values[idx])
+		if !ok {
+			continue
+		}
+		pairs = append(pairs, field+"="+value)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	tags := ""
+	for _, key := range set.Tags.Keys() {
+		tags += "," + key + "=" + set.Tags[key]
+	}
+	return fmt.Sprintf("%s,source=%s,writer=%s%s %s %d\n", set.Name, set.Source, writer.Name(), tags, strings.Join(pairs, ","), set.Time*1e9)
+}
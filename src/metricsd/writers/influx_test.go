@@ -0,0 +1,32 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+	"metricsd/types"
+)
+
+type InfluxS struct{}
+
+var _ = Suite(&InfluxS{})
+
+func (s *InfluxS) TestInfluxLine(c *C) {
+	set := createSampleSet(1000, 1, -1)
+	writer := &Count{}
+	line := influxLine(writer, set, writer.rollupData(set))
+	c.Check(line, Equals, "metric,source=src,writer=count ok=1,fail=1 1000000000000\n")
+}
+
+func (s *InfluxS) TestInfluxLineWithTags(c *C) {
+	set := createSampleSet(1000, 1)
+	set.Tags = types.Tags{"status": "200", "region": "eu"}
+	writer := &Count{}
+	line := influxLine(writer, set, writer.rollupData(set))
+	c.Check(line, Equals, "metric,source=src,writer=count,region=eu,status=200 ok=1,fail=0 1000000000000\n")
+}
+
+func (s *InfluxS) TestInfluxLineWithUnknownValues(c *C) {
+	set := createSampleSet(1000, 10)
+	writer := &Cov{}
+	line := influxLine(writer, set, writer.rollupData(set))
+	c.Check(line, Equals, "")
+}
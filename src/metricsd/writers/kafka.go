@@ -0,0 +1,245 @@
+package writers
+
+import (
+	"fmt"
+	"hash/crc32"
+	"json"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+const (
+	// Number of batches waiting to be produced to Kafka.
+	kafkaQueueSize = 10
+)
+
+var (
+	// Number of rollups not produced to Kafka because the queue was full,
+	// or brokers rejected them (reset by stats reporting)
+	KafkaDropped int64
+	// Producer of rollups to Kafka (created on first use)
+	kafkaOutput *kafkaProducer
+	// Mutex protecting kafkaOutput
+	kafkaOutputMutex = &sync.Mutex{}
+)
+
+// A kafkaProducer produces rollups to the Kafka topic in the background.
+// Rollups published during a write pass are collected, and produced as a
+// single batch when the pass finishes (see flushKafka). Leaders of
+// partitions are discovered from any of the configured brokers. When
+// brokers are unavailable, the batch is retried after the backoff delay
+// (see config.Reconnect) with refreshed metadata, while new batches are
+// queued, or dropped when the queue is full.
+type kafkaProducer struct {
+	config   *config.KafkaConfig
+	mutex    *sync.Mutex
+	pending  []*kafkaMessage // messages of the current pass
+	queue    chan []*kafkaMessage
+	backoff  *backoff
+	metadata *kafkaMetadata        // nil when it should be refreshed
+	conns    map[string]*kafkaConn // connections to brokers keyed by address
+}
+
+// newKafkaProducer returns a new kafkaProducer and starts producing
+// batches.
+func newKafkaProducer(kafka *config.KafkaConfig) *kafkaProducer {
+	producer := &kafkaProducer{
+		config:  kafka,
+		mutex:   &sync.Mutex{},
+		queue:   make(chan []*kafkaMessage, kafkaQueueSize),
+		backoff: newBackoff(),
+		conns:   make(map[string]*kafkaConn),
+	}
+	go producer.run()
+	return producer
+}
+
+// forwardToKafka adds the data item to the batch produced to Kafka, when
+// Kafka output is configured. Messages are keyed by metric name, so all
+// series of a metric go to the same partition.
+func forwardToKafka(writer Writer, set *types.SampleSet, data dataItem) {
+	if config.Kafka == nil {
+		return
+	}
+	value, error := kafkaValue(writer, set, data)
+	if error != nil {
+		config.Logger.Debug("Cannot encode %s rollup of %s for Kafka: %s", writer.Name(), set.SeriesName(), error)
+		return
+	}
+	kafkaOutputMutex.Lock()
+	if kafkaOutput == nil {
+		kafkaOutput = newKafkaProducer(config.Kafka)
+	}
+	producer := kafkaOutput
+	kafkaOutputMutex.Unlock()
+	producer.add(&kafkaMessage{key: set.Name, value: value})
+}
+
+// flushKafka queues rollups published since the previous call as a batch
+// produced to Kafka.
+func flushKafka() {
+	kafkaOutputMutex.Lock()
+	producer := kafkaOutput
+	kafkaOutputMutex.Unlock()
+	if producer != nil {
+		producer.flush()
+	}
+}
+
+// kafkaValue returns the value of the Kafka message with the data item in
+// the configured format: the object of JSON debug format (see
+// debugRecord), or its Avro encoding (see KafkaAvroSchema).
+func kafkaValue(writer Writer, set *types.SampleSet, data dataItem) ([]byte, os.Error) {
+	record := &debugRecord{Time: set.Time, Source: set.Source, Name: set.Name, Tags: set.Tags, Writer: writer.Name(), Unit: seriesUnit(writer, set.Name), Values: debugValues(data)}
+	if config.Kafka.Format == config.KAFKA_FORMAT_AVRO {
+		return avroRecord(record), nil
+	}
+	return json.Marshal(record)
+}
+
+// add appends the message to the current batch, or drops it when the
+// batch is full.
+func (producer *kafkaProducer) add(message *kafkaMessage) {
+	producer.mutex.Lock()
+	defer producer.mutex.Unlock()
+	if len(producer.pending) >= senderQueueSize {
+		atomic.AddInt64(&KafkaDropped, 1)
+		return
+	}
+	producer.pending = append(producer.pending, message)
+}
+
+// flush queues the current batch, or drops it when the queue is full.
+func (producer *kafkaProducer) flush() {
+	producer.mutex.Lock()
+	batch := producer.pending
+	producer.pending = nil
+	producer.mutex.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+	select {
+	case producer.queue <- batch:
+	default:
+		config.Logger.Debug("Kafka queue is full, dropping %d rollups", len(batch))
+		atomic.AddInt64(&KafkaDropped, int64(len(batch)))
+	}
+}
+
+// run produces batches from the queue, retrying messages not accepted by
+// brokers after the backoff delay.
+func (producer *kafkaProducer) run() {
+	for batch := range producer.queue {
+		for len(batch) > 0 {
+			var error os.Error
+			batch, error = producer.produce(batch)
+			if error == nil {
+				producer.backoff.reset()
+				continue
+			}
+			delay := producer.backoff.next()
+			config.Logger.Debug("Cannot produce %d rollups to Kafka: %s, retrying in %v seconds", len(batch), error, float64(delay)/1e9)
+			time.Sleep(delay)
+		}
+	}
+}
+
+// produce sends messages to leaders of their partitions, and returns
+// messages to retry with the first error occurred. Metadata is refreshed
+// before retrying. Messages rejected as invalid are dropped.
+func (producer *kafkaProducer) produce(messages []*kafkaMessage) (failed []*kafkaMessage, err os.Error) {
+	if producer.metadata == nil {
+		if producer.metadata, err = producer.refreshMetadata(); err != nil {
+			return messages, err
+		}
+	}
+	metadata := producer.metadata
+
+	// Messages keyed by leader address and partition id
+	leaders := make(map[string]map[int32][]*kafkaMessage)
+	for _, message := range messages {
+		partition := metadata.partitions[crc32.ChecksumIEEE([]byte(message.key))%uint32(len(metadata.partitions))]
+		address, found := metadata.brokers[partition.leader]
+		if !found {
+			failed = append(failed, message)
+			err = os.NewError(fmt.Sprintf("Kafka partition %d has no leader", partition.id))
+			continue
+		}
+		if leaders[address] == nil {
+			leaders[address] = make(map[int32][]*kafkaMessage)
+		}
+		leaders[address][partition.id] = append(leaders[address][partition.id], message)
+	}
+
+	for address, partitions := range leaders {
+		codes, error := producer.connect(address).produce(producer.config.Topic, producer.config.RequiredAcks, producer.config.Timeout, partitions)
+		if error != nil {
+			producer.disconnect(address)
+		}
+		for id, list := range partitions {
+			code, found := codes[id]
+			switch {
+			case error != nil:
+				err = os.NewError(fmt.Sprintf("broker %s: %s", address, error))
+				failed = append(failed, list...)
+			case !found || code == kafkaNoError:
+			case code == kafkaCorruptMessage || code == kafkaMessageTooLarge:
+				config.Logger.Debug("Kafka broker %s rejected %d rollups of partition %d (error code %d)", address, len(list), id, code)
+				atomic.AddInt64(&KafkaDropped, int64(len(list)))
+			default:
+				err = os.NewError(fmt.Sprintf("broker %s: partition %d: error code %d", address, id, code))
+				failed = append(failed, list...)
+			}
+		}
+	}
+	if err != nil {
+		producer.metadata = nil
+	}
+	return
+}
+
+// refreshMetadata requests partitions of the topic from configured brokers
+// in turn, returns the first successful response.
+func (producer *kafkaProducer) refreshMetadata() (metadata *kafkaMetadata, err os.Error) {
+	for _, address := range producer.config.Brokers {
+		if metadata, err = producer.connect(address).metadata(producer.config.Topic); err == nil {
+			return
+		}
+		err = os.NewError(fmt.Sprintf("broker %s: %s", address, err))
+		producer.disconnect(address)
+	}
+	return
+}
+
+// connect returns the connection to the broker, connecting first if
+// necessary. The returned connection has nil conn when the broker is
+// unavailable (requests fail).
+func (producer *kafkaProducer) connect(address string) *kafkaConn {
+	if conn, found := producer.conns[address]; found {
+		return conn
+	}
+	conn := &kafkaConn{clientId: producer.config.ClientId}
+	netConn, error := net.Dial("tcp", address)
+	if error != nil {
+		config.Logger.Debug("Cannot connect to Kafka broker %s: %s", address, error)
+		return conn
+	}
+	// Brokers wait for acknowledgements up to the configured timeout
+	netConn.SetTimeout(int64(producer.config.Timeout)*1e9 + senderTimeout)
+	conn.conn = netConn
+	producer.conns[address] = conn
+	return conn
+}
+
+// disconnect closes the connection to the broker.
+func (producer *kafkaProducer) disconnect(address string) {
+	if conn, found := producer.conns[address]; found {
+		conn.conn.Close()
+		producer.conns[address] = nil, false
+	}
+}
@@ -0,0 +1,284 @@
+package writers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"os"
+	"sort"
+)
+
+// Kafka protocol (version 0 of requests, see
+// https://kafka.apache.org/protocol) used by the Kafka output.
+const (
+	kafkaProduceKey  = 0 // API key of produce requests
+	kafkaMetadataKey = 3 // API key of metadata requests
+)
+
+// Kafka error codes handled by the producer.
+const (
+	kafkaNoError         = 0
+	kafkaCorruptMessage  = 2
+	kafkaMessageTooLarge = 10
+)
+
+// kafkaEncoder encodes primitive types of Kafka protocol (big endian).
+type kafkaEncoder struct {
+	bytes.Buffer
+}
+
+func (self *kafkaEncoder) putInt8(value int8) {
+	self.WriteByte(byte(value))
+}
+
+func (self *kafkaEncoder) putInt16(value int16) {
+	var data [2]byte
+	binary.BigEndian.PutUint16(data[:], uint16(value))
+	self.Write(data[:])
+}
+
+func (self *kafkaEncoder) putInt32(value int32) {
+	var data [4]byte
+	binary.BigEndian.PutUint32(data[:], uint32(value))
+	self.Write(data[:])
+}
+
+func (self *kafkaEncoder) putInt64(value int64) {
+	var data [8]byte
+	binary.BigEndian.PutUint64(data[:], uint64(value))
+	self.Write(data[:])
+}
+
+// putString encodes the string with int16 length.
+func (self *kafkaEncoder) putString(value string) {
+	self.putInt16(int16(len(value)))
+	self.WriteString(value)
+}
+
+// putBytes encodes the data with int32 length, nil data is encoded as null
+// (length -1).
+func (self *kafkaEncoder) putBytes(data []byte) {
+	if data == nil {
+		self.putInt32(-1)
+		return
+	}
+	self.putInt32(int32(len(data)))
+	self.Write(data)
+}
+
+// kafkaDecoder decodes primitive types of Kafka protocol. The first error
+// (truncated data) is kept, values decoded after it are zero.
+type kafkaDecoder struct {
+	data  []byte
+	error os.Error
+}
+
+// take returns the next n bytes of data, nil when data is truncated.
+func (self *kafkaDecoder) take(n int) []byte {
+	if self.error != nil {
+		return nil
+	}
+	if n < 0 || n > len(self.data) {
+		self.error = os.NewError("Truncated Kafka response")
+		return nil
+	}
+	result := self.data[:n]
+	self.data = self.data[n:]
+	return result
+}
+
+func (self *kafkaDecoder) int16() int16 {
+	if data := self.take(2); data != nil {
+		return int16(binary.BigEndian.Uint16(data))
+	}
+	return 0
+}
+
+func (self *kafkaDecoder) int32() int32 {
+	if data := self.take(4); data != nil {
+		return int32(binary.BigEndian.Uint32(data))
+	}
+	return 0
+}
+
+func (self *kafkaDecoder) int64() int64 {
+	if data := self.take(8); data != nil {
+		return int64(binary.BigEndian.Uint64(data))
+	}
+	return 0
+}
+
+func (self *kafkaDecoder) string() string {
+	return string(self.take(int(self.int16())))
+}
+
+// kafkaMessage is a message produced to Kafka.
+type kafkaMessage struct {
+	key   string
+	value []byte
+}
+
+// kafkaMessageSet encodes messages in MessageSet format (magic byte 0,
+// without compression). Offsets are assigned by brokers.
+func kafkaMessageSet(messages []*kafkaMessage) []byte {
+	set := &kafkaEncoder{}
+	for _, message := range messages {
+		body := &kafkaEncoder{}
+		body.putInt8(0) // magic byte
+		body.putInt8(0) // attributes
+		body.putBytes([]byte(message.key))
+		body.putBytes(message.value)
+
+		set.putInt64(0)
+		set.putInt32(int32(4 + body.Len()))
+		set.putInt32(int32(crc32.ChecksumIEEE(body.Bytes())))
+		set.Write(body.Bytes())
+	}
+	return set.Bytes()
+}
+
+// kafkaPartition describes a partition of the topic.
+type kafkaPartition struct {
+	id     int32
+	leader int32 // node id of the leader, -1 when there is no leader
+}
+
+// kafkaPartitions sorts partitions by id.
+type kafkaPartitions []*kafkaPartition
+
+func (self kafkaPartitions) Len() int           { return len(self) }
+func (self kafkaPartitions) Less(i, j int) bool { return self[i].id < self[j].id }
+func (self kafkaPartitions) Swap(i, j int)      { self[i], self[j] = self[j], self[i] }
+
+// kafkaMetadata describes brokers and partitions of the topic.
+type kafkaMetadata struct {
+	brokers    map[int32]string // broker addresses keyed by node id
+	partitions kafkaPartitions  // sorted by id
+}
+
+// kafkaConn is a connection to a broker.
+type kafkaConn struct {
+	conn          net.Conn
+	clientId      string
+	correlationId int32
+}
+
+// request sends the request with the given API key and body, and returns
+// the response body after the correlation id. The response is not read
+// when expectResponse is false (produce requests without acks). Requests
+// fail when the broker is not connected.
+func (self *kafkaConn) request(apiKey int16, body []byte, expectResponse bool) (response *kafkaDecoder, err os.Error) {
+	if self.conn == nil {
+		return nil, os.NewError("not connected")
+	}
+	self.correlationId++
+	header := &kafkaEncoder{}
+	header.putInt16(apiKey)
+	header.putInt16(0) // API version
+	header.putInt32(self.correlationId)
+	header.putString(self.clientId)
+	request := &kafkaEncoder{}
+	request.putInt32(int32(header.Len() + len(body)))
+	request.Write(header.Bytes())
+	request.Write(body)
+	if _, err = self.conn.Write(request.Bytes()); err != nil || !expectResponse {
+		return
+	}
+
+	var size [4]byte
+	if _, err = io.ReadFull(self.conn, size[:]); err != nil {
+		return
+	}
+	data := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err = io.ReadFull(self.conn, data); err != nil {
+		return
+	}
+	response = &kafkaDecoder{data: data}
+	if id := response.int32(); id != self.correlationId {
+		return nil, os.NewError(fmt.Sprintf("Kafka response has correlation id %d, expected %d", id, self.correlationId))
+	}
+	return
+}
+
+// metadata requests brokers and partitions of the topic.
+func (self *kafkaConn) metadata(topic string) (metadata *kafkaMetadata, err os.Error) {
+	body := &kafkaEncoder{}
+	body.putInt32(1)
+	body.putString(topic)
+	response, err := self.request(kafkaMetadataKey, body.Bytes(), true)
+	if err != nil {
+		return
+	}
+
+	metadata = &kafkaMetadata{brokers: make(map[int32]string)}
+	for count := response.int32(); count > 0 && response.error == nil; count-- {
+		id := response.int32()
+		host := response.string()
+		port := response.int32()
+		metadata.brokers[id] = fmt.Sprintf("%s:%d", host, port)
+	}
+	for count := response.int32(); count > 0 && response.error == nil; count-- {
+		code := response.int16()
+		name := response.string()
+		for partitions := response.int32(); partitions > 0 && response.error == nil; partitions-- {
+			response.int16() // partition error code, leader is -1 when unavailable
+			partition := &kafkaPartition{id: response.int32(), leader: response.int32()}
+			// Replicas and in-sync replicas are not used
+			for lists := 0; lists < 2; lists++ {
+				for nodes := response.int32(); nodes > 0 && response.error == nil; nodes-- {
+					response.int32()
+				}
+			}
+			if name == topic {
+				metadata.partitions = append(metadata.partitions, partition)
+			}
+		}
+		if name == topic && code != kafkaNoError {
+			return nil, os.NewError(fmt.Sprintf("Kafka topic %s is not available (error code %d)", topic, code))
+		}
+	}
+	if response.error != nil {
+		return nil, response.error
+	}
+	if len(metadata.partitions) == 0 {
+		return nil, os.NewError(fmt.Sprintf("Kafka topic %s has no partitions", topic))
+	}
+	sort.Sort(metadata.partitions)
+	return
+}
+
+// produce sends messages keyed by partition id to the topic, and returns
+// error codes keyed by partition id (empty when acks are not required).
+func (self *kafkaConn) produce(topic string, requiredAcks, timeout int, messages map[int32][]*kafkaMessage) (codes map[int32]int16, err os.Error) {
+	body := &kafkaEncoder{}
+	body.putInt16(int16(requiredAcks))
+	body.putInt32(int32(timeout * 1000))
+	body.putInt32(1)
+	body.putString(topic)
+	body.putInt32(int32(len(messages)))
+	for partition, list := range messages {
+		set := kafkaMessageSet(list)
+		body.putInt32(partition)
+		body.putInt32(int32(len(set)))
+		body.Write(set)
+	}
+	response, err := self.request(kafkaProduceKey, body.Bytes(), requiredAcks != 0)
+	codes = make(map[int32]int16)
+	if err != nil || response == nil {
+		return
+	}
+
+	for count := response.int32(); count > 0 && response.error == nil; count-- {
+		response.string()
+		for partitions := response.int32(); partitions > 0 && response.error == nil; partitions-- {
+			partition := response.int32()
+			codes[partition] = response.int16()
+			response.int64() // offset
+		}
+	}
+	err = response.error
+	return
+}
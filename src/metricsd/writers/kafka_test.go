@@ -0,0 +1,62 @@
+package writers
+
+import (
+	"hash/crc32"
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+type KafkaS struct{}
+
+var _ = Suite(&KafkaS{})
+
+func (s *KafkaS) TearDownTest(c *C) {
+	config.Kafka = nil
+	config.DebugFormat = config.DEFAULT_DEBUG_FORMAT
+}
+
+func (s *KafkaS) TestKafkaValueInJson(c *C) {
+	config.Kafka = &config.KafkaConfig{Format: config.KAFKA_FORMAT_JSON}
+	config.DebugFormat = config.DEBUG_FORMAT_JSON
+	set := createSampleSet(1000, 1, -1)
+	writer := &Count{}
+	data := writer.rollupData(set)
+	value, err := kafkaValue(writer, set, data)
+	c.Assert(err, IsNil)
+	c.Check(string(value)+"\n", Equals, debugLine(writer, set, data))
+}
+
+func (s *KafkaS) TestAvroRecord(c *C) {
+	record := &debugRecord{Time: 1000, Source: "src", Name: "m", Tags: types.Tags{"a": "b"}, Writer: "sum", Unit: "ms", Values: map[string]interface{}{"ok": 1.0, "x": nil}}
+	c.Check(avroRecord(record), Equals, []byte{
+		0xd0, 0x0f, // time
+		0x06, 's', 'r', 'c',
+		0x02, 'm',
+		0x02, 0x02, 'a', 0x02, 'b', 0x00, // tags
+		0x06, 's', 'u', 'm',
+		0x04, 'm', 's',
+		0x04, // values
+		0x04, 'o', 'k', 0x02, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f,
+		0x02, 'x', 0x00,
+		0x00,
+	})
+}
+
+func (s *KafkaS) TestKafkaMessageSet(c *C) {
+	set := kafkaMessageSet([]*kafkaMessage{&kafkaMessage{key: "m", value: []byte("v")}})
+	decoder := &kafkaDecoder{data: set}
+	c.Check(decoder.int64(), Equals, int64(0))
+	c.Check(decoder.int32(), Equals, int32(len(set)-12))
+	crc := uint32(decoder.int32())
+	c.Check(crc, Equals, crc32.ChecksumIEEE(decoder.data))
+	c.Check(decoder.data, Equals, []byte{0, 0, 0, 0, 0, 1, 'm', 0, 0, 0, 1, 'v'})
+	c.Check(decoder.error, IsNil)
+}
+
+func (s *KafkaS) TestKafkaDecoderTruncated(c *C) {
+	decoder := &kafkaDecoder{data: []byte{0, 5, 'a'}}
+	c.Check(decoder.string(), Equals, "")
+	c.Check(decoder.int32(), Equals, int32(0))
+	c.Check(decoder.error, Not(IsNil))
+}
@@ -0,0 +1,82 @@
+package writers
+
+import (
+	"fmt"
+	"metricsd/types"
+)
+
+// Last writer is used to report gauges by their latest value, e.g. a queue
+// depth sampled by the producer. With LatestGauges enabled, gauges
+// processed by Last writer only keep just the latest value in sample sets
+// (see config.KeepsLatest).
+type Last struct {
+	*BaseWriter
+}
+
+// lastItem stores the latest value of the sample set.
+type lastItem struct {
+	// Timestamp of the sample set.
+	time int64
+	// The value added last.
+	value int
+	// Value indicating whether the value is known.
+	known bool
+}
+
+// Name returns the name of the writer.
+func (*Last) Name() string {
+	return "last"
+}
+
+// rollupData returns lastItem with the value added last to the given
+// sample set (nothing is reported for empty sample sets).
+func (*Last) rollupData(set *types.SampleSet) (data dataItem) {
+	if len(set.Values) == 0 && !set.Latest {
+		return
+	}
+	data = &lastItem{time: set.Time, value: set.Last, known: true}
+	return
+}
+
+// prototype returns an empty data item used to report unknown values.
+func (*Last) prototype() dataItem {
+	return &lastItem{}
+}
+
+// String returns string representation of the given lastItem.
+func (self *lastItem) String() string {
+	return fmt.Sprintf("lastItem[time=%d, value=%s]", self.time, self.formattedValue())
+}
+
+// rrdInfo returns the list of parameters used to create RRD file.
+func (*lastItem) rrdInfo() []string {
+	return []string{
+		"DS:last:GAUGE:600:U:U",
+		"RRA:AVERAGE:0.5:1:25920",   // 72 hours at 1 sample per 10 secs
+		"RRA:AVERAGE:0.5:60:4320",   // 1 month at 1 sample per 10 mins
+		"RRA:AVERAGE:0.5:2880:5475", // 5 years at 1 sample per 8 hours
+		"RRA:LAST:0.5:1:25920",      // 72 hours at 1 sample per 10 secs
+		"RRA:LAST:0.5:60:4320",      // 1 month at 1 sample per 10 mins
+		"RRA:LAST:0.5:2880:5475",    // 5 years at 1 sample per 8 hours
+	}
+}
+
+// rrdTemplate returns template for RRDTool used to update data.
+func (*lastItem) rrdTemplate() string {
+	return "last"
+}
+
+// rrdString returns a string matching template format with the data to
+// update RRD files.
+func (self *lastItem) rrdString() string {
+	return fmt.Sprintf("%d:%s", self.time, self.formattedValue())
+}
+
+// formattedValue returns the formatted value, or UnknownValue when it is
+// not known.
+func (self *lastItem) formattedValue() string {
+	if !self.known {
+		return UnknownValue
+	}
+	return fmt.Sprintf("%d", self.value)
+}
@@ -0,0 +1,75 @@
+package writers
+
+import (
+	"fmt"
+	"metricsd/types"
+)
+
+// LastSeen writer is used to track freshness of metrics: it reports the
+// time of the most recent event of the sample set, so alerts could be
+// triggered when a source goes silent. With "carry" gap policy the last
+// known time is reported for slices without events (see
+// config.GAP_POLICY_CARRY), so its difference from the current time grows.
+type LastSeen struct {
+	*BaseWriter
+}
+
+// lastSeenItem stores the time of the most recent event of the sample set.
+type lastSeenItem struct {
+	// Timestamp of the sample set.
+	time int64
+	// Time of the most recent event (seconds since epoch).
+	seen int64
+}
+
+// Name returns the name of the writer.
+func (*LastSeen) Name() string {
+	return "last_seen"
+}
+
+// unit returns the unit of the writer rollups.
+func (*LastSeen) unit() string {
+	return "timestamp"
+}
+
+// rollupData returns lastSeenItem with the time of the most recent event
+// of the given sample set (nothing is reported when it is unknown).
+func (*LastSeen) rollupData(set *types.SampleSet) (data dataItem) {
+	if set.LastSeen == 0 {
+		return
+	}
+	data = &lastSeenItem{time: set.Time, seen: set.LastSeen}
+	return
+}
+
+// prototype returns an empty data item used to report unknown values.
+func (*LastSeen) prototype() dataItem {
+	return &lastSeenItem{}
+}
+
+// String returns string representation of the given lastSeenItem.
+func (self *lastSeenItem) String() string {
+	return fmt.Sprintf("lastSeenItem[time=%d, seen=%d]", self.time, self.seen)
+}
+
+// rrdInfo returns the list of parameters used to create RRD file. Maximum
+// is the most recent time in consolidated archives.
+func (*lastSeenItem) rrdInfo() []string {
+	return []string{
+		"DS:last_seen:GAUGE:600:0:U",
+		"RRA:MAX:0.5:1:25920",   // 72 hours at 1 sample per 10 secs
+		"RRA:MAX:0.5:60:4320",   // 1 month at 1 sample per 10 mins
+		"RRA:MAX:0.5:2880:5475", // 5 years at 1 sample per 8 hours
+	}
+}
+
+// rrdTemplate returns template for RRDTool used to update data.
+func (*lastSeenItem) rrdTemplate() string {
+	return "last_seen"
+}
+
+// rrdString returns a string matching template format with the data to
+// update RRD files.
+func (self *lastSeenItem) rrdString() string {
+	return fmt.Sprintf("%d:%d", self.time, self.seen)
+}
@@ -0,0 +1,35 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+type LastSeenS struct {
+	writer *LastSeen
+}
+
+var _ = Suite(&LastSeenS{})
+
+func (s *LastSeenS) SetUpTest(c *C) {
+	s.writer = &LastSeen{}
+}
+
+func (s *LastSeenS) TestRollupData(c *C) {
+	set := createSampleSet(1000, 10)
+	set.Touch(1007)
+	set.Touch(1003)
+	data := s.writer.rollupData(set)
+	c.Check(data, Equals, &lastSeenItem{time: 1000, seen: 1007})
+	c.Check(data.rrdString(), Equals, "1000:1007")
+}
+
+func (s *LastSeenS) TestRollupDataWithoutEvents(c *C) {
+	c.Check(s.writer.rollupData(createSampleSet(1000)), IsNil)
+}
+
+func (s *LastSeenS) TestSummarizeCarriedSampleSet(c *C) {
+	set := createSampleSet(1010, 10)
+	set.Carried = true
+	set.LastSeen = 995
+	c.Check(summarize(s.writer, set).rrdString(), Equals, "1010:995")
+}
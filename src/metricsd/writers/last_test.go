@@ -0,0 +1,41 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+	"metricsd/types"
+)
+
+type LastS struct {
+	writer *Last
+}
+
+var _ = Suite(&LastS{})
+
+func (s *LastS) SetUpTest(c *C) {
+	s.writer = &Last{}
+}
+
+func (s *LastS) TestRollupData(c *C) {
+	set := createSampleSet(1000, 10, 30, 20)
+	set.Sort()
+	data := s.writer.rollupData(set)
+	c.Check(data, Equals, &lastItem{time: 1000, value: 20, known: true})
+	c.Check(data.rrdString(), Equals, "1000:20")
+}
+
+func (s *LastS) TestRollupDataOfLatestSampleSet(c *C) {
+	set := types.NewSampleSet(1000, "src", "metric")
+	set.AddLatest(10)
+	set.AddLatest(-5)
+	c.Check(s.writer.rollupData(set).rrdString(), Equals, "1000:-5")
+}
+
+func (s *LastS) TestRollupDataWithoutValues(c *C) {
+	c.Check(s.writer.rollupData(createSampleSet(1000)), IsNil)
+}
+
+func (s *LastS) TestSummarizeEmptyCarriedSampleSet(c *C) {
+	set := createSampleSet(1010)
+	set.Carried = true
+	c.Check(summarize(s.writer, set).rrdString(), Equals, "1010:"+UnknownValue)
+}
@@ -0,0 +1,92 @@
+package writers
+
+import (
+	"sync"
+	"sync/atomic"
+	"metricsd/config"
+)
+
+var (
+	// Times metrics being captured were first seen at (seconds since
+	// epoch), keyed by metric name
+	launches = make(map[string]int64)
+	// Times metrics captured already were last seen at (seconds since
+	// epoch), keyed by metric name
+	launched = make(map[string]*int64)
+	// Mutex protecting launches and launched
+	launchesMutex = &sync.RWMutex{}
+)
+
+// launching returns a value indicating whether the metric received at the
+// given time (seconds since epoch) is captured at the launch interval (see
+// config.LaunchCapture): it was first seen since startup less than
+// LaunchCapture.Duration() seconds ago. Metrics are moved to launched when
+// their capture is over, and remembered until they are absent for StateTTL
+// slice intervals (see expireLaunches), so a metric is captured once.
+func launching(name string, now int64) bool {
+	// Most events are of metrics captured already, they are checked
+	// holding the read lock only
+	launchesMutex.RLock()
+	seen, found := launched[name]
+	if found {
+		touchLaunched(seen, now)
+	}
+	launchesMutex.RUnlock()
+	if found {
+		return false
+	}
+
+	launchesMutex.Lock()
+	defer launchesMutex.Unlock()
+	if seen, found := launched[name]; found {
+		touchLaunched(seen, now)
+		return false
+	}
+	first, found := launches[name]
+	if !found {
+		first = now
+		launches[name] = first
+	}
+	if now-first < config.LaunchCapture.Duration() {
+		return true
+	}
+	launches[name] = 0, false
+	launched[name] = &now
+	return false
+}
+
+// touchLaunched updates the time a metric captured already was last seen
+// at, when the given time is later.
+func touchLaunched(seen *int64, now int64) {
+	if last := atomic.AddInt64(seen, 0); now > last {
+		atomic.CompareAndSwapInt64(seen, last, now)
+	}
+}
+
+// expireLaunches moves metrics, which capture is over at the given time
+// (seconds since epoch), to launched, and forgets launched metrics absent
+// for StateTTL slice intervals, so memory is not held by metrics which are
+// gone. Forgotten metrics are captured again when they reappear. Returns
+// the number of forgotten metrics.
+func expireLaunches(now int64) (expired int) {
+	launchesMutex.Lock()
+	defer launchesMutex.Unlock()
+	for name, first := range launches {
+		if now-first >= config.LaunchCapture.Duration() {
+			launches[name] = 0, false
+			seen := now
+			launched[name] = &seen
+		}
+	}
+	if config.StateTTL <= 0 {
+		return
+	}
+	ttl := int64(config.StateTTL) * int64(config.SliceInterval)
+	for name, seen := range launched {
+		if now-atomic.AddInt64(seen, 0) > ttl {
+			launched[name] = nil, false
+			expired++
+		}
+	}
+	return
+}
@@ -0,0 +1,74 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+	"metricsd/logger"
+	"metricsd/types"
+)
+
+type LaunchS struct{}
+
+var _ = Suite(&LaunchS{})
+
+func (s *LaunchS) SetUpTest(c *C) {
+	config.Logger = logger.NewConsoleLogger(logger.UNKNOWN)
+	config.LaunchCapture = &config.LaunchConfig{Interval: 1, Intervals: 5}
+	launches = make(map[string]int64)
+	launched = make(map[string]*int64)
+}
+
+func (s *LaunchS) TearDownTest(c *C) {
+	config.LaunchCapture = config.DEFAULT_LAUNCH_CAPTURE
+}
+
+func (s *LaunchS) TestLaunching(c *C) {
+	c.Check(launching("metric", 1000), Equals, true)
+	c.Check(launching("metric", 1004), Equals, true)
+	c.Check(launching("metric", 1005), Equals, false)
+	// Metrics are captured once
+	c.Check(launching("metric", 1000), Equals, false)
+	c.Check(launching("other", 1005), Equals, true)
+	c.Check(len(launches), Equals, 1)
+	c.Check(len(launched), Equals, 1)
+}
+
+func (s *LaunchS) TestExpireLaunches(c *C) {
+	launching("metric", 1000)
+	launching("other", 1000)
+	launching("other", 1005)
+	c.Check(expireLaunches(1005), Equals, 0)
+	c.Check(len(launches), Equals, 0)
+	c.Check(len(launched), Equals, 2)
+
+	// Metrics absent for StateTTL slice intervals are captured again
+	config.StateTTL = 10
+	defer func() { config.StateTTL = config.DEFAULT_STATE_TTL }()
+	launching("other", 1100)
+	ttl := int64(config.StateTTL) * int64(config.SliceInterval)
+	c.Check(expireLaunches(1005+ttl+1), Equals, 1)
+	c.Check(launching("metric", 1005+ttl+1), Equals, true)
+	c.Check(launching("other", 1005+ttl+1), Equals, false)
+}
+
+func (s *LaunchS) TestRouterCopiesNewMetrics(c *C) {
+	router, err := NewRouter(nil)
+	c.Assert(err, IsNil)
+	c.Check(len(router.Routes), Equals, 2)
+	c.Check(router.Routes[0].Timeline.Interval, Equals, int64(1))
+	c.Check(router.Route("metric"), Equals, router.Default())
+
+	router.Add(types.NewEvent("src", "metric", 10))
+	sets := router.Routes[0].Timeline.ExtractClosedSampleSets(true)
+	c.Assert(len(sets), Equals, 1)
+	c.Check(sets[0].Name, Equals, "metric"+config.LAUNCH_SUFFIX)
+	c.Check(sets[0].Values, Equals, []int{10})
+	sets = router.Default().Timeline.ExtractClosedSampleSets(true)
+	c.Assert(len(sets), Equals, 1)
+	c.Check(sets[0].Name, Equals, "metric")
+
+	// Capture of an old metric is over
+	launches["metric"] -= config.LaunchCapture.Duration()
+	router.Add(types.NewEvent("src", "metric", 10))
+	c.Check(len(router.Routes[0].Timeline.ExtractClosedSampleSets(true)), Equals, 0)
+}
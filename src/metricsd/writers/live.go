@@ -0,0 +1,70 @@
+package writers
+
+import (
+	"sort"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+// statefulWriter is implemented by writers keeping state between rollups
+// of a series (see Change), which would be corrupted by rollups of
+// incomplete slices, so they are not calculated live.
+type statefulWriter interface {
+	stateful() bool
+}
+
+// liveRollups returns rollups of current slices of all routes for the
+// metric with the given name (or all metrics, when the name is empty),
+// sorted by source, series, and writer names. Slices are copied and marked
+// as partial first (see types.Timeline.SnapshotCurrent), so ingestion is
+// not blocked while rollups are calculated. Rollups are not remembered,
+// and not written to outputs. Stateful and capturing writers are skipped.
+func (router *Router) liveRollups(name string) []*latestRollup {
+	rollups := make(map[string]*latestRollup)
+	for _, route := range router.Routes {
+		slice := route.Timeline.SnapshotCurrent()
+		for _, set := range slice.Sets {
+			if name != "" && set.Name != name {
+				continue
+			}
+			for _, writer := range route.Aggregator.Writers {
+				if _, ok := writer.(capturingWriter); ok {
+					continue
+				}
+				if item, ok := writer.(statefulWriter); ok && item.stateful() {
+					continue
+				}
+				if !config.UsesWriter(set.Name, set.Type, set.Tags, writer.Name()) {
+					continue
+				}
+				data := summarizeLive(writer, set)
+				if data == nil {
+					continue
+				}
+				key := set.Source + "-" + set.SeriesName() + "-" + writer.Name()
+				rollups[key] = &latestRollup{set.Source, set.Name, set.Tags, writer.Name(), seriesUnit(writer, set.Name), data, set.Time, nil, nil}
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(rollups))
+	for key := range rollups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	result := make([]*latestRollup, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, rollups[key])
+	}
+	return result
+}
+
+// summarizeLive returns the data item of the writer for the sample set of
+// a current slice, or nil when the writer panicked (see recoverRollup).
+// Unlike summarize, warmup, negative values, and minimum samples policies
+// are not applied, so live rollups do not affect their counters, and time
+// spent is not observed.
+func summarizeLive(writer Writer, set *types.SampleSet) (data dataItem) {
+	defer recoverRollup(writer, set, &data)
+	return withSampleCount(writer, set, writer.rollupData(set))
+}
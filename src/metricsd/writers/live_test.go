@@ -0,0 +1,81 @@
+package writers
+
+import (
+	"bytes"
+	"json"
+	"strings"
+	"time"
+	. "launchpad.net/gocheck"
+	"metricsd/types"
+)
+
+type LiveS struct {
+	router *Router
+	now    int64
+}
+
+var _ = Suite(&LiveS{})
+
+func (s *LiveS) SetUpTest(c *C) {
+	s.now = 1005
+	types.Clock = func() int64 { return s.now }
+	timeline := types.NewTimeline(10)
+	route := &Route{Timeline: timeline, Aggregator: &Aggregator{Timeline: timeline, Writers: []Writer{&Count{}, &Change{}}}}
+	s.router = &Router{Routes: []*Route{route}}
+	latestRollups = make(map[string]*latestRollup)
+}
+
+func (s *LiveS) TearDownTest(c *C) {
+	types.Clock = time.Seconds
+	latestRollups = make(map[string]*latestRollup)
+}
+
+func (s *LiveS) TestLiveRollups(c *C) {
+	timeline := s.router.Routes[0].Timeline
+	timeline.Add(types.NewEvent("src", "metric", 1))
+	timeline.Add(types.NewEvent("src", "metric", -1))
+	timeline.Add(types.NewEvent("src", "other", 1))
+
+	rollups := s.router.liveRollups("metric")
+	// Stateful writers are skipped
+	c.Assert(len(rollups), Equals, 1)
+	c.Check(rollups[0].writer, Equals, "count")
+	c.Check(rollups[0].time, Equals, int64(1000))
+	c.Check(rollups[0].tags, Equals, types.Tags{types.PARTIAL_TAG: "true"})
+	c.Check(debugValues(rollups[0].data), Equals, map[string]interface{}{"ok": float64(1), "fail": float64(1)})
+	c.Check(len(s.router.liveRollups("")), Equals, 2)
+
+	// Live rollups are not remembered
+	c.Check(len(latestRollups), Equals, 0)
+}
+
+func (s *LiveS) TestWriteLiveRollups(c *C) {
+	writer := &Count{}
+	set := createSampleSet(990, 1, 1)
+	remember(writer, set, writer.rollupData(set))
+	s.router.Routes[0].Timeline.Add(types.NewEvent("src", "metric", -1))
+
+	buffer := &bytes.Buffer{}
+	count, err := s.router.WriteLiveRollups(buffer, "metric")
+	c.Assert(err, IsNil)
+	c.Check(count, Equals, 2)
+
+	var records []map[string]interface{}
+	c.Assert(json.Unmarshal(buffer.Bytes(), &records), IsNil)
+	c.Assert(len(records), Equals, 2)
+	c.Check(records[0]["time"], Equals, float64(990))
+	c.Check(records[1]["time"], Equals, float64(1000))
+	c.Check(records[1]["tags"], Equals, map[string]interface{}{types.PARTIAL_TAG: "true"})
+	c.Check(records[1]["values"], Equals, map[string]interface{}{"ok": float64(0), "fail": float64(1)})
+}
+
+func (s *LiveS) TestWriteLivePrometheus(c *C) {
+	s.router.Routes[0].Timeline.Add(types.NewEvent("src", "metric", 1))
+
+	buffer := &bytes.Buffer{}
+	WritePrometheus(buffer)
+	c.Check(buffer.String(), Equals, "")
+
+	s.router.WriteLivePrometheus(buffer)
+	c.Check(strings.Contains(buffer.String(), `metricsd_metric_count_ok{source="src",partial="true"} 1`), Equals, true)
+}
@@ -0,0 +1,100 @@
+package writers
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"metricsd/types"
+)
+
+// capturingWriter is implemented by writers keeping rollups themselves
+// instead of writing them to outputs (see Memory).
+type capturingWriter interface {
+	// capture summarizes the sample set and keeps the result.
+	capture(set *types.SampleSet)
+}
+
+// Memory writer records rollups of the wrapped writer in memory instead of
+// writing them to outputs, so tests of the whole pipeline (parsing,
+// timeline, rollups) do not depend on RRD files or network. Rollups are
+// calculated exactly as for outputs (see summarize), including per-metric
+// settings of the wrapped writer. It is not registered, so it could not be
+// enabled in configuration.
+type Memory struct {
+	Writer  Writer // writer calculating rollups
+	records []*MemoryRecord
+	mutex   *sync.Mutex
+}
+
+// A MemoryRecord is a rollup recorded by Memory writer.
+type MemoryRecord struct {
+	Source string
+	Name   string            // series name (see types.SampleSet.SeriesName)
+	Time   int64             // timestamp of the sample set
+	Fields map[string]string // values keyed by RRD data source names ("U" when unknown)
+}
+
+// NewMemory returns a new Memory writer recording rollups of the given
+// writer.
+func NewMemory(writer Writer) *Memory {
+	return &Memory{Writer: writer, mutex: &sync.Mutex{}}
+}
+
+// Name returns the name of the wrapped writer, so per-metric settings of
+// the writer apply.
+func (self *Memory) Name() string {
+	return self.Writer.Name()
+}
+
+// Rollup records the rollup of the sample set (see Rollup).
+func (self *Memory) Rollup(set *types.SampleSet, done <-chan bool) os.Error {
+	return Rollup(self, set, done)
+}
+
+// BatchRollup records rollups of the sample sets (see BatchRollup).
+func (self *Memory) BatchRollup(sets []*types.SampleSet, done <-chan bool) os.Error {
+	return BatchRollup(self, sets, done)
+}
+
+// rollupData returns the data item of the wrapped writer.
+func (self *Memory) rollupData(set *types.SampleSet) dataItem {
+	return self.Writer.rollupData(set)
+}
+
+// Records returns all recorded rollups in the order they were received.
+func (self *Memory) Records() []*MemoryRecord {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	records := make([]*MemoryRecord, len(self.records))
+	copy(records, self.records)
+	return records
+}
+
+// Reset forgets all recorded rollups.
+func (self *Memory) Reset() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.records = nil
+}
+
+// capture summarizes the sample set using the wrapped writer, and records
+// the result, unless there is nothing to report.
+func (self *Memory) capture(set *types.SampleSet) {
+	data := summarize(self.Writer, set)
+	if data == nil {
+		return
+	}
+	record := &MemoryRecord{Source: set.Source, Name: set.SeriesName(), Time: set.Time, Fields: make(map[string]string)}
+	fields, values := dataFields(data)
+	for idx, field := range fields {
+		record.Fields[field] = values[idx]
+	}
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.records = append(self.records, record)
+}
+
+func (self *MemoryRecord) String() string {
+	return fmt.Sprintf("MemoryRecord[source=%s, name=%s, time=%d, fields=%v]", self.Source, self.Name, self.Time, self.Fields)
+}
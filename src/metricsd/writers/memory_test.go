@@ -0,0 +1,69 @@
+package writers
+
+import (
+	"os"
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+	"metricsd/logger"
+	"metricsd/parser"
+	"metricsd/types"
+)
+
+type MemoryS struct {
+	timeline *types.Timeline
+	memory   *Memory
+}
+
+var _ = Suite(&MemoryS{})
+
+func (s *MemoryS) SetUpTest(c *C) {
+	config.Logger = logger.NewConsoleLogger(logger.UNKNOWN)
+	s.timeline = types.NewTimeline(10)
+	s.memory = NewMemory(&Count{})
+}
+
+func (s *MemoryS) addAt(buf string, timestamp int64) {
+	parser.Parse(buf, func(event *types.Event, err os.Error) {
+		if err == nil {
+			s.timeline.AddAt(event, timestamp)
+		}
+	})
+}
+
+func (s *MemoryS) TestRunOnceRecordsRollups(c *C) {
+	s.addAt("app01@requests:1;app01@requests:-1;app01@requests:1", 1000)
+	aggregator := NewAggregator(s.timeline, []Writer{s.memory}, nil)
+	c.Check(aggregator.RunOnce(true), IsNil)
+	c.Check(queuedRrdUpdates(), Equals, 0)
+
+	records := s.memory.Records()
+	c.Assert(len(records), Equals, 2) // "app01" and "all" sources
+	for _, record := range records {
+		c.Check(record.Name, Equals, "requests")
+		c.Check(record.Time, Equals, int64(1000))
+		c.Check(record.Fields, Equals, map[string]string{"ok": "2", "fail": "1"})
+	}
+}
+
+func (s *MemoryS) TestBatchRunOnceRecordsRollups(c *C) {
+	s.addAt("app01@requests:1", 1000)
+	s.addAt("app01@requests:-1", 1010)
+	aggregator := NewAggregator(s.timeline, []Writer{s.memory}, nil)
+	aggregator.Batch = true
+	c.Check(aggregator.RunOnce(true), IsNil)
+	c.Check(queuedRrdUpdates(), Equals, 0)
+
+	records := s.memory.Records()
+	c.Assert(len(records), Equals, 4)
+	c.Check(records[0].Source, Equals, "all")
+	c.Check(records[0].Time, Equals, int64(1000))
+	c.Check(records[1].Time, Equals, int64(1010))
+	c.Check(records[1].Fields, Equals, map[string]string{"ok": "0", "fail": "1"})
+}
+
+func (s *MemoryS) TestReset(c *C) {
+	c.Check(Rollup(s.memory, createSampleSet(1000, 1), nil), IsNil)
+	c.Check(len(s.memory.Records()), Equals, 1)
+	s.memory.Reset()
+	c.Check(len(s.memory.Records()), Equals, 0)
+}
@@ -0,0 +1,53 @@
+package writers
+
+import (
+	"sync/atomic"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+// quantileWriter is implemented by writers calculating quantiles (medians,
+// percentiles), which are meaningless for a few samples. Such writers
+// report unknown values for sample sets with less than MinSamples samples.
+type quantileWriter interface {
+	gaugeWriter
+	// quantiles returns a value indicating whether the writer calculates
+	// quantiles.
+	quantiles() bool
+}
+
+var (
+	// Number of rollups reported as unknown because of too few samples
+	// (reset by stats reporting)
+	BelowMinSamples int64
+)
+
+// belowMinSamples returns the unknown data item, when the writer calculates
+// quantiles, and the sample set has less samples than its minimum (see
+// config.MinSamples). Weighted values are counted as many samples as their
+// weight, summarized values are included. Returns nil when the sample set
+// should be summarized.
+func belowMinSamples(writer Writer, set *types.SampleSet) dataItem {
+	quantile, ok := writer.(quantileWriter)
+	if !ok || !quantile.quantiles() || (len(set.Values) == 0 && set.Summary == nil) {
+		return nil
+	}
+	min := int64(config.WriterMinSamples(writer.Name()))
+	if min <= 0 {
+		return nil
+	}
+	var samples int64
+	if set.Summary != nil {
+		samples = set.Summary.Count
+	}
+	if samples >= min {
+		return nil
+	}
+	for idx := range set.Values {
+		if samples += int64(set.Weight(idx)); samples >= min {
+			return nil
+		}
+	}
+	atomic.AddInt64(&BelowMinSamples, 1)
+	return &unknownItem{time: set.Time, prototype: quantile.prototype()}
+}
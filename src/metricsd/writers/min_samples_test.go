@@ -0,0 +1,42 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+)
+
+type MinSamplesS struct{}
+
+var _ = Suite(&MinSamplesS{})
+
+func (s *MinSamplesS) SetUpTest(c *C) {
+	config.MinSamples = map[string]int{"*": 3, "quartiles": 2}
+	BelowMinSamples = 0
+}
+
+func (s *MinSamplesS) TearDownTest(c *C) {
+	config.MinSamples = config.DEFAULT_MIN_SAMPLES
+}
+
+func (s *MinSamplesS) TestSummarizeBelowMinSamples(c *C) {
+	data := summarize(&Percentiles{}, createSampleSet(1000, 10, 20))
+	c.Check(data.rrdString(), Equals, "1000:U:U:U:U:U:U")
+	c.Check(BelowMinSamples, Equals, int64(1))
+}
+
+func (s *MinSamplesS) TestSummarizeWithWriterMinSamples(c *C) {
+	data := summarize(&Quartiles{}, createSampleSet(1000, 10, 20))
+	c.Check(data.rrdString(), Not(Equals), "1000:U:U:U:U:U:U")
+	c.Check(BelowMinSamples, Equals, int64(0))
+}
+
+func (s *MinSamplesS) TestSummarizeCountsWeights(c *C) {
+	set := createSampleSet(1000)
+	set.AddWeighted(10, 2)
+	set.AddWeighted(20, 1)
+	c.Check(summarize(&Percentiles{}, set).rrdString(), Not(Equals), "1000:U:U:U:U:U:U")
+}
+
+func (s *MinSamplesS) TestSummarizeIgnoresOtherWriters(c *C) {
+	c.Check(summarize(&Count{}, createSampleSet(1000, 1)), Equals, &countItem{time: 1000, ok: 1})
+}
@@ -0,0 +1,64 @@
+package writers
+
+import (
+	"sync/atomic"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+var (
+	// Number of negative values replaced with zero (reset by stats reporting)
+	NegativeValuesClamped int64
+	// Number of negative values dropped (reset by stats reporting)
+	NegativeValuesRejected int64
+)
+
+// applyNegativePolicy returns the sample set to be summarized by the writer
+// with the given name, according to its policy for negative values (see
+// config.NegativeValues). The set is shared by all writers, so a copy is
+// returned when values are changed. Accumulated totals and summaries are
+// not affected.
+func applyNegativePolicy(writer string, set *types.SampleSet) *types.SampleSet {
+	policy := config.NegativePolicy(writer)
+	if policy == config.NEGATIVE_POLICY_ALLOW || !hasNegativeValues(set.Values) {
+		return set
+	}
+
+	result := *set
+	result.Values = make([]int, 0, len(set.Values))
+	if set.Weights != nil {
+		result.Weights = make([]int, 0, len(set.Weights))
+	}
+	if set.Times != nil {
+		result.Times = make([]int64, 0, len(set.Times))
+	}
+	for idx, value := range set.Values {
+		if value < 0 {
+			if policy == config.NEGATIVE_POLICY_REJECT {
+				atomic.AddInt64(&NegativeValuesRejected, 1)
+				continue
+			}
+			atomic.AddInt64(&NegativeValuesClamped, 1)
+			value = 0
+		}
+		result.Values = append(result.Values, value)
+		if set.Weights != nil {
+			result.Weights = append(result.Weights, set.Weights[idx])
+		}
+		if set.Times != nil {
+			result.Times = append(result.Times, set.Times[idx])
+		}
+	}
+	return &result
+}
+
+// hasNegativeValues returns a value indicating whether there are negative
+// values in the list.
+func hasNegativeValues(values []int) bool {
+	for _, value := range values {
+		if value < 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+)
+
+type NegativeS struct{}
+
+var _ = Suite(&NegativeS{})
+
+func (s *NegativeS) SetUpTest(c *C) {
+	NegativeValuesClamped = 0
+	NegativeValuesRejected = 0
+}
+
+func (s *NegativeS) TearDownTest(c *C) {
+	config.NegativeValues = config.DEFAULT_NEGATIVE_VALUES
+}
+
+func (s *NegativeS) TestAllow(c *C) {
+	set := createSampleSet(1000, -5, 10)
+	c.Check(applyNegativePolicy("cov", set), Equals, set)
+}
+
+func (s *NegativeS) TestClamp(c *C) {
+	config.NegativeValues = map[string]string{"cov": config.NEGATIVE_POLICY_CLAMP}
+	set := createSampleSet(1000, -5, 10)
+	result := applyNegativePolicy("cov", set)
+	c.Check(result.Values, Equals, []int{0, 10})
+	c.Check(set.Values, Equals, []int{-5, 10})
+	c.Check(NegativeValuesClamped, Equals, int64(1))
+	c.Check(applyNegativePolicy("quartiles", set), Equals, set)
+}
+
+func (s *NegativeS) TestRejectKeepsWeights(c *C) {
+	config.NegativeValues = map[string]string{"*": config.NEGATIVE_POLICY_REJECT}
+	set := createSampleSet(1000)
+	set.AddWeighted(-5, 2)
+	set.AddWeighted(10, 3)
+	result := applyNegativePolicy("cov", set)
+	c.Check(result.Values, Equals, []int{10})
+	c.Check(result.Weights, Equals, []int{3})
+	c.Check(NegativeValuesRejected, Equals, int64(1))
+}
+
+func (s *NegativeS) TestRejectWithoutNegativeValues(c *C) {
+	config.NegativeValues = map[string]string{"cov": config.NEGATIVE_POLICY_REJECT}
+	set := createSampleSet(1000, 5, 10)
+	c.Check(applyNegativePolicy("cov", set), Equals, set)
+}
+
+func (s *NegativeS) TestSummarizeAppliesPolicy(c *C) {
+	config.NegativeValues = map[string]string{"cov": config.NEGATIVE_POLICY_REJECT}
+	data := summarize(&Cov{}, createSampleSet(1000, -20, 10, 20))
+	c.Check(data, Equals, &covItem{time: 1000, cov: 5.0 / 15.0, known: true})
+}
@@ -0,0 +1,160 @@
+package writers
+
+import (
+	"fmt"
+	"sync"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+// OverRate writer is used to track how often the event rate of a metric
+// exceeds the threshold defined by MaxRate per-metric option, for
+// "time spent over capacity" SLOs: every slice is reported as over (1) or
+// under (0) the threshold, along with the number of consecutive slices over
+// the threshold.
+type OverRate struct {
+	*BaseWriter
+	// Slice interval in seconds, used to calculate the rate.
+	Interval int
+}
+
+// NewOverRate returns a new OverRate writer using the slice interval
+// defined in configuration.
+func NewOverRate() *OverRate {
+	return &OverRate{Interval: config.SliceInterval}
+}
+
+// overRateItem stores the state of the threshold calculated by OverRate
+// writer.
+type overRateItem struct {
+	// Timestamp of the sample set.
+	time int64
+	// Number of events per second.
+	rate float64
+	// 1 when the rate is above the threshold, 0 otherwise.
+	over int
+	// Number of consecutive slices over the threshold, ending at the sample
+	// set.
+	streak int
+}
+
+// overRateStreak is the latest slice of a metric seen by OverRate writer.
+type overRateStreak struct {
+	streak int
+	time   int64 // timestamp of the sample set
+}
+
+var (
+	// Streaks of the latest slices, keyed by source and series names
+	overRateStreaks = make(map[string]*overRateStreak)
+	// Mutex protecting overRateStreaks
+	overRateStreaksMutex = &sync.Mutex{}
+)
+
+// Name returns the name of the writer.
+func (*OverRate) Name() string {
+	return "over_rate"
+}
+
+// stateful returns true: rollups count consecutive slices over the
+// threshold (see statefulWriter).
+func (self *OverRate) stateful() bool {
+	return true
+}
+
+// setInterval sets the slice interval of the timeline written by the
+// writer (see Router).
+func (self *OverRate) setInterval(interval int) {
+	self.Interval = interval
+}
+
+// rollupData performs summarization on the given sample set and returns
+// overRateItem with statistics. Pre-aggregated and accumulated events are
+// counted as many times as their weight. The streak continues only from
+// the previous slice of the metric, older sample sets (e.g. imported
+// history) do not change it.
+func (self *OverRate) rollupData(set *types.SampleSet) (data dataItem) {
+	count := set.Count
+	for idx := range set.Values {
+		count = types.SaturatedAdd(count, int64(set.Weight(idx)))
+	}
+	data = self.item(set, count)
+	return
+}
+
+// zero returns the data item reported for slices without samples: the rate
+// is zero, so the streak is over.
+func (self *OverRate) zero(time int64) dataItem {
+	return &overRateItem{time: time}
+}
+
+// item returns overRateItem of the sample set with the given number of
+// events, and remembers the streak for the next slice of the metric.
+func (self *OverRate) item(set *types.SampleSet, count int64) *overRateItem {
+	item := &overRateItem{time: set.Time}
+	if self.Interval > 0 {
+		item.rate = float64(count) / float64(self.Interval)
+	}
+	if item.rate <= config.MetricOptions(set.Name).MaxRate {
+		return item
+	}
+	item.over = 1
+	item.streak = 1
+
+	key := set.Source + "-" + set.SeriesName()
+	overRateStreaksMutex.Lock()
+	defer overRateStreaksMutex.Unlock()
+	previous, found := overRateStreaks[key]
+	if found && previous.time >= set.Time {
+		return item
+	}
+	if found && previous.time+int64(self.Interval) == set.Time {
+		item.streak = previous.streak + 1
+	}
+	overRateStreaks[key] = &overRateStreak{streak: item.streak, time: set.Time}
+	return item
+}
+
+// expireStreaks forgets streaks of metrics not over the threshold since the
+// given time (seconds since epoch), returns the number of forgotten streaks.
+func expireStreaks(before int64) (expired int) {
+	overRateStreaksMutex.Lock()
+	defer overRateStreaksMutex.Unlock()
+	for key, previous := range overRateStreaks {
+		if previous.time < before {
+			overRateStreaks[key] = nil, false
+			expired++
+		}
+	}
+	return
+}
+
+// String returns string representation of the given overRateItem.
+func (self *overRateItem) String() string {
+	return fmt.Sprintf("overRateItem[time=%d, rate=%.6f, over=%d, streak=%d]", self.time, self.rate, self.over, self.streak)
+}
+
+// rrdInfo returns the list of parameters used to create RRD file.
+func (*overRateItem) rrdInfo() []string {
+	return []string{
+		"DS:over:GAUGE:600:0:1",
+		"DS:streak:GAUGE:600:0:U",
+		"RRA:AVERAGE:0.5:1:25920",   // 72 hours at 1 sample per 10 secs
+		"RRA:AVERAGE:0.5:60:4320",   // 1 month at 1 sample per 10 mins
+		"RRA:AVERAGE:0.5:2880:5475", // 5 years at 1 sample per 8 hours
+		"RRA:MAX:0.5:1:25920",       // 72 hours at 1 sample per 10 secs
+		"RRA:MAX:0.5:60:4320",       // 1 month at 1 sample per 10 mins
+		"RRA:MAX:0.5:2880:5475",     // 5 years at 1 sample per 8 hours
+	}
+}
+
+// rrdTemplate returns template for RRDTool used to update data.
+func (*overRateItem) rrdTemplate() string {
+	return "over:streak"
+}
+
+// rrdString returns a string matching template format with the data to
+// update RRD files.
+func (self *overRateItem) rrdString() string {
+	return fmt.Sprintf("%d:%d:%d", self.time, self.over, self.streak)
+}
@@ -0,0 +1,63 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+)
+
+type OverRateS struct {
+	overRate *OverRate
+}
+
+var _ = Suite(&OverRateS{})
+
+func (s *OverRateS) SetUpTest(c *C) {
+	s.overRate = &OverRate{Interval: 10}
+	overRateStreaks = make(map[string]*overRateStreak)
+	config.SetMetrics([]*config.MetricConfig{&config.MetricConfig{Pattern: "metric", MaxRate: 0.2}})
+}
+
+func (s *OverRateS) TearDownTest(c *C) {
+	config.SetMetrics(nil)
+}
+
+func (s *OverRateS) TestRollupDataUnderThreshold(c *C) {
+	data := s.overRate.rollupData(createSampleSet(1000, 5, 10))
+	c.Check(data, Equals, &overRateItem{time: 1000, rate: 0.2})
+	c.Check(data.rrdString(), Equals, "1000:0:0")
+}
+
+func (s *OverRateS) TestRollupDataStreak(c *C) {
+	c.Check(s.overRate.rollupData(createSampleSet(1000, 1, 2, 3)), Equals, &overRateItem{time: 1000, rate: 0.3, over: 1, streak: 1})
+	data := s.overRate.rollupData(createSampleSet(1010, 1, 2, 3))
+	c.Check(data, Equals, &overRateItem{time: 1010, rate: 0.3, over: 1, streak: 2})
+	c.Check(data.rrdString(), Equals, "1010:1:2")
+
+	// The streak is over after a slice under the threshold, or a gap
+	s.overRate.rollupData(createSampleSet(1020, 1))
+	c.Check(s.overRate.rollupData(createSampleSet(1030, 1, 2, 3)).(*overRateItem).streak, Equals, 1)
+	c.Check(s.overRate.rollupData(createSampleSet(1050, 1, 2, 3)).(*overRateItem).streak, Equals, 1)
+
+	// Older sample sets do not change the streak
+	c.Check(s.overRate.rollupData(createSampleSet(1040, 1, 2, 3)).(*overRateItem).streak, Equals, 1)
+	c.Check(s.overRate.rollupData(createSampleSet(1060, 1, 2, 3)).(*overRateItem).streak, Equals, 2)
+}
+
+func (s *OverRateS) TestRollupDataWithWeightedSampleSet(c *C) {
+	set := createSampleSet(1000)
+	set.AddWeighted(7, 3)
+	data := s.overRate.rollupData(set)
+	c.Check(data, Equals, &overRateItem{time: 1000, rate: 0.3, over: 1, streak: 1})
+}
+
+func (s *OverRateS) TestSummarizeCarriedSampleSet(c *C) {
+	set := createSampleSet(1000, 1, 2, 3)
+	set.Carried = true
+	c.Check(summarize(s.overRate, set), Equals, &overRateItem{time: 1000})
+}
+
+func (s *OverRateS) TestExpireStreaks(c *C) {
+	s.overRate.rollupData(createSampleSet(1000, 1, 2, 3))
+	c.Check(expireStreaks(1000), Equals, 0)
+	c.Check(expireStreaks(1001), Equals, 1)
+}
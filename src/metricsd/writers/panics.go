@@ -0,0 +1,57 @@
+package writers
+
+import (
+	"sync"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+var (
+	// Number of panics of writers since the last reset, keyed by writer name
+	writerPanics = make(map[string]int64)
+	// Writers with panics reported already
+	panickedWriters = make(map[string]bool)
+	// Mutex protecting writerPanics and panickedWriters
+	writerPanicsMutex = &sync.Mutex{}
+)
+
+// recoverRollup stops a panic of the writer summarizing the sample set
+// (should be deferred by summarize), when config.RecoverPanics is enabled:
+// the panic is counted, nothing is reported for the sample set, and the
+// writer continues with the next one, so a faulty writer does not take the
+// daemon down. The first panic of every writer is logged as an error,
+// later ones are logged for debugging.
+func recoverRollup(writer Writer, set *types.SampleSet, data *dataItem) {
+	if !config.RecoverPanics {
+		return
+	}
+	r := recover()
+	if r == nil {
+		return
+	}
+	*data = nil
+
+	writerPanicsMutex.Lock()
+	defer writerPanicsMutex.Unlock()
+	writerPanics[writer.Name()]++
+	if !panickedWriters[writer.Name()] {
+		panickedWriters[writer.Name()] = true
+		config.Logger.Error("Writer %s panicked summarizing %s of %s: %v (later panics are logged for debugging)", writer.Name(), set.SeriesName(), set.Source, r)
+		return
+	}
+	config.Logger.Debug("Writer %s panicked summarizing %s of %s: %v", writer.Name(), set.SeriesName(), set.Source, r)
+}
+
+// ResetWriterPanics returns numbers of panics of writers since the
+// previous call, keyed by writer name, and starts over. Writers panicked
+// before are reported with zero, when they have not panicked since then.
+func ResetWriterPanics() map[string]int64 {
+	writerPanicsMutex.Lock()
+	defer writerPanicsMutex.Unlock()
+	panics := make(map[string]int64, len(writerPanics))
+	for name, count := range writerPanics {
+		panics[name] = count
+		writerPanics[name] = 0
+	}
+	return panics
+}
@@ -0,0 +1,73 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+	"metricsd/logger"
+	"metricsd/types"
+)
+
+// panickingWriter panics summarizing sample sets of "bad" metric, and
+// remembers other sample sets.
+type panickingWriter struct {
+	recordingWriter
+}
+
+func (*panickingWriter) Name() string {
+	return "panicking"
+}
+
+func (self *panickingWriter) rollupData(set *types.SampleSet) dataItem {
+	if set.Name == "bad" {
+		panic("bad metric")
+	}
+	return self.recordingWriter.rollupData(set)
+}
+
+type PanicsS struct{}
+
+var _ = Suite(&PanicsS{})
+
+func (s *PanicsS) SetUpTest(c *C) {
+	config.Logger = logger.NewConsoleLogger(logger.UNKNOWN)
+	writerPanics = make(map[string]int64)
+	panickedWriters = make(map[string]bool)
+}
+
+func (s *PanicsS) TearDownTest(c *C) {
+	config.RecoverPanics = config.DEFAULT_RECOVER_PANICS
+}
+
+func (s *PanicsS) TestBatchRollupContinuesAfterPanic(c *C) {
+	writer := &panickingWriter{}
+	sets := []*types.SampleSet{
+		types.NewSampleSet(1000, "all", "a"),
+		types.NewSampleSet(1000, "all", "bad"),
+		types.NewSampleSet(1010, "all", "bad"),
+		types.NewSampleSet(1000, "all", "c"),
+	}
+	c.Check(BatchRollup(writer, sets, nil), IsNil)
+	c.Assert(len(writer.sets), Equals, 2)
+	c.Check(writer.sets[0].Name, Equals, "a")
+	c.Check(writer.sets[1].Name, Equals, "c")
+
+	c.Check(ResetWriterPanics(), Equals, map[string]int64{"panicking": 2})
+	c.Check(ResetWriterPanics(), Equals, map[string]int64{"panicking": 0})
+}
+
+func (s *PanicsS) TestRollupContinuesAfterPanic(c *C) {
+	writer := &panickingWriter{}
+	c.Check(Rollup(writer, types.NewSampleSet(1000, "all", "bad"), nil), IsNil)
+	c.Check(Rollup(writer, types.NewSampleSet(1000, "all", "c"), nil), IsNil)
+	c.Check(len(writer.sets), Equals, 1)
+	c.Check(writerPanics["panicking"], Equals, int64(1))
+}
+
+func (s *PanicsS) TestPanicsAreNotRecoveredWhenDisabled(c *C) {
+	config.RecoverPanics = false
+	defer func() {
+		c.Check(recover(), Equals, "bad metric")
+	}()
+	summarize(&panickingWriter{}, types.NewSampleSet(1000, "all", "bad"))
+	c.Error("panic is expected")
+}
@@ -4,16 +4,27 @@ import (
 	"fmt"
 	"math"
 	"sort"
+	"metricsd/config"
 	"metricsd/types"
 )
 
 // Percentiles writer is used to calculate 90th and 95th percentiles, mean
 // values, and standard deviations under percentiles.
 //
-// NIST recommended method is used to calculate percentiles:
+// NIST recommended method is used to calculate percentiles by default:
 // http://www.itl.nist.gov/div898/handbook/prc/section2/prc252.htm
+// Other methods are nearest rank, linear interpolation between ranks, and
+// the lower or higher of surrounding values (see config.PercentileMethod).
 type Percentiles struct {
 	*BaseWriter
+	// Method of percentile calculation (NIST when empty).
+	Method string
+}
+
+// NewPercentiles returns a new Percentiles writer with the percentile
+// method defined in configuration.
+func NewPercentiles() *Percentiles {
+	return &Percentiles{Method: config.PercentileMethod}
 }
 
 // percentilesItem stores statistics information calculated by Percentiles
@@ -41,35 +52,28 @@ func (self *Percentiles) Name() string {
 }
 
 // rollupData performs summarization on the given sample set and returns
-// percentilesItem with statistics.
+// percentilesItem with statistics. Weighted values are counted as many
+// times as their weight (see types.SampleSet.AddWeighted).
 func (self *Percentiles) rollupData(set *types.SampleSet) (data dataItem) {
 	if len(set.Values) == 0 {
 		return
 	}
-	sort.Ints(set.Values)
+	set.Sort()
+	ranked := newRankedValues(set)
 
-	pct90index, pct90 := pecentile(0.90, set)
-	pct95index, pct95 := pecentile(0.95, set)
+	pct90index, pct90 := ranked.percentile(0.90, self.Method)
+	pct95index, pct95 := ranked.percentile(0.95, self.Method)
 
-	var pct90sum float64 = 0
-	var pct95sum float64 = 0
-	for idx, elem := range set.Values[0:pct95index] {
-		if int64(idx) < pct90index {
-			pct90sum += float64(elem)
-		}
-		pct95sum += float64(elem)
-	}
-	var pct90mean float64 = pct90sum / float64(pct90index)
-	var pct95mean float64 = pct95sum / float64(pct95index)
-
-	var pct90sqdiff float64 = 0
-	var pct95sqdiff float64 = 0
-	for idx, elem := range set.Values[0:pct95index] {
-		if int64(idx) <= pct90index {
-			pct90sqdiff += math.Pow(float64(pct90mean)-float64(elem), 2)
-		}
-		pct95sqdiff += math.Pow(float64(pct95mean)-float64(elem), 2)
+	var pct90mean float64 = ranked.sum(pct90index) / float64(pct90index)
+	var pct95mean float64 = ranked.sum(pct95index) / float64(pct95index)
+
+	// Deviation under 90th percentile includes the percentile value itself
+	pct90devnumber := pct90index + 1
+	if pct90devnumber > pct95index {
+		pct90devnumber = pct95index
 	}
+	var pct90sqdiff float64 = ranked.sqdiff(pct90devnumber, pct90mean)
+	var pct95sqdiff float64 = ranked.sqdiff(pct95index, pct95mean)
 
 	data = &percentilesItem{
 		time:      set.Time,
@@ -83,6 +87,17 @@ func (self *Percentiles) rollupData(set *types.SampleSet) (data dataItem) {
 	return
 }
 
+// prototype returns an empty data item used to report unknown values.
+func (*Percentiles) prototype() dataItem {
+	return &percentilesItem{}
+}
+
+// quantiles returns a value indicating whether the writer calculates
+// quantiles.
+func (*Percentiles) quantiles() bool {
+	return true
+}
+
 // String returns string representation of the given percentilesItem.
 func (self *percentilesItem) String() string {
 	return fmt.Sprintf(
@@ -135,16 +150,108 @@ func (self *percentilesItem) rrdString() string {
 	)
 }
 
-// percentile calculates pth percentile for the given sample set.
-func pecentile(p float64, set *types.SampleSet) (index int64, pct float64) {
-	number := int64(len(set.Values))
+// rankedValues is a sorted list of values with weights, which allows to
+// find values by rank as if every value was repeated weight times.
+type rankedValues struct {
+	values []int
+	// Total weight of values up to (and including) the value with the index.
+	cumulative []int64
+}
+
+// newRankedValues returns rankedValues for the sorted sample set.
+func newRankedValues(set *types.SampleSet) *rankedValues {
+	ranked := &rankedValues{values: set.Values, cumulative: make([]int64, len(set.Values))}
+	var total int64
+	for idx := range set.Values {
+		total += int64(set.Weight(idx))
+		ranked.cumulative[idx] = total
+	}
+	return ranked
+}
+
+// number returns the total weight of values.
+func (self *rankedValues) number() int64 {
+	return self.cumulative[len(self.cumulative)-1]
+}
+
+// at returns the value with the given rank (starting from 0): the first
+// value with cumulative weight greater than the rank.
+func (self *rankedValues) at(rank int64) int {
+	idx := sort.Search(len(self.values), func(i int) bool {
+		return self.cumulative[i] > rank
+	})
+	return self.values[idx]
+}
+
+// sum returns the sum of count values with the lowest ranks.
+func (self *rankedValues) sum(count int64) (sum float64) {
+	self.each(count, func(value int, weight int64) {
+		sum += float64(value) * float64(weight)
+	})
+	return
+}
+
+// sqdiff returns the sum of squared differences between the mean and count
+// values with the lowest ranks.
+func (self *rankedValues) sqdiff(count int64, mean float64) (sqdiff float64) {
+	self.each(count, func(value int, weight int64) {
+		sqdiff += math.Pow(mean-float64(value), 2) * float64(weight)
+	})
+	return
+}
+
+// each calls function f for values with the lowest ranks, until their total
+// weight reaches count (weight of the last value is truncated if needed).
+func (self *rankedValues) each(count int64, f func(value int, weight int64)) {
+	var prev int64
+	for idx, value := range self.values {
+		if prev >= count {
+			break
+		}
+		weight := self.cumulative[idx] - prev
+		if prev+weight > count {
+			weight = count - prev
+		}
+		f(value, weight)
+		prev = self.cumulative[idx]
+	}
+}
+
+// percentile calculates pth percentile using the given method (see
+// config.PercentileMethod), and returns it along with its rank (starting
+// from 1). With interpolation, the rank of the lower value is returned.
+func (self *rankedValues) percentile(p float64, method string) (index int64, pct float64) {
+	number := self.number()
+
+	switch method {
+	case config.PERCENTILE_NEAREST:
+		index = int64(math.Ceil(p * float64(number)))
+		if index < 1 {
+			index = 1
+		}
+		return index, float64(self.at(index - 1))
+	case config.PERCENTILE_LINEAR:
+		k, d := math.Modf(p * float64(number-1))
+		index = int64(k) + 1
+		pct = float64(self.at(index - 1))
+		if index < number {
+			pct += d * float64(self.at(index)-self.at(index-1))
+		}
+		return
+	case config.PERCENTILE_LOWER:
+		index = int64(math.Floor(p*float64(number-1))) + 1
+		return index, float64(self.at(index - 1))
+	case config.PERCENTILE_HIGHER:
+		index = int64(math.Ceil(p*float64(number-1))) + 1
+		return index, float64(self.at(index - 1))
+	}
 
 	var n float64 = p * (float64(number) + 1)
 	k, d := math.Modf(n)
 	index = int64(k)
-	pct = float64(set.Values[index-1])
+	pct = float64(self.at(index - 1))
 	if index > 1 && index < number {
-		pct += d * float64(set.Values[index]-set.Values[index-1])
+		pct += d * float64(self.at(index)-self.at(index-1))
 	}
 
 	return
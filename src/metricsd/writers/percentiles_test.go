@@ -2,6 +2,8 @@ package writers
 
 import (
 	. "launchpad.net/gocheck"
+	"metricsd/config"
+	"metricsd/types"
 )
 
 type PercentilesS struct {
@@ -52,3 +54,64 @@ func (s *PercentilesS) TestRollupDataWithComplexSampleSet(c *C) {
 	data := s.percentiles.rollupData(ss)
 	c.Check(data, Equals, &percentilesItem{time: 6000, pct90: 900, pct90mean: 455, pct90dev: 264, pct95: 950, pct95mean: 480, pct95dev: 274})
 }
+
+func (s *PercentilesS) TestRollupDataWithWeightedSampleSet(c *C) {
+	weighted := createSampleSet(4000)
+	expanded := createSampleSet(4000)
+	for value := 1; value <= 20; value++ {
+		weighted.AddWeighted(value*10, value)
+		for i := 0; i < value; i++ {
+			expanded.Add(value * 10)
+		}
+	}
+	c.Check(s.percentiles.rollupData(weighted), Equals, s.percentiles.rollupData(expanded))
+}
+
+func (s *PercentilesS) TestRollupDataWithUnsortedWeightedSampleSet(c *C) {
+	ss := createSampleSet(5000, 50, 10)
+	ss.AddWeighted(20, 18)
+	data := s.percentiles.rollupData(ss)
+	c.Check(data, Equals, &percentilesItem{time: 5000, pct90: 20, pct90mean: 19, pct90dev: 2, pct95: 48, pct95mean: 19, pct95dev: 2})
+}
+
+func (s *PercentilesS) TestPercentileMethods(c *C) {
+	ranked := newRankedValues(createSampleSet(1000, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100))
+	check := func(method string, expectedIndex int64, expected float64) {
+		index, pct := ranked.percentile(0.9, method)
+		c.Check(index, Equals, expectedIndex)
+		c.Check(pct, Equals, expected)
+	}
+	check(config.PERCENTILE_NIST, 9, 99)
+	check(config.PERCENTILE_NEAREST, 9, 90)
+	check(config.PERCENTILE_LINEAR, 9, 91)
+	check(config.PERCENTILE_LOWER, 9, 90)
+	check(config.PERCENTILE_HIGHER, 10, 100)
+}
+
+func (s *PercentilesS) TestRollupDataWithMethod(c *C) {
+	s.percentiles.Method = config.PERCENTILE_LOWER
+	data := s.percentiles.rollupData(createSampleSet(3000, 10, 20))
+	c.Check(data, Equals, &percentilesItem{time: 3000, pct90: 10, pct90mean: 10, pct90dev: 0, pct95: 10, pct95mean: 10, pct95dev: 0})
+}
+
+func (s *PercentilesS) TestRollupDataWithShardedTimeline(c *C) {
+	defer func(clock func() int64) { types.Clock = clock }(types.Clock)
+	now := int64(1005)
+	types.Clock = func() int64 { return now }
+	timeline := types.NewShardedTimeline(10, 4)
+	values := make([]int, 0, 100)
+	for value := 100; value > 0; value-- {
+		timeline.Add(types.NewEvent("src", "metric", value))
+		values = append(values, value)
+	}
+
+	now = 1010
+	checked := 0
+	for _, set := range timeline.ExtractClosedSampleSets(false) {
+		if set.Source == "src" {
+			c.Check(s.percentiles.rollupData(set), Equals, s.percentiles.rollupData(createSampleSet(1000, values...)))
+			checked++
+		}
+	}
+	c.Check(checked, Equals, 1)
+}
@@ -3,7 +3,6 @@ package writers
 import (
 	"fmt"
 	"math"
-	"sort"
 	"metricsd/types"
 )
 
@@ -36,17 +35,22 @@ func (self *Quartiles) Name() string {
 }
 
 // rollupData performs summarization on the given sample set and returns
-// quartilesItem with statistics.
+// quartilesItem with statistics. Pre-aggregated or coalesced values are
+// counted as many times as their weight, both in quartiles and in the total.
+// Quartiles of summarized sample sets are approximate (see types.Summary).
 func (self *Quartiles) rollupData(set *types.SampleSet) (data dataItem) {
+	if set.Summary != nil {
+		return summaryQuartiles(set)
+	}
 	if len(set.Values) == 0 {
 		return
 	}
-	sort.Ints(set.Values)
-	number := int64(len(set.Values))
+	set.Sort()
+	ranked := newRankedValues(set)
 	lo := int64(set.Values[0])
-	hi := int64(set.Values[number-1])
+	hi := int64(set.Values[len(set.Values)-1])
 
-	q1, q2, q3 := quartiles(set)
+	q1, q2, q3 := quartiles(ranked)
 
 	data = &quartilesItem{
 		time:  set.Time,
@@ -55,12 +59,38 @@ func (self *Quartiles) rollupData(set *types.SampleSet) (data dataItem) {
 		q2:    int64(q2 + 0.5),
 		q3:    int64(q3 + 0.5),
 		hi:    hi,
-		total: number,
+		total: ranked.number(),
 	}
 
 	return
 }
 
+// summaryQuartiles returns quartilesItem with statistics of the summary of
+// the sample set.
+func summaryQuartiles(set *types.SampleSet) dataItem {
+	summary := set.Summary
+	return &quartilesItem{
+		time:  set.Time,
+		lo:    int64(summary.Min),
+		q1:    int64(summary.Quantile(0.25) + 0.5),
+		q2:    int64(summary.Quantile(0.5) + 0.5),
+		q3:    int64(summary.Quantile(0.75) + 0.5),
+		hi:    int64(summary.Max),
+		total: summary.Count,
+	}
+}
+
+// prototype returns an empty data item used to report unknown values.
+func (*Quartiles) prototype() dataItem {
+	return &quartilesItem{}
+}
+
+// quantiles returns a value indicating whether the writer calculates
+// quantiles.
+func (*Quartiles) quantiles() bool {
+	return true
+}
+
 // String returns string representation of the given quartilesItem.
 func (self *quartilesItem) String() string {
 	return fmt.Sprintf(
@@ -113,24 +143,24 @@ func (self *quartilesItem) rrdString() string {
 	)
 }
 
-// quartiles calculates quartiles for the given sample set.
-func quartiles(set *types.SampleSet) (q1, q2, q3 float64) {
-	number := int64(len(set.Values))
-	q2index, q2 := median(set.Values)
-	_, q1 = median(set.Values[:q2index+1])
-	_, q3 = median(set.Values[number-q2index-1:])
+// quartiles calculates quartiles for the given ranked values.
+func quartiles(ranked *rankedValues) (q1, q2, q3 float64) {
+	number := ranked.number()
+	q2index, q2 := median(ranked, 0, number)
+	_, q1 = median(ranked, 0, q2index+1)
+	_, q3 = median(ranked, number-q2index-1, q2index+1)
 	return
 }
 
-// median calculates value and index of the median for the given sample set.
-func median(set []int) (index int64, median float64) {
-	number := int64(len(set))
+// median calculates value and index (relative to from) of the median of
+// number ranked values starting from the rank from.
+func median(ranked *rankedValues, from, number int64) (index int64, median float64) {
 	var n float64 = float64(number-1) / 2.0
 	k, d := math.Modf(n)
 	index = int64(k)
-	median = float64(set[index])
+	median = float64(ranked.at(from + index))
 	if index+1 < number {
-		median += d * float64(set[index+1]-set[index])
+		median += d * float64(ranked.at(from+index+1)-ranked.at(from+index))
 	}
 	return
 }
@@ -49,3 +49,21 @@ func (s *QuartilesS) TestRollupDataWithLargeSampleSet(c *C) {
 	data := s.quartiles.rollupData(ss)
 	c.Check(data, Equals, &quartilesItem{time: 6000, lo: 6, q1: 26, q2: 40, q3: 43, hi: 49, total: 11})
 }
+
+func (s *QuartilesS) TestRollupDataWithCoalescedValues(c *C) {
+	values := []int{10, 10, 10, 10, 20, 30, 30, 40, 40, 40, 50}
+	coalesced := createCoalescedSampleSet(8000, values...)
+	c.Check(coalesced.Values, Equals, []int{10, 20, 30, 40, 50})
+	data := s.quartiles.rollupData(coalesced)
+	c.Check(data, Equals, s.quartiles.rollupData(createSampleSet(8000, values...)))
+	c.Check(data, Equals, &quartilesItem{time: 8000, lo: 10, q1: 10, q2: 30, q3: 40, hi: 50, total: 11})
+}
+
+func (s *QuartilesS) TestRollupDataWithSummarizedSampleSet(c *C) {
+	ss := createSampleSet(7000)
+	for value := 100; value > 0; value-- {
+		ss.Summarize(value, 1, 0.01)
+	}
+	data := s.quartiles.rollupData(ss)
+	c.Check(data, Equals, &quartilesItem{time: 7000, lo: 1, q1: 25, q2: 50, q3: 74, hi: 100, total: 100})
+}
@@ -0,0 +1,47 @@
+package writers
+
+// recentRollups is a ring buffer of the most recent rollups of a series
+// (see config.RecentRollups), giving exports lightweight recent history
+// without reading RRD files.
+type recentRollups struct {
+	items []*recentRollup
+	next  int // index of the oldest rollup, the next one to be replaced
+	count int // number of stored rollups
+}
+
+// recentRollup is a rollup stored in recentRollups.
+type recentRollup struct {
+	time int64 // timestamp of the sample set
+	data dataItem
+}
+
+// newRecentRollups returns a ring buffer keeping size rollups.
+func newRecentRollups(size int) *recentRollups {
+	return &recentRollups{items: make([]*recentRollup, size)}
+}
+
+// add stores the rollup, replacing the oldest one when the buffer is full.
+// Rollups of the same sample set (e.g. recalculated by writes of imported
+// history) replace the previous one.
+func (self *recentRollups) add(time int64, data dataItem) {
+	if self.count > 0 {
+		if last := self.items[(self.next+len(self.items)-1)%len(self.items)]; last.time == time {
+			last.data = data
+			return
+		}
+	}
+	self.items[self.next] = &recentRollup{time, data}
+	self.next = (self.next + 1) % len(self.items)
+	if self.count < len(self.items) {
+		self.count++
+	}
+}
+
+// list returns stored rollups, the oldest first.
+func (self *recentRollups) list() []*recentRollup {
+	result := make([]*recentRollup, 0, self.count)
+	for idx := 0; idx < self.count; idx++ {
+		result = append(result, self.items[(self.next+len(self.items)-self.count+idx)%len(self.items)])
+	}
+	return result
+}
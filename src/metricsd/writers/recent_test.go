@@ -0,0 +1,78 @@
+package writers
+
+import (
+	"bytes"
+	"json"
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+)
+
+type RecentS struct{}
+
+var _ = Suite(&RecentS{})
+
+func (s *RecentS) SetUpTest(c *C) {
+	latestRollups = make(map[string]*latestRollup)
+}
+
+func (s *RecentS) TearDownTest(c *C) {
+	latestRollups = make(map[string]*latestRollup)
+	config.RecentRollups = config.DEFAULT_RECENT_ROLLUPS
+}
+
+// times returns timestamps of the rollups.
+func times(rollups []*recentRollup) (result []int64) {
+	result = make([]int64, len(rollups))
+	for idx, rollup := range rollups {
+		result[idx] = rollup.time
+	}
+	return
+}
+
+func (s *RecentS) TestRecentRollups(c *C) {
+	recent := newRecentRollups(3)
+	c.Check(times(recent.list()), Equals, []int64{})
+	recent.add(10, &countItem{time: 10})
+	recent.add(20, &countItem{time: 20})
+	c.Check(times(recent.list()), Equals, []int64{10, 20})
+	recent.add(30, &countItem{time: 30})
+	recent.add(40, &countItem{time: 40})
+	c.Check(times(recent.list()), Equals, []int64{20, 30, 40})
+
+	// The rollup of the same sample set is replaced
+	recent.add(40, &countItem{time: 40, ok: 1})
+	c.Check(times(recent.list()), Equals, []int64{20, 30, 40})
+	c.Check(recent.list()[2].data, Equals, &countItem{time: 40, ok: 1})
+}
+
+func (s *RecentS) TestWriteRecentRollups(c *C) {
+	config.RecentRollups = 2
+	writer := &Count{}
+	for _, time := range []int64{1000, 1010, 1020} {
+		set := createSampleSet(time, 1)
+		remember(writer, set, writer.rollupData(set))
+	}
+
+	buffer := &bytes.Buffer{}
+	count, err := WriteRecentRollups(buffer, "metric")
+	c.Assert(err, IsNil)
+	c.Check(count, Equals, 2)
+
+	var records []map[string]interface{}
+	c.Assert(json.Unmarshal(buffer.Bytes(), &records), IsNil)
+	c.Assert(len(records), Equals, 2)
+	c.Check(records[0]["time"], Equals, float64(1010))
+	c.Check(records[1]["time"], Equals, float64(1020))
+	c.Check(records[1]["writer"], Equals, "count")
+}
+
+func (s *RecentS) TestWriteRecentRollupsDisabled(c *C) {
+	writer := &Count{}
+	set := createSampleSet(1000, 1)
+	remember(writer, set, writer.rollupData(set))
+
+	buffer := &bytes.Buffer{}
+	count, err := WriteRecentRollups(buffer, "metric")
+	c.Assert(err, IsNil)
+	c.Check(count, Equals, 1)
+}
@@ -0,0 +1,56 @@
+package writers
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// registry holds constructors of all known writers, keyed by writer name.
+var registry = map[string]func() Writer{
+	"change":        func() Writer { return &Change{} },
+	"classes":       func() Writer { return NewClasses() },
+	"count":         func() Writer { return &Count{} },
+	"quartiles":     func() Writer { return &Quartiles{} },
+	"percentiles":   func() Writer { return NewPercentiles() },
+	"cov":           func() Writer { return &Cov{} },
+	"histogram":     func() Writer { return NewHistogram() },
+	"last":          func() Writer { return &Last{} },
+	"last_seen":     func() Writer { return &LastSeen{} },
+	"over_rate":     func() Writer { return NewOverRate() },
+	"reservoir":     func() Writer { return NewReservoir() },
+	"set":           func() Writer { return NewSet() },
+	"sketch":        func() Writer { return NewSketch() },
+	"sum":           func() Writer { return NewSum() },
+	"summary":       func() Writer { return NewSummary() },
+	"tail_latency":  func() Writer { return &TailLatency{} },
+	"time_weighted": func() Writer { return &TimeWeighted{} },
+	"trimmed_mean":  func() Writer { return NewTrimmedMean() },
+}
+
+// Register makes a writer available by the given name. If Register is called
+// twice with the same name, the latter constructor wins.
+func Register(name string, constructor func() Writer) {
+	registry[name] = constructor
+}
+
+// New returns a new instance of the writer with the given name.
+func New(name string) (writer Writer, err os.Error) {
+	constructor, found := registry[name]
+	if !found {
+		err = os.NewError(fmt.Sprintf("Unknown writer %q, available writers: %v", name, Names()))
+		return
+	}
+	writer = constructor()
+	return
+}
+
+// Names returns sorted list of names of all registered writers.
+func Names() (names []string) {
+	names = make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
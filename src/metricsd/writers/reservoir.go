@@ -0,0 +1,69 @@
+package writers
+
+import (
+	"rand"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+// Reservoir writer is used to calculate the same statistics as Percentiles
+// writer, but using a fixed-size random sample of values (see Algorithm R:
+// http://en.wikipedia.org/wiki/Reservoir_sampling), so CPU usage does not
+// depend on number of values in the sample set. Results are approximate
+// when there are more values than the reservoir size.
+type Reservoir struct {
+	*BaseWriter
+	// Maximum number of values used to calculate percentiles.
+	Size int
+}
+
+// NewReservoir returns a new Reservoir writer with reservoir size defined
+// in configuration.
+func NewReservoir() *Reservoir {
+	return &Reservoir{Size: config.ReservoirSize}
+}
+
+// Name returns the name of the writer.
+func (self *Reservoir) Name() string {
+	return "reservoir"
+}
+
+// rollupData performs summarization on the random sample of values from
+// the given sample set and returns percentilesItem with statistics. Sampled
+// values keep their weights.
+func (self *Reservoir) rollupData(set *types.SampleSet) (data dataItem) {
+	if len(set.Values) <= self.Size {
+		return NewPercentiles().rollupData(set)
+	}
+	sampled := types.NewSampleSet(set.Time, set.Source, set.Name)
+	for _, idx := range sample(len(set.Values), self.Size) {
+		sampled.AddWeighted(set.Values[idx], set.Weight(idx))
+	}
+	return NewPercentiles().rollupData(sampled)
+}
+
+// prototype returns an empty data item used to report unknown values.
+func (*Reservoir) prototype() dataItem {
+	return &percentilesItem{}
+}
+
+// quantiles returns a value indicating whether the writer calculates
+// quantiles.
+func (*Reservoir) quantiles() bool {
+	return true
+}
+
+// sample returns indexes of size values randomly chosen from the list of
+// the given length, every value has the same probability to be chosen.
+func sample(length, size int) []int {
+	reservoir := make([]int, size)
+	for idx := range reservoir {
+		reservoir[idx] = idx
+	}
+	for idx := size; idx < length; idx++ {
+		if replace := rand.Intn(idx + 1); replace < size {
+			reservoir[replace] = idx
+		}
+	}
+	return reservoir
+}
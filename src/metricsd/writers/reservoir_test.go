@@ -0,0 +1,48 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+type ReservoirS struct {
+	reservoir *Reservoir
+}
+
+var _ = Suite(&ReservoirS{})
+
+func (s *ReservoirS) SetUpTest(c *C) {
+	s.reservoir = &Reservoir{Size: 10}
+}
+
+func (s *ReservoirS) TestRollupDataWithEmptySampleSet(c *C) {
+	ss := createSampleSet(1000)
+	data := s.reservoir.rollupData(ss)
+	c.Check(data, IsNil)
+}
+
+func (s *ReservoirS) TestRollupDataWithSampleSetSmallerThanReservoir(c *C) {
+	ss := createSampleSet(2000, 10, 20, 30)
+	data := s.reservoir.rollupData(ss)
+	c.Check(data, Equals, (&Percentiles{}).rollupData(createSampleSet(2000, 10, 20, 30)))
+}
+
+func (s *ReservoirS) TestRollupDataWithSampleSetLargerThanReservoir(c *C) {
+	ss := createSampleSet(3000)
+	for i := 0; i < 1000; i++ {
+		ss.Add(100)
+	}
+	data := s.reservoir.rollupData(ss)
+	c.Check(data, Equals, &percentilesItem{time: 3000, pct90: 100, pct90mean: 100, pct90dev: 0, pct95: 100, pct95mean: 100, pct95dev: 0})
+	c.Check(len(ss.Values), Equals, 1000)
+}
+
+func (s *ReservoirS) TestSample(c *C) {
+	sampled := sample(100, 10)
+	c.Check(len(sampled), Equals, 10)
+	seen := make(map[int]bool)
+	for _, value := range sampled {
+		c.Check(value >= 0 && value < 100, Equals, true)
+		c.Check(seen[value], Equals, false)
+		seen[value] = true
+	}
+}
@@ -0,0 +1,134 @@
+package writers
+
+import (
+	"exec"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"metricsd/config"
+)
+
+var (
+	// RRD files with retention checked against per-metric override
+	retentionChecked = make(map[string]bool)
+	// Mutex protecting retentionChecked
+	retentionCheckedMutex = &sync.Mutex{}
+)
+
+// rrdCreateInfo returns the list of parameters used to create RRD file of
+// the metric. When per-metric Retention is configured, archives of the
+// data item are replaced with the configured ones, keeping consolidation
+// functions of the writer.
+func rrdCreateInfo(name string, data dataItem) []string {
+	info := data.rrdInfo()
+	archives := config.MetricOptions(name).Retention
+	if archives == nil {
+		return info
+	}
+	interval := config.MetricInterval(name)
+
+	result := make([]string, 0, len(info))
+	functions := make([]string, 0, 2)
+	seen := make(map[string]bool)
+	for _, item := range info {
+		if !strings.HasPrefix(item, "RRA:") {
+			result = append(result, item)
+			continue
+		}
+		// RRA:<consolidation function>:<xff>:<steps>:<rows>
+		function := strings.Join(strings.Split(item, ":")[1:3], ":")
+		if !seen[function] {
+			seen[function] = true
+			functions = append(functions, function)
+		}
+	}
+	for _, function := range functions {
+		for _, archive := range archives {
+			result = append(result, fmt.Sprintf("RRA:%s:%d:%d", function, archive.Steps(interval), archive.Rows(interval)))
+		}
+	}
+	return result
+}
+
+// checkRetention logs a warning when archives of the existing RRD file do
+// not match per-metric Retention (archives of existing files are never
+// changed). Every file is checked once, using "rrdtool info".
+func checkRetention(file, name string) {
+	archives := config.MetricOptions(name).Retention
+	if archives == nil {
+		return
+	}
+	retentionCheckedMutex.Lock()
+	checked := retentionChecked[file]
+	retentionChecked[file] = true
+	retentionCheckedMutex.Unlock()
+	if checked {
+		return
+	}
+
+	output, err := exec.Command(config.RrdtoolPath, "info", file).Output()
+	if err != nil {
+		config.Logger.Debug("Cannot check retention of %s: %s", file, err)
+		return
+	}
+	interval := config.MetricInterval(name)
+	expected := make([]string, 0, len(archives))
+	for _, archive := range archives {
+		expected = append(expected, fmt.Sprintf("%d:%d", archive.Steps(interval), archive.Rows(interval)))
+	}
+	actual := rrdArchives(string(output))
+	if !sameArchives(expected, actual) {
+		config.Logger.Warn("RRD file %s has archives %v (steps:rows), which differ from configured retention %v (%v); existing archives are not changed", file, actual, archives, expected)
+	}
+}
+
+// rrdArchives returns unique archives of RRD file in "<steps>:<rows>"
+// format, parsed from "rrdtool info" output.
+func rrdArchives(info string) []string {
+	steps := make(map[string]string)
+	rows := make(map[string]string)
+	for _, line := range strings.Split(info, "\n") {
+		parts := strings.Split(line, " = ")
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], "rra[") {
+			continue
+		}
+		idx := parts[0][:strings.Index(parts[0], "]")+1]
+		switch parts[0][len(idx):] {
+		case ".pdp_per_row":
+			steps[idx] = parts[1]
+		case ".rows":
+			rows[idx] = parts[1]
+		}
+	}
+
+	seen := make(map[string]bool)
+	archives := make([]string, 0, len(steps))
+	for idx, step := range steps {
+		archive := step + ":" + rows[idx]
+		if !seen[archive] {
+			seen[archive] = true
+			archives = append(archives, archive)
+		}
+	}
+	sort.Strings(archives)
+	return archives
+}
+
+// sameArchives returns a value indicating whether both lists contain the
+// same unique archives.
+func sameArchives(expected, actual []string) bool {
+	unique := make(map[string]bool)
+	for _, archive := range expected {
+		unique[archive] = true
+	}
+	if len(unique) != len(actual) {
+		return false
+	}
+	for _, archive := range actual {
+		if !unique[archive] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+)
+
+type RetentionS struct{}
+
+var _ = Suite(&RetentionS{})
+
+func (s *RetentionS) TearDownTest(c *C) {
+	config.SetMetrics(nil)
+}
+
+func (s *RetentionS) TestParseRetentionArchive(c *C) {
+	archive, err := config.ParseRetentionArchive("10m:30d")
+	c.Assert(err, IsNil)
+	c.Check(archive, Equals, &config.RetentionArchive{Resolution: 600, Period: 2592000})
+	c.Check(archive.Steps(10), Equals, 60)
+	c.Check(archive.Rows(10), Equals, 4320)
+
+	for _, spec := range []string{"10m", "10x:1d", "0s:1d", "1d:1h", "m:1d"} {
+		if _, err := config.ParseRetentionArchive(spec); err == nil {
+			c.Errorf("Retention archive %q should be invalid", spec)
+		}
+	}
+}
+
+func (s *RetentionS) TestRrdCreateInfoWithoutRetention(c *C) {
+	data := (&Cov{}).prototype()
+	c.Check(rrdCreateInfo("metric", data), Equals, data.rrdInfo())
+}
+
+func (s *RetentionS) TestRrdCreateInfoWithRetention(c *C) {
+	archive, _ := config.ParseRetentionArchive("10s:7d")
+	config.SetMetrics([]*config.MetricConfig{&config.MetricConfig{Pattern: "short.*", Retention: []*config.RetentionArchive{archive}}})
+	info := rrdCreateInfo("short.metric", (&Cov{}).prototype())
+	c.Check(info, Equals, []string{
+		"DS:cov:GAUGE:600:U:U",
+		"RRA:AVERAGE:0.5:1:60480",
+		"RRA:MAX:0.5:1:60480",
+	})
+}
+
+func (s *RetentionS) TestRrdArchives(c *C) {
+	info := "filename = \"metric.rrd\"\n" +
+		"rra[0].cf = \"AVERAGE\"\n" +
+		"rra[0].rows = 25920\n" +
+		"rra[0].pdp_per_row = 1\n" +
+		"rra[0].cdp_prep[0].value = NaN\n" +
+		"rra[1].cf = \"MAX\"\n" +
+		"rra[1].rows = 25920\n" +
+		"rra[1].pdp_per_row = 1\n" +
+		"rra[2].cf = \"AVERAGE\"\n" +
+		"rra[2].rows = 4320\n" +
+		"rra[2].pdp_per_row = 60\n"
+	archives := rrdArchives(info)
+	c.Check(archives, Equals, []string{"1:25920", "60:4320"})
+	c.Check(sameArchives([]string{"60:4320", "1:25920"}, archives), Equals, true)
+	c.Check(sameArchives([]string{"1:25920"}, archives), Equals, false)
+}
@@ -0,0 +1,98 @@
+package writers
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// hashRing maps keys to nodes using consistent hashing: every node is
+// placed on the ring at several points derived from its name, and a key
+// belongs to the node of the first point following the hash of the key.
+// Adding or removing a node moves only keys of its points.
+type hashRing struct {
+	points []uint32 // hashes of points, sorted
+	nodes  []int    // indexes of nodes of points
+}
+
+// newHashRing returns a new hashRing with the given number of points
+// (replicas) per node.
+func newHashRing(names []string, replicas int) *hashRing {
+	ring := &hashRing{}
+	points := make(map[uint32]int)
+	for idx, name := range names {
+		for replica := 0; replica < replicas; replica++ {
+			point := crc32.ChecksumIEEE([]byte(name + "#" + strconv.Itoa(replica)))
+			if _, found := points[point]; !found {
+				points[point] = idx
+				ring.points = append(ring.points, point)
+			}
+		}
+	}
+	sort.Sort(uint32Slice(ring.points))
+	ring.nodes = make([]int, len(ring.points))
+	for idx, point := range ring.points {
+		ring.nodes[idx] = points[point]
+	}
+	return ring
+}
+
+// node returns the index of the node owning the key, -1 when the ring is
+// empty.
+func (ring *hashRing) node(key string) int {
+	if len(ring.points) == 0 {
+		return -1
+	}
+	hash := crc32.ChecksumIEEE([]byte(key))
+	idx := sort.Search(len(ring.points), func(i int) bool {
+		return ring.points[i] >= hash
+	})
+	if idx == len(ring.points) {
+		idx = 0
+	}
+	return ring.nodes[idx]
+}
+
+// uint32Slice sorts points of the ring in increasing order.
+type uint32Slice []uint32
+
+func (p uint32Slice) Len() int           { return len(p) }
+func (p uint32Slice) Less(i, j int) bool { return p[i] < p[j] }
+func (p uint32Slice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// A shardedSender sends lines to a cluster of receivers, so rollups of
+// every metric always land on the same receiver (see hashRing).
+type shardedSender struct {
+	ring    *hashRing
+	senders []*lineSender
+}
+
+// newShardedSender returns a new shardedSender for the comma-separated
+// list of addresses, with the given number of points per address on the
+// hash ring, and starts sending lines.
+func newShardedSender(name, network, addresses string, replicas int) *shardedSender {
+	list := splitAddresses(addresses)
+	sender := &shardedSender{ring: newHashRing(list, replicas), senders: make([]*lineSender, len(list))}
+	for idx, address := range list {
+		sender.senders[idx] = newLineSender(name, network, address)
+	}
+	return sender
+}
+
+// send puts the line into the queue of the receiver owning the key.
+func (sender *shardedSender) send(key, line string) {
+	if idx := sender.ring.node(key); idx >= 0 {
+		sender.senders[idx].send(line)
+	}
+}
+
+// splitAddresses returns non-empty addresses of the comma-separated list.
+func splitAddresses(addresses string) (list []string) {
+	for _, address := range strings.Split(addresses, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			list = append(list, address)
+		}
+	}
+	return
+}
@@ -0,0 +1,48 @@
+package writers
+
+import (
+	"fmt"
+	. "launchpad.net/gocheck"
+)
+
+type RingS struct{}
+
+var _ = Suite(&RingS{})
+
+func (s *RingS) TestEmptyRing(c *C) {
+	c.Check(newHashRing(nil, 100).node("metric"), Equals, -1)
+}
+
+func (s *RingS) TestNodeIsConsistent(c *C) {
+	ring := newHashRing([]string{"a:2003", "b:2003", "c:2003"}, 100)
+	counts := make([]int, 3)
+	for idx := 0; idx < 3000; idx++ {
+		key := fmt.Sprintf("app.metric%d", idx)
+		node := ring.node(key)
+		c.Assert(node >= 0 && node < 3, Equals, true)
+		c.Check(ring.node(key), Equals, node)
+		counts[node]++
+	}
+	for _, count := range counts {
+		c.Check(count > 500, Equals, true)
+	}
+}
+
+func (s *RingS) TestAddingNodeMovesKeysToIt(c *C) {
+	before := newHashRing([]string{"a:2003", "b:2003", "c:2003"}, 100)
+	after := newHashRing([]string{"a:2003", "b:2003", "c:2003", "d:2003"}, 100)
+	moved := 0
+	for idx := 0; idx < 3000; idx++ {
+		key := fmt.Sprintf("app.metric%d", idx)
+		if node := after.node(key); node != before.node(key) {
+			c.Check(node, Equals, 3)
+			moved++
+		}
+	}
+	c.Check(moved > 0 && moved < 1500, Equals, true)
+}
+
+func (s *RingS) TestSplitAddresses(c *C) {
+	c.Check(splitAddresses(""), IsNil)
+	c.Check(splitAddresses("a:2003, b:2003,"), Equals, []string{"a:2003", "b:2003"})
+}
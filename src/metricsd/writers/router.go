@@ -0,0 +1,334 @@
+package writers
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+// A Route is a timeline receiving metrics with names starting with the
+// prefix, written by its own aggregator.
+type Route struct {
+	Prefix     string // empty for the default route
+	Timeline   *types.Timeline
+	Aggregator *Aggregator
+}
+
+// A Router dispatches events to timelines by metric name prefix (see
+// config.Timelines), so every family of metrics has its own slice interval
+// and writers. Metrics not matching any prefix go to the default route, or
+// to an adaptive route picked by their arrival rate (see
+// config.AdaptiveIntervals). New metrics are copied to the launch route
+// for a while, when launch capture is enabled (see config.LaunchCapture).
+type Router struct {
+	Routes   []*Route       // sorted by prefix length (the longest first), then adaptive routes and the launch route, the default route is the last
+	adaptive map[int]*Route // adaptive routes keyed by slice interval
+	launch   *Route         // route of new metrics, nil when launch capture is disabled
+	mutex    *sync.Mutex    // serializes passes of all aggregators
+}
+
+// intervalWriter is implemented by writers depending on the slice interval
+// of the timeline they write.
+type intervalWriter interface {
+	setInterval(interval int)
+}
+
+// NewRoute returns a new route with a timeline of the given slice interval
+// (split into shards, see config.TimelineShards), written by new instances
+// of the named writers. Writers writing the same RRD files (having the same
+// name) are rejected, unless CollisionPolicy is "rename" and the same writer
+// is listed twice (it is used once then).
+func NewRoute(prefix string, interval int, names []string, done <-chan bool) (route *Route, err os.Error) {
+	activeWriters := make([]Writer, 0, len(names))
+	listed := make(map[string]string) // names the writers are listed with, keyed by writer name
+	for _, name := range names {
+		writer, err := New(name)
+		if err != nil {
+			return nil, err
+		}
+		if other, found := listed[writer.Name()]; found {
+			if other != name || config.CollisionPolicy != config.COLLISION_POLICY_RENAME {
+				return nil, os.NewError(fmt.Sprintf("Writers %q and %q would write the same RRD files (*-%s.rrd)", other, name, writer.Name()))
+			}
+			config.Logger.Warn("Writer %q is listed twice, using it once", name)
+			continue
+		}
+		listed[writer.Name()] = name
+		if err := validateDataSources(writer); err != nil {
+			return nil, err
+		}
+		if writer, ok := writer.(intervalWriter); ok {
+			writer.setInterval(interval)
+		}
+		activeWriters = append(activeWriters, writer)
+	}
+	timeline := types.NewShardedTimeline(interval, config.TimelineShards)
+	route = &Route{
+		Prefix:     prefix,
+		Timeline:   timeline,
+		Aggregator: NewAggregator(timeline, activeWriters, done),
+	}
+	return
+}
+
+// NewRouter returns a new Router with the default route, a route for
+// every timeline defined in configuration, and a route for every adaptive
+// interval longer than SliceInterval and for new metrics (using default
+// writers).
+func NewRouter(done <-chan bool) (router *Router, err os.Error) {
+	routes := make([]*Route, 0, len(config.Timelines)+len(config.AdaptiveIntervals)+2)
+	for _, timeline := range config.Timelines {
+		route, err := NewRoute(timeline.Prefix, timeline.SliceInterval(), timeline.ActiveWriters(), done)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+	sort.Sort(routesByPrefix(routes))
+
+	adaptive := make(map[int]*Route)
+	for _, interval := range config.AdaptiveIntervals {
+		if _, found := adaptive[interval]; found || interval <= config.SliceInterval {
+			continue
+		}
+		route, err := NewRoute("", interval, config.Writers, done)
+		if err != nil {
+			return nil, err
+		}
+		adaptive[interval] = route
+		routes = append(routes, route)
+	}
+
+	var launch *Route
+	if config.LaunchCapture.Enabled() {
+		launch, err = NewRoute("", config.LaunchCapture.Interval, config.Writers, done)
+		if err != nil {
+			return
+		}
+		routes = append(routes, launch)
+	}
+
+	route, err := NewRoute("", config.SliceInterval, config.Writers, done)
+	if err != nil {
+		return
+	}
+	router = &Router{Routes: append(routes, route), adaptive: adaptive, launch: launch, mutex: &sync.Mutex{}}
+	return
+}
+
+// Default returns the route of metrics not matching any prefix.
+func (router *Router) Default() *Route {
+	return router.Routes[len(router.Routes)-1]
+}
+
+// Route returns the route of the metric with the given name.
+func (router *Router) Route(name string) *Route {
+	for _, route := range router.Routes {
+		if route.Prefix == "" {
+			break
+		}
+		if strings.HasPrefix(name, route.Prefix) {
+			return route
+		}
+	}
+	if interval, found := config.AdaptedInterval(name); found {
+		if route, found := router.adaptive[interval]; found {
+			return route
+		}
+	}
+	return router.Default()
+}
+
+// Add appends the event to the current slice of its timeline. Arrivals of
+// metrics of the default timeline are measured, when adaptive intervals are
+// enabled (see observeArrival). Events of new metrics are copied to the
+// launch timeline with LAUNCH_SUFFIX appended to the name, when launch
+// capture is enabled (see launching).
+func (router *Router) Add(event *types.Event) {
+	if len(router.adaptive) > 0 {
+		router.observe(event)
+	}
+	router.Route(event.Name).Timeline.Add(event)
+	if router.launch != nil && launching(event.Name, types.Clock()) {
+		launched := *event
+		launched.Name += config.LAUNCH_SUFFIX
+		router.launch.Timeline.Add(&launched)
+	}
+}
+
+// observe measures arrivals of the metric of the event, until its interval
+// is picked. Metrics moved to adaptive routes stay denied, if they were
+// denied in the default route.
+func (router *Router) observe(event *types.Event) {
+	if config.TimelineFor(event.Name) != nil {
+		return
+	}
+	if _, found := config.AdaptedInterval(event.Name); found {
+		return
+	}
+	interval, picked := observeArrival(event.Source, event.Name, types.Clock())
+	if !picked {
+		return
+	}
+	config.SetAdaptedInterval(event.Name, interval)
+	if route, found := router.adaptive[interval]; found {
+		if router.Default().Timeline.IsDenied(event.Name) {
+			route.Timeline.Deny(event.Name)
+		}
+		config.Logger.Info("Metric %s moved to adaptive timeline with %d seconds interval", event.Name, interval)
+	}
+}
+
+// AddAt appends the event to the slice of its timeline containing the given
+// time (see Timeline.AddAt).
+func (router *Router) AddAt(event *types.Event, timestamp int64) {
+	router.Route(event.Name).Timeline.AddAt(event, timestamp)
+}
+
+// RunOnce performs a write pass of every route (see Aggregator.RunOnce),
+// one after another. Failed updates from previous passes are retried once
+// per pass before that (see RetryFailedUpdates), so their backoff does not
+// depend on the number of routes. Returns the first error occurred.
+func (router *Router) RunOnce(force bool) (err os.Error) {
+	router.mutex.Lock()
+	defer router.mutex.Unlock()
+	if len(router.adaptive) > 0 {
+		if expired := expireArrivals(types.Clock()); expired > 0 {
+			config.Logger.Debug("Forgot arrivals of %d metrics, which interval has not been picked", expired)
+		}
+	}
+	if router.launch != nil {
+		if expired := expireLaunches(types.Clock()); expired > 0 {
+			config.Logger.Debug("Forgot %d launched metrics absent for %d slice intervals", expired, config.StateTTL)
+		}
+	}
+	// Failed updates should be written before new data
+	err = RetryFailedUpdates(router.Default().Aggregator.Done)
+	for _, route := range router.Routes {
+		if error := route.Aggregator.RunOnce(force); error != nil && err == nil {
+			err = error
+		}
+	}
+	return
+}
+
+// FlushClosed writes closed slices of all timelines immediately, without
+// waiting for the write interval (the current slices are left open), and
+// returns after the writers finish. It is the force-flush path shared by
+// /admin/flush and SIGUSR2. The result is logged.
+func (router *Router) FlushClosed() os.Error {
+	if error := router.RunOnce(false); error != nil {
+		config.Logger.Error("Cannot flush closed slices: %s", error)
+		return error
+	}
+	config.Logger.Info("Closed slices have been flushed")
+	return nil
+}
+
+// LastRun returns the time (seconds since epoch) the oldest of the last
+// passes of all routes finished (see Aggregator.LastRun).
+func (router *Router) LastRun() (finished int64) {
+	for idx, route := range router.Routes {
+		if last := route.Aggregator.LastRun(); idx == 0 || last < finished {
+			finished = last
+		}
+	}
+	return
+}
+
+// ClosedSliceCount returns the greatest number of closed slices waiting for
+// extraction in a timeline.
+func (router *Router) ClosedSliceCount() (count int) {
+	for _, route := range router.Routes {
+		if closed := route.Timeline.ClosedSliceCount(); closed > count {
+			count = closed
+		}
+	}
+	return
+}
+
+// ExtractionLag returns the greatest age (in seconds) of the oldest slice
+// waiting for extraction in a timeline: the time since its start. It grows
+// past the slice and write intervals when extraction falls behind real
+// time. Returns 0 when there are no slices.
+func (router *Router) ExtractionLag() (lag int64) {
+	now := types.Clock()
+	for _, route := range router.Routes {
+		if oldest := route.Timeline.OldestSliceTime(); oldest > 0 && now-oldest > lag {
+			lag = now - oldest
+		}
+	}
+	return
+}
+
+// Snapshot returns the snapshot of open slices of the default route, with
+// snapshots of other routes in its Timelines, told apart by the prefix and
+// the slice interval of their routes (see Restore).
+func (router *Router) Snapshot() *types.TimelineSnapshot {
+	snapshot := router.Default().Timeline.Snapshot()
+	for _, route := range router.Routes[:len(router.Routes)-1] {
+		other := route.Timeline.Snapshot()
+		other.Prefix = route.Prefix
+		snapshot.Timelines = append(snapshot.Timelines, other)
+	}
+	return snapshot
+}
+
+// Restore adds slices of the snapshot and of its other timelines to the
+// timelines of routes with the same prefix and slice interval (see
+// types.Timeline.RestoreSnapshot). Nothing is restored, when a timeline of
+// the snapshot matches no route (e.g. intervals have been changed since).
+// Returns the number of restored slices.
+func (router *Router) Restore(snapshot *types.TimelineSnapshot) (restored int, err os.Error) {
+	snapshots := append([]*types.TimelineSnapshot{snapshot}, snapshot.Timelines...)
+	routes := make([]*Route, len(snapshots))
+	for idx, snapshot := range snapshots {
+		for _, route := range router.Routes {
+			if route.Prefix == snapshot.Prefix && route.Timeline.Interval == snapshot.Interval {
+				routes[idx] = route
+				break
+			}
+		}
+		if routes[idx] == nil {
+			return 0, os.NewError(fmt.Sprintf("No timeline with prefix %q and slice interval %d to restore", snapshot.Prefix, snapshot.Interval))
+		}
+	}
+	for idx, snapshot := range snapshots {
+		count, err := routes[idx].Timeline.RestoreSnapshot(snapshot)
+		if err != nil {
+			return restored, err
+		}
+		restored += count
+	}
+	return
+}
+
+// SnapshotClosed returns the most recent closed slices of all routes (see
+// types.Timeline.SnapshotClosed) combined into a slice with the time of the
+// default route (sample sets keep their own times). Sample sets of a metric
+// closed in several routes (e.g. moved to an adaptive route) are taken from
+// the first route. Combined sample sets are shared, so they should not be
+// modified.
+func (router *Router) SnapshotClosed() *types.Slice {
+	combined := types.NewSlice(router.Default().Timeline.SnapshotClosed().Time)
+	for _, route := range router.Routes {
+		for key, set := range route.Timeline.SnapshotClosed().Sets {
+			if _, found := combined.Sets[key]; !found {
+				combined.Sets[key] = set
+			}
+		}
+	}
+	return combined
+}
+
+// routesByPrefix attaches the methods of sort.Interface to []*Route,
+// sorting by prefix length in decreasing order.
+type routesByPrefix []*Route
+
+func (p routesByPrefix) Len() int           { return len(p) }
+func (p routesByPrefix) Less(i, j int) bool { return len(p[i].Prefix) > len(p[j].Prefix) }
+func (p routesByPrefix) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
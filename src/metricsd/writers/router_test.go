@@ -0,0 +1,121 @@
+package writers
+
+import (
+	"os"
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+	"metricsd/logger"
+	"metricsd/types"
+)
+
+type RouterS struct {
+	router *Router
+}
+
+var _ = Suite(&RouterS{})
+
+func (s *RouterS) SetUpTest(c *C) {
+	config.Logger = logger.NewConsoleLogger(logger.UNKNOWN)
+	config.Timelines = []*config.TimelineConfig{
+		&config.TimelineConfig{Prefix: "app.", Interval: 60, Writers: []string{"sum"}},
+		&config.TimelineConfig{Prefix: "app.business.", Writers: []string{"count"}},
+	}
+	router, error := NewRouter(nil)
+	c.Assert(error, IsNil)
+	s.router = router
+}
+
+func (s *RouterS) TearDownTest(c *C) {
+	config.Timelines = nil
+}
+
+func (s *RouterS) TestNewRouter(c *C) {
+	c.Check(len(s.router.Routes), Equals, 3)
+	c.Check(s.router.Routes[0].Prefix, Equals, "app.business.")
+	c.Check(s.router.Routes[0].Timeline.Interval, Equals, int64(config.SliceInterval))
+	c.Check(s.router.Routes[1].Prefix, Equals, "app.")
+	c.Check(s.router.Routes[1].Timeline.Interval, Equals, int64(60))
+	c.Check(s.router.Routes[1].Aggregator.Writers[0].(*Sum).Interval, Equals, 60)
+	c.Check(s.router.Default().Prefix, Equals, "")
+	c.Check(len(s.router.Default().Aggregator.Writers), Equals, len(config.Writers))
+}
+
+func (s *RouterS) TestNewRouterWithUnknownWriter(c *C) {
+	config.Timelines = []*config.TimelineConfig{&config.TimelineConfig{Prefix: "app.", Writers: []string{"unknown"}}}
+	_, error := NewRouter(nil)
+	c.Check(error, Not(IsNil))
+}
+
+func (s *RouterS) TestRoute(c *C) {
+	c.Check(s.router.Route("app.business.orders").Prefix, Equals, "app.business.")
+	c.Check(s.router.Route("app.latency").Prefix, Equals, "app.")
+	c.Check(s.router.Route("infra.cpu"), Equals, s.router.Default())
+}
+
+func (s *RouterS) TestAdd(c *C) {
+	s.router.Add(types.NewEvent("src", "app.latency", 10))
+	s.router.AddAt(types.NewEvent("src", "infra.cpu", 20), 1000)
+	c.Check(len(s.router.Routes[1].Timeline.Slices), Equals, 1)
+	c.Check(len(s.router.Default().Timeline.Slices), Equals, 1)
+	c.Check(s.router.ClosedSliceCount(), Equals, 1)
+}
+
+func (s *RouterS) TestRunOnceRetriesFailedUpdatesOncePerPass(c *C) {
+	updates := 0
+	updateRrdFile = func(writer Writer, set *types.SampleSet, data dataItem, args []string) os.Error {
+		updates++
+		return nil
+	}
+	defer func() {
+		updateRrdFile = safeUpdateRrd
+		failedUpdates = failedUpdates[:0]
+	}()
+	task := &rrdUpdateTask{writer: &Count{}, firstSampleSet: createSampleSet(1000, 1), f: func(args []string) []string { return args }, skips: 1}
+	failedUpdates = []*rrdUpdateTask{task}
+
+	// Backoff is counted in passes, regardless of the number of routes
+	c.Check(len(s.router.Routes) > 1, Equals, true)
+	c.Check(s.router.RunOnce(false), IsNil)
+	c.Check(updates, Equals, 0)
+	c.Check(task.skips, Equals, 0)
+	c.Check(s.router.RunOnce(false), IsNil)
+	c.Check(updates, Equals, 1)
+	c.Check(len(failedUpdates), Equals, 0)
+}
+
+func (s *RouterS) TestSnapshotAndRestore(c *C) {
+	s.router.AddAt(types.NewEvent("src", "app.latency", 10), 1020)
+	s.router.AddAt(types.NewEvent("src", "infra.cpu", 20), 1000)
+	snapshot := s.router.Snapshot()
+	c.Check(len(snapshot.Slices), Equals, 1)
+	c.Check(len(snapshot.Timelines), Equals, 2)
+	c.Check(snapshot.Timelines[1].Prefix, Equals, "app.")
+	c.Check(len(snapshot.Timelines[1].Slices), Equals, 1)
+
+	router, error := NewRouter(nil)
+	c.Assert(error, IsNil)
+	restored, error := router.Restore(snapshot)
+	c.Check(error, IsNil)
+	c.Check(restored, Equals, 2)
+	c.Check(len(router.Routes[0].Timeline.Slices), Equals, 0)
+	c.Check(router.Routes[1].Timeline.Slices[17].Sets["src-app.latency"].Values, Equals, []int{10})
+	c.Check(router.Default().Timeline.Slices[1000/int64(config.SliceInterval)].Sets["src-infra.cpu"].Values, Equals, []int{20})
+}
+
+func (s *RouterS) TestRestoreWithUnknownTimeline(c *C) {
+	s.router.AddAt(types.NewEvent("src", "infra.cpu", 20), 1000)
+	snapshot := s.router.Snapshot()
+	snapshot.Timelines[1].Interval = 30
+	_, error := s.router.Restore(snapshot)
+	c.Check(error, Not(IsNil))
+	c.Check(len(s.router.Default().Timeline.Slices), Equals, 1)
+}
+
+func (s *RouterS) TestSnapshotClosed(c *C) {
+	s.router.AddAt(types.NewEvent("src", "app.latency", 10), types.Clock()-60)
+	s.router.AddAt(types.NewEvent("src", "infra.cpu", 20), types.Clock()-int64(config.SliceInterval))
+	closed := s.router.SnapshotClosed()
+	c.Check(closed.Time, Equals, s.router.Default().Timeline.SnapshotClosed().Time)
+	c.Check(closed.Sets["src-app.latency"].Values, Equals, []int{10})
+	c.Check(closed.Sets["src-infra.cpu"].Values, Equals, []int{20})
+}
@@ -0,0 +1,71 @@
+package writers
+
+import (
+	"fmt"
+	"strings"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+// samplesItem appends the number of samples backing the rollup to the data
+// item of a writer, as "samples" data source (see
+// config.SampleCountWriters).
+type samplesItem struct {
+	dataItem
+	// Number of samples in the sample set.
+	samples int64
+}
+
+// withSampleCount returns the data item with the number of samples of the
+// sample set appended, when the writer counts samples. Weighted values are
+// counted as many samples as their weight, accumulated observations and
+// summarized values are included (see config.Accumulates and
+// config.Summarizes).
+func withSampleCount(writer Writer, set *types.SampleSet, data dataItem) dataItem {
+	if data == nil || !config.CountsSamples(writer.Name()) {
+		return data
+	}
+	samples := set.Count
+	if set.Summary != nil {
+		samples = types.SaturatedAdd(samples, set.Summary.Count)
+	}
+	for idx := range set.Values {
+		samples = types.SaturatedAdd(samples, int64(set.Weight(idx)))
+	}
+	return &samplesItem{dataItem: data, samples: samples}
+}
+
+// String returns string representation of the given samplesItem.
+func (self *samplesItem) String() string {
+	return fmt.Sprintf("samplesItem[samples=%d, data=%s]", self.samples, self.dataItem)
+}
+
+// rrdInfo returns the list of parameters used to create RRD file, with the
+// samples data source following data sources of the writer.
+func (self *samplesItem) rrdInfo() []string {
+	info := self.dataItem.rrdInfo()
+	result := make([]string, 0, len(info)+1)
+	added := false
+	for _, item := range info {
+		if !added && !strings.HasPrefix(item, "DS:") {
+			result = append(result, "DS:samples:GAUGE:600:0:U")
+			added = true
+		}
+		result = append(result, item)
+	}
+	if !added {
+		result = append(result, "DS:samples:GAUGE:600:0:U")
+	}
+	return result
+}
+
+// rrdTemplate returns template for RRDTool used to update data.
+func (self *samplesItem) rrdTemplate() string {
+	return self.dataItem.rrdTemplate() + ":samples"
+}
+
+// rrdString returns a string matching template format with the data to
+// update RRD files.
+func (self *samplesItem) rrdString() string {
+	return fmt.Sprintf("%s:%d", self.dataItem.rrdString(), self.samples)
+}
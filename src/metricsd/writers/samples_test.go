@@ -0,0 +1,39 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+)
+
+type SamplesS struct{}
+
+var _ = Suite(&SamplesS{})
+
+func (s *SamplesS) SetUpTest(c *C) {
+	config.SampleCountWriters = []string{"sum"}
+}
+
+func (s *SamplesS) TearDownTest(c *C) {
+	config.SampleCountWriters = nil
+}
+
+func (s *SamplesS) TestSummarizeAppendsSampleCount(c *C) {
+	set := createSampleSet(1000, 10, 20)
+	set.AddWeighted(30, 3)
+	data := summarize(&Sum{Interval: 10}, set)
+	c.Check(data.rrdTemplate(), Equals, "sum:rate:samples")
+	c.Check(data.rrdString(), Equals, "1000:120:12.000000:5")
+	c.Check(data.rrdInfo()[:3], Equals, []string{"DS:sum:GAUGE:600:U:U", "DS:rate:GAUGE:600:U:U", "DS:samples:GAUGE:600:0:U"})
+	c.Check(data.rrdInfo()[3], Equals, "RRA:AVERAGE:0.5:1:25920")
+}
+
+func (s *SamplesS) TestSummarizeIgnoresOtherWriters(c *C) {
+	data := summarize(&Count{}, createSampleSet(1000, 10, -20))
+	c.Check(data.rrdTemplate(), Not(Matches), ".*samples")
+}
+
+func (s *SamplesS) TestSummarizeWithAllWriters(c *C) {
+	config.SampleCountWriters = []string{"*"}
+	data := summarize(&Count{}, createSampleSet(1000, 10, -20))
+	c.Check(data.rrdTemplate(), Matches, ".*:samples")
+}
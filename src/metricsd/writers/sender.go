@@ -0,0 +1,122 @@
+package writers
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"time"
+	"metricsd/config"
+)
+
+const (
+	// Size of the queue of lines waiting to be sent.
+	senderQueueSize = 10000
+	// Timeout of sending a line (in nanoseconds), so a stuck receiver does
+	// not block sending forever.
+	senderTimeout = 5e9
+)
+
+// A lineSender sends text lines to a network address in the background.
+// When the queue is full or the receiver is unavailable, lines are dropped.
+// After a failure, reconnection is not attempted until the backoff delay
+// expires (see config.Reconnect). When batching is enabled, lines are
+// coalesced into batches sent with a single write (see config.OutputBatch).
+type lineSender struct {
+	name    string // receiver name used in logs
+	network string // "tcp" or "udp"
+	address string // receiver address
+	queue   chan string
+	backoff *backoff
+	batch   *config.BatchConfig
+	pending bytes.Buffer // lines of the current partial batch
+	conn    net.Conn     // connection to the receiver, nil when disconnected
+	retryAt int64        // time of the next reconnection attempt
+}
+
+// newLineSender returns a new lineSender and starts sending lines.
+func newLineSender(name, network, address string) *lineSender {
+	sender := &lineSender{name: name, network: network, address: address, queue: make(chan string, senderQueueSize), backoff: newBackoff(), batch: config.OutputBatch}
+	go sender.run()
+	return sender
+}
+
+// send puts the line into the queue, or drops it when the queue is full.
+func (sender *lineSender) send(line string) {
+	select {
+	case sender.queue <- line:
+	default:
+		config.Logger.Debug("%s queue is full, dropping %q", sender.name, line)
+	}
+}
+
+// run sends lines from the queue, reconnecting when connection is lost.
+// Partial batches are sent when their delay expires. Lines received while
+// waiting for reconnection are dropped.
+func (sender *lineSender) run() {
+	var timer <-chan int64
+	for {
+		select {
+		case line := <-sender.queue:
+			for _, data := range sender.add(line) {
+				sender.write(data)
+			}
+			if sender.pending.Len() == 0 {
+				timer = nil
+			} else if timer == nil {
+				timer = time.After(int64(sender.batch.MaxDelay * 1e9))
+			}
+		case <-timer:
+			sender.write(sender.flush())
+			timer = nil
+		}
+	}
+}
+
+// add appends the line to the current batch, and returns data ready to be
+// sent: the current batch when the line does not fit into it, and the new
+// batch when it is full. Without batching the line is returned as is.
+func (sender *lineSender) add(line string) (ready []string) {
+	if sender.batch == nil || sender.batch.MaxSize == 0 {
+		return []string{line}
+	}
+	if sender.pending.Len() > 0 && sender.pending.Len()+len(line) > sender.batch.MaxSize {
+		ready = append(ready, sender.flush())
+	}
+	sender.pending.WriteString(line)
+	if sender.pending.Len() >= sender.batch.MaxSize {
+		ready = append(ready, sender.flush())
+	}
+	return
+}
+
+// flush returns the current batch and starts a new one.
+func (sender *lineSender) flush() string {
+	data := sender.pending.String()
+	sender.pending.Reset()
+	return data
+}
+
+// write sends the data, connecting to the receiver first if necessary. The
+// data is dropped while waiting for reconnection.
+func (sender *lineSender) write(data string) {
+	if sender.conn == nil {
+		if time.Nanoseconds() < sender.retryAt {
+			return
+		}
+		conn, error := net.Dial(sender.network, sender.address)
+		if error != nil {
+			delay := sender.backoff.next()
+			config.Logger.Debug("Cannot connect to %s at %s: %s, retrying in %v seconds", sender.name, sender.address, error, float64(delay)/1e9)
+			sender.retryAt = time.Nanoseconds() + delay
+			return
+		}
+		sender.backoff.reset()
+		conn.SetWriteTimeout(senderTimeout)
+		sender.conn = conn
+	}
+	if _, error := sender.conn.Write([]byte(data)); error != nil {
+		config.Logger.Debug("Cannot send data to %s at %s: %s", sender.name, sender.address, error)
+		sender.conn.Close()
+		sender.conn = nil
+	}
+}
@@ -0,0 +1,35 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+)
+
+type SenderS struct{}
+
+var _ = Suite(&SenderS{})
+
+func (s *SenderS) TestAddWithoutBatching(c *C) {
+	sender := &lineSender{batch: &config.BatchConfig{MaxSize: 0, MaxDelay: 1}}
+	c.Check(sender.add("a 1 10\n"), Equals, []string{"a 1 10\n"})
+	c.Check(sender.pending.Len(), Equals, 0)
+}
+
+func (s *SenderS) TestAddBatchesLines(c *C) {
+	sender := &lineSender{batch: &config.BatchConfig{MaxSize: 20, MaxDelay: 1}}
+	c.Check(sender.add("a 1 10\n"), IsNil)
+	c.Check(sender.add("b 2 10\n"), IsNil)
+	// Does not fit into the current batch
+	c.Check(sender.add("c 3 10\n"), Equals, []string{"a 1 10\nb 2 10\n"})
+	c.Check(sender.flush(), Equals, "c 3 10\n")
+	c.Check(sender.pending.Len(), Equals, 0)
+}
+
+func (s *SenderS) TestAddSendsFullBatch(c *C) {
+	sender := &lineSender{batch: &config.BatchConfig{MaxSize: 14, MaxDelay: 1}}
+	c.Check(sender.add("a 1 10\n"), IsNil)
+	c.Check(sender.add("b 2 10\n"), Equals, []string{"a 1 10\nb 2 10\n"})
+	// Lines longer than the batch are sent alone
+	c.Check(sender.add("long.metric 3 10\n"), Equals, []string{"long.metric 3 10\n"})
+	c.Check(sender.pending.Len(), Equals, 0)
+}
@@ -0,0 +1,171 @@
+package writers
+
+import (
+	"fmt"
+	"math"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+// Number of bits of value hashes selecting HyperLogLog registers (2^14
+// registers of a byte, about 0.8% standard error).
+const setPrecision = 14
+
+// Set writer is used to count values of StatsD sets: the number of unique
+// values, and the total number of values submitted. Unique values are
+// counted exactly in a hash set of up to ExactLimit values, the count is
+// estimated using HyperLogLog (see hyperLogLog) above it, so memory does
+// not depend on the cardinality of the set.
+type Set struct {
+	*BaseWriter
+	// Maximum number of unique values counted exactly.
+	ExactLimit int
+}
+
+// NewSet returns a new Set writer with the exact limit defined in
+// configuration.
+func NewSet() *Set {
+	return &Set{ExactLimit: config.SetExactLimit}
+}
+
+// setItem stores counts calculated by Set writer.
+type setItem struct {
+	// Timestamp of the sample set.
+	time int64
+	// Number of unique values.
+	unique int64
+	// Number of values (sum of weights).
+	total int64
+	// Value indicating whether the number of unique values is estimated.
+	estimated bool
+}
+
+// Name returns the name of the writer.
+func (*Set) Name() string {
+	return "set"
+}
+
+// unit returns the unit of the writer rollups.
+func (*Set) unit() string {
+	return "count"
+}
+
+// rollupData counts unique values of the given sample set, and returns
+// setItem with statistics. Pre-aggregated events are counted as many times
+// as their weight in the total, and once in unique values.
+func (self *Set) rollupData(set *types.SampleSet) (data dataItem) {
+	item := &setItem{time: set.Time}
+	seen := make(map[int]bool)
+	var estimator *hyperLogLog
+	for idx, value := range set.Values {
+		item.total = types.SaturatedAdd(item.total, int64(set.Weight(idx)))
+		if estimator != nil {
+			estimator.add(value)
+			continue
+		}
+		seen[value] = true
+		if len(seen) > self.ExactLimit {
+			estimator = newHyperLogLog()
+			for unique := range seen {
+				estimator.add(unique)
+			}
+			seen = nil
+		}
+	}
+	if estimator != nil {
+		item.unique = estimator.estimate()
+		item.estimated = true
+	} else {
+		item.unique = int64(len(seen))
+	}
+	data = item
+	return
+}
+
+// prototype returns an empty data item used to report unknown values.
+func (*Set) prototype() dataItem {
+	return &setItem{}
+}
+
+// String returns string representation of the given setItem.
+func (self *setItem) String() string {
+	return fmt.Sprintf("setItem[time=%d, unique=%d, total=%d, estimated=%t]", self.time, self.unique, self.total, self.estimated)
+}
+
+// rrdInfo returns the list of parameters used to create RRD file.
+func (*setItem) rrdInfo() []string {
+	return []string{
+		"DS:unique:GAUGE:600:0:U",
+		"DS:total:GAUGE:600:0:U",
+		"RRA:AVERAGE:0.5:1:25920",   // 72 hours at 1 sample per 10 secs
+		"RRA:AVERAGE:0.5:60:4320",   // 1 month at 1 sample per 10 mins
+		"RRA:AVERAGE:0.5:2880:5475", // 5 years at 1 sample per 8 hours
+	}
+}
+
+// rrdTemplate returns template for RRDTool used to update data.
+func (*setItem) rrdTemplate() string {
+	return "unique:total"
+}
+
+// rrdString returns a string matching template format with the data to
+// update RRD files.
+func (self *setItem) rrdString() string {
+	return fmt.Sprintf("%d:%d:%d", self.time, self.unique, self.total)
+}
+
+// hyperLogLog estimates the number of unique values (see HyperLogLog:
+// http://algo.inria.fr/flajolet/Publications/FlFuGaMe07.pdf): every
+// register keeps the maximum rank of the first set bit of hashes of values
+// falling into it.
+type hyperLogLog struct {
+	registers []uint8
+}
+
+// newHyperLogLog returns an empty estimator with 2^setPrecision registers.
+func newHyperLogLog() *hyperLogLog {
+	return &hyperLogLog{registers: make([]uint8, 1<<setPrecision)}
+}
+
+// add observes the value.
+func (self *hyperLogLog) add(value int) {
+	hash := hashValue(value)
+	index := hash >> (64 - setPrecision)
+	rank := uint8(1)
+	for bits := hash << setPrecision; rank <= 64-setPrecision && bits&(1<<63) == 0; bits <<= 1 {
+		rank++
+	}
+	if rank > self.registers[index] {
+		self.registers[index] = rank
+	}
+}
+
+// estimate returns the estimated number of unique values observed, using
+// linear counting for small cardinalities.
+func (self *hyperLogLog) estimate() int64 {
+	m := float64(len(self.registers))
+	sum, zeros := 0.0, 0
+	for _, rank := range self.registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int64(estimate + 0.5)
+}
+
+// hashValue returns a 64-bit hash of the value with well mixed bits (the
+// finalizer of MurmurHash3).
+func hashValue(value int) uint64 {
+	hash := uint64(value)
+	hash ^= hash >> 33
+	hash *= 0xff51afd7ed558ccd
+	hash ^= hash >> 33
+	hash *= 0xc4ceb3f99e5e48ad
+	hash ^= hash >> 33
+	return hash
+}
@@ -0,0 +1,66 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+	"math"
+)
+
+type SetS struct {
+	set *Set
+}
+
+var _ = Suite(&SetS{})
+
+func (s *SetS) SetUpTest(c *C) {
+	s.set = &Set{ExactLimit: 100}
+}
+
+func (s *SetS) TestRollupDataWithEmptySampleSet(c *C) {
+	data := s.set.rollupData(createSampleSet(1000))
+	c.Check(data, Equals, &setItem{time: 1000})
+	c.Check(data.rrdString(), Equals, "1000:0:0")
+}
+
+func (s *SetS) TestRollupDataWithSimpleSampleSet(c *C) {
+	data := s.set.rollupData(createSampleSet(2000, 5, 10, 5, -3, 10))
+	c.Check(data, Equals, &setItem{time: 2000, unique: 3, total: 5})
+	c.Check(data.rrdString(), Equals, "2000:3:5")
+}
+
+func (s *SetS) TestRollupDataWithWeightedSampleSet(c *C) {
+	set := createSampleSet(3000)
+	set.AddWeighted(1, 10)
+	set.AddWeighted(5, 1)
+	set.AddWeighted(1, 2)
+	data := s.set.rollupData(set)
+	c.Check(data, Equals, &setItem{time: 3000, unique: 2, total: 13})
+}
+
+func (s *SetS) TestRollupDataAboveExactLimit(c *C) {
+	set := createSampleSet(4000)
+	for value := 0; value < 50000; value++ {
+		set.Add(value * 7)
+		set.Add(value * 7)
+	}
+	item := s.set.rollupData(set).(*setItem)
+	c.Check(item.total, Equals, int64(100000))
+	c.Check(item.estimated, Equals, true)
+	c.Check(math.Fabs(float64(item.unique)-50000)/50000 < 0.03, Equals, true)
+}
+
+func (s *SetS) TestRollupDataAtExactLimit(c *C) {
+	set := createSampleSet(5000)
+	for value := 0; value < 100; value++ {
+		set.Add(value)
+	}
+	c.Check(s.set.rollupData(set), Equals, &setItem{time: 5000, unique: 100, total: 100})
+}
+
+func (s *SetS) TestHyperLogLogWithFewValues(c *C) {
+	estimator := newHyperLogLog()
+	for value := -5; value < 5; value++ {
+		estimator.add(value)
+		estimator.add(value)
+	}
+	c.Check(estimator.estimate(), Equals, int64(10))
+}
@@ -0,0 +1,136 @@
+package writers
+
+import (
+	"fmt"
+	"strings"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+// Sketch writer is used to calculate approximate quantiles with bounded
+// relative error (see types.Sketch). Values are counted in buckets with
+// exponentially growing bounds, so the error stays the same for values of
+// any magnitude, which makes it suitable for latencies spanning several
+// orders of magnitude.
+type Sketch struct {
+	*BaseWriter
+	// Relative accuracy of calculated quantiles (e.g. 0.01 means 1%).
+	Accuracy float64
+	// Quantiles to calculate, each in (0, 1].
+	Quantiles []float64
+}
+
+// NewSketch returns a new Sketch writer with accuracy and quantiles defined
+// in configuration.
+func NewSketch() *Sketch {
+	return &Sketch{Accuracy: config.SketchAccuracy, Quantiles: config.SketchQuantiles}
+}
+
+// sketchItem stores quantiles calculated by Sketch writer.
+type sketchItem struct {
+	// Timestamp of the sample set.
+	time int64
+	// Calculated quantiles.
+	quantiles []float64
+	// Quantile values, nil when unknown.
+	values []float64
+}
+
+// Name returns the name of the writer.
+func (self *Sketch) Name() string {
+	return "sketch"
+}
+
+// rollupData performs summarization on the given sample set and returns
+// sketchItem with statistics. Sketches of summarized sample sets (see
+// types.Summary) are used as is, with the accuracy they have been created
+// with (see config.SketchAccuracy).
+func (self *Sketch) rollupData(set *types.SampleSet) (data dataItem) {
+	var sketch *types.Sketch
+	switch {
+	case set.Summary != nil:
+		sketch = set.Summary.Sketch
+	case len(set.Values) == 0:
+		return
+	default:
+		sketch = types.NewSketch(self.Accuracy)
+		for idx, value := range set.Values {
+			sketch.Add(value, set.Weight(idx))
+		}
+	}
+	item := &sketchItem{time: set.Time, quantiles: self.Quantiles, values: make([]float64, len(self.Quantiles))}
+	for idx, q := range self.Quantiles {
+		item.values[idx] = sketch.Quantile(q)
+	}
+	data = item
+	return
+}
+
+// prototype returns an empty data item used to report unknown values.
+func (self *Sketch) prototype() dataItem {
+	return &sketchItem{quantiles: self.Quantiles}
+}
+
+// quantiles returns a value indicating whether the writer calculates
+// quantiles.
+func (self *Sketch) quantiles() bool {
+	return true
+}
+
+// String returns string representation of the given sketchItem.
+func (self *sketchItem) String() string {
+	return fmt.Sprintf("sketchItem[time=%d, quantiles=%v, values=%v]", self.time, self.quantiles, self.values)
+}
+
+// rrdInfo returns the list of parameters used to create RRD file.
+func (self *sketchItem) rrdInfo() []string {
+	info := make([]string, 0, len(self.quantiles)+3)
+	for _, name := range sketchDataSources(self.quantiles) {
+		info = append(info, fmt.Sprintf("DS:%s:GAUGE:600:U:U", name))
+	}
+	return append(info,
+		"RRA:AVERAGE:0.5:1:25920",   // 72 hours at 1 sample per 10 secs
+		"RRA:AVERAGE:0.5:60:4320",   // 1 month at 1 sample per 10 mins
+		"RRA:AVERAGE:0.5:2880:5475", // 5 years at 1 sample per 8 hours
+	)
+}
+
+// rrdTemplate returns template for RRDTool used to update data.
+func (self *sketchItem) rrdTemplate() string {
+	return strings.Join(sketchDataSources(self.quantiles), ":")
+}
+
+// rrdString returns a string matching template format with the data to
+// update RRD files.
+func (self *sketchItem) rrdString() string {
+	result := fmt.Sprintf("%d", self.time)
+	for idx := range self.quantiles {
+		if self.values == nil {
+			result += ":" + UnknownValue
+		} else {
+			result += fmt.Sprintf(":%.2f", self.values[idx])
+		}
+	}
+	return result
+}
+
+// sketchDataSources returns RRD data source names for the given quantiles,
+// e.g. "p99" for 0.99 and "p99_9" for 0.999 (see dataSourceName).
+func sketchDataSources(quantiles []float64) []string {
+	names := make([]string, len(quantiles))
+	for idx, q := range quantiles {
+		names[idx] = dataSourceName(fmt.Sprintf("p%g", q*100))
+	}
+	return names
+}
+
+// dataSources returns names of RRD data sources of the writer rollups.
+func (self *Sketch) dataSources() []string {
+	return sketchDataSources(self.Quantiles)
+}
+
+// SketchDataSources returns RRD data source names for quantiles defined in
+// configuration.
+func SketchDataSources() []string {
+	return sketchDataSources(config.SketchQuantiles)
+}
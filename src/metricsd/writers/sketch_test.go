@@ -0,0 +1,70 @@
+package writers
+
+import (
+	"math"
+	. "launchpad.net/gocheck"
+)
+
+type SketchS struct {
+	sketch *Sketch
+}
+
+var _ = Suite(&SketchS{})
+
+func (s *SketchS) SetUpTest(c *C) {
+	s.sketch = &Sketch{Accuracy: 0.01, Quantiles: []float64{0.5, 0.9, 0.999}}
+}
+
+func (s *SketchS) TestRollupDataWithEmptySampleSet(c *C) {
+	ss := createSampleSet(1000)
+	data := s.sketch.rollupData(ss)
+	c.Check(data, IsNil)
+}
+
+func (s *SketchS) TestRollupData(c *C) {
+	ss := createSampleSet(2000)
+	for i := 1; i <= 1000; i++ {
+		ss.Add(i * 1000)
+	}
+	item := s.sketch.rollupData(ss).(*sketchItem)
+	c.Check(item.time, Equals, int64(2000))
+	for idx, expected := range []float64{500000, 900000, 999000} {
+		if error := math.Fabs(item.values[idx]-expected) / expected; error > 0.01 {
+			c.Errorf("quantile %v: expected %v, got %v", item.quantiles[idx], expected, item.values[idx])
+		}
+	}
+}
+
+func (s *SketchS) TestRollupDataWithNegativeAndZeroValues(c *C) {
+	s.sketch.Quantiles = []float64{0.25, 0.5, 1}
+	data := s.sketch.rollupData(createSampleSet(2000, -100, 0, 0, 100))
+	item := data.(*sketchItem)
+	c.Check(math.Fabs(item.values[0]+100) <= 1, Equals, true)
+	c.Check(item.values[1], Equals, 0.0)
+	c.Check(math.Fabs(item.values[2]-100) <= 1, Equals, true)
+}
+
+func (s *SketchS) TestRollupDataWithWeights(c *C) {
+	s.sketch.Quantiles = []float64{0.5}
+	ss := createSampleSet(2000, 10)
+	ss.AddWeighted(1000, 3)
+	item := s.sketch.rollupData(ss).(*sketchItem)
+	c.Check(math.Fabs(item.values[0]-1000) <= 10, Equals, true)
+}
+
+func (s *SketchS) TestRrdString(c *C) {
+	item := &sketchItem{time: 2000, quantiles: []float64{0.5, 0.999}, values: []float64{10, 20.5}}
+	c.Check(item.rrdTemplate(), Equals, "p50:p99_9")
+	c.Check(item.rrdString(), Equals, "2000:10.00:20.50")
+	c.Check(s.sketch.prototype().rrdString(), Equals, "0:U:U:U")
+}
+
+func (s *SketchS) TestRollupDataWithSummarizedSampleSet(c *C) {
+	s.sketch.Quantiles = []float64{0.5, 1}
+	ss := createSampleSet(2000)
+	ss.Summarize(10, 3, 0.01)
+	ss.Summarize(1000, 1, 0.01)
+	item := s.sketch.rollupData(ss).(*sketchItem)
+	c.Check(math.Fabs(item.values[0]-10) <= 0.1, Equals, true)
+	c.Check(math.Fabs(item.values[1]-1000) <= 10, Equals, true)
+}
@@ -0,0 +1,96 @@
+package writers
+
+import (
+	"io"
+	"json"
+	"os"
+	"time"
+	"metricsd/config"
+)
+
+// Version of the persisted writer state format, should be increased on
+// every incompatible change. State saved with another version is skipped.
+const writerStateVersion = 1
+
+// writerState is the cross-interval state of writers (previous means of
+// Change writer and warmup state), persisted on shutdown so writers resume
+// after a clean restart without warming up again (see SaveState).
+type writerState struct {
+	Version int                         // format version (see writerStateVersion)
+	Time    int64                       // time the state was saved at (seconds since epoch)
+	Means   map[string]*persistedMean   // previous means, keyed by source and series names
+	Warmups map[string]*persistedWarmup // warmup state, keyed by source, series, and writer names
+}
+
+// persistedMean is the persisted form of previousMean.
+type persistedMean struct {
+	Mean float64
+	Time int64
+}
+
+// persistedWarmup is the persisted form of warmupState.
+type persistedWarmup struct {
+	First int64
+	Last  int64
+}
+
+// SaveState writes the cross-interval state of writers in JSON format.
+// It should be called after the final write pass, so the state covers all
+// written slices.
+func SaveState(w io.Writer) os.Error {
+	state := &writerState{
+		Version: writerStateVersion,
+		Time:    time.Seconds(),
+		Means:   make(map[string]*persistedMean),
+		Warmups: make(map[string]*persistedWarmup),
+	}
+
+	previousMeansMutex.Lock()
+	for key, previous := range previousMeans {
+		state.Means[key] = &persistedMean{previous.mean, previous.time}
+	}
+	previousMeansMutex.Unlock()
+
+	warmupStatesMutex.Lock()
+	for key, warmup := range warmupStates {
+		state.Warmups[key] = &persistedWarmup{warmup.first, warmup.last}
+	}
+	warmupStatesMutex.Unlock()
+
+	return json.NewEncoder(w).Encode(state)
+}
+
+// LoadState restores the cross-interval state of writers saved with
+// SaveState, returns the number of restored entries. State saved with
+// another format version is skipped (nothing is restored, and no error is
+// returned), so writers warm up as after a fresh start. Entries newer than
+// the ones already known are kept.
+func LoadState(r io.Reader) (restored int, error os.Error) {
+	state := &writerState{}
+	if error = json.NewDecoder(r).Decode(state); error != nil {
+		return
+	}
+	if state.Version != writerStateVersion {
+		config.Logger.Warn("Writer state version %d does not match %d, skipping it", state.Version, writerStateVersion)
+		return
+	}
+
+	previousMeansMutex.Lock()
+	for key, mean := range state.Means {
+		if previous, found := previousMeans[key]; !found || previous.time < mean.Time {
+			previousMeans[key] = &previousMean{mean: mean.Mean, time: mean.Time}
+			restored++
+		}
+	}
+	previousMeansMutex.Unlock()
+
+	warmupStatesMutex.Lock()
+	for key, warmup := range state.Warmups {
+		if current, found := warmupStates[key]; !found || current.last < warmup.Last {
+			warmupStates[key] = &warmupState{first: warmup.First, last: warmup.Last}
+			restored++
+		}
+	}
+	warmupStatesMutex.Unlock()
+	return
+}
@@ -0,0 +1,73 @@
+package writers
+
+import (
+	"bytes"
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+	"metricsd/logger"
+)
+
+type StateS struct{}
+
+var _ = Suite(&StateS{})
+
+func (s *StateS) SetUpTest(c *C) {
+	config.Logger = logger.NewConsoleLogger(logger.UNKNOWN)
+	config.SetMetrics([]*config.MetricConfig{&config.MetricConfig{Pattern: "metric", Warmup: 2}})
+	previousMeans = make(map[string]*previousMean)
+	warmupStates = make(map[string]*warmupState)
+	WarmupSuppressed = 0
+}
+
+func (s *StateS) TearDownTest(c *C) {
+	config.SetMetrics(nil)
+}
+
+func (s *StateS) TestSaveAndLoadState(c *C) {
+	interval := int64(config.SliceInterval)
+	sum := &Sum{Interval: 10}
+	for idx := int64(0); idx < 3; idx++ {
+		summarize(sum, createSampleSet(1000+idx*interval, 10))
+	}
+	change := &Change{}
+	change.rollupData(createSampleSet(1000, 10))
+
+	buffer := &bytes.Buffer{}
+	c.Assert(SaveState(buffer), IsNil)
+
+	// Restart
+	previousMeans = make(map[string]*previousMean)
+	warmupStates = make(map[string]*warmupState)
+	restored, err := LoadState(buffer)
+	c.Assert(err, IsNil)
+	c.Check(restored, Equals, 2)
+
+	// Neither warmup, nor unknown change after restart
+	WarmupSuppressed = 0
+	c.Check(summarize(sum, createSampleSet(1000+3*interval, 10)), Not(IsNil))
+	c.Check(WarmupSuppressed, Equals, int64(0))
+	data := change.rollupData(createSampleSet(1000+3*interval, 15))
+	c.Check(data, Equals, &changeItem{time: 1000 + 3*interval, change: 50, known: true})
+}
+
+func (s *StateS) TestLoadStateKeepsNewerEntries(c *C) {
+	buffer := bytes.NewBufferString(`{"Version":1,"Means":{"src-metric":{"Mean":10,"Time":1000}}}`)
+	previousMeans["src-metric"] = &previousMean{mean: 20, time: 1010}
+	restored, err := LoadState(buffer)
+	c.Assert(err, IsNil)
+	c.Check(restored, Equals, 0)
+	c.Check(previousMeans["src-metric"].mean, Equals, 20.0)
+}
+
+func (s *StateS) TestLoadStateSkipsOtherVersions(c *C) {
+	buffer := bytes.NewBufferString(`{"Version":0,"Means":{"src-metric":{"Mean":10,"Time":1000}}}`)
+	restored, err := LoadState(buffer)
+	c.Assert(err, IsNil)
+	c.Check(restored, Equals, 0)
+	c.Check(len(previousMeans), Equals, 0)
+}
+
+func (s *StateS) TestLoadStateInvalid(c *C) {
+	_, err := LoadState(bytes.NewBufferString("{"))
+	c.Check(err, Not(IsNil))
+}
@@ -0,0 +1,106 @@
+package writers
+
+import (
+	"fmt"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+// Sum writer is used to calculate the sum of values, along with the sum
+// normalized to the slice interval (per-second rate), so counters could be
+// graphed both ways from a single RRD file.
+type Sum struct {
+	*BaseWriter
+	// Slice interval in seconds, used to calculate the rate.
+	Interval int
+}
+
+// NewSum returns a new Sum writer using the slice interval defined in
+// configuration.
+func NewSum() *Sum {
+	return &Sum{Interval: config.SliceInterval}
+}
+
+// setInterval sets the slice interval of the timeline written by the
+// writer (see Router).
+func (self *Sum) setInterval(interval int) {
+	self.Interval = interval
+}
+
+// warmup returns true: the rate of the first slice after startup is
+// calculated from a partial interval.
+func (*Sum) warmup() bool {
+	return true
+}
+
+// sumItem stores the sum of values of the sample set.
+type sumItem struct {
+	// Timestamp of the sample set.
+	time int64
+	// Sum of values (multiplied by their weights).
+	sum int64
+	// Sum divided by the slice interval.
+	rate float64
+}
+
+// Name returns the name of the writer.
+func (*Sum) Name() string {
+	return "sum"
+}
+
+// rollupData performs summarization on the given sample set and returns
+// sumItem with statistics. Values accumulated on arrival (see
+// config.Accumulates) and summarized values (see config.Summarizes) are
+// included. Empty sample sets are reported as zero.
+func (self *Sum) rollupData(set *types.SampleSet) (data dataItem) {
+	sum := set.Total
+	if set.Summary != nil {
+		sum = types.SaturatedAdd(sum, set.Summary.Total)
+	}
+	for idx, elem := range set.Values {
+		sum = types.SaturatedAdd(sum, types.SaturatedMul(int64(elem), int64(set.Weight(idx))))
+	}
+	data = self.item(set.Time, sum)
+	return
+}
+
+// zero returns the data item reported for slices without samples.
+func (self *Sum) zero(time int64) dataItem {
+	return self.item(time, 0)
+}
+
+// item returns sumItem with the given sum and the rate calculated from it.
+func (self *Sum) item(time, sum int64) *sumItem {
+	item := &sumItem{time: time, sum: sum}
+	if self.Interval > 0 {
+		item.rate = float64(sum) / float64(self.Interval)
+	}
+	return item
+}
+
+// String returns string representation of the given sumItem.
+func (self *sumItem) String() string {
+	return fmt.Sprintf("sumItem[time=%d, sum=%d, rate=%.6f]", self.time, self.sum, self.rate)
+}
+
+// rrdInfo returns the list of parameters used to create RRD file.
+func (*sumItem) rrdInfo() []string {
+	return []string{
+		"DS:sum:GAUGE:600:U:U",
+		"DS:rate:GAUGE:600:U:U",
+		"RRA:AVERAGE:0.5:1:25920",   // 72 hours at 1 sample per 10 secs
+		"RRA:AVERAGE:0.5:60:4320",   // 1 month at 1 sample per 10 mins
+		"RRA:AVERAGE:0.5:2880:5475", // 5 years at 1 sample per 8 hours
+	}
+}
+
+// rrdTemplate returns template for RRDTool used to update data.
+func (*sumItem) rrdTemplate() string {
+	return "sum:rate"
+}
+
+// rrdString returns a string matching template format with the data to
+// update RRD files.
+func (self *sumItem) rrdString() string {
+	return fmt.Sprintf("%d:%d:%.6f", self.time, self.sum, self.rate)
+}
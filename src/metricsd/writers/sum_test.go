@@ -0,0 +1,71 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+	"math"
+)
+
+type SumS struct {
+	sum *Sum
+}
+
+var _ = Suite(&SumS{})
+
+func (s *SumS) SetUpTest(c *C) {
+	s.sum = &Sum{Interval: 10}
+}
+
+func (s *SumS) TestRollupDataWithEmptySampleSet(c *C) {
+	data := s.sum.rollupData(createSampleSet(1000))
+	c.Check(data, Equals, &sumItem{time: 1000, sum: 0, rate: 0})
+	c.Check(data.rrdString(), Equals, "1000:0:0.000000")
+}
+
+func (s *SumS) TestRollupDataWithSimpleSampleSet(c *C) {
+	data := s.sum.rollupData(createSampleSet(2000, 5, 10, -3))
+	c.Check(data, Equals, &sumItem{time: 2000, sum: 12, rate: 1.2})
+	c.Check(data.rrdString(), Equals, "2000:12:1.200000")
+}
+
+func (s *SumS) TestRollupDataWithWeightedSampleSet(c *C) {
+	set := createSampleSet(3000)
+	set.AddWeighted(1, 10)
+	set.AddWeighted(5, 1)
+	data := s.sum.rollupData(set)
+	c.Check(data, Equals, &sumItem{time: 3000, sum: 15, rate: 1.5})
+}
+
+func (s *SumS) TestRollupDataWithAccumulatedSampleSet(c *C) {
+	set := createSampleSet(3000, 2)
+	set.Accumulated = true
+	set.Accumulate(10, 1)
+	set.Accumulate(3, 5)
+	data := s.sum.rollupData(set)
+	c.Check(data, Equals, &sumItem{time: 3000, sum: 27, rate: 2.7})
+}
+
+func (s *SumS) TestRollupDataWithSummarizedSampleSet(c *C) {
+	set := createSampleSet(3000)
+	set.Summarize(10, 1, 0.01)
+	set.Summarize(3, 5, 0.01)
+	data := s.sum.rollupData(set)
+	c.Check(data, Equals, &sumItem{time: 3000, sum: 25, rate: 2.5})
+}
+
+func (s *SumS) TestSummarizeCarriedSampleSet(c *C) {
+	set := createSampleSet(4000, 7)
+	set.Carried = true
+	data := summarize(s.sum, set)
+	c.Check(data, Equals, &sumItem{time: 4000, sum: 0, rate: 0})
+	c.Check(data.rrdString(), Equals, "4000:0:0.000000")
+}
+
+func (s *SumS) TestRollupDataWithNearMaxValues(c *C) {
+	set := createSampleSet(5000)
+	set.AddWeighted(math.MaxInt32, math.MaxInt32)
+	set.Accumulated = true
+	set.Total = math.MaxInt64 - 10
+	data := s.sum.rollupData(set)
+	c.Check(data.(*sumItem).sum, Equals, int64(math.MaxInt64))
+	c.Check(data.rrdString(), Matches, "5000:9223372036854775807:[0-9.e+]+")
+}
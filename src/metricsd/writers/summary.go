@@ -0,0 +1,349 @@
+package writers
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+// Summary writer is used to calculate quantiles over a sliding time window
+// instead of a single slice, the same way as summaries of Prometheus client
+// libraries: values are observed in several streams of targeted quantiles
+// (see CKMS: http://www.cs.rutgers.edu/~muthu/bquant.pdf), which are reset
+// in turn, so quantiles decay with the age of values. The sum and the count
+// of values are accumulated since startup.
+type Summary struct {
+	*BaseWriter
+	// Quantiles with allowed errors, and the window.
+	Config *config.SummaryConfig
+}
+
+// NewSummary returns a new Summary writer with settings defined in
+// configuration.
+func NewSummary() *Summary {
+	return &Summary{Config: config.Summary}
+}
+
+// summaryItem stores quantiles calculated by Summary writer.
+type summaryItem struct {
+	// Timestamp of the sample set.
+	time int64
+	// Calculated quantiles.
+	quantiles []float64
+	// Quantile values.
+	values []float64
+	// Number of values observed since startup.
+	count int64
+	// Sum of values observed since startup.
+	sum float64
+}
+
+// decayingSummary holds streams of a series, all values are observed in
+// every stream. The head stream, covering the whole window, is queried,
+// then reset and moved to the tail.
+type decayingSummary struct {
+	streams []*quantileStream
+	head    int   // index of the head stream
+	expires int64 // time the head stream is reset at
+	count   int64
+	sum     float64
+	time    int64 // timestamp of the latest sample set
+}
+
+var (
+	// Summaries of series, keyed by source and series names
+	summaries = make(map[string]*decayingSummary)
+	// Mutex protecting summaries
+	summariesMutex = &sync.Mutex{}
+)
+
+// Name returns the name of the writer.
+func (self *Summary) Name() string {
+	return "summary"
+}
+
+// stateful returns true: rollups observe values in summaries of series
+// (see statefulWriter).
+func (self *Summary) stateful() bool {
+	return true
+}
+
+// rollupData observes values of the sample set in the summary of the
+// series, and returns summaryItem with quantiles of the window ending at
+// the sample set. Older sample sets (e.g. imported history) do not move
+// the window back. Pre-aggregated events are counted as many times as their
+// weight.
+func (self *Summary) rollupData(set *types.SampleSet) (data dataItem) {
+	if len(set.Values) == 0 {
+		return
+	}
+
+	samples := make(summarySamples, len(set.Values))
+	for idx, value := range set.Values {
+		samples[idx] = &summarySample{value: float64(value), width: float64(set.Weight(idx))}
+	}
+	sort.Sort(samples)
+
+	key := set.Source + "-" + set.SeriesName()
+	summariesMutex.Lock()
+	defer summariesMutex.Unlock()
+	summary, found := summaries[key]
+	if !found {
+		summary = self.newSummary(set.Time)
+		summaries[key] = summary
+	}
+	summary.rotate(set.Time, self.streamDuration())
+	for _, stream := range summary.streams {
+		stream.merge(samples)
+	}
+	for _, sample := range samples {
+		summary.count = types.SaturatedAdd(summary.count, int64(sample.width))
+		summary.sum += sample.value * sample.width
+	}
+	if set.Time > summary.time {
+		summary.time = set.Time
+	}
+
+	head := summary.streams[summary.head]
+	item := &summaryItem{time: set.Time, quantiles: self.Config.Quantiles(), count: summary.count, sum: summary.sum}
+	item.values = make([]float64, len(item.quantiles))
+	for idx, q := range item.quantiles {
+		item.values[idx] = head.query(q)
+	}
+	data = item
+	return
+}
+
+// newSummary returns the summary of a series first seen at the given time.
+func (self *Summary) newSummary(now int64) *decayingSummary {
+	summary := &decayingSummary{
+		streams: make([]*quantileStream, self.Config.AgeBuckets),
+		expires: now + self.streamDuration(),
+	}
+	for idx := range summary.streams {
+		summary.streams[idx] = &quantileStream{objectives: self.Config.Objectives}
+	}
+	return summary
+}
+
+// streamDuration returns the time in seconds between resets of streams.
+func (self *Summary) streamDuration() int64 {
+	duration := int64(self.Config.MaxAge / self.Config.AgeBuckets)
+	if duration < 1 {
+		duration = 1
+	}
+	return duration
+}
+
+// dataSources returns names of RRD data sources of the writer rollups.
+func (self *Summary) dataSources() []string {
+	return sketchDataSources(self.Config.Quantiles())
+}
+
+// rotate resets expired streams at the given time (seconds since epoch).
+// All streams are reset when the series was absent for the whole window.
+func (self *decayingSummary) rotate(now, duration int64) {
+	if now-self.expires >= duration*int64(len(self.streams)) {
+		for _, stream := range self.streams {
+			stream.reset()
+		}
+		self.expires = now + duration
+		return
+	}
+	for now >= self.expires {
+		self.streams[self.head].reset()
+		self.head = (self.head + 1) % len(self.streams)
+		self.expires += duration
+	}
+}
+
+// expireSummaries forgets summaries of metrics not received since the
+// given time (seconds since epoch), returns the number of forgotten
+// summaries.
+func expireSummaries(before int64) (expired int) {
+	summariesMutex.Lock()
+	defer summariesMutex.Unlock()
+	for key, summary := range summaries {
+		if summary.time < before {
+			summaries[key] = nil, false
+			expired++
+		}
+	}
+	return
+}
+
+// String returns string representation of the given summaryItem.
+func (self *summaryItem) String() string {
+	return fmt.Sprintf("summaryItem[time=%d, quantiles=%v, values=%v, count=%d, sum=%v]", self.time, self.quantiles, self.values, self.count, self.sum)
+}
+
+// rrdInfo returns the list of parameters used to create RRD file.
+func (self *summaryItem) rrdInfo() []string {
+	info := make([]string, 0, len(self.quantiles)+3)
+	for _, name := range sketchDataSources(self.quantiles) {
+		info = append(info, fmt.Sprintf("DS:%s:GAUGE:600:U:U", name))
+	}
+	return append(info,
+		"RRA:AVERAGE:0.5:1:25920",   // 72 hours at 1 sample per 10 secs
+		"RRA:AVERAGE:0.5:60:4320",   // 1 month at 1 sample per 10 mins
+		"RRA:AVERAGE:0.5:2880:5475", // 5 years at 1 sample per 8 hours
+	)
+}
+
+// rrdTemplate returns template for RRDTool used to update data.
+func (self *summaryItem) rrdTemplate() string {
+	return strings.Join(sketchDataSources(self.quantiles), ":")
+}
+
+// rrdString returns a string matching template format with the data to
+// update RRD files.
+func (self *summaryItem) rrdString() string {
+	result := fmt.Sprintf("%d", self.time)
+	for _, value := range self.values {
+		if math.IsNaN(value) {
+			result += ":" + UnknownValue
+		} else {
+			result += fmt.Sprintf(":%.2f", value)
+		}
+	}
+	return result
+}
+
+// prometheusType returns Prometheus metric type of the item.
+func (*summaryItem) prometheusType() string {
+	return "summary"
+}
+
+// prometheusSamples returns the summary in Prometheus format: quantiles
+// with "quantile" label, sum and count of values.
+func (self *summaryItem) prometheusSamples(name, labels string) []string {
+	samples := make([]string, 0, len(self.quantiles)+2)
+	for idx, q := range self.quantiles {
+		samples = append(samples, fmt.Sprintf("%s{%s,quantile=\"%g\"} %g", name, labels, q, self.values[idx]))
+	}
+	samples = append(samples, fmt.Sprintf("%s_sum{%s} %g", name, labels, self.sum))
+	samples = append(samples, fmt.Sprintf("%s_count{%s} %d", name, labels, self.count))
+	return samples
+}
+
+// summarySample is a sample of a quantile stream: width is the number of
+// observations merged into the sample, delta is the uncertainty of its rank.
+type summarySample struct {
+	value float64
+	width float64
+	delta float64
+}
+
+// summarySamples sorts samples by value.
+type summarySamples []*summarySample
+
+func (self summarySamples) Len() int           { return len(self) }
+func (self summarySamples) Less(i, j int) bool { return self[i].value < self[j].value }
+func (self summarySamples) Swap(i, j int)      { self[i], self[j] = self[j], self[i] }
+
+// quantileStream estimates targeted quantiles, keeping only samples needed
+// to answer them within allowed errors.
+type quantileStream struct {
+	objectives []*config.Objective
+	samples    []*summarySample // sorted by value
+	count      float64          // number of observations
+}
+
+// invariant returns the maximum allowed uncertainty of rank r.
+func (self *quantileStream) invariant(r float64) float64 {
+	min := math.MaxFloat64
+	for _, objective := range self.objectives {
+		var f float64
+		if objective.Quantile*self.count <= r {
+			f = 2 * objective.Error * r / objective.Quantile
+		} else {
+			f = 2 * objective.Error * (self.count - r) / (1 - objective.Quantile)
+		}
+		if f < min {
+			min = f
+		}
+	}
+	return min
+}
+
+// merge observes the given samples sorted by value, and compresses the
+// stream.
+func (self *quantileStream) merge(samples summarySamples) {
+	merged := make([]*summarySample, 0, len(self.samples)+len(samples))
+	var r float64
+	idx := 0
+	for _, sample := range samples {
+		for idx < len(self.samples) && self.samples[idx].value <= sample.value {
+			r += self.samples[idx].width
+			merged = append(merged, self.samples[idx])
+			idx++
+		}
+		inserted := &summarySample{value: sample.value, width: sample.width}
+		if idx < len(self.samples) {
+			inserted.delta = math.Fmax(0, math.Floor(self.invariant(r))-1)
+		}
+		merged = append(merged, inserted)
+		self.count += sample.width
+		r += sample.width
+	}
+	self.samples = append(merged, self.samples[idx:]...)
+	self.compress()
+}
+
+// compress merges adjacent samples while their combined uncertainty stays
+// within the invariant.
+func (self *quantileStream) compress() {
+	if len(self.samples) < 2 {
+		return
+	}
+	last := len(self.samples) - 1
+	x := self.samples[last]
+	r := self.count - 1 - x.width
+	compressed := make([]*summarySample, 0, len(self.samples))
+	for idx := last - 1; idx >= 0; idx-- {
+		c := self.samples[idx]
+		if c.width+x.width+x.delta <= self.invariant(r) {
+			x.width += c.width
+		} else {
+			compressed = append(compressed, x)
+			x = c
+		}
+		r -= c.width
+	}
+	compressed = append(compressed, x)
+	// Samples were collected from the largest value
+	for i, j := 0, len(compressed)-1; i < j; i, j = i+1, j-1 {
+		compressed[i], compressed[j] = compressed[j], compressed[i]
+	}
+	self.samples = compressed
+}
+
+// query returns the estimate of the q-th quantile, NaN when the stream is
+// empty.
+func (self *quantileStream) query(q float64) float64 {
+	if len(self.samples) == 0 {
+		return math.NaN()
+	}
+	t := math.Ceil(q * self.count)
+	t += math.Ceil(self.invariant(t) / 2)
+	previous := self.samples[0]
+	var r float64
+	for _, c := range self.samples[1:] {
+		r += previous.width
+		if r+c.width+c.delta > t {
+			return previous.value
+		}
+		previous = c
+	}
+	return previous.value
+}
+
+// reset forgets all observations of the stream.
+func (self *quantileStream) reset() {
+	self.samples = nil
+	self.count = 0
+}
@@ -0,0 +1,77 @@
+package writers
+
+import (
+	"math"
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+)
+
+type SummaryS struct {
+	summary *Summary
+}
+
+var _ = Suite(&SummaryS{})
+
+func (s *SummaryS) SetUpTest(c *C) {
+	s.summary = &Summary{Config: &config.SummaryConfig{
+		Objectives: []*config.Objective{&config.Objective{0.5, 0.05}, &config.Objective{0.99, 0.001}},
+		MaxAge:     60,
+		AgeBuckets: 3,
+	}}
+	summaries = make(map[string]*decayingSummary)
+}
+
+func (s *SummaryS) TestRollupDataWithEmptySampleSet(c *C) {
+	c.Check(s.summary.rollupData(createSampleSet(1000)), IsNil)
+}
+
+func (s *SummaryS) TestRollupData(c *C) {
+	ss := createSampleSet(1000)
+	for i := 1; i <= 1000; i++ {
+		ss.Add(i)
+	}
+	item := s.summary.rollupData(ss).(*summaryItem)
+	c.Check(item.quantiles, Equals, []float64{0.5, 0.99})
+	c.Check(math.Fabs(item.values[0]-500) <= 50, Equals, true)
+	c.Check(math.Fabs(item.values[1]-990) <= 1, Equals, true)
+	c.Check(item.count, Equals, int64(1000))
+	c.Check(item.sum, Equals, float64(500500))
+	c.Check(item.rrdTemplate(), Equals, "p50:p99")
+}
+
+func (s *SummaryS) TestRollupDataDecays(c *C) {
+	s.summary.rollupData(createSampleSet(1000, 100, 100, 100))
+	item := s.summary.rollupData(createSampleSet(1030, 1)).(*summaryItem)
+	c.Check(item.values, Equals, []float64{100, 100})
+
+	item = s.summary.rollupData(createSampleSet(1045, 1)).(*summaryItem)
+	c.Check(item.values, Equals, []float64{100, 100})
+
+	// Values older than the window are forgotten, the count is not
+	item = s.summary.rollupData(createSampleSet(1065, 1)).(*summaryItem)
+	c.Check(item.values, Equals, []float64{1, 1})
+	c.Check(item.count, Equals, int64(6))
+
+	// All streams are reset after the whole window
+	item = s.summary.rollupData(createSampleSet(2000, 7)).(*summaryItem)
+	c.Check(item.values, Equals, []float64{7, 7})
+	c.Check(item.count, Equals, int64(7))
+}
+
+func (s *SummaryS) TestPrometheusSamples(c *C) {
+	data := s.summary.rollupData(createSampleSet(1000, 5, 5)).(*summaryItem)
+	c.Check(data.prometheusType(), Equals, "summary")
+	c.Check(data.prometheusSamples("metricsd_metric_summary", "source=\"src\""), Equals, []string{
+		"metricsd_metric_summary{source=\"src\",quantile=\"0.5\"} 5",
+		"metricsd_metric_summary{source=\"src\",quantile=\"0.99\"} 5",
+		"metricsd_metric_summary_sum{source=\"src\"} 10",
+		"metricsd_metric_summary_count{source=\"src\"} 2",
+	})
+}
+
+func (s *SummaryS) TestExpireSummaries(c *C) {
+	s.summary.rollupData(createSampleSet(1000, 1))
+	c.Check(expireSummaries(1000), Equals, 0)
+	c.Check(expireSummaries(1001), Equals, 1)
+	c.Check(len(summaries), Equals, 0)
+}
@@ -0,0 +1,105 @@
+package writers
+
+import (
+	"fmt"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+// Minimum number of values (counting weights) p99.9 is meaningful for:
+// with fewer values it is interpolated between the highest values.
+const tailMinValues = 1000
+
+// TailLatency writer is used to calculate the 99.9th percentile and the
+// maximum of values together, since tail outliers hidden by lower
+// percentiles are what latency incidents are about.
+type TailLatency struct {
+	*BaseWriter
+}
+
+// tailLatencyItem stores statistics calculated by TailLatency writer.
+type tailLatencyItem struct {
+	// Timestamp of the sample set.
+	time int64
+	// 99.9th percentile.
+	p999 float64
+	// Maximum value.
+	max int
+	// Value indicating whether there were too few values for p99.9, which
+	// is reported as the maximum then.
+	low bool
+}
+
+// Name returns the name of the writer.
+func (*TailLatency) Name() string {
+	return "tail_latency"
+}
+
+// rollupData returns tailLatencyItem with p99.9 interpolated linearly
+// between ranks, and the maximum of the sample set. Weighted values are
+// counted as many times as their weight. With less than tailMinValues
+// values both statistics are the maximum, flagged as low confidence.
+func (*TailLatency) rollupData(set *types.SampleSet) (data dataItem) {
+	if len(set.Values) == 0 {
+		return
+	}
+	set.Sort()
+	ranked := newRankedValues(set)
+
+	item := &tailLatencyItem{time: set.Time, max: set.Values[len(set.Values)-1]}
+	if ranked.number() < tailMinValues {
+		item.p999 = float64(item.max)
+		item.low = true
+	} else {
+		_, item.p999 = ranked.percentile(0.999, config.PERCENTILE_LINEAR)
+	}
+	data = item
+	return
+}
+
+// prototype returns an empty data item used to report unknown values.
+func (*TailLatency) prototype() dataItem {
+	return &tailLatencyItem{}
+}
+
+// quantiles returns a value indicating whether the writer calculates
+// quantiles.
+func (*TailLatency) quantiles() bool {
+	return true
+}
+
+// String returns string representation of the given tailLatencyItem.
+func (self *tailLatencyItem) String() string {
+	return fmt.Sprintf("tailLatencyItem[time=%d, p999=%.2f, max=%d, low=%v]", self.time, self.p999, self.max, self.low)
+}
+
+// rrdInfo returns the list of parameters used to create RRD file.
+func (*tailLatencyItem) rrdInfo() []string {
+	return []string{
+		"DS:p999:GAUGE:600:0:U",
+		"DS:max:GAUGE:600:0:U",
+		"RRA:AVERAGE:0.5:1:25920",   // 72 hours at 1 sample per 10 secs
+		"RRA:AVERAGE:0.5:60:4320",   // 1 month at 1 sample per 10 mins
+		"RRA:AVERAGE:0.5:2880:5475", // 5 years at 1 sample per 8 hours
+		"RRA:MAX:0.5:1:25920",       // 72 hours at 1 sample per 10 secs
+		"RRA:MAX:0.5:60:4320",       // 1 month at 1 sample per 10 mins
+		"RRA:MAX:0.5:2880:5475",     // 5 years at 1 sample per 8 hours
+	}
+}
+
+// rrdTemplate returns template for RRDTool used to update data.
+func (*tailLatencyItem) rrdTemplate() string {
+	return "p999:max"
+}
+
+// rrdString returns a string matching template format with the data to
+// update RRD files.
+func (self *tailLatencyItem) rrdString() string {
+	return fmt.Sprintf("%d:%.2f:%d", self.time, self.p999, self.max)
+}
+
+// lowConfidence returns a value indicating whether p99.9 was calculated
+// from too few values.
+func (self *tailLatencyItem) lowConfidence() bool {
+	return self.low
+}
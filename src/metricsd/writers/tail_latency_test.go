@@ -0,0 +1,44 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+type TailLatencyS struct {
+	writer *TailLatency
+}
+
+var _ = Suite(&TailLatencyS{})
+
+func (s *TailLatencyS) SetUpTest(c *C) {
+	s.writer = &TailLatency{}
+}
+
+func (s *TailLatencyS) TestRollupDataWithEmptySampleSet(c *C) {
+	c.Check(s.writer.rollupData(createSampleSet(1000)), IsNil)
+}
+
+func (s *TailLatencyS) TestRollupDataWithSmallSampleSet(c *C) {
+	data := s.writer.rollupData(createSampleSet(1000, 30, 10, 500, 20))
+	c.Check(data, Equals, &tailLatencyItem{time: 1000, p999: 500, max: 500, low: true})
+	c.Check(data.rrdString(), Equals, "1000:500.00:500")
+	c.Check(debugValues(data), Equals, map[string]interface{}{"p999": 500.0, "max": 500.0, "low_confidence": true})
+}
+
+func (s *TailLatencyS) TestRollupData(c *C) {
+	ss := createSampleSet(1000)
+	for i := 1; i <= 2001; i++ {
+		ss.Add(i)
+	}
+	data := s.writer.rollupData(ss)
+	c.Check(data, Equals, &tailLatencyItem{time: 1000, p999: 1999, max: 2001})
+	c.Check(debugValues(data), Equals, map[string]interface{}{"p999": 1999.0, "max": 2001.0})
+}
+
+func (s *TailLatencyS) TestRollupDataWithWeights(c *C) {
+	// 1000 events: 1 (x998), 1000 (x2)
+	ss := createSampleSet(1000, 1, 1000)
+	ss.Weights = []int{998, 2}
+	data := s.writer.rollupData(ss)
+	c.Check(data, Equals, &tailLatencyItem{time: 1000, p999: 1000, max: 1000})
+}
@@ -0,0 +1,95 @@
+package writers
+
+import (
+	"fmt"
+	"math"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+// TimeWeighted writer is used to calculate the mean of values weighted by
+// their timestamps within the slice: the weight of a value halves every
+// HalfLife seconds (per-metric option) before the latest value, so the
+// mean reflects the current state of smoothly changing gauges better than
+// the plain mean.
+type TimeWeighted struct {
+	*BaseWriter
+}
+
+// timeWeightedItem stores the mean calculated by TimeWeighted writer.
+type timeWeightedItem struct {
+	// Timestamp of the sample set.
+	time int64
+	// Time-weighted mean of values.
+	mean float64
+}
+
+// Name returns the name of the writer.
+func (*TimeWeighted) Name() string {
+	return "time_weighted"
+}
+
+// rollupData performs summarization on the given sample set and returns
+// timeWeightedItem with the mean of values. Pre-aggregated events are
+// counted as many times as their weight. Values are weighted equally when
+// their timestamps are not kept (metrics without HalfLife option, see
+// types.SampleSet.KeepTimes), including summarized values.
+func (self *TimeWeighted) rollupData(set *types.SampleSet) (data dataItem) {
+	if set.Summary != nil {
+		return &timeWeightedItem{time: set.Time, mean: set.Summary.Mean()}
+	}
+	if len(set.Values) == 0 {
+		return
+	}
+
+	halfLife := config.MetricOptions(set.Name).HalfLife
+	var latest int64
+	for _, timestamp := range set.Times {
+		if timestamp > latest {
+			latest = timestamp
+		}
+	}
+	var total, count float64
+	for idx, value := range set.Values {
+		weight := float64(set.Weight(idx))
+		if set.Times != nil && halfLife > 0 {
+			// Relative to the latest value, so weights never overflow
+			weight *= math.Pow(2, float64(set.Times[idx]-latest)/halfLife)
+		}
+		total += float64(value) * weight
+		count += weight
+	}
+	data = &timeWeightedItem{time: set.Time, mean: total / count}
+	return
+}
+
+// prototype returns an empty data item used to report unknown values.
+func (*TimeWeighted) prototype() dataItem {
+	return &timeWeightedItem{}
+}
+
+// String returns string representation of the given timeWeightedItem.
+func (self *timeWeightedItem) String() string {
+	return fmt.Sprintf("timeWeightedItem[time=%d, mean=%.6f]", self.time, self.mean)
+}
+
+// rrdInfo returns the list of parameters used to create RRD file.
+func (*timeWeightedItem) rrdInfo() []string {
+	return []string{
+		"DS:time_weighted:GAUGE:600:U:U",
+		"RRA:AVERAGE:0.5:1:25920",   // 72 hours at 1 sample per 10 secs
+		"RRA:AVERAGE:0.5:60:4320",   // 1 month at 1 sample per 10 mins
+		"RRA:AVERAGE:0.5:2880:5475", // 5 years at 1 sample per 8 hours
+	}
+}
+
+// rrdTemplate returns template for RRDTool used to update data.
+func (*timeWeightedItem) rrdTemplate() string {
+	return "time_weighted"
+}
+
+// rrdString returns a string matching template format with the data to
+// update RRD files.
+func (self *timeWeightedItem) rrdString() string {
+	return fmt.Sprintf("%d:%.6f", self.time, self.mean)
+}
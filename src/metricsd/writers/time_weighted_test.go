@@ -0,0 +1,57 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+)
+
+type TimeWeightedS struct {
+	timeWeighted *TimeWeighted
+}
+
+var _ = Suite(&TimeWeightedS{})
+
+func (s *TimeWeightedS) SetUpTest(c *C) {
+	s.timeWeighted = &TimeWeighted{}
+	config.SetMetrics([]*config.MetricConfig{&config.MetricConfig{Pattern: "metric", HalfLife: 5}})
+}
+
+func (s *TimeWeightedS) TearDownTest(c *C) {
+	config.SetMetrics(nil)
+}
+
+func (s *TimeWeightedS) TestRollupDataWithEmptySampleSet(c *C) {
+	c.Check(s.timeWeighted.rollupData(createSampleSet(1000)), IsNil)
+}
+
+func (s *TimeWeightedS) TestRollupDataWithoutTimes(c *C) {
+	data := s.timeWeighted.rollupData(createSampleSet(1000, 10, 20, 60))
+	c.Check(data, Equals, &timeWeightedItem{time: 1000, mean: 30})
+	c.Check(data.rrdString(), Equals, "1000:30.000000")
+}
+
+func (s *TimeWeightedS) TestRollupData(c *C) {
+	set := createSampleSet(1000)
+	set.KeepTimes(1000)
+	set.Add(10)
+	set.KeepTimes(1005)
+	set.AddWeighted(40, 2)
+	set.KeepTimes(1010)
+	set.Add(100)
+	// Weights are 0.25, 0.5 * 2, and 1
+	data := s.timeWeighted.rollupData(set)
+	c.Check(data, Equals, &timeWeightedItem{time: 1000, mean: (2.5 + 40 + 100) / 2.25})
+}
+
+func (s *TimeWeightedS) TestRollupDataWithSummarizedSampleSet(c *C) {
+	set := createSampleSet(3000)
+	set.Summarize(10, 3, 0.01)
+	set.Summarize(30, 1, 0.01)
+	c.Check(s.timeWeighted.rollupData(set), Equals, &timeWeightedItem{time: 3000, mean: 15})
+}
+
+func (s *TimeWeightedS) TestSummarizeCarriedSampleSet(c *C) {
+	set := createSampleSet(1000)
+	set.Carried = true
+	c.Check(summarize(s.timeWeighted, set).rrdString(), Equals, "1000:U")
+}
@@ -0,0 +1,118 @@
+package writers
+
+import (
+	"fmt"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+// TrimmedMean writer is used to calculate the mean of values after
+// discarding the given fraction of the lowest and of the highest values,
+// a central tendency robust to outliers (e.g. for noisy latencies).
+type TrimmedMean struct {
+	*BaseWriter
+	// Fraction of values discarded from each tail.
+	Fraction float64
+}
+
+// NewTrimmedMean returns a new TrimmedMean writer with the trim fraction
+// defined in configuration.
+func NewTrimmedMean() *TrimmedMean {
+	return &TrimmedMean{Fraction: config.TrimFraction}
+}
+
+// trimmedMeanItem stores the mean calculated by TrimmedMean writer.
+type trimmedMeanItem struct {
+	// Timestamp of the sample set.
+	time int64
+	// Mean of values remaining after trimming.
+	mean float64
+	// Value indicating whether any values remain after trimming.
+	known bool
+}
+
+// Name returns the name of the writer.
+func (*TrimmedMean) Name() string {
+	return "trimmed_mean"
+}
+
+// rollupData sorts values of the sample set, discards Fraction of them
+// (rounded down) from each tail, and returns trimmedMeanItem with the mean
+// of the rest. Pre-aggregated events are counted as many times as their
+// weight, so a weighted value could be trimmed partially. The mean is
+// unknown when trimming removes all values.
+func (self *TrimmedMean) rollupData(set *types.SampleSet) (data dataItem) {
+	if len(set.Values) == 0 {
+		return
+	}
+	set.Sort()
+
+	var total int64
+	for idx := range set.Values {
+		total += int64(set.Weight(idx))
+	}
+	trimmed := int64(self.Fraction * float64(total))
+	item := &trimmedMeanItem{time: set.Time}
+	if remaining := total - 2*trimmed; remaining > 0 {
+		// Positions of values (counting weights) kept are [trimmed, total-trimmed)
+		var position int64
+		var sum float64
+		for idx, value := range set.Values {
+			from, to := position, position+int64(set.Weight(idx))
+			position = to
+			if from < trimmed {
+				from = trimmed
+			}
+			if to > total-trimmed {
+				to = total - trimmed
+			}
+			if to > from {
+				sum += float64(value) * float64(to-from)
+			}
+		}
+		item.mean = sum / float64(remaining)
+		item.known = true
+	}
+	data = item
+	return
+}
+
+// prototype returns an empty data item used to report unknown values.
+func (*TrimmedMean) prototype() dataItem {
+	return &trimmedMeanItem{}
+}
+
+// String returns string representation of the given trimmedMeanItem.
+func (self *trimmedMeanItem) String() string {
+	return fmt.Sprintf("trimmedMeanItem[time=%d, mean=%s]", self.time, self.value())
+}
+
+// rrdInfo returns the list of parameters used to create RRD file.
+func (*trimmedMeanItem) rrdInfo() []string {
+	return []string{
+		"DS:trimmed_mean:GAUGE:600:U:U",
+		"RRA:AVERAGE:0.5:1:25920",   // 72 hours at 1 sample per 10 secs
+		"RRA:AVERAGE:0.5:60:4320",   // 1 month at 1 sample per 10 mins
+		"RRA:AVERAGE:0.5:2880:5475", // 5 years at 1 sample per 8 hours
+	}
+}
+
+// rrdTemplate returns template for RRDTool used to update data.
+func (*trimmedMeanItem) rrdTemplate() string {
+	return "trimmed_mean"
+}
+
+// rrdString returns a string matching template format with the data to
+// update RRD files.
+func (self *trimmedMeanItem) rrdString() string {
+	return fmt.Sprintf("%d:%s", self.time, self.value())
+}
+
+// value returns the formatted mean, or UnknownValue when trimming removed
+// all values.
+func (self *trimmedMeanItem) value() string {
+	if !self.known {
+		return UnknownValue
+	}
+	return fmt.Sprintf("%.6f", self.mean)
+}
@@ -0,0 +1,51 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+type TrimmedMeanS struct {
+	writer *TrimmedMean
+}
+
+var _ = Suite(&TrimmedMeanS{})
+
+func (s *TrimmedMeanS) SetUpTest(c *C) {
+	s.writer = &TrimmedMean{Fraction: 0.1}
+}
+
+func (s *TrimmedMeanS) TestRollupDataWithEmptySampleSet(c *C) {
+	c.Check(s.writer.rollupData(createSampleSet(1000)), IsNil)
+}
+
+func (s *TrimmedMeanS) TestRollupDataDiscardsTails(c *C) {
+	ss := createSampleSet(1000, 1000, 5, 4, 3, 2, 1, 6, 7, 8, -1000)
+	data := s.writer.rollupData(ss)
+	c.Check(data, Equals, &trimmedMeanItem{time: 1000, mean: 4.5, known: true})
+	c.Check(data.rrdString(), Equals, "1000:4.500000")
+}
+
+func (s *TrimmedMeanS) TestRollupDataRoundsTrimmedCountDown(c *C) {
+	// 10% of 5 values is less than a value, nothing is trimmed
+	data := s.writer.rollupData(createSampleSet(1000, 1, 2, 3, 4, 100))
+	c.Check(data, Equals, &trimmedMeanItem{time: 1000, mean: 22, known: true})
+}
+
+func (s *TrimmedMeanS) TestRollupDataWithWeights(c *C) {
+	// 10 events: 1 (x1), 2 (x8), 50 (x1)
+	ss := createSampleSet(1000, 1, 2, 50)
+	ss.Weights = []int{1, 8, 1}
+	data := s.writer.rollupData(ss)
+	c.Check(data, Equals, &trimmedMeanItem{time: 1000, mean: 2, known: true})
+}
+
+func (s *TrimmedMeanS) TestRollupDataTrimmingEverything(c *C) {
+	s.writer.Fraction = 0.5
+	data := s.writer.rollupData(createSampleSet(1000, 1, 2))
+	c.Check(data, Equals, &trimmedMeanItem{time: 1000})
+	c.Check(data.rrdString(), Equals, "1000:U")
+
+	// The median remains of an odd number of values
+	data = s.writer.rollupData(createSampleSet(1000, 1, 2, 30))
+	c.Check(data, Equals, &trimmedMeanItem{time: 1000, mean: 2, known: true})
+}
@@ -0,0 +1,28 @@
+package writers
+
+import (
+	"metricsd/config"
+)
+
+// unitWriter is implemented by writers whose rollups have their own unit
+// regardless of the unit of metric values (for example, counts of values).
+// Rollups of other writers have the unit of metric values.
+type unitWriter interface {
+	// unit returns the unit of the writer rollups.
+	unit() string
+}
+
+// seriesUnit returns the unit of the writer rollups of the metric with the
+// given name, attached to exported rollups: per-metric Units setting for
+// the writer, the writer's own unit (see unitWriter), or per-metric Units
+// setting for all writers ("*"), in this order. Empty unit means unknown.
+func seriesUnit(writer Writer, name string) string {
+	units := config.MetricOptions(name).Units
+	if unit, found := units[writer.Name()]; found {
+		return unit
+	}
+	if unitWriter, ok := writer.(unitWriter); ok {
+		return unitWriter.unit()
+	}
+	return units[config.ALL_WRITERS]
+}
@@ -0,0 +1,44 @@
+package writers
+
+import (
+	"bytes"
+	"strings"
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+)
+
+type UnitsS struct{}
+
+var _ = Suite(&UnitsS{})
+
+func (s *UnitsS) SetUpTest(c *C) {
+	config.SetMetrics([]*config.MetricConfig{&config.MetricConfig{Pattern: "latency", Units: map[string]string{"*": "seconds", "cov": "fraction"}}})
+}
+
+func (s *UnitsS) TearDownTest(c *C) {
+	config.SetMetrics(nil)
+	latestRollups = make(map[string]*latestRollup)
+}
+
+func (s *UnitsS) TestSeriesUnit(c *C) {
+	c.Check(seriesUnit(&Quartiles{}, "latency"), Equals, "seconds")
+	c.Check(seriesUnit(&Count{}, "latency"), Equals, "count")
+	c.Check(seriesUnit(&Cov{}, "latency"), Equals, "fraction")
+	c.Check(seriesUnit(&Quartiles{}, "metric"), Equals, "")
+	c.Check(seriesUnit(&Count{}, "metric"), Equals, "count")
+}
+
+func (s *UnitsS) TestPrometheusHelp(c *C) {
+	set := createSampleSet(1000, 1, -1)
+	set.Name = "latency"
+	writer := &Count{}
+	remember(writer, set, writer.rollupData(set))
+	set = createSampleSet(1000, 1)
+	remember(&Quartiles{}, set, (&Quartiles{}).rollupData(set))
+
+	buffer := &bytes.Buffer{}
+	WritePrometheus(buffer)
+	output := buffer.String()
+	c.Check(strings.Contains(output, "# HELP metricsd_latency_count_ok Unit: count\n# TYPE metricsd_latency_count_ok gauge\n"), Equals, true)
+	c.Check(strings.Contains(output, "# HELP metricsd_metric_quartiles"), Equals, false)
+}
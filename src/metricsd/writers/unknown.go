@@ -0,0 +1,22 @@
+package writers
+
+import (
+	"metricsd/config"
+)
+
+// UnknownValue is used by data items for unknown values (no samples, or
+// statistics not defined for the samples) in RRD update strings. Output
+// formats render it on their own (see renderValue).
+const UnknownValue = "U"
+
+// renderValue returns the value of a data source rendered for the output
+// format (see config.UnknownValues): known values are returned as is,
+// unknown values are replaced with the rendering configured for the
+// format. Returns false when the value should be skipped.
+func renderValue(format, value string) (string, bool) {
+	if value != UnknownValue {
+		return value, true
+	}
+	rendering := config.UnknownValues[format]
+	return rendering, rendering != ""
+}
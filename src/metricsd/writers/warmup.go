@@ -0,0 +1,83 @@
+package writers
+
+import (
+	"sync"
+	"sync/atomic"
+	"metricsd/config"
+	"metricsd/types"
+)
+
+// warmupWriter is implemented by writers reporting rates, which are
+// misleading for the first intervals of a metric: the first slice after
+// startup is partial, and there is no previous value to derive from. Such
+// writers suppress rollups for the first Warmup intervals of every metric
+// (see config.MetricConfig).
+type warmupWriter interface {
+	// warmup returns a value indicating whether rollups should be
+	// suppressed during warmup.
+	warmup() bool
+}
+
+// warmupState tracks slices seen by a writer for a series.
+type warmupState struct {
+	first int64 // time of the first slice seen
+	last  int64 // time of the latest slice seen
+}
+
+var (
+	// Number of rollups suppressed during warmup (reset by stats reporting)
+	WarmupSuppressed int64
+	// Slices seen by warmup writers, keyed by source, series, and writer names
+	warmupStates = make(map[string]*warmupState)
+	// Mutex protecting warmupStates
+	warmupStatesMutex = &sync.Mutex{}
+)
+
+// warmingUp returns a value indicating whether the rollup of the sample set
+// produced by the writer should be suppressed, because the metric has been
+// seen for less than Warmup intervals. Every metric warms up separately,
+// since metrics appear at different times. Suppressed rollups are counted
+// in WarmupSuppressed.
+func warmingUp(writer Writer, set *types.SampleSet) bool {
+	if w, ok := writer.(warmupWriter); !ok || !w.warmup() {
+		return false
+	}
+	warmup := config.MetricOptions(set.Name).Warmup
+	if warmup <= 0 {
+		return false
+	}
+
+	key := set.Source + "-" + set.SeriesName() + "-" + writer.Name()
+	warmupStatesMutex.Lock()
+	state, found := warmupStates[key]
+	if !found {
+		state = &warmupState{first: set.Time}
+		warmupStates[key] = state
+	}
+	if set.Time > state.last {
+		state.last = set.Time
+	}
+	first := state.first
+	warmupStatesMutex.Unlock()
+
+	if set.Time < first+int64(warmup*config.MetricInterval(set.Name)) {
+		atomic.AddInt64(&WarmupSuppressed, 1)
+		return true
+	}
+	return false
+}
+
+// expireWarmups forgets warmup state of metrics not received since the
+// given time (seconds since epoch), so metrics reappearing after a long
+// absence warm up again. Returns the number of forgotten states.
+func expireWarmups(before int64) (expired int) {
+	warmupStatesMutex.Lock()
+	defer warmupStatesMutex.Unlock()
+	for key, state := range warmupStates {
+		if state.last < before {
+			warmupStates[key] = nil, false
+			expired++
+		}
+	}
+	return
+}
@@ -0,0 +1,52 @@
+package writers
+
+import (
+	. "launchpad.net/gocheck"
+	"metricsd/config"
+)
+
+type WarmupS struct{}
+
+var _ = Suite(&WarmupS{})
+
+func (s *WarmupS) SetUpTest(c *C) {
+	config.SetMetrics([]*config.MetricConfig{&config.MetricConfig{Pattern: "metric", Warmup: 2}})
+	warmupStates = make(map[string]*warmupState)
+	WarmupSuppressed = 0
+}
+
+func (s *WarmupS) TearDownTest(c *C) {
+	config.SetMetrics(nil)
+}
+
+func (s *WarmupS) TestWarmingUp(c *C) {
+	sum := &Sum{Interval: 10}
+	interval := int64(config.SliceInterval)
+	c.Check(summarize(sum, createSampleSet(1000, 10)), IsNil)
+	c.Check(summarize(sum, createSampleSet(1000+interval, 10)), IsNil)
+	c.Check(summarize(sum, createSampleSet(1000+2*interval, 10)), Not(IsNil))
+	c.Check(WarmupSuppressed, Equals, int64(2))
+}
+
+func (s *WarmupS) TestWarmingUpPerMetric(c *C) {
+	sum := &Sum{Interval: 10}
+	interval := int64(config.SliceInterval)
+	summarize(sum, createSampleSet(1000, 10))
+	summarize(sum, createSampleSet(1000+interval, 10))
+	set := createSampleSet(1000+2*interval, 10)
+	set.Source = "other"
+	c.Check(summarize(sum, set), IsNil)
+}
+
+func (s *WarmupS) TestWarmingUpIgnoresOtherWriters(c *C) {
+	c.Check(summarize(&Quartiles{}, createSampleSet(1000, 10)), Not(IsNil))
+	c.Check(WarmupSuppressed, Equals, int64(0))
+}
+
+func (s *WarmupS) TestExpireWarmups(c *C) {
+	sum := &Sum{Interval: 10}
+	summarize(sum, createSampleSet(1000, 10))
+	c.Check(expireWarmups(1000), Equals, 0)
+	c.Check(expireWarmups(1001), Equals, 1)
+	c.Check(summarize(sum, createSampleSet(5000, 10)), IsNil)
+}
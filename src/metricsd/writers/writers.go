@@ -2,10 +2,13 @@ package writers
 
 import (
 	"fmt"
+	"hash/crc32"
 	"os"
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 	"metricsd/config"
 	"metricsd/types"
 	"github.com/kpumuk/gorrd"
@@ -17,87 +20,209 @@ type rrdUpdateTask struct {
 	firstDataItem  dataItem
 	f              func([]string) []string
 	wg             *sync.WaitGroup
+	attempts       int // number of retries after failed updates
+	skips          int // number of writes to skip before the next retry
 }
 
+// Cancelled is returned by rollup functions when writes were cancelled.
+var Cancelled = os.NewError("Writes cancelled")
+
+// Number of rollups computed, but not written in dry-run mode (see
+// config.DryRun).
+var dryRunRollups int64
+
 var (
-	// Channel with tasks for RRD update threads
-	rrdUpdateTasks chan *rrdUpdateTask
+	// Channels with tasks, one per RRD update thread (see rrdUpdateQueue)
+	rrdUpdateTasks []chan *rrdUpdateTask
 	// Indicating whether RRD update threads were created
 	rrdUpdateThreadsPrepared bool = false
+	// Function used by RRD update threads to update RRD files
+	updateRrdFile = safeUpdateRrd
 )
 
-func Rollup(writer Writer, set *types.SampleSet) {
+// Rollup summarizes the sample set using the writer, and writes the result
+// to configured outputs (nothing is written in dry-run mode, partial sample
+// sets are not written to RRD files). Sample sets of metrics not processed
+// by the writer are skipped (see config.UsesWriter). When done channel is
+// closed, Rollup stops waiting for RRD update and returns Cancelled.
+// Writers keeping rollups themselves (see capturingWriter) write nothing.
+func Rollup(writer Writer, set *types.SampleSet, done <-chan bool) os.Error {
+	if !config.UsesWriter(set.Name, set.Type, set.Tags, writer.Name()) {
+		return nil
+	}
+	if capturing, ok := writer.(capturingWriter); ok {
+		capturing.capture(set)
+		return nil
+	}
 	prepareRrdUpdateThreads()
 	wg := &sync.WaitGroup{}
 
-	if data := writer.rollupData(set); data != nil {
-		updateRrd(writer, set, data, wg, func(args []string) []string {
-			return append(args, data.rrdString())
-		})
+	if data := summarize(writer, set); data != nil {
+		publish(writer, set, data)
+		if !config.DryRun && !set.Partial && config.HasOutput(set.Name, writer.Name(), config.OUTPUT_RRD) {
+			if error := updateRrd(writer, set, data, wg, done, func(args []string) []string {
+				return append(args, data.rrdString())
+			}); error != nil {
+				return error
+			}
+		}
 	}
 
-	wg.Wait()
+	return wait(wg, done)
 }
 
-func BatchRollup(writer Writer, sets []*types.SampleSet) {
+// BatchRollup summarizes sample sets (sorted by source and name) using the
+// writer, and writes results to configured outputs, updating every RRD file
+// once (nothing is written in dry-run mode, partial sample sets are not
+// written to RRD files). Sample sets of metrics not processed by the writer
+// are skipped (see config.UsesWriter). Rollups of metrics with
+// consolidation are combined into a single rollup per series (see
+// consolidate). When done channel is closed, BatchRollup stops queuing and
+// waiting for RRD updates and returns Cancelled.
+func BatchRollup(writer Writer, sets []*types.SampleSet, done <-chan bool) os.Error {
+	sets = writerSampleSets(writer, sets)
+	if capturing, ok := writer.(capturingWriter); ok {
+		for _, set := range sets {
+			capturing.capture(set)
+		}
+		return nil
+	}
 	data := make([]dataItem, 0, 10)
 
-	var from int
+	// The first and the latest sample sets with data to write of the sequence
+	var first, latest *types.SampleSet
 	var prevSource, prevName string
+	// Partial sample sets of consolidated metrics, published after the
+	// consolidated rollup
+	var partialSets []*types.SampleSet
+	var partialData []dataItem
+	// Partial sample sets are published, but not written to RRD files
+	add := func(set *types.SampleSet) {
+		item := summarize(writer, set)
+		switch {
+		case item == nil:
+		case !consolidates(set):
+			publish(writer, set, item)
+		case set.Partial:
+			partialSets = append(partialSets, set)
+			partialData = append(partialData, item)
+		}
+		if item != nil && !set.Partial {
+			if len(data) == 0 {
+				first = set
+			}
+			latest = set
+			data = append(data, item)
+		}
+	}
 
 	prepareRrdUpdateThreads()
 	wg := &sync.WaitGroup{}
 
+	// Rollups of consolidated metrics are published and written once per
+	// sequence
+	flush := func() (error os.Error) {
+		if len(data) > 0 && consolidates(latest) {
+			item := consolidate(config.MetricOptions(latest.Name).Consolidation, data)
+			publish(writer, latest, item)
+			error = batchRollup(writer, latest, []dataItem{item}, wg, done)
+		} else {
+			error = batchRollup(writer, first, data, wg, done)
+		}
+		for idx, set := range partialSets {
+			publish(writer, set, partialData[idx])
+		}
+		partialSets, partialData = nil, nil
+		return
+	}
+
 	for cur, set := range sets {
 		// config.Logger.Debug("... source=%s, name=%s, prevSource=%s, prevName=%s", set.Source, set.Name, prevSource, prevName)
 		if cur == 0 {
 			prevSource = set.Source
-			prevName = set.Name
+			prevName = set.SeriesName()
 		}
 
 		// Next item in the sequence of samples
 		pushed := false
-		if prevSource == set.Source && prevName == set.Name {
-			if item := writer.rollupData(set); item != nil {
-				data = append(data, item)
-			}
+		if prevSource == set.Source && prevName == set.SeriesName() {
+			add(set)
 			pushed = true
 		}
 
 		// Reached a new sequence or the end of samples list
-		if prevSource != set.Source || prevName != set.Name || cur == len(sets)-1 {
-			batchRollup(writer, sets[from], data, wg)
+		if prevSource != set.Source || prevName != set.SeriesName() || cur == len(sets)-1 {
+			if error := flush(); error != nil {
+				return error
+			}
 
-			from = cur
 			prevSource = set.Source
-			prevName = set.Name
+			prevName = set.SeriesName()
 			data = make([]dataItem, 0, 10)
 		}
 
 		// A new sequence beginning
 		if !pushed {
-			if item := writer.rollupData(set); item != nil {
-				data = append(data, item)
+			add(set)
 
-				// The last item in the samples list
-				if cur == len(sets)-1 {
-					batchRollup(writer, sets[from], data, wg)
+			// The last item in the samples list
+			if cur == len(sets)-1 {
+				if error := flush(); error != nil {
+					return error
 				}
 			}
 		}
 	}
 
-	wg.Wait()
+	return wait(wg, done)
 }
 
-func batchRollup(writer Writer, firstSampleSet *types.SampleSet, data []dataItem, wg *sync.WaitGroup) {
-	// Nothing to save
-	if len(data) == 0 {
+// writerSampleSets returns sample sets of metrics processed by the writer.
+func writerSampleSets(writer Writer, sets []*types.SampleSet) []*types.SampleSet {
+	selected := make([]*types.SampleSet, 0, len(sets))
+	for _, set := range sets {
+		if config.UsesWriter(set.Name, set.Type, set.Tags, writer.Name()) {
+			selected = append(selected, set)
+		}
+	}
+	return selected
+}
+
+// publish makes the data item available to Prometheus endpoint, and sends
+// it to output backends configured for the writer (except RRD files,
+// which are updated in batches). In dry-run mode the data item is logged
+// and counted in dryRunRollups instead.
+func publish(writer Writer, set *types.SampleSet, data dataItem) {
+	remember(writer, set, data)
+	if config.DryRun {
+		atomic.AddInt64(&dryRunRollups, 1)
+		config.Logger.Debug("Dry run, would write: %s", strings.TrimSpace(debugLine(writer, set, data)))
 		return
 	}
+	for _, output := range config.WriterOutputs(set.Name, writer.Name()) {
+		switch output {
+		case config.OUTPUT_GRAPHITE:
+			forwardToGraphite(writer, set, data)
+		case config.OUTPUT_INFLUX:
+			forwardToInflux(writer, set, data)
+		case config.OUTPUT_KAFKA:
+			forwardToKafka(writer, set, data)
+		case config.OUTPUT_STDOUT:
+			printToStdout(writer, set, data)
+		case config.OUTPUT_FILE:
+			writeToDebugFile(writer, set, data)
+		}
+	}
+}
+
+func batchRollup(writer Writer, firstSampleSet *types.SampleSet, data []dataItem, wg *sync.WaitGroup, done <-chan bool) os.Error {
+	// Nothing to save
+	if len(data) == 0 || config.DryRun || !config.HasOutput(firstSampleSet.Name, writer.Name(), config.OUTPUT_RRD) {
+		return nil
+	}
 
 	// Update RRD database
-	updateRrd(writer, firstSampleSet, data[0], wg, func(args []string) []string {
+	return updateRrd(writer, firstSampleSet, data[0], wg, done, func(args []string) []string {
 		// Serialize all data items to the arguments array
 		for _, elem := range data {
 			args = append(args, elem.rrdString())
@@ -111,42 +236,154 @@ func prepareRrdUpdateThreads() {
 		return
 	}
 
-	rrdUpdateTasks = make(chan *rrdUpdateTask, config.RrdUpdateThreads)
-	for i := 1; i <= config.RrdUpdateThreads; i++ {
-		go func(idx int) {
+	rrdUpdateTasks = startRrdUpdateThreads(config.RrdUpdateThreads)
+	rrdUpdateThreadsPrepared = true
+}
+
+// startRrdUpdateThreads starts the given number of RRD update threads, and
+// returns their channels of tasks. Every thread performs its tasks in the
+// order they have been queued.
+func startRrdUpdateThreads(threads int) []chan *rrdUpdateTask {
+	queues := make([]chan *rrdUpdateTask, threads)
+	for i := range queues {
+		queues[i] = make(chan *rrdUpdateTask, 1)
+		go func(idx int, tasks <-chan *rrdUpdateTask) {
 			config.Logger.Debug("Started RRD update thread #%d", idx)
 			args := make([]string, 0, 10)
 			runtime.LockOSThread()
-			for {
-				task := <-rrdUpdateTasks
+			for task := range tasks {
 				args = task.f(args[:0])
-				doUpdateRrd(task.writer, task.firstSampleSet, task.firstDataItem, args)
+				if error := updateRrdFile(task.writer, task.firstSampleSet, task.firstDataItem, args); error != nil {
+					storageFailed(error)
+					updateFailed(task, error)
+				} else {
+					storageSucceeded()
+				}
 				task.wg.Done()
 			}
-		}(i)
+		}(i+1, queues[i])
 	}
-	rrdUpdateThreadsPrepared = true
+	return queues
+}
+
+// rrdUpdateQueue returns the channel of the RRD update thread responsible
+// for the RRD file updated by the task. All updates of a file are performed
+// by the same thread in the order they have been queued, so RRD files
+// always receive data in ascending time order (sample sets are written
+// oldest first, see types.SortSampleSets), regardless of the number of
+// threads.
+func rrdUpdateQueue(queues []chan *rrdUpdateTask, task *rrdUpdateTask) chan *rrdUpdateTask {
+	key := task.writer.Name() + " " + task.firstSampleSet.Source + " " + task.firstSampleSet.SeriesName()
+	return queues[crc32.ChecksumIEEE([]byte(key))%uint32(len(queues))]
+}
+
+// queuedRrdUpdates returns the number of tasks waiting for RRD update
+// threads.
+func queuedRrdUpdates() (count int) {
+	for _, tasks := range rrdUpdateTasks {
+		count += len(tasks)
+	}
+	return
+}
+
+// updateRrd queues update of the RRD file of the sample set (see
+// queueRrdUpdate).
+func updateRrd(writer Writer, firstSampleSet *types.SampleSet, firstDataItem dataItem, wg *sync.WaitGroup, done <-chan bool, f func([]string) []string) os.Error {
+	return queueRrdUpdate(&rrdUpdateTask{writer: writer, firstSampleSet: firstSampleSet, firstDataItem: firstDataItem, f: f, wg: wg}, done)
+}
+
+// queueRrdUpdate queues the task for RRD update threads. The task is
+// dropped in degraded mode (see skipRrdUpdate). Returns Cancelled when
+// done channel is closed before the task is queued.
+func queueRrdUpdate(task *rrdUpdateTask, done <-chan bool) os.Error {
+	if skipRrdUpdate() {
+		return nil
+	}
+	task.wg.Add(1)
+	select {
+	case rrdUpdateQueue(rrdUpdateTasks, task) <- task:
+	case <-done:
+		task.wg.Done()
+		return Cancelled
+	}
+	return nil
+}
+
+// wait waits for all RRD updates in the wait group to complete. Returns
+// Cancelled when done channel is closed before that (RRD updates are not
+// interrupted, but the caller does not wait for them anymore).
+func wait(wg *sync.WaitGroup, done <-chan bool) os.Error {
+	completed := make(chan bool, 1)
+	go func() {
+		wg.Wait()
+		completed <- true
+	}()
+	select {
+	case <-completed:
+	case <-done:
+		return Cancelled
+	}
+	return nil
 }
 
-func updateRrd(writer Writer, firstSampleSet *types.SampleSet, firstDataItem dataItem, wg *sync.WaitGroup, f func([]string) []string) {
-	wg.Add(1)
-	rrdUpdateTasks <- &rrdUpdateTask{writer: writer, firstSampleSet: firstSampleSet, firstDataItem: firstDataItem, f: f, wg: wg}
+// safeUpdateRrd updates RRD file, converting panics to errors (ErrPermanent),
+// so a broken RRD file does not stop RRD update thread.
+func safeUpdateRrd(writer Writer, firstSampleSet *types.SampleSet, firstDataItem dataItem, args []string) (err os.Error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = ErrPermanent{os.NewError(fmt.Sprintf("panic: %v", r))}
+		}
+	}()
+	return doUpdateRrd(writer, firstSampleSet, firstDataItem, args)
 }
 
-func doUpdateRrd(writer Writer, firstSampleSet *types.SampleSet, firstDataItem dataItem, args []string) {
-	file := getRrdFile(writer, firstSampleSet)
+// doUpdateRrd creates RRD file of the sample set series, if it does not
+// exist, and updates it, skipping duplicate updates (see dedupRrdUpdate).
+// Returns errors classified by classifyRrdError.
+func doUpdateRrd(writer Writer, firstSampleSet *types.SampleSet, firstDataItem dataItem, args []string) os.Error {
+	file, ok := claimRrdFile(writer, firstSampleSet)
+	if !ok {
+		return nil
+	}
 	if _, err := os.Stat(file); err != nil {
-		err := rrd.Create(file, int64(config.SliceInterval), firstSampleSet.Time-int64(config.SliceInterval), firstDataItem.rrdInfo())
+		if intervalPending(firstSampleSet.Name, types.Clock()) {
+			config.Logger.Debug("Interval of %s is being measured, not creating %s yet", firstSampleSet.Name, file)
+			return nil
+		}
+		interval := int64(config.MetricInterval(firstSampleSet.Name))
+		info, err := rrdBoundedInfo(writer, firstSampleSet.Name, rrdCreateInfo(firstSampleSet.Name, firstDataItem))
+		if err == nil {
+			info, err = rrdHeartbeatInfo(firstSampleSet.Name, interval, info)
+		}
+		if err == nil {
+			err = validateRrdInfo(info)
+		}
 		if err != nil {
-			config.Logger.Debug("Error occurred: %s", err)
-			return
+			return ErrBadData{os.NewError(fmt.Sprintf("Cannot create %s: %s", file, err))}
 		}
+		start := firstSampleSet.Time - interval
+		err = limitCreate(func() os.Error {
+			return rrd.Create(file, interval, start, info)
+		})
+		if err != nil {
+			return classifyRrdError(err)
+		}
+		rrdUpdated(file, start)
+	} else {
+		checkRetention(file, firstSampleSet.Name)
+	}
+	if args = dedupRrdUpdate(file, args); len(args) == 0 {
+		return nil
 	}
 	// config.Logger.Debug("... file=%s", file)
-	err := rrd.Update(file, firstDataItem.rrdTemplate(), args)
-	if err != nil {
-		config.Logger.Debug("Error occurred: %s", err)
+	if err := rrd.Update(file, firstDataItem.rrdTemplate(), args); err != nil {
+		rrdUpdateFailed(file)
+		return classifyRrdError(err)
+	}
+	if last, ok := rrdUpdateTime(args[len(args)-1]); ok {
+		rrdUpdated(file, last)
 	}
+	return nil
 }
 
 func getRrdFile(writer Writer, set *types.SampleSet) string {
@@ -160,7 +397,7 @@ func getRrdFile(writer Writer, set *types.SampleSet) string {
 	if strings.HasSuffix(metricName, "_count") {
 		metricName = metricName[0:len(metricName)-len("_count")] + ".status"
 	}
-	file := fmt.Sprintf("%s-%s", metricName, writer.Name())
+	file := fmt.Sprintf("%s-%s", types.SeriesName(metricName, set.Tags), writer.Name())
 	path := fmt.Sprintf("%s/%s.rrd", dir, file)
 	migrateDollarGroupsToDots(dir, file, path)
 	return path
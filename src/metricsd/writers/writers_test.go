@@ -2,13 +2,144 @@ package writers
 
 import (
 	. "launchpad.net/gocheck"
+	"os"
+	"rand"
+	"sync"
 	"testing"
+	"time"
+	"metricsd/config"
 	"metricsd/types"
 )
 
 // Hook up gocheck into the gotest runner.
 func Test(t *testing.T) { TestingT(t) }
 
+type WritersS struct{}
+
+var _ = Suite(&WritersS{})
+
+func (s *WritersS) TestWait(c *C) {
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	wg.Done()
+	c.Check(wait(wg, nil), IsNil)
+}
+
+func (s *WritersS) TestWaitCancelled(c *C) {
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	done := make(chan bool)
+	close(done)
+	c.Check(wait(wg, done), Equals, Cancelled)
+}
+
+func (s *WritersS) TestRrdUpdatesOrderedPerFile(c *C) {
+	mutex := &sync.Mutex{}
+	updates := make(map[string][]int64)
+	updateRrdFile = func(writer Writer, set *types.SampleSet, data dataItem, args []string) os.Error {
+		// Updates of different files take different time
+		time.Sleep(rand.Int63n(1e5))
+		mutex.Lock()
+		defer mutex.Unlock()
+		updates[set.Name] = append(updates[set.Name], set.Time)
+		return nil
+	}
+	defer func() { updateRrdFile = safeUpdateRrd }()
+
+	queues := startRrdUpdateThreads(4)
+	wg := &sync.WaitGroup{}
+	names := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	for number := int64(1); number <= 50; number++ {
+		for _, name := range names {
+			set := types.NewSampleSet(number*10, "src", name)
+			task := &rrdUpdateTask{writer: &Sum{}, firstSampleSet: set, f: func(args []string) []string { return args }, wg: wg}
+			wg.Add(1)
+			rrdUpdateQueue(queues, task) <- task
+		}
+	}
+	wg.Wait()
+	for _, queue := range queues {
+		close(queue)
+	}
+
+	for _, name := range names {
+		times := updates[name]
+		c.Check(len(times), Equals, 50)
+		for idx := 1; idx < len(times); idx++ {
+			if times[idx] <= times[idx-1] {
+				c.Errorf("Updates of %s are out of order: %v", name, times)
+				break
+			}
+		}
+	}
+}
+
+func (s *WritersS) TestWriterSampleSets(c *C) {
+	config.SetMetrics([]*config.MetricConfig{&config.MetricConfig{Pattern: "explicit", Writers: []string{"quartiles"}}})
+	defer config.SetMetrics(nil)
+
+	untyped := createSampleSet(1000, 10)
+	counter := createSampleSet(1000, 10)
+	counter.Type = config.METRIC_TYPE_COUNTER
+	timer := createSampleSet(1000, 10)
+	timer.Type = config.METRIC_TYPE_TIMER
+	explicit := types.NewSampleSet(1000, "src", "explicit")
+	explicit.Type = config.METRIC_TYPE_COUNTER
+	sets := []*types.SampleSet{untyped, counter, timer, explicit}
+
+	c.Check(writerSampleSets(&Count{}, sets), Equals, []*types.SampleSet{untyped, counter})
+	c.Check(writerSampleSets(&Quartiles{}, sets), Equals, []*types.SampleSet{untyped, timer, explicit})
+}
+
+func (s *WritersS) TestBatchRollupSkipsPartialSampleSets(c *C) {
+	mutex := &sync.Mutex{}
+	updates := make(map[string][]string)
+	updateRrdFile = func(writer Writer, set *types.SampleSet, data dataItem, args []string) os.Error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		updates[set.Name] = append(updates[set.Name], args...)
+		return nil
+	}
+	defer func() { updateRrdFile = safeUpdateRrd }()
+
+	// The trailing slice of a series is partial, all slices of another one
+	complete := createSampleSet(1000, 1)
+	next := createSampleSet(1010, 2)
+	partial := createSampleSet(1020, 3)
+	partial.Partial = true
+	other := types.NewSampleSet(1020, "src", "other")
+	other.Add(4)
+	other.Partial = true
+	sets := []*types.SampleSet{complete, next, partial, other}
+
+	writer := &Sum{Interval: 10}
+	c.Check(BatchRollup(writer, sets, nil), IsNil)
+	c.Check(updates, Equals, map[string][]string{"metric": []string{"1000:1:0.100000", "1010:2:0.200000"}})
+}
+
+func (s *WritersS) TestWriterSampleSetsWithTagWriters(c *C) {
+	config.SetMetrics([]*config.MetricConfig{&config.MetricConfig{Pattern: "http.requests", Writers: []string{"percentiles"}}})
+	config.TagWriters = []*config.TagWritersConfig{
+		&config.TagWritersConfig{Pattern: "http.*", Tags: map[string]string{"status": "5*"}, Writers: []string{"count"}},
+	}
+	defer func() {
+		config.SetMetrics(nil)
+		config.TagWriters = nil
+	}()
+
+	failed := types.NewSampleSet(1000, "src", "http.requests")
+	failed.Tags = types.Tags{"status": "503", "host": "a"}
+	succeeded := types.NewSampleSet(1000, "src", "http.requests")
+	succeeded.Tags = types.Tags{"status": "200"}
+	untagged := types.NewSampleSet(1000, "src", "http.requests")
+	other := types.NewSampleSet(1000, "src", "db.queries")
+	other.Tags = types.Tags{"status": "500"}
+	sets := []*types.SampleSet{failed, succeeded, untagged, other}
+
+	c.Check(writerSampleSets(&Count{}, sets), Equals, []*types.SampleSet{failed, other})
+	c.Check(writerSampleSets(NewPercentiles(), sets), Equals, []*types.SampleSet{succeeded, untagged, other})
+}
+
 func createSampleSet(time int64, values ...int) (ss *types.SampleSet) {
 	ss = types.NewSampleSet(time, "src", "metric")
 	fillSampleSet(ss, values...)
@@ -20,3 +151,13 @@ func fillSampleSet(ss *types.SampleSet, values ...int) {
 		ss.Add(value)
 	}
 }
+
+func createCoalescedSampleSet(time int64, values ...int) (ss *types.SampleSet) {
+	ss = types.NewSampleSet(time, "src", "metric")
+	for _, value := range values {
+		if !ss.Coalesce(value, 1) {
+			ss.Add(value)
+		}
+	}
+	return
+}